@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"gopkg.in/urfave/cli.v1"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// settings that are applied to the running client when the config is
+// reloaded by SIGHUP. Others need a restart.
+var reloadableSettings = map[string]bool{
+	"share-threshold": true,
+	"claim-threshold": true,
+	"gasprice":        true,
+	"max-fee":         true,
+	"priority-fee":    true,
+	"gas-bump":        true,
+	"legacy-tx":       true,
+	"log-level":       true,
+}
+
+// envName returns the environment variable overriding setting name, e.g.
+// SMARTPOOL_SHARE_THRESHOLD for share-threshold.
+func envName(name string) string {
+	return "SMARTPOOL_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func parseConfigValue(raw string) (string, error) {
+	if strings.HasPrefix(raw, "[") {
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("unterminated array %s", raw)
+		}
+		items := []string{}
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			value, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	}
+	if strings.HasPrefix(raw, "\"") {
+		return strconv.Unquote(raw)
+	}
+	if strings.HasPrefix(raw, "'") {
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+	return raw, nil
+}
+
+// stripComment removes a # comment that is not inside a string.
+func stripComment(line string) string {
+	quote := rune(0)
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigFile reads settings from a TOML file. Keys are the names of
+// command line flags. Keys of a [table] are prefixed with the table name,
+// e.g. level in [log] is log-level. Arrays are joined with commas.
+func parseConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	settings := map[string]string{}
+	table := ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNo)
+		}
+		key := strings.Trim(strings.TrimSpace(kv[0]), "\"")
+		if table != "" {
+			key = table + "-" + key
+		}
+		value, err := parseConfigValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineNo, err)
+		}
+		settings[key] = value
+	}
+	return settings, scanner.Err()
+}
+
+// flagDefaults returns the default values of the flags as they would be
+// given on the command line.
+func flagDefaults(c *cli.Context) map[string]string {
+	defaults := map[string]string{}
+	for _, f := range c.App.Flags {
+		name := strings.Split(f.GetName(), ",")[0]
+		switch f := f.(type) {
+		case cli.StringFlag:
+			defaults[name] = f.Value
+		case cli.UintFlag:
+			defaults[name] = strconv.FormatUint(uint64(f.Value), 10)
+		case cli.BoolFlag:
+			defaults[name] = "false"
+		case cli.DurationFlag:
+			defaults[name] = f.Value.String()
+		}
+	}
+	return defaults
+}
+
+func flagNames(c *cli.Context) map[string]bool {
+	names := map[string]bool{}
+	for _, f := range c.App.Flags {
+		names[strings.Split(f.GetName(), ",")[0]] = true
+	}
+	return names
+}
+
+// loadSettings returns settings from the config file overridden by
+// environment variables. Settings given on the command line, which are in
+// fromCLI, are left out because they override both.
+func loadSettings(c *cli.Context, fromCLI map[string]bool) (map[string]string, error) {
+	names := flagNames(c)
+	settings := map[string]string{}
+	if path := c.String("config"); path != "" {
+		fromFile, err := parseConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fromFile {
+			if !names[name] || name == "config" {
+				return nil, fmt.Errorf("%s: unknown setting %s", path, name)
+			}
+			settings[name] = value
+		}
+	}
+	for name := range names {
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			settings[name] = value
+		}
+	}
+	for name := range fromCLI {
+		delete(settings, name)
+	}
+	return settings, nil
+}
+
+// applyConfig sets flags not given on the command line from the config
+// file and environment. It returns the flags that were given on the
+// command line.
+func applyConfig(c *cli.Context) (map[string]bool, error) {
+	fromCLI := map[string]bool{}
+	for name := range flagNames(c) {
+		if c.IsSet(name) {
+			fromCLI[name] = true
+		}
+	}
+	settings, err := loadSettings(c, fromCLI)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range settings {
+		if err = c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", name, value, err)
+		}
+	}
+	return fromCLI, nil
+}
+
+// runtimeSettings are the values of reloadable settings the running client
+// uses. A reload replaces them while other goroutines read them, so they
+// are kept apart from the cli.Context, which is only written before the
+// client starts.
+type runtimeSettings struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// reloadableValues returns values of reloadable settings in c.
+func reloadableValues(c *cli.Context) map[string]string {
+	values := map[string]string{}
+	for name := range reloadableSettings {
+		values[name] = fmt.Sprint(c.Generic(name))
+	}
+	return values
+}
+
+func newRuntimeSettings(c *cli.Context) *runtimeSettings {
+	return &runtimeSettings{sync.RWMutex{}, reloadableValues(c)}
+}
+
+func (s *runtimeSettings) String(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[name]
+}
+
+func (s *runtimeSettings) Uint(name string) uint {
+	value, _ := strconv.ParseUint(s.String(name), 0, 64)
+	return uint(value)
+}
+
+func (s *runtimeSettings) Bool(name string) bool {
+	value, _ := strconv.ParseBool(s.String(name))
+	return value
+}
+
+func (s *runtimeSettings) set(values map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = values
+}
+
+// reloadConfig reads the config file and environment again and applies
+// reloadable settings to the running client. Settings removed from both
+// revert to their defaults. Changes to other settings are reported and
+// ignored. Fees set by the admin API are kept.
+func reloadConfig(
+	c *cli.Context, fromCLI map[string]bool, current *runtimeSettings,
+	miners []*minerIdentity, feePolicy *geth.FeePolicy) error {
+	settings, err := loadSettings(c, fromCLI)
+	if err != nil {
+		return err
+	}
+	for name, value := range flagDefaults(c) {
+		if _, ok := settings[name]; !ok && !fromCLI[name] && name != "config" {
+			settings[name] = value
+		}
+	}
+	// values are checked as the command line would be
+	flags := flag.NewFlagSet("reload", flag.ContinueOnError)
+	for _, f := range c.App.Flags {
+		f.Apply(flags)
+	}
+	values := reloadableValues(c)
+	for name, value := range settings {
+		if !reloadableSettings[name] {
+			if c.Generic(name) != nil && fmt.Sprint(c.Generic(name)) != value {
+				smartpool.Output.Printf("%s changed. Restart SmartPool to apply it.\n", name)
+			}
+			continue
+		}
+		if err = flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s %q: %s", name, value, err)
+		}
+		values[name] = flags.Lookup(name).Value.String()
+	}
+	level, levels, err := smartpool.ParseLevels(values["log-level"])
+	if err != nil {
+		return err
+	}
+	current.set(values)
+	smartpool.SetLogLevels(level, levels)
+	shareThreshold := int(current.Uint("share-threshold"))
+	claimThreshold := int(current.Uint("claim-threshold"))
+	for _, miner := range miners {
+		miner.pool.SetThresholds(shareThreshold, claimThreshold)
+		miner.input.SetThresholds(shareThreshold, claimThreshold)
+	}
+	feePolicy.Update(buildFeePolicy(current))
+	smartpool.Output.Printf(
+		"Reloaded config: share threshold %d, claim threshold %d, log level %s.\n",
+		shareThreshold, claimThreshold, current.String("log-level"))
+	return nil
+}
+
+// watchConfig reloads the config on every SIGHUP.
+func watchConfig(
+	c *cli.Context, fromCLI map[string]bool, current *runtimeSettings,
+	miners []*minerIdentity, feePolicy *geth.FeePolicy) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(c, fromCLI, current, miners, feePolicy); err != nil {
+			smartpool.Output.Printf("Couldn't reload config: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"gopkg.in/urfave/cli.v1"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestContext returns a context of the client's command line with args
+// and a config file of config.
+func newTestContext(t *testing.T, config string, args ...string) (*cli.Context, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "smartpool.toml")
+	if err = ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	app := BuildAppCommandLine()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range app.Flags {
+		f.Apply(set)
+	}
+	if err = set.Parse(append([]string{"--config", path}, args...)); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, set, nil), func() { os.RemoveAll(dir) }
+}
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+		fails    bool
+	}{
+		{`140`, "140", false},
+		{`"http://localhost:8545"`, "http://localhost:8545", false},
+		{`"a \"quoted\" value"`, `a "quoted" value`, false},
+		{`'C:\keystore'`, `C:\keystore`, false},
+		{`["0x01", '0x02', 0x03]`, "0x01,0x02,0x03", false},
+		{`[ "a" , ]`, "a", false},
+		{`[]`, "", false},
+		{`"unterminated`, "", true},
+		{`'unterminated`, "", true},
+		{`'`, "", true},
+		{`["a", "b"`, "", true},
+	}
+	for _, test := range tests {
+		value, err := parseConfigValue(test.raw)
+		if test.fails {
+			if err == nil {
+				t.Fatalf("expected %s to be invalid, got %q", test.raw, value)
+			}
+			continue
+		}
+		if err != nil || value != test.expected {
+			t.Fatalf("expected %s to be %q, got %q (%v)", test.raw, test.expected, value, err)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := map[string]string{
+		`rpc = "x" # the node`:      `rpc = "x" `,
+		`# a comment`:               ``,
+		`extra = "pool #1" # extra`: `extra = "pool #1" `,
+		`extra = 'pool #1'`:         `extra = 'pool #1'`,
+		`extra = "it's #1"`:         `extra = "it's #1"`,
+	}
+	for line, expected := range tests {
+		if stripped := stripComment(line); stripped != expected {
+			t.Fatalf("expected %q to be %q, got %q", line, expected, stripped)
+		}
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	c, cleanup := newTestContext(t, `
+# SmartPool config
+share-threshold = 140 # shares per claim
+miners = ["0x01", "0x02"]
+extra = "pool #1"
+
+[log]
+level = 'debug'
+`)
+	defer cleanup()
+	settings, err := parseConfigFile(c.String("config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"share-threshold": "140", "miners": "0x01,0x02",
+		"extra": "pool #1", "log-level": "debug",
+	}
+	if len(settings) != len(expected) {
+		t.Fatalf("expected settings %v, got %v", expected, settings)
+	}
+	for name, value := range expected {
+		if settings[name] != value {
+			t.Fatalf("expected %s to be %q, got %q", name, value, settings[name])
+		}
+	}
+	for _, config := range []string{"share-threshold 140", `rpc = "http://`} {
+		c, cleanup := newTestContext(t, config)
+		if _, err = parseConfigFile(c.String("config")); err == nil {
+			t.Fatalf("expected %q to be invalid", config)
+		}
+		cleanup()
+	}
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	c, cleanup := newTestContext(t,
+		"share-threshold = 140\nclaim-threshold = 20\ngas-bump = 15\n",
+		"--claim-threshold", "30")
+	defer cleanup()
+	os.Setenv("SMARTPOOL_SHARE_THRESHOLD", "150")
+	os.Setenv("SMARTPOOL_CLAIM_THRESHOLD", "40")
+	defer os.Unsetenv("SMARTPOOL_SHARE_THRESHOLD")
+	defer os.Unsetenv("SMARTPOOL_CLAIM_THRESHOLD")
+	fromCLI, err := applyConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// command line over environment over file over defaults
+	if c.Uint("claim-threshold") != 30 || c.Uint("share-threshold") != 150 ||
+		c.Uint("gas-bump") != 15 || c.Uint("max-fee") != 60 {
+		t.Fatalf("unexpected thresholds %d, %d, gas bump %d and max fee %d",
+			c.Uint("claim-threshold"), c.Uint("share-threshold"),
+			c.Uint("gas-bump"), c.Uint("max-fee"))
+	}
+	if !fromCLI["claim-threshold"] || fromCLI["share-threshold"] {
+		t.Fatalf("expected only claim-threshold to be given on the command line, got %v", fromCLI)
+	}
+	unknown, cleanup := newTestContext(t, "no-such-setting = 1\n")
+	defer cleanup()
+	if _, err = applyConfig(unknown); err == nil {
+		t.Fatalf("expected unknown setting to be refused")
+	}
+}
+
+func TestReloadConfigRevertsRemovedSettings(t *testing.T) {
+	smartpool.Output = smartpool.NewLogger("main")
+	c, cleanup := newTestContext(t, "gas-bump = 15\nlegacy-tx = true\nmax-fee = 90\n", "--max-fee", "80")
+	defer cleanup()
+	fromCLI, err := applyConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(c.String("config"), []byte("# no settings\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	current := newRuntimeSettings(c)
+	feePolicy := &geth.FeePolicy{}
+	feePolicy.SetGasPrice(big.NewInt(7), nil, nil)
+	if err = reloadConfig(c, fromCLI, current, []*minerIdentity{}, feePolicy); err != nil {
+		t.Fatal(err)
+	}
+	if current.Uint("gas-bump") != 10 || current.Bool("legacy-tx") || current.Uint("max-fee") != 80 {
+		t.Fatalf("expected removed settings to revert to defaults, got gas bump %d, legacy tx %t and max fee %d",
+			current.Uint("gas-bump"), current.Bool("legacy-tx"), current.Uint("max-fee"))
+	}
+	if c.Uint("gas-bump") != 15 || !c.Bool("legacy-tx") {
+		t.Fatalf("expected the reload to leave the command line context alone")
+	}
+	if feePolicy.BumpPercent != 10 || feePolicy.Legacy {
+		t.Fatalf("expected the fee policy to be rebuilt from defaults, got %+v", feePolicy)
+	}
+	if feePolicy.GasPrice.Int64() != 7 {
+		t.Fatalf("expected the gas price set by the admin to be kept, got %s", feePolicy.GasPrice)
+	}
+	if err = ioutil.WriteFile(c.String("config"), []byte("gas-bump = many\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = reloadConfig(c, fromCLI, current, []*minerIdentity{}, feePolicy); err == nil {
+		t.Fatalf("expected an invalid setting to be refused")
+	}
+	if current.Uint("gas-bump") != 10 {
+		t.Fatalf("expected a refused reload to keep the settings, got gas bump %d", current.Uint("gas-bump"))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/urfave/cli.v1"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+func readPassphrase(c *cli.Context, address common.Address) (string, error) {
+	if c.String("pass") == "" {
+		return promptUserPassPhrase(address.Hex())
+	}
+	passbytes, err := ioutil.ReadFile(c.String("pass"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(passbytes)), nil
+}
+
+// RunEpochSeeder sets epoch data on the Ethash contract from an owner
+// account of the contract until it is stopped.
+func RunEpochSeeder(c *cli.Context) error {
+	if err := setupLogging(c); err != nil {
+		fmt.Printf("Couldn't set up logging: %s\n", err)
+		return err
+	}
+	contractAddr := common.HexToAddress(c.String("ethash-contract"))
+	if contractAddr.Big().Cmp(common.Big0) == 0 {
+		fmt.Printf("You have to specify the Ethash contract by --ethash-contract. Abort!\n")
+		return errors.New("Ethash contract address is not set")
+	}
+	if c.String("keystore") == "" && c.String("signer") == "" {
+		fmt.Printf("You have to specify keystore path by --keystore or an external signer by --signer. Abort!\n")
+		return errors.New("keystore path is not set")
+	}
+	if c.String("storage-dir") != "" {
+		storage.SmartPoolDir = c.String("storage-dir")
+	}
+	owner, ok, _, err := lookupAccount(c, common.HexToAddress(c.String("owner")))
+	if err != nil {
+		fmt.Printf("Couldn't list accounts of the external signer: %s\n", err)
+		return err
+	}
+	if !ok {
+		fmt.Printf("We couldn't find the private key of the owner address in your keystore path or external signer. Abort!\n")
+		return errors.New("owner account is not found")
+	}
+	fmt.Printf("Using owner address: %s\n", owner.Hex())
+	node, err := geth.NewMultiRPC(
+		c.String("rpc"), contractAddr.Hex(), "", big.NewInt(0), owner.Hex())
+	if err != nil {
+		fmt.Printf("Invalid RPC endpoints: %s\n", err)
+		return err
+	}
+	go node.RunHealthCheck()
+	var signer geth.Signer
+	if c.String("signer") != "" {
+		chainID, err := node.ChainID()
+		if err != nil {
+			fmt.Printf("Couldn't get chain id: %s\n", err)
+			return err
+		}
+		signer, err = geth.NewExternalSigner(c.String("signer"), owner, chainID)
+		if err != nil {
+			fmt.Printf("Couldn't connect to the external signer: %s\n", err)
+			return err
+		}
+	} else {
+		passphrase, err := readPassphrase(c, owner)
+		if err != nil {
+			fmt.Printf("Couldn't read your passphrase: %s\n", err)
+			return err
+		}
+		signer, err = geth.UnlockKeystore(c.String("keystore"), owner, passphrase, node)
+		if err != nil {
+			fmt.Printf("Couldn't unlock the owner account: %s\n", err)
+			return err
+		}
+	}
+	client, err := geth.NewEthashContractClient(
+		contractAddr, node, c.String("rpc"), signer, buildFeePolicy(c))
+	if client == nil {
+		fmt.Printf("Couldn't connect to the Ethash contract: %s\n", err)
+		return errors.New("couldn't connect to the Ethash contract")
+	}
+	seeder := ethereum.NewEpochSeeder(
+		ethereum.NewEthashContract(client), node, ethereum.LocalDagProofs,
+		storage.NewGobFileStorage(), c.Duration("interval"))
+	seeder.Run()
+	return nil
+}
+
+func epochSeederCommand() cli.Command {
+	return cli.Command{
+		Name:  "epoch-seeder",
+		Usage: "Set epoch data on the Ethash contract before each epoch starts",
+		Description: "Watches block height, generates the DAG of the next epoch and sets merkle nodes of the current and the next epochs " +
+			"that are not set on the Ethash contract yet. An interrupted upload resumes with the missing nodes. " +
+			"It must be run from an owner account of the contract.",
+		Action: RunEpochSeeder,
+		Flags: joinFlags([]cli.Flag{
+			cli.StringFlag{
+				Name:  "ethash-contract",
+				Usage: "Address of the Ethash contract.",
+			},
+			cli.StringFlag{
+				Name:  "rpc",
+				Value: "http://localhost:8545",
+				Usage: "RPC endpoint of Ethereum node. Use comma separated endpoints to fail over between several nodes",
+			},
+			cli.StringFlag{
+				Name:  "keystore",
+				Usage: "Keystore path to the private key of the owner address.",
+			},
+			cli.StringFlag{
+				Name:  "owner",
+				Usage: "Owner address of the Ethash contract. (Default: First account in your keystore.)",
+			},
+			cli.StringFlag{
+				Name:  "pass",
+				Value: "",
+				Usage: "Path to passphrase file.",
+			},
+			cli.StringFlag{
+				Name:  "signer",
+				Usage: "JSON-RPC endpoint of an external signer like clef. Txs are signed by it instead of the key in --keystore.",
+			},
+			cli.DurationFlag{
+				Name:  "interval",
+				Value: 5 * time.Minute,
+				Usage: "How often block height is checked for a new epoch.",
+			},
+			cli.StringFlag{
+				Name:  "storage-dir",
+				Usage: "Directory seeding progress is kept in. (Default: ~/.smartpool)",
+			},
+		}, feeFlags, logFlags),
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/ethash"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"github.com/SmartPool/smartpool-client/ethereum/stat"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/urfave/cli.v1"
+	"path/filepath"
+	"strings"
+)
+
+// reservedMinerNames can't name a miner because the server serves other
+// things at them. See ethminer.NewServer.
+var reservedMinerNames = map[string]bool{
+	"stats": true, "proof": true, "admin": true, "status": true,
+	"metrics": true, "events": true,
+}
+
+// minerIdentity is a miner address the client claims shares for with its
+// own account, nodes, claims, counter and stats. Rigs mine for it at
+// /<name>/<rig>/.
+type minerIdentity struct {
+	name    string
+	address common.Address
+	rpc     string
+	input   *smartpool.Input
+	node    *geth.MultiRPC
+	pool    *protocol.SmartPool
+}
+
+// parseMiners parses --miners, comma separated name=address entries, and
+// --miners-rpc, comma separated name=endpoint entries giving the nodes of
+// each miner. Miners not in --miners-rpc use the nodes of --rpc. A node
+// builds blocks with the extra data of one miner only so miners can't
+// share nodes.
+func parseMiners(miners, minersRPC, defaultRPC string) ([]*minerIdentity, error) {
+	result := []*minerIdentity{}
+	byName := map[string]*minerIdentity{}
+	for _, entry := range strings.Split(miners, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || !common.IsHexAddress(strings.TrimSpace(kv[1])) {
+			return nil, fmt.Errorf("expected name=address, got %s", entry)
+		}
+		name := strings.TrimSpace(kv[0])
+		if name == "" || strings.ContainsAny(name, "/?#% ") || reservedMinerNames[name] {
+			return nil, fmt.Errorf("invalid miner name %q", name)
+		}
+		if byName[name] != nil {
+			return nil, fmt.Errorf("miner %s is given twice", name)
+		}
+		address := common.HexToAddress(strings.TrimSpace(kv[1]))
+		for _, other := range result {
+			if other.address == address {
+				return nil, fmt.Errorf("miners %s and %s have the same address", other.name, name)
+			}
+		}
+		miner := &minerIdentity{name: name, address: address}
+		byName[name] = miner
+		result = append(result, miner)
+	}
+	endpoints := map[string][]string{}
+	for _, entry := range strings.Split(minersRPC, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || byName[strings.TrimSpace(kv[0])] == nil {
+			return nil, fmt.Errorf("expected name=endpoint of a miner in --miners, got %s", entry)
+		}
+		name := strings.TrimSpace(kv[0])
+		endpoints[name] = append(endpoints[name], strings.TrimSpace(kv[1]))
+	}
+	usedBy := map[string]string{}
+	for _, miner := range result {
+		nodes := endpoints[miner.name]
+		if len(nodes) == 0 {
+			nodes = strings.Split(defaultRPC, ",")
+		}
+		rpc := []string{}
+		for _, node := range nodes {
+			node = strings.TrimSpace(node)
+			if node == "" {
+				continue
+			}
+			if other, ok := usedBy[node]; ok {
+				return nil, fmt.Errorf(
+					"miners %s and %s can't share node %s because a node builds blocks with the extra data of one miner only",
+					other, miner.name, node)
+			}
+			usedBy[node] = miner.name
+			rpc = append(rpc, node)
+		}
+		miner.rpc = strings.Join(rpc, ",")
+	}
+	return result, nil
+}
+
+// lookupAccount returns address or, if it is not set, the first account
+// the keystore or the external signer in --signer has. ok is false if they
+// don't have address. accounts are all accounts they have.
+func lookupAccount(c *cli.Context, address common.Address) (common.Address, bool, []common.Address, error) {
+	if c.String("signer") == "" {
+		account, ok, accounts := geth.GetAddress(c.String("keystore"), address)
+		return account, ok, accounts, nil
+	}
+	accounts, err := geth.SignerAccounts(c.String("signer"))
+	if err != nil {
+		return address, false, nil, err
+	}
+	for _, account := range accounts {
+		if account == address || address.Big().Cmp(common.Big0) == 0 {
+			return account, true, accounts, nil
+		}
+	}
+	return address, false, accounts, nil
+}
+
+// reportMissingKey tells the user the private key of the miner address is
+// not in the keystore.
+func reportMissingKey(keystorePath string, miner common.Address, addresses []common.Address) {
+	fmt.Printf("Your keystore: %s\n", keystorePath)
+	fmt.Printf("Your miner address: %s\n", miner.Hex())
+	if len(addresses) > 0 {
+		fmt.Printf("We couldn't find the private key of your miner address in the keystore path you specified. We found following addresses:\n")
+		for i, addr := range addresses {
+			fmt.Printf("%d. %s\n", i+1, addr.Hex())
+		}
+		fmt.Printf("Please make sure you entered correct miner address.\n")
+	} else {
+		fmt.Printf("We couldn't find any private keys in your keystore path.\n")
+		fmt.Printf("Please make sure your keystore path exists.\nAbort!\n")
+	}
+}
+
+// connect sets the miner's input from the settings of the client and
+// connects to its nodes.
+func (m *minerIdentity) connect(base *smartpool.Input) error {
+	m.input = smartpool.NewInput(
+		m.rpc, base.KeystorePath(), base.ShareThreshold(),
+		base.ClaimThreshold(), base.ShareDifficulty(),
+		base.MaxShareDifficulty(), base.SubmitInterval(),
+		base.ContractAddress(), m.address.Hex(),
+		ethereum.BuildExtraData(m.address, base.ShareDifficulty()),
+		base.HotStop(),
+	)
+	node, err := geth.NewMultiRPC(
+		m.input.RPCEndpoint(), m.input.ContractAddress(),
+		m.input.ExtraData(), m.input.ShareDifficulty(),
+		m.input.MinerAddress(),
+	)
+	if err != nil {
+		fmt.Printf("Invalid RPC endpoints of miner %s: %s\n", m.name, err)
+		return err
+	}
+	client, err := node.ClientVersion()
+	if err != nil {
+		fmt.Printf("Node RPC server is unavailable.\n")
+		fmt.Printf("Make sure you have Geth or Parity installed. If you do, you can:\n")
+		fmt.Printf("Run Geth by following command:\n")
+		fmt.Printf("geth --testnet --rpc --rpcapi \"db,eth,net,web3,miner\"\n")
+		fmt.Printf("Or run Parity by following command:\n")
+		fmt.Printf("parity --chain ropsten --jsonrpc-apis \"web3,eth,net,parity,traces,rpc,parity_set\"\n")
+		return err
+	}
+	fmt.Printf("Connected to Ethereum node of miner %s: %s\n", m.name, client)
+	go node.RunHealthCheck()
+	m.node = node
+	return nil
+}
+
+// signer signs txs of the miner with the external signer in --signer or,
+// without it, with the miner's key in the keystore. The key is unlocked
+// with the passphrase in --pass. Without --pass, it prompts for the
+// passphrase until the key is unlocked.
+func (m *minerIdentity) signer(c *cli.Context) (geth.Signer, error) {
+	if c.String("signer") != "" {
+		chainID, err := m.node.ChainID()
+		if err != nil {
+			return nil, err
+		}
+		signer, err := geth.NewExternalSigner(c.String("signer"), m.address, chainID)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return nil, err
+		}
+		fmt.Printf("Txs of miner %s are signed by %s.\n", m.name, c.String("signer"))
+		return signer, nil
+	}
+	for {
+		passphrase, err := readPassphrase(c, m.address)
+		if err != nil {
+			fmt.Printf("Couldn't read your passphrase file. Abort!\n")
+			return nil, err
+		}
+		signer, err := geth.UnlockKeystore(
+			m.input.KeystorePath(), m.address, passphrase, m.node)
+		if err == nil {
+			return signer, nil
+		}
+		fmt.Printf("error: %s\n", err)
+		if c.String("pass") != "" {
+			return nil, errors.New("couldn't unlock the miner account")
+		}
+	}
+}
+
+// contractClient connects to the SmartPool contract to send txs of the
+// miner.
+func (m *minerIdentity) contractClient(c *cli.Context, feePolicy *geth.FeePolicy) (*geth.GethContractClient, error) {
+	signer, err := m.signer(c)
+	if err != nil {
+		return nil, err
+	}
+	client, err := geth.NewGethContractClient(
+		common.HexToAddress(m.input.ContractAddress()), m.node,
+		m.input.RPCEndpoint(), signer, feePolicy,
+	)
+	if client == nil {
+		fmt.Printf("error: %s\n", err)
+		return nil, errors.New("couldn't connect to the SmartPool contract")
+	}
+	return client, nil
+}
+
+// preflightEpochs gives the claim verifier the epoch data that is set on
+// the Ethash contract the SmartPool contract of client verifies shares with.
+func preflightEpochs(client *geth.GethContractClient, rpc string) (ethereum.ProofProvider, error) {
+	ethashAddr, err := client.EthashContract()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := geth.NewEthashContractReader(ethashAddr, rpc)
+	if err != nil {
+		return nil, err
+	}
+	return ethereum.NewContractEpochs(ethereum.DagProofs, reader), nil
+}
+
+// start builds the SmartPool of the miner. Its work pool, claims, counter
+// and stats are kept in ps.
+func (m *minerIdentity) start(
+	c *cli.Context, ps smartpool.PersistentStorage,
+	poolMonitor smartpool.PoolMonitor, feePolicy *geth.FeePolicy,
+	restorePolicy ethereum.RestoreConflictPolicy) error {
+	contractClient, err := m.contractClient(c, feePolicy)
+	if err != nil {
+		return err
+	}
+	workPool := ethereum.NewWorkPool(ps)
+	go workPool.RunCleaner()
+	networkClient := ethereum.NewNetworkClient(m.node, workPool)
+	statRecorder := stat.NewStatRecorder(ps)
+	chainID, err := m.node.ChainID()
+	if err != nil {
+		fmt.Printf("Couldn't get chain id of miner %s: %s\n", m.name, err)
+		return err
+	}
+	blockTracker := ethereum.NewBlockTracker(
+		m.node, statRecorder, ps, ethereum.BlockRewardsOf(chainID))
+	go blockTracker.Run()
+	networkClient.SetBlockTracker(blockTracker)
+	var claimRepo protocol.ClaimRepo
+	diffTiers := m.input.ShareDifficultyTiers()
+	if len(diffTiers) > 1 {
+		claimRepo = ethereum.NewTieredClaimRepo(
+			diffTiers, m.address.Hex(),
+			common.HexToAddress(m.input.ContractAddress()).Hex(),
+			ps, restorePolicy,
+		)
+	} else if c.String("claim-repo") == "counter-window" {
+		claimRepo = ethereum.NewCounterWindowClaimRepo(
+			m.input.ShareDifficulty(), m.address.Hex(),
+			common.HexToAddress(m.input.ContractAddress()).Hex(),
+			ps, restorePolicy, workPool,
+		)
+	} else {
+		claimRepo = ethereum.NewTimestampClaimRepo(
+			m.input.ShareDifficulty(), m.address.Hex(),
+			common.HexToAddress(m.input.ContractAddress()).Hex(),
+			ps, restorePolicy,
+		)
+	}
+	contract := ethereum.NewContract(contractClient, m.address)
+	if !c.Bool("no-preflight") {
+		epochs, err := preflightEpochs(contractClient, m.input.RPCEndpoint())
+		if err != nil {
+			fmt.Printf("Couldn't connect to the Ethash contract of miner %s: %s\n", m.name, err)
+			return err
+		}
+		contract.SetVerifier(ethereum.NewClaimVerifier(
+			common.HexToAddress(m.input.ContractAddress()), m.address, epochs,
+			filepath.Join(storage.SmartPoolDir, "diagnostics"),
+		))
+	}
+	m.pool = protocol.NewSmartPool(
+		poolMonitor, workPool, networkClient, claimRepo, ps, contract,
+		statRecorder, common.HexToAddress(m.input.ContractAddress()),
+		m.address, m.input.ExtraData(), m.input.SubmitInterval(),
+		m.input.ShareThreshold(), m.input.ClaimThreshold(),
+		m.input.HotStop(), m.input,
+	)
+	statRecorder.ShareRestored(m.pool.RestoredShares())
+	if len(diffTiers) > 1 {
+		fmt.Printf("Variable share difficulty is enabled: %d difficulty tiers up to %s.\n",
+			len(diffTiers), diffTiers[len(diffTiers)-1].Text(10))
+		m.pool.DiffAdjuster = stat.NewVarDiff(statRecorder, diffTiers)
+	}
+	// lists are validated by Run
+	allow, _ := parseIPList(c.String("rig-allow"))
+	deny, _ := parseIPList(c.String("rig-deny"))
+	guard := stat.NewRigGuard(
+		statRecorder, float64(c.Uint("rig-rate-limit")),
+		float64(c.Uint("ip-rate-limit")), allow, deny,
+	)
+	if c.Bool("verify-mix") {
+		workPool.VerifyMixDigest(ethash.Instance)
+		guard.SetBadMixBan(stat.NewBadMixBan(statRecorder))
+	}
+	m.pool.BanPolicy = guard
+	return nil
+}
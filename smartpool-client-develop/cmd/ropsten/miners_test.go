@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseMiners(t *testing.T) {
+	miners, err := parseMiners(
+		"alice=0x0000000000000000000000000000000000000001, bob=0x0000000000000000000000000000000000000002",
+		"bob=http://node-b1:8545,bob=http://node-b2:8545",
+		"http://node-a:8545")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(miners) != 2 || miners[0].name != "alice" || miners[1].name != "bob" {
+		t.Fatalf("expected miners alice and bob in order, got %d miners", len(miners))
+	}
+	if miners[0].address.Big().Int64() != 1 || miners[0].rpc != "http://node-a:8545" {
+		t.Fatalf("expected alice to use the nodes of --rpc, got %s", miners[0].rpc)
+	}
+	if miners[1].rpc != "http://node-b1:8545,http://node-b2:8545" {
+		t.Fatalf("expected bob to use its own nodes, got %s", miners[1].rpc)
+	}
+}
+
+func TestParseMinersRefusesInvalidMiners(t *testing.T) {
+	address := "=0x0000000000000000000000000000000000000001"
+	tests := []struct {
+		name      string
+		miners    string
+		minersRPC string
+	}{
+		{"no address", "alice", ""},
+		{"invalid address", "alice=0x01zz", ""},
+		{"empty name", address, ""},
+		{"name with a slash", "a/b" + address, ""},
+		{"name with a space", "a b" + address, ""},
+		{"stats", "stats" + address, ""},
+		{"proof", "proof" + address, ""},
+		{"admin", "admin" + address, ""},
+		{"status", "status" + address, ""},
+		{"metrics", "metrics" + address, ""},
+		{"events", "events" + address, ""},
+		{"same name", "alice" + address + ",alice=0x0000000000000000000000000000000000000002", ""},
+		{"same address", "alice" + address + ",bob" + address, ""},
+		{"nodes of unknown miner", "alice" + address, "bob=http://node-b:8545"},
+		{"shared node", "alice" + address + ",bob=0x0000000000000000000000000000000000000002",
+			"alice=http://node:8545,bob=http://node:8545"},
+		{"node shared with --rpc", "alice" + address + ",bob=0x0000000000000000000000000000000000000002",
+			"alice=http://node-a:8545"},
+	}
+	for _, test := range tests {
+		if _, err := parseMiners(test.miners, test.minersRPC, "http://node-a:8545"); err == nil {
+			t.Fatalf("%s: expected miners %q to be refused", test.name, test.miners)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/stat"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/urfave/cli.v1"
+	"math/big"
+	"path/filepath"
+	"time"
+)
+
+// listArchives prints sessions archived with --on-restore-conflict=archive.
+func listArchives(archives []*ethereum.Archive) {
+	if len(archives) == 0 {
+		fmt.Printf("There are no archived sessions in the storage.\n")
+		return
+	}
+	fmt.Printf("Archived sessions:\n")
+	for _, archive := range archives {
+		fmt.Printf("%s: %d shares of miner %s with difficulty %s, archived at %s\n",
+			archive.Namespace, archive.NumShares, archive.Miner,
+			archive.Difficulty.Text(10),
+			time.Unix(archive.Created, 0).Format(time.RFC3339))
+	}
+	fmt.Printf("Run with --archive <name> to submit one of them.\n")
+}
+
+// RunRestore submits the shares and claims of a session archived with
+// --on-restore-conflict=archive to the SmartPool contract under the miner
+// and difficulty they were mined with. Without --archive, it lists the
+// archived sessions.
+func RunRestore(c *cli.Context) error {
+	if err := setupLogging(c); err != nil {
+		fmt.Printf("Couldn't set up logging: %s\n", err)
+		return err
+	}
+	smartpool.Output = smartpool.NewLogger("main")
+	if c.String("storage-dir") != "" {
+		storage.SmartPoolDir = c.String("storage-dir")
+	}
+	fileStorage, err := storage.NewPersistentStorage(c.String("storage"))
+	if err != nil {
+		fmt.Printf("Couldn't open %s storage: %s\n", c.String("storage"), err)
+		return err
+	}
+	var ps smartpool.PersistentStorage = fileStorage
+	if c.String("miner") != "" {
+		ps = storage.NewNamespaceStorage(fileStorage, common.HexToAddress(c.String("miner")).Hex())
+	}
+	archives, err := ethereum.LoadArchives(ps)
+	if err != nil {
+		fmt.Printf("Couldn't load archived sessions: %s\n", err)
+		return err
+	}
+	if c.String("archive") == "" {
+		listArchives(archives)
+		return nil
+	}
+	var archive *ethereum.Archive
+	for _, a := range archives {
+		if a.Namespace == c.String("archive") {
+			archive = a
+		}
+	}
+	if archive == nil {
+		fmt.Printf("Archive %s is not found. Run without --archive to list archived sessions.\n", c.String("archive"))
+		return errors.New("archive is not found")
+	}
+	if c.String("keystore") == "" && c.String("signer") == "" {
+		fmt.Printf("You have to specify keystore path by --keystore or an external signer by --signer. Abort!\n")
+		return errors.New("keystore path is not set")
+	}
+	address, ok, addresses, err := lookupAccount(c, common.HexToAddress(archive.Miner))
+	if err != nil {
+		fmt.Printf("Couldn't list accounts of the external signer: %s\n", err)
+		return err
+	}
+	if !ok {
+		reportMissingKey(c.String("keystore"), common.HexToAddress(archive.Miner), addresses)
+		return errors.New("miner account is not found")
+	}
+	fmt.Printf("Using miner address: %s\n", address.Hex())
+	miner := &minerIdentity{name: address.Hex(), address: address, rpc: c.String("rpc")}
+	contractAddr := common.HexToAddress(c.String("spcontract"))
+	base := smartpool.NewInput(
+		c.String("rpc"), c.String("keystore"), 1, 1, archive.Difficulty,
+		big.NewInt(0), time.Minute, contractAddr.Hex(), address.Hex(), "", true,
+	)
+	if err = miner.connect(base); err != nil {
+		return err
+	}
+	archiveStorage := archive.Storage(ps)
+	claimRepo := ethereum.NewTimestampClaimRepo(
+		archive.Difficulty, archive.Miner, contractAddr.Hex(),
+		archiveStorage, ethereum.RestoreAbort,
+	)
+	if claimRepo.NoActiveShares() == 0 && claimRepo.NumOpenClaims() == 0 {
+		fmt.Printf("Archive %s has no shares to submit to contract %s.\n", archive.Namespace, contractAddr.Hex())
+		return nil
+	}
+	// no more shares are added to the archived session
+	claimRepo.ReleaseRecentShares()
+	contractClient, err := miner.contractClient(c, buildFeePolicy(c))
+	if err != nil {
+		return err
+	}
+	contract := ethereum.NewContract(contractClient, address)
+	if !c.Bool("no-preflight") {
+		epochs, err := preflightEpochs(contractClient, miner.input.RPCEndpoint())
+		if err != nil {
+			fmt.Printf("Couldn't connect to the Ethash contract: %s\n", err)
+			return err
+		}
+		contract.SetVerifier(ethereum.NewClaimVerifier(
+			contractAddr, address, epochs,
+			filepath.Join(storage.SmartPoolDir, "diagnostics"),
+		))
+	}
+	pool := protocol.NewSmartPool(
+		nil, ethereum.NewWorkPool(archiveStorage), nil, claimRepo,
+		archiveStorage, contract, stat.NewStatRecorder(archiveStorage),
+		contractAddr, address, miner.input.ExtraData(),
+		miner.input.SubmitInterval(), 1, 1, true, miner.input,
+	)
+	fmt.Printf("Submitting %d shares of archive %s...\n", claimRepo.NoActiveShares(), archive.Namespace)
+	verified, err := pool.SubmitRemaining()
+	pool.Persist()
+	if err != nil {
+		fmt.Printf("Couldn't submit the archived shares: %s\n", err)
+		return err
+	}
+	if !verified {
+		fmt.Printf("There was no claim to submit.\n")
+		return nil
+	}
+	fmt.Printf("The archived shares are submitted and verified.\n")
+	return nil
+}
+
+func restoreCommand() cli.Command {
+	return cli.Command{
+		Name:  "restore",
+		Usage: "Submit shares of a session archived with --on-restore-conflict=archive",
+		Description: "Lists the sessions archived in the storage or, with --archive, submits the shares and claims of one of them " +
+			"under the miner address and share difficulty they were mined with. The key of the archive's miner must be in --keystore or --signer.",
+		Action: RunRestore,
+		Flags: joinFlags([]cli.Flag{
+			cli.StringFlag{
+				Name:  "archive",
+				Usage: "Name of the archive to submit. Run without it to list archived sessions.",
+			},
+			cli.StringFlag{
+				Name:  "miner",
+				Usage: "Address of the miner in --miners whose storage has the archive. Not needed for the first miner or a client of a single miner.",
+			},
+			cli.StringFlag{
+				Name:  "rpc",
+				Value: "http://localhost:8545",
+				Usage: "RPC endpoint of Ethereum node. Use comma separated endpoints to fail over between several nodes",
+			},
+			cli.StringFlag{
+				Name:  "spcontract",
+				Value: "0x893DC419776635F8FD1b1fa9934BF529aeF25607",
+				Usage: "SmartPool contract address the archived shares were mined for.",
+			},
+			cli.StringFlag{
+				Name:  "keystore",
+				Usage: "Keystore path to the private key of the archive's miner address.",
+			},
+			cli.StringFlag{
+				Name:  "pass",
+				Value: "",
+				Usage: "Path to passphrase file.",
+			},
+			cli.StringFlag{
+				Name:  "signer",
+				Usage: "JSON-RPC endpoint of an external signer like clef. Txs are signed by it instead of the key in --keystore.",
+			},
+			cli.StringFlag{
+				Name:  "storage",
+				Value: "gob",
+				Usage: "Storage backend SmartPool ran with, \"gob\" or \"bolt\".",
+			},
+			cli.StringFlag{
+				Name:  "storage-dir",
+				Usage: "Directory SmartPool keeps its state in. (Default: ~/.smartpool)",
+			},
+			cli.BoolFlag{
+				Name:  "no-preflight",
+				Usage: "Send the claim verification without replaying the contract's checks locally first.",
+			},
+		}, feeFlags, logFlags),
+	}
+}
@@ -8,13 +8,12 @@ import (
 	"github.com/SmartPool/smartpool-client/ethereum/ethminer"
 	"github.com/SmartPool/smartpool-client/ethereum/geth"
 	"github.com/SmartPool/smartpool-client/ethereum/stat"
-	"github.com/SmartPool/smartpool-client/protocol"
 	"github.com/SmartPool/smartpool-client/storage"
 	"github.com/ethereum/go-ethereum/common"
 	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/urfave/cli.v1"
-	"io/ioutil"
 	"math/big"
+	"net"
 	"os"
 	"strings"
 	"syscall"
@@ -28,7 +27,8 @@ func Initialize(c *cli.Context) *smartpool.Input {
 	shareThreshold := int(c.Uint("share-threshold"))
 	claimThreshold := int(c.Uint("claim-threshold"))
 	shareDifficulty := big.NewInt(int64(c.Uint("diff")))
-	submitInterval := 1 * time.Minute
+	maxShareDiff := big.NewInt(int64(c.Uint("max-diff")))
+	submitInterval := c.Duration("submit-interval")
 	contractAddr := c.String("spcontract")
 	minerAddr := c.String("miner")
 	hotStop := !c.Bool("no-hot-stop")
@@ -38,7 +38,7 @@ func Initialize(c *cli.Context) *smartpool.Input {
 	extraData := ""
 	return smartpool.NewInput(
 		rpcEndPoint, keystorePath, shareThreshold, claimThreshold,
-		shareDifficulty, submitInterval, contractAddr, minerAddr,
+		shareDifficulty, maxShareDiff, submitInterval, contractAddr, minerAddr,
 		extraData, hotStop,
 	)
 }
@@ -55,10 +55,89 @@ func promptUserPassPhrase(acc string) (string, error) {
 	}
 }
 
+func gweiToWei(gwei uint) *big.Int {
+	return new(big.Int).Mul(big.NewInt(int64(gwei)), big.NewInt(1000000000))
+}
+
+// settingReader reads settings from the command line or, after a reload,
+// from runtimeSettings.
+type settingReader interface {
+	Uint(name string) uint
+	Bool(name string) bool
+}
+
+func buildFeePolicy(c settingReader) *geth.FeePolicy {
+	var gasPrice *big.Int
+	if c.Uint("gasprice") != 0 {
+		gasPrice = gweiToWei(c.Uint("gasprice"))
+	}
+	return &geth.FeePolicy{
+		GasPrice:     gasPrice,
+		MaxFeePerGas: gweiToWei(c.Uint("max-fee")),
+		PriorityFee:  gweiToWei(c.Uint("priority-fee")),
+		BumpPercent:  int(c.Uint("gas-bump")),
+		Legacy:       c.Bool("legacy-tx"),
+	}
+}
+
+// parseIPList parses comma separated IPs and CIDR ranges.
+func parseIPList(list string) ([]*net.IPNet, error) {
+	result := []*net.IPNet{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+func setupLogging(c *cli.Context) error {
+	level, levels, err := smartpool.ParseLevels(c.String("log-level"))
+	if err != nil {
+		return err
+	}
+	format := c.String("log-format")
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unsupported log format %s", format)
+	}
+	return smartpool.SetupLogging(&smartpool.LogConfig{
+		Dir:      c.String("log-dir"),
+		JSON:     format == "json",
+		MaxSize:  int64(c.Uint("log-max-size")) * 1024 * 1024,
+		MaxFiles: int(c.Uint("log-max-files")),
+		Level:    level,
+		Levels:   levels,
+	})
+}
+
 func Run(c *cli.Context) error {
+	fromCLI, err := applyConfig(c)
+	if err != nil {
+		fmt.Printf("Couldn't load config: %s\n", err)
+		return err
+	}
 	input := Initialize(c)
-	if input.KeystorePath() == "" {
-		fmt.Printf("You have to specify keystore path by --keystore. Abort!\n")
+	if input.KeystorePath() == "" && c.String("signer") == "" {
+		fmt.Printf("You have to specify keystore path by --keystore or an external signer by --signer. Abort!\n")
 		return nil
 	}
 	gateway := common.HexToAddress(c.String("gateway"))
@@ -66,156 +145,253 @@ func Run(c *cli.Context) error {
 		fmt.Printf("Gateway address %s is invalid.\n", c.String("gateway"))
 		return nil
 	}
-	gasprice := c.Uint("gasprice")
-	smartpool.Output = smartpool.NewLog()
-	fileStorage := storage.NewGobFileStorage()
-	ethereumWorkPool := ethereum.NewWorkPool(fileStorage)
-	go ethereumWorkPool.RunCleaner()
-	address, ok, addresses := geth.GetAddress(
-		input.KeystorePath(),
-		common.HexToAddress(input.MinerAddress()),
-	)
-	if len(addresses) == 0 {
-		fmt.Printf("We couldn't find any private keys in your keystore path.\n")
-		fmt.Printf("Please make sure your keystore path exists.\nAbort!\n")
-		return nil
+	feePolicy := buildFeePolicy(c)
+	if c.String("storage-dir") != "" {
+		storage.SmartPoolDir = c.String("storage-dir")
 	}
-	fmt.Printf("Using miner address: %s\n", address.Hex())
-	input.SetMinerAddress(address)
-	input.SetExtraData(ethereum.BuildExtraData(
-		common.HexToAddress(input.MinerAddress()),
-		input.ShareDifficulty()))
-	gethRPC, _ := geth.NewGethRPC(
-		input.RPCEndpoint(), input.ContractAddress(),
-		input.ExtraData(), input.ShareDifficulty(),
-		input.MinerAddress(),
-	)
-	client, err := gethRPC.ClientVersion()
+	if c.String("proof-server") != "" {
+		ethereum.DagProofs = ethereum.NewRemoteProofProvider(c.String("proof-server"))
+	}
+	var proofs *ethereum.LocalProofProvider
+	if c.Bool("serve-proofs") {
+		proofs = ethereum.LocalDagProofs
+	}
+	restorePolicy, err := ethereum.ParseRestoreConflictPolicy(c.String("on-restore-conflict"))
 	if err != nil {
-		fmt.Printf("Node RPC server is unavailable.\n")
-		fmt.Printf("Make sure you have Geth or Parity installed. If you do, you can:\n")
-		fmt.Printf("Run Geth by following command:\n")
-		fmt.Printf("geth --testnet --rpc --rpcapi \"db,eth,net,web3,miner\"\n")
-		fmt.Printf("Or run Parity by following command:\n")
-		fmt.Printf("parity --chain ropsten --jsonrpc-apis \"web3,eth,net,parity,traces,rpc,parity_set\"\n")
+		fmt.Printf("Invalid --on-restore-conflict: %s\n", err)
 		return err
 	}
-	fmt.Printf("Connected to Ethereum node: %s\n", client)
-	ethereumNetworkClient := ethereum.NewNetworkClient(
-		gethRPC,
-		ethereumWorkPool,
-	)
+	switch c.String("claim-repo") {
+	case "timestamp":
+	case "counter-window":
+		if len(input.ShareDifficultyTiers()) > 1 {
+			fmt.Printf("--claim-repo counter-window doesn't support variable share difficulty. Abort!\n")
+			return errors.New("counter-window claim repo with difficulty tiers")
+		}
+	default:
+		fmt.Printf("Invalid --claim-repo: %s (expected timestamp or counter-window)\n", c.String("claim-repo"))
+		return errors.New("invalid claim repo")
+	}
+	for _, name := range []string{"rig-allow", "rig-deny"} {
+		if _, err = parseIPList(c.String(name)); err != nil {
+			fmt.Printf("Invalid --%s: %s\n", name, err)
+			return err
+		}
+	}
+	stat.BanDuration = c.Duration("ban-duration")
+	if err = setupLogging(c); err != nil {
+		fmt.Printf("Couldn't set up logging: %s\n", err)
+		return err
+	}
+	smartpool.Output = smartpool.NewLogger("main")
+	fileStorage, err := storage.NewPersistentStorage(c.String("storage"))
+	if err != nil {
+		fmt.Printf("Couldn't open %s storage: %s\n", c.String("storage"), err)
+		return err
+	}
+	miners, err := parseMiners(c.String("miners"), c.String("miners-rpc"), input.RPCEndpoint())
+	if err != nil {
+		fmt.Printf("Invalid --miners: %s\n", err)
+		return err
+	}
+	if len(miners) > 0 && c.String("miner") != "" {
+		fmt.Printf("Use either --miner or --miners. Abort!\n")
+		return errors.New("both --miner and --miners are set")
+	}
+	if len(miners) == 0 {
+		address, _, _, _ := lookupAccount(c, common.HexToAddress(input.MinerAddress()))
+		miners = []*minerIdentity{{
+			name: address.Hex(), address: address, rpc: input.RPCEndpoint(),
+		}}
+	}
+	for _, miner := range miners {
+		address, ok, addresses, err := lookupAccount(c, miner.address)
+		if err != nil {
+			fmt.Printf("Couldn't list accounts of the external signer: %s\n", err)
+			return err
+		}
+		if len(addresses) == 0 {
+			fmt.Printf("We couldn't find any private keys in your keystore path or external signer.\n")
+			fmt.Printf("Please make sure your keystore path exists.\nAbort!\n")
+			return nil
+		}
+		if !ok {
+			reportMissingKey(input.KeystorePath(), miner.address, addresses)
+			return nil
+		}
+		fmt.Printf("Using miner address: %s\n", address.Hex())
+		if err = miner.connect(input); err != nil {
+			return err
+		}
+	}
 	ethereumPoolMonitor, err := geth.NewPoolMonitor(
 		gateway,
 		common.HexToAddress(input.ContractAddress()),
 		smartpool.VERSION,
-		input.RPCEndpoint(),
+		miners[0].input.RPCEndpoint(),
 	)
-	contractAddr := ethereumPoolMonitor.ContractAddress()
 	if err != nil {
 		fmt.Printf("Couln't connect to gateway.\n")
 		return err
 	}
+	contractAddr := ethereumPoolMonitor.ContractAddress()
 	if contractAddr.Big().Cmp(common.Big0) == 0 {
 		fmt.Printf("Couldn't get SmartPool contract address from gateway.\n")
 		return errors.New("Contract address is not set on the gateway")
 	}
-	var gethContractClient *geth.GethContractClient
-	for {
-		if ok {
-			if c.String("pass") != "" {
-				path := c.String("pass")
-				passbytes, err := ioutil.ReadFile(path)
-				if err != nil {
-					fmt.Printf("Couldn't read your passphrase file. Abort!\n")
-					return nil
-				}
-				passphrase := strings.TrimSpace(string(passbytes))
-				gethContractClient, err = geth.NewGethContractClient(
-					common.HexToAddress(input.ContractAddress()), gethRPC,
-					common.HexToAddress(input.MinerAddress()),
-					input.RPCEndpoint(), input.KeystorePath(), passphrase,
-					uint64(gasprice),
-				)
-				if gethContractClient != nil {
-					break
-				} else {
-					fmt.Printf("error: %s\n", err)
-					return nil
-				}
-			} else {
-				passphrase, _ := promptUserPassPhrase(
-					input.MinerAddress(),
-				)
-				gethContractClient, err = geth.NewGethContractClient(
-					common.HexToAddress(input.ContractAddress()), gethRPC,
-					common.HexToAddress(input.MinerAddress()),
-					input.RPCEndpoint(), input.KeystorePath(), passphrase,
-					uint64(gasprice),
-				)
-				if gethContractClient != nil {
-					break
-				} else {
-					fmt.Printf("error: %s\n", err)
-				}
-			}
-		} else {
-			if input.KeystorePath() == "" {
-				fmt.Printf("You have to specify keystore path by --keystore. Abort!\n")
-			} else {
-				fmt.Printf("Your keystore: %s\n", input.KeystorePath())
-				fmt.Printf("Your miner address: %s\n", input.MinerAddress())
-				if len(addresses) > 0 {
-					fmt.Printf("We couldn't find the private key of your miner address in the keystore path you specified. We found following addresses:\n")
-					for i, addr := range addresses {
-						fmt.Printf("%d. %s\n", i+1, addr.Hex())
-					}
-					fmt.Printf("Please make sure you entered correct miner address.\n")
-				} else {
-					fmt.Printf("We couldn't find any private keys in your keystore path.\n")
-					fmt.Printf("Please make sure your keystore path exists.\nAbort!\n")
-				}
-			}
-			return nil
+	for i, miner := range miners {
+		// the first miner keeps its state where a client of a single miner
+		// does so miners can be added to a running client
+		var ps smartpool.PersistentStorage = fileStorage
+		if i > 0 {
+			ps = storage.NewNamespaceStorage(fileStorage, miner.address.Hex())
 		}
+		if err = miner.start(c, ps, ethereumPoolMonitor, feePolicy, restorePolicy); err != nil {
+			return err
+		}
+		ethminer.Miners[miner.name] = miner.pool
 	}
-	statRecorder := stat.NewStatRecorder(fileStorage)
-	ethereumClaimRepo := ethereum.NewTimestampClaimRepo(
-		input.ShareDifficulty(),
-		common.HexToAddress(input.MinerAddress()).Hex(),
-		common.HexToAddress(input.ContractAddress()).Hex(),
-		fileStorage,
-	)
-	statRecorder.ShareRestored(ethereumClaimRepo.NoActiveShares())
-	ethereumContract := ethereum.NewContract(
-		gethContractClient, common.HexToAddress(input.MinerAddress()))
-	ethminer.SmartPool = protocol.NewSmartPool(
-		ethereumPoolMonitor, ethereumWorkPool, ethereumNetworkClient,
-		ethereumClaimRepo, fileStorage, ethereumContract, statRecorder,
-		common.HexToAddress(input.ContractAddress()),
-		common.HexToAddress(input.MinerAddress()),
-		input.ExtraData(), input.SubmitInterval(),
-		input.ShareThreshold(), input.ClaimThreshold(), input.HotStop(), input,
-	)
+	ethminer.SmartPool = miners[0].pool
+	go watchConfig(c, fromCLI, newRuntimeSettings(c), miners, feePolicy)
 	server := ethminer.NewServer(
-		smartpool.Output,
-		uint16(1633),
+		smartpool.NewLogger("ethminer"),
+		c.String("bind"),
+		uint16(c.Uint("port")),
+		uint16(c.Uint("stratum-port")),
+		c.String("admin-token"),
+		feePolicy,
+		proofs,
 	)
 	server.Start()
 	return nil
 }
 
+// feeFlags set fees of txs sent to the SmartPool and Ethash contracts.
+var feeFlags = []cli.Flag{
+	cli.UintFlag{
+		Name:  "gasprice",
+		Value: 10,
+		Usage: "Gas price in gwei of legacy txs sent to the contract. Specify 0 if you let your Ethereum Client decide on gas price.",
+	},
+	cli.UintFlag{
+		Name:  "max-fee",
+		Value: 60,
+		Usage: "Max fee per gas in gwei any tx sent to the contract or its replacement can pay.",
+	},
+	cli.UintFlag{
+		Name:  "priority-fee",
+		Value: 1,
+		Usage: "Min priority fee per gas in gwei of EIP-1559 txs.",
+	},
+	cli.UintFlag{
+		Name:  "gas-bump",
+		Value: 10,
+		Usage: "Percent fees are raised by when a tx is not mined in time and is replaced. Nodes reject replacements raised less than 10%.",
+	},
+	cli.BoolFlag{
+		Name:  "legacy-tx",
+		Usage: "Send legacy txs even if the network supports EIP-1559.",
+	},
+}
+
+// rigPolicyFlags set how rigs misbehaving or calling the miner facing
+// servers too often are banned and throttled.
+var rigPolicyFlags = []cli.Flag{
+	cli.DurationFlag{
+		Name:  "ban-duration",
+		Value: 30 * time.Minute,
+		Usage: "How long a rig or an IP is banned after submitting too many invalid or duplicated shares.",
+	},
+	cli.UintFlag{
+		Name:  "rig-rate-limit",
+		Value: 20,
+		Usage: "Requests per second each rig can make to the miner facing servers. Specify 0 for no limit.",
+	},
+	cli.UintFlag{
+		Name:  "ip-rate-limit",
+		Usage: "Requests per second all rigs behind one IP can make to the miner facing servers together. Specify 0 for no limit.",
+	},
+	cli.StringFlag{
+		Name:  "rig-allow",
+		Usage: "Comma separated IPs or CIDR ranges of rigs that are never banned nor throttled, e.g. \"10.0.0.5,192.168.1.0/24\".",
+	},
+	cli.StringFlag{
+		Name:  "rig-deny",
+		Usage: "Comma separated IPs or CIDR ranges of rigs whose requests are always refused.",
+	},
+}
+
+// logFlags set verbosity, format and rotation of smartpool.log.
+var logFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "log-dir",
+		Value: ".",
+		Usage: "Directory to write smartpool.log to. Specify an empty string to only log to stdout.",
+	},
+	cli.StringFlag{
+		Name:  "log-format",
+		Value: "text",
+		Usage: "Format of smartpool.log, \"text\" or \"json\". Stdout is always text.",
+	},
+	cli.StringFlag{
+		Name:  "log-level",
+		Value: "info",
+		Usage: "Log verbosity: debug, info, warn or error. Verbosity of subsystems (protocol, geth, stat, ethminer, ethereum, storage) can be set separately, e.g. \"info,protocol=debug,geth=warn\".",
+	},
+	cli.UintFlag{
+		Name:  "log-max-size",
+		Value: 100,
+		Usage: "Size in MB smartpool.log is rotated at. Specify 0 to never rotate.",
+	},
+	cli.UintFlag{
+		Name:  "log-max-files",
+		Value: 5,
+		Usage: "Number of rotated log files to keep.",
+	},
+}
+
+func joinFlags(groups ...[]cli.Flag) []cli.Flag {
+	result := []cli.Flag{}
+	for _, group := range groups {
+		result = append(result, group...)
+	}
+	return result
+}
+
 func BuildAppCommandLine() *cli.App {
 	app := cli.NewApp()
 	app.Description = "Efficient Decentralized Mining Pools for Existing Cryptocurrencies Based on Ethereum Smart Contracts"
 	app.Name = "SmartPool commandline tool"
 	app.Usage = "SmartPool client for ropsten ethereum chain"
 	app.Version = smartpool.VERSION
-	app.Flags = []cli.Flag{
+	app.Flags = joinFlags([]cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "Path to a TOML config file. Keys are names of these options, e.g. share-threshold = 140. Options given on the command line override environment variables like SMARTPOOL_SHARE_THRESHOLD which override the file. Thresholds, fee options and log level are reloaded on SIGHUP.",
+		},
+		cli.StringFlag{
+			Name:  "bind",
+			Value: "0.0.0.0",
+			Usage: "Address the miner facing servers listen on.",
+		},
+		cli.UintFlag{
+			Name:  "port",
+			Value: 1633,
+			Usage: "Port miners connect to with getwork.",
+		},
+		cli.DurationFlag{
+			Name:  "submit-interval",
+			Value: 1 * time.Minute,
+			Usage: "How often SmartPool checks whether there are enough shares to submit a claim.",
+		},
+		cli.StringFlag{
+			Name:  "storage-dir",
+			Usage: "Directory SmartPool keeps its state in. (Default: ~/.smartpool)",
+		},
 		cli.StringFlag{
 			Name:  "rpc",
 			Value: "http://localhost:8545",
-			Usage: "RPC endpoint of Ethereum node",
+			Usage: "RPC endpoint of Ethereum node. Use comma separated endpoints to fail over between several nodes. With a WebSocket URL (ws://localhost:8546) or an IPC path, work is fetched when the node announces a new head or pending block instead of being polled every 50ms",
 		},
 		cli.StringFlag{
 			Name:  "keystore",
@@ -237,10 +413,11 @@ func BuildAppCommandLine() *cli.App {
 			Usage: "Difficulty of a share.",
 		},
 		cli.UintFlag{
-			Name:  "gasprice",
-			Value: 10,
-			Usage: "Gas price in gwei to use in communication with the contract. Specify 0 if you let your Ethereum Client decide on gas price.",
+			Name:  "max-diff",
+			Value: 0,
+			Usage: "Maximum difficulty of a share. If it is at least twice --diff, each rig gets a share difficulty that fits its hashrate, doubling from --diff up to this value. Specify 0 to use --diff for every rig.",
 		},
+	}, feeFlags, []cli.Flag{
 		cli.StringFlag{
 			Name:  "spcontract",
 			Value: "0x893DC419776635F8FD1b1fa9934BF529aeF25607",
@@ -255,17 +432,71 @@ func BuildAppCommandLine() *cli.App {
 			Name:  "miner",
 			Usage: "The address that would be paid by SmartPool. This is often your address. (Default: First account in your keystore.)",
 		},
+		cli.StringFlag{
+			Name:  "miners",
+			Usage: "Host several miners in one client, e.g. \"unit-a=0x...,unit-b=0x...\". Each miner has its own account in --keystore, claims, counter and stats. Rigs mine for a miner at /<name>/<rig>/ and for the first one also at /<rig>/. Use instead of --miner.",
+		},
+		cli.StringFlag{
+			Name:  "miners-rpc",
+			Usage: "RPC endpoints of the miners in --miners, e.g. \"unit-a=http://10.0.0.2:8545,unit-b=http://10.0.0.3:8545\". Repeat a name to fail over between several nodes. A node builds blocks for one miner only so miners can't share nodes. Only one miner can use --rpc.",
+		},
 		cli.StringFlag{
 			Name:  "pass",
 			Value: "",
-			Usage: "Path to passphrase file.",
+			Usage: "Path to passphrase file. It unlocks the accounts of all miners.",
+		},
+		cli.StringFlag{
+			Name:  "signer",
+			Usage: "JSON-RPC endpoint of an external signer like clef, e.g. http://10.0.0.5:8550. Txs are signed by it with accounts it keeps instead of keys in --keystore.",
+		},
+		cli.UintFlag{
+			Name:  "stratum-port",
+			Value: 0,
+			Usage: "Port to serve EthereumStratum/1.0 (NiceHash) miners over TCP. Specify 0 to disable the stratum server.",
+		},
+		cli.StringFlag{
+			Name:  "proof-server",
+			Usage: "URL of a SmartPool client run with --serve-proofs, e.g. http://10.0.0.2:1633. DAG proofs of shares are fetched from it instead of generating the DAG and its merkle tree on this machine.",
+		},
+		cli.BoolFlag{
+			Name:  "serve-proofs",
+			Usage: "Serve DAG proofs at /proof/:epoch to clients run with --proof-server. Only epochs whose DAG merkle tree is already built here are served.",
+		},
+		cli.BoolFlag{
+			Name:  "no-preflight",
+			Usage: "Send claim verifications without replaying the contract's checks locally first. Verifications failing the checks are not sent and a diagnostic bundle is written to the diagnostics directory in --storage-dir.",
+		},
+		cli.BoolFlag{
+			Name:  "verify-mix",
+			Usage: "Verify the mix digest of every solution with ethash's light cache of its epoch. Solutions with a bad mix digest are rejected and rigs submitting 3 of them in 10 minutes are banned for --ban-duration.",
+		},
+		cli.StringFlag{
+			Name:  "admin-token",
+			Usage: "Token authorizing requests to the admin JSON-RPC API at /admin. The API is disabled when it is empty. Prefer SMARTPOOL_ADMIN_TOKEN or the config file so it doesn't show in the process list.",
+		},
+	}, logFlags, rigPolicyFlags, []cli.Flag{
+		cli.StringFlag{
+			Name:  "on-restore-conflict",
+			Value: "abort",
+			Usage: "What to do with shares from last session that were mined for a different miner or difficulty. \"discard\" drops them, \"abort\" stops SmartPool so you can rerun it with the old --miner or --diff, \"archive\" moves them with their claims and counter into a timestamped archive in the storage.",
+		},
+		cli.StringFlag{
+			Name:  "claim-repo",
+			Value: "timestamp",
+			Usage: "How shares are put into claims. \"timestamp\" holds back all shares with the most recent block timestamp so later shares have greater counters. \"counter-window\" claims every share below the smallest counter the works rigs are mining can give and rejects later shares not above the last claim as stale. It doesn't support variable share difficulty.",
+		},
+		cli.StringFlag{
+			Name:  "storage",
+			Value: "gob",
+			Usage: "Storage backend to persist shares, claims and stats. \"gob\" keeps one gob file per item in ~/.smartpool. \"bolt\" keeps all of them in an embedded BoltDB database and commits related items atomically. Gob files are migrated to the database on the first run with \"bolt\".",
 		},
 		cli.BoolFlag{
 			Name:  "no-hot-stop",
 			Usage: "If hot-stop is true, SmartPool will stop running once it got an error returned from the Contract",
 		},
-	}
+	})
 	app.Action = Run
+	app.Commands = []cli.Command{epochSeederCommand(), restoreCommand()}
 	return app
 }
 
@@ -13,6 +13,7 @@ mustrun build/env.sh go get -v github.com/bmizerany/pat
 mustrun build/env.sh go get -v github.com/mitchellh/go-homedir
 mustrun build/env.sh go get -v golang.org/x/net/context
 mustrun build/env.sh go get -v github.com/gorilla/websocket
+mustrun build/env.sh go get -v go.etcd.io/bbolt
 mustrun build/env.sh go get -v github.com/ethereum/go-ethereum
 echo "Compiling SmartPool client..."
 mustrun build/env.sh go build -ldflags -s -o smartpool cmd/ropsten/ropsten.go
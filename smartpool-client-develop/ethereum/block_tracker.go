@@ -0,0 +1,278 @@
+package ethereum
+
+import (
+	"github.com/SmartPool/smartpool-client"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const BLOCK_TRACKER_FILE string = "block_tracker"
+
+// Outcomes of a block candidate submitted to the node.
+const (
+	BlockPending   = "pending"
+	BlockCanonical = "canonical"
+	BlockUncle     = "uncle"
+	BlockLost      = "lost"
+)
+
+// BLOCK_MATURITY is how many blocks the chain head has to be ahead of a
+// block candidate before it is classified. It must be greater than
+// MAX_UNCLE_DEPTH so every block that can include it as an uncle is mined.
+var BLOCK_MATURITY uint64 = 12
+
+// BLOCK_TRACK_INTERVAL is how often the chain head is checked for matured
+// block candidates.
+var BLOCK_TRACK_INTERVAL = 30 * time.Second
+
+// Static rewards in wei of a block before Byzantium, from Byzantium and
+// from Constantinople.
+var (
+	FRONTIER_BLOCK_REWARD       = big.NewInt(5e+18)
+	BYZANTIUM_BLOCK_REWARD      = big.NewInt(3e+18)
+	CONSTANTINOPLE_BLOCK_REWARD = big.NewInt(2e+18)
+)
+
+// BlockRewards is when the static block reward of a chain changes. A nil
+// fork block means the fork is not scheduled.
+type BlockRewards struct {
+	ByzantiumBlock      *big.Int
+	ConstantinopleBlock *big.Int
+}
+
+var (
+	MainnetBlockRewards = &BlockRewards{big.NewInt(4370000), big.NewInt(7280000)}
+	RopstenBlockRewards = &BlockRewards{big.NewInt(1700000), big.NewInt(4230000)}
+	// private chains usually activate every fork from genesis
+	GenesisBlockRewards = &BlockRewards{big.NewInt(0), big.NewInt(0)}
+)
+
+// BlockRewardsOf returns the block rewards of the chain with chainID.
+func BlockRewardsOf(chainID *big.Int) *BlockRewards {
+	switch chainID.Uint64() {
+	case 1:
+		return MainnetBlockRewards
+	case 3:
+		return RopstenBlockRewards
+	}
+	return GenesisBlockRewards
+}
+
+func forked(fork *big.Int, number uint64) bool {
+	return fork != nil && fork.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// Reward returns the static reward of the block at number. Uncles are
+// rewarded by the reward of the block including them.
+func (r *BlockRewards) Reward(number uint64) *big.Int {
+	if forked(r.ConstantinopleBlock, number) {
+		return new(big.Int).Set(CONSTANTINOPLE_BLOCK_REWARD)
+	}
+	if forked(r.ByzantiumBlock, number) {
+		return new(big.Int).Set(BYZANTIUM_BLOCK_REWARD)
+	}
+	return new(big.Int).Set(FRONTIER_BLOCK_REWARD)
+}
+
+// TrackedBlock is a full block solution the node accepted. Hash is the
+// hash of its header as SmartPool built it until the block is found on the
+// chain. IncludedIn is the number of the block that included it if it is an
+// uncle. Reward is the static reward credited to the coinbase without tx
+// fees.
+type TrackedBlock struct {
+	Hash        common.Hash
+	Number      uint64
+	Nonce       types.BlockNonce
+	MixDigest   common.Hash
+	SubmittedAt time.Time
+	Status      string
+	IncludedIn  uint64
+	Reward      *big.Int
+}
+
+func (b *TrackedBlock) matches(cb *ChainBlock) bool {
+	return cb.Nonce == b.Nonce && cb.MixDigest == b.MixDigest
+}
+
+// BlockRecorder records outcomes of tracked blocks.
+type BlockRecorder interface {
+	RecordBlock(status string, reward *big.Int)
+}
+
+// BlockTracker follows full block solutions submitted to the node until
+// they mature and then classifies them as canonical, uncle or lost. Blocks
+// are matched by their nonce and mix digest so they are found even if the
+// node built a different header than SmartPool.
+type BlockTracker struct {
+	mu       sync.Mutex
+	checkMu  sync.Mutex
+	reader   BlockReader
+	recorder BlockRecorder
+	storage  smartpool.PersistentStorage
+	rewards  *BlockRewards
+	pending  []*TrackedBlock
+}
+
+func NewBlockTracker(reader BlockReader, recorder BlockRecorder, storage smartpool.PersistentStorage, rewards *BlockRewards) *BlockTracker {
+	pending := []*TrackedBlock{}
+	loaded, err := storage.Load(&pending, BLOCK_TRACKER_FILE)
+	if tracked, ok := loaded.(*[]*TrackedBlock); err == nil && ok && tracked != nil {
+		pending = *tracked
+		logger.Infof("Loaded %d tracked blocks from last session.\n", len(pending))
+	}
+	return &BlockTracker{
+		sync.Mutex{}, sync.Mutex{}, reader, recorder, storage, rewards, pending,
+	}
+}
+
+// Track starts tracking the block of w solved by s.
+func (bt *BlockTracker) Track(w *Work, s *Solution) {
+	header := types.CopyHeader(w.BlockHeader)
+	header.Nonce = s.Nonce
+	header.MixDigest = s.MixDigest
+	block := &TrackedBlock{
+		Hash:        header.Hash(),
+		Number:      header.Number.Uint64(),
+		Nonce:       s.Nonce,
+		MixDigest:   s.MixDigest,
+		SubmittedAt: time.Now(),
+		Status:      BlockPending,
+	}
+	logger.Infof("Tracking block candidate %d (%s).\n", block.Number, block.Hash.Hex())
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.pending = append(bt.pending, block)
+	bt.persist()
+}
+
+// Pending returns number of blocks that are not classified yet.
+func (bt *BlockTracker) Pending() int {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return len(bt.pending)
+}
+
+// persist saves pending blocks. The caller must hold bt.mu.
+func (bt *BlockTracker) persist() {
+	if err := bt.storage.Persist(&bt.pending, BLOCK_TRACKER_FILE); err != nil {
+		logger.Warnf("Couldn't save tracked blocks: %s\n", err)
+	}
+}
+
+// classify looks for block in the canonical chain and in uncles of the
+// blocks that could include it.
+func (bt *BlockTracker) classify(block *TrackedBlock) error {
+	number := new(big.Int).SetUint64(block.Number)
+	canonical, err := bt.reader.BlockByNumber(number)
+	if err != nil {
+		return err
+	}
+	if block.matches(canonical) {
+		block.Status = BlockCanonical
+		block.Hash = canonical.Hash
+		block.Reward = bt.rewards.Reward(block.Number)
+		inclusion := new(big.Int).Div(block.Reward, big.NewInt(32))
+		inclusion.Mul(inclusion, big.NewInt(int64(len(canonical.Uncles))))
+		block.Reward.Add(block.Reward, inclusion)
+		return nil
+	}
+	for depth := uint64(1); depth <= MAX_UNCLE_DEPTH; depth++ {
+		nephew, err := bt.reader.BlockByNumber(new(big.Int).SetUint64(block.Number + depth))
+		if err != nil {
+			return err
+		}
+		for i := range nephew.Uncles {
+			uncle, err := bt.reader.UncleByBlockNumber(nephew.Number, i)
+			if err != nil {
+				return err
+			}
+			if block.matches(uncle) {
+				block.Status = BlockUncle
+				block.Hash = uncle.Hash
+				block.IncludedIn = block.Number + depth
+				block.Reward = bt.rewards.Reward(block.Number + depth)
+				block.Reward.Mul(block.Reward, new(big.Int).SetUint64(8-depth))
+				block.Reward.Div(block.Reward, big.NewInt(8))
+				return nil
+			}
+		}
+	}
+	block.Status = BlockLost
+	block.Reward = big.NewInt(0)
+	return nil
+}
+
+// Check classifies pending blocks that have matured. Blocks that couldn't
+// be read from the node are checked again next time. The node is read
+// without holding bt.mu so blocks can be tracked in the meantime.
+func (bt *BlockTracker) Check() {
+	bt.checkMu.Lock()
+	defer bt.checkMu.Unlock()
+	bt.mu.Lock()
+	pending := make([]*TrackedBlock, len(bt.pending))
+	copy(pending, bt.pending)
+	bt.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	head, err := bt.reader.BlockNumber()
+	if err != nil {
+		logger.Warnf("Couldn't get chain head to check tracked blocks: %s\n", err)
+		return
+	}
+	classified := map[*TrackedBlock]*TrackedBlock{}
+	for _, block := range pending {
+		if head.Uint64() < block.Number+BLOCK_MATURITY {
+			continue
+		}
+		// pending blocks are persisted by Track so they are not changed
+		candidate := *block
+		if err := bt.classify(&candidate); err != nil {
+			logger.Warnf("Couldn't check tracked block %d: %s\n", block.Number, err)
+			continue
+		}
+		classified[block] = &candidate
+	}
+	if len(classified) == 0 {
+		return
+	}
+	bt.mu.Lock()
+	remaining := []*TrackedBlock{}
+	for _, block := range bt.pending {
+		if classified[block] == nil {
+			remaining = append(remaining, block)
+		}
+	}
+	bt.pending = remaining
+	bt.persist()
+	bt.mu.Unlock()
+	for _, original := range pending {
+		block := classified[original]
+		if block == nil {
+			continue
+		}
+		if block.Status == BlockLost {
+			logger.Warnf("Block candidate %d (%s) didn't make it into the chain.\n", block.Number, block.Hash.Hex())
+		} else {
+			logger.Infof("Block candidate %d (%s) is %s, rewarded %s wei.\n", block.Number, block.Hash.Hex(), block.Status, block.Reward)
+		}
+		logger.Emit(smartpool.BlockOutcomeEvent, smartpool.Fields{
+			"hash":        block.Hash.Hex(),
+			"number":      block.Number,
+			"status":      block.Status,
+			"included_in": block.IncludedIn,
+			"reward":      block.Reward.Text(10),
+		})
+		bt.recorder.RecordBlock(block.Status, block.Reward)
+	}
+}
+
+func (bt *BlockTracker) Run() {
+	ticker := time.Tick(BLOCK_TRACK_INTERVAL)
+	for _ = range ticker {
+		bt.Check()
+	}
+}
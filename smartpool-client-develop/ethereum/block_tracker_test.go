@@ -0,0 +1,161 @@
+package ethereum
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testChain is a canonical chain whose blocks are identified by their
+// nonces.
+type testChain struct {
+	head   uint64
+	blocks map[uint64]*ChainBlock
+	uncles map[uint64][]*ChainBlock
+}
+
+func newTestChain(head uint64) *testChain {
+	c := &testChain{head, map[uint64]*ChainBlock{}, map[uint64][]*ChainBlock{}}
+	for n := uint64(0); n <= head; n++ {
+		c.blocks[n] = &ChainBlock{Number: new(big.Int).SetUint64(n), Nonce: types.EncodeNonce(n)}
+	}
+	return c
+}
+
+func (c *testChain) include(number uint64, uncle *ChainBlock) {
+	c.uncles[number] = append(c.uncles[number], uncle)
+	c.blocks[number].Uncles = append(c.blocks[number].Uncles, uncle.Hash)
+}
+
+func (c *testChain) BlockNumber() (*big.Int, error) {
+	return new(big.Int).SetUint64(c.head), nil
+}
+
+func (c *testChain) BlockByNumber(number *big.Int) (*ChainBlock, error) {
+	if block := c.blocks[number.Uint64()]; block != nil {
+		return block, nil
+	}
+	return nil, fmt.Errorf("block %s not found", number)
+}
+
+func (c *testChain) UncleByBlockNumber(number *big.Int, index int) (*ChainBlock, error) {
+	return c.uncles[number.Uint64()][index], nil
+}
+
+type testBlockRecorder struct {
+	statuses []string
+	reward   *big.Int
+}
+
+func (r *testBlockRecorder) RecordBlock(status string, reward *big.Int) {
+	r.statuses = append(r.statuses, status)
+	r.reward.Add(r.reward, reward)
+}
+
+func trackTestBlock(tracker *BlockTracker, number uint64, nonce uint64) {
+	w := newTestWork()
+	w.BlockHeader.Number = new(big.Int).SetUint64(number)
+	tracker.Track(w, &Solution{Nonce: types.EncodeNonce(nonce), Hash: common.HexToHash(w.Hash)})
+}
+
+func TestBlockTrackerClassifiesMaturedBlocks(t *testing.T) {
+	chain := newTestChain(100)
+	recorder := &testBlockRecorder{reward: big.NewInt(0)}
+	tracker := NewBlockTracker(chain, recorder, &testPersistentStorage{}, &BlockRewards{})
+	// block 80 is on the chain and includes an uncle
+	trackTestBlock(tracker, 80, 80)
+	chain.include(80, &ChainBlock{Number: big.NewInt(79), Nonce: types.EncodeNonce(1000)})
+	// our block 82 lost to another one and was included by block 84
+	trackTestBlock(tracker, 82, 2000)
+	chain.include(84, &ChainBlock{Number: big.NewInt(82), Nonce: types.EncodeNonce(2000)})
+	// our block 85 lost and wasn't included
+	trackTestBlock(tracker, 85, 3000)
+	// block 95 is not mature yet
+	trackTestBlock(tracker, 95, 95)
+	tracker.Check()
+	if len(recorder.statuses) != 3 ||
+		recorder.statuses[0] != BlockCanonical ||
+		recorder.statuses[1] != BlockUncle ||
+		recorder.statuses[2] != BlockLost {
+		t.Fatalf("expected canonical, uncle and lost blocks, got %v", recorder.statuses)
+	}
+	// 5 + 5/32 for the included uncle + 5*6/8 for the uncle of depth 2
+	expected, _ := new(big.Int).SetString("8906250000000000000", 10)
+	if recorder.reward.Cmp(expected) != 0 {
+		t.Fatalf("expected rewards of %s, got %s", expected, recorder.reward)
+	}
+	if tracker.Pending() != 1 {
+		t.Fatalf("expected immature block to be still pending")
+	}
+}
+
+func TestBlockRewardsChangeAtForks(t *testing.T) {
+	rewards := &BlockRewards{big.NewInt(90), big.NewInt(100)}
+	for number, expected := range map[uint64]*big.Int{
+		89: FRONTIER_BLOCK_REWARD, 90: BYZANTIUM_BLOCK_REWARD,
+		99: BYZANTIUM_BLOCK_REWARD, 100: CONSTANTINOPLE_BLOCK_REWARD,
+	} {
+		if reward := rewards.Reward(number); reward.Cmp(expected) != 0 {
+			t.Fatalf("expected reward of block %d to be %s, got %s", number, expected, reward)
+		}
+	}
+	if BlockRewardsOf(big.NewInt(3)) != RopstenBlockRewards {
+		t.Fatalf("expected rewards of Ropsten")
+	}
+}
+
+func TestBlockTrackerRewardsBlocksAfterForks(t *testing.T) {
+	chain := newTestChain(120)
+	recorder := &testBlockRecorder{reward: big.NewInt(0)}
+	tracker := NewBlockTracker(
+		chain, recorder, &testPersistentStorage{},
+		&BlockRewards{big.NewInt(80), big.NewInt(84)})
+	// block 80 is on the chain after Byzantium
+	trackTestBlock(tracker, 80, 80)
+	// our block 82 was included by block 84 after Constantinople
+	trackTestBlock(tracker, 82, 2000)
+	chain.include(84, &ChainBlock{Number: big.NewInt(82), Nonce: types.EncodeNonce(2000)})
+	tracker.Check()
+	// 3 + 2*6/8 for the uncle of depth 2
+	expected, _ := new(big.Int).SetString("4500000000000000000", 10)
+	if recorder.reward.Cmp(expected) != 0 {
+		t.Fatalf("expected rewards of %s, got %s", expected, recorder.reward)
+	}
+}
+
+// lockingChain tracks a block while the tracker reads the chain.
+type lockingChain struct {
+	*testChain
+	tracker *BlockTracker
+	tracked chan bool
+}
+
+func (c *lockingChain) BlockByNumber(number *big.Int) (*ChainBlock, error) {
+	go func() {
+		trackTestBlock(c.tracker, 110, 110)
+		c.tracked <- true
+	}()
+	select {
+	case <-c.tracked:
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("tracking a block is blocked by checking")
+	}
+	return c.testChain.BlockByNumber(number)
+}
+
+func TestBlockTrackerTracksBlocksWhileChecking(t *testing.T) {
+	chain := &lockingChain{newTestChain(100), nil, make(chan bool)}
+	recorder := &testBlockRecorder{reward: big.NewInt(0)}
+	chain.tracker = NewBlockTracker(chain, recorder, &testPersistentStorage{}, &BlockRewards{})
+	trackTestBlock(chain.tracker, 80, 80)
+	chain.tracker.Check()
+	if len(recorder.statuses) != 1 || recorder.statuses[0] != BlockCanonical {
+		t.Fatalf("expected the block to be classified, got %v", recorder.statuses)
+	}
+	if chain.tracker.Pending() != 1 {
+		t.Fatalf("expected the block tracked while checking to be pending")
+	}
+}
@@ -0,0 +1,397 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum/ethash"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// number of dataset elements hashimoto looks up
+	dagLookups = 64
+	// number of uint256 a dataset element is sent as
+	wordsPerElement = 4
+)
+
+var (
+	extraDataPrefix = "SmartPool-"
+	lower128Bits    = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 128), common.Big1)
+)
+
+// contractClaim is a claim as the contract keeps it.
+type contractClaim struct {
+	numShares  *big.Int
+	difficulty *big.Int
+	min        *big.Int
+	max        *big.Int
+	augMerkle  *big.Int
+}
+
+// EpochData is the data of an epoch the Ethash contract verifies DAG
+// elements with. BranchDepth is the number of levels of DAG element
+// branches below the stored level. MerkleNodes are the stored level nodes
+// packed in pairs.
+type EpochData struct {
+	FullSizeIn128Resolution uint64
+	BranchDepth             uint64
+	MerkleNodes             []*big.Int
+}
+
+// headerWithoutNonce is the header rlp Share.RlpHeaderWithoutNonce encodes.
+type headerWithoutNonce struct {
+	ParentHash  common.Hash
+	UncleHash   common.Hash
+	Coinbase    common.Address
+	Root        common.Hash
+	TxHash      common.Hash
+	ReceiptHash common.Hash
+	Bloom       types.Bloom
+	Difficulty  *big.Int
+	Number      *big.Int
+	GasLimit    *big.Int
+	GasUsed     *big.Int
+	Time        *big.Int
+	Extra       []byte
+}
+
+// augNode is a node of the augmented merkle tree of a claim.
+type augNode struct {
+	min  *big.Int
+	max  *big.Int
+	hash smartpool.SPHash
+}
+
+// verifyExtraData checks the extra data is SmartPool- followed by base 62
+// miner id and claim difficulty as BuildExtraData builds it.
+func verifyExtraData(extra []byte, id *big.Int, difficulty *big.Int) *ContractError {
+	idEnd := len(extraDataPrefix) + 11
+	if len(extra) <= idEnd || !bytes.HasPrefix(extra, []byte(extraDataPrefix)) {
+		return NewContractError(0x84000004, smartpool.BytesToBig(extra))
+	}
+	extraID, ok := smartpool.Base62ToBig(string(extra[len(extraDataPrefix):idEnd]))
+	if !ok {
+		return NewContractError(0x84000004, smartpool.BytesToBig(extra))
+	}
+	if extraID.Cmp(id) != 0 {
+		return NewContractError(0x83000000, extraID)
+	}
+	extraDiff, ok := smartpool.Base62ToBig(string(extra[idEnd:]))
+	if !ok {
+		return NewContractError(0x84000004, smartpool.BytesToBig(extra))
+	}
+	if extraDiff.Cmp(difficulty) != 0 {
+		return NewContractError(0x83000001, extraDiff)
+	}
+	return nil
+}
+
+func augHash(left, right augNode) smartpool.SPHash {
+	counterBytes := func(n augNode) []byte {
+		return append(
+			common.LeftPadBytes(n.max.Bytes(), 16),
+			common.LeftPadBytes(n.min.Bytes(), 16)...)
+	}
+	keccak := crypto.Keccak256(
+		counterBytes(left), common.LeftPadBytes(left.hash[:], 32),
+		counterBytes(right), common.LeftPadBytes(right.hash[:], 32),
+	)
+	result := smartpool.SPHash{}
+	copy(result[:], keccak[smartpool.HashLength:])
+	return result
+}
+
+// verifyAugBranch climbs from the share's leaf to the root of the augmented
+// merkle tree. Each counters element is the sibling's max and min in 16
+// bytes each, each hashes element is the sibling's hash. Counters must be
+// strictly increasing from left to right so a share can't be claimed twice.
+func verifyAugBranch(leaf augNode, index *big.Int, counters, hashes []*big.Int, claim *contractClaim) bool {
+	if len(counters) != len(hashes) {
+		return false
+	}
+	node := leaf
+	for i := range counters {
+		sibling := augNode{
+			new(big.Int).And(counters[i], lower128Bits),
+			new(big.Int).Rsh(counters[i], 128),
+			smartpool.SPHash{},
+		}
+		copy(sibling.hash[:], common.LeftPadBytes(hashes[i].Bytes(), 32)[smartpool.HashLength:])
+		left, right := node, sibling
+		if index.Bit(i) == 1 {
+			left, right = sibling, node
+		}
+		if left.max.Cmp(right.min) >= 0 {
+			return false
+		}
+		node = augNode{left.min, right.max, augHash(left, right)}
+	}
+	return node.min.Cmp(claim.min) == 0 &&
+		node.max.Cmp(claim.max) == 0 &&
+		node.hash.Big().Cmp(claim.augMerkle) == 0
+}
+
+func dagHash(left, right smartpool.SPHash) smartpool.SPHash {
+	keccak := crypto.Keccak256(
+		common.LeftPadBytes(left[:], 32), common.LeftPadBytes(right[:], 32))
+	result := smartpool.SPHash{}
+	copy(result[:], keccak[smartpool.HashLength:])
+	return result
+}
+
+// halfOf returns the lower (second == false) or higher 16 bytes of a branch
+// element packed by smartpool.BranchElementFromHash.
+func halfOf(element *big.Int, second bool) smartpool.SPHash {
+	raw := common.LeftPadBytes(element.Bytes(), 32)
+	result := smartpool.SPHash{}
+	if second {
+		copy(result[:], raw[:smartpool.HashLength])
+	} else {
+		copy(result[:], raw[smartpool.HashLength:])
+	}
+	return result
+}
+
+func (epoch *EpochData) node(index uint64) *big.Int {
+	if index < uint64(len(epoch.MerkleNodes)) {
+		return epoch.MerkleNodes[index]
+	}
+	return big.NewInt(0)
+}
+
+// verifyDAGBranch climbs from the dataset element at index to the stored
+// merkle node of the epoch. Each witness element packs two levels of the
+// branch.
+func (epoch *EpochData) verifyDAGBranch(leaf smartpool.SPHash, index uint32, witness []*big.Int) bool {
+	node := leaf
+	for level := uint64(0); level < epoch.BranchDepth; level++ {
+		sibling := halfOf(witness[level/2], level%2 == 1)
+		if (index>>level)&1 == 1 {
+			node = dagHash(sibling, node)
+		} else {
+			node = dagHash(node, sibling)
+		}
+	}
+	stored := uint64(index) >> epoch.BranchDepth
+	if stored/2 >= uint64(len(epoch.MerkleNodes)) {
+		return false
+	}
+	return halfOf(epoch.MerkleNodes[stored/2], stored%2 == 1) == node
+}
+
+// hashimoto computes the pow value of the header hash and nonce with the
+// given dataset elements. It returns false if the elements are not the ones
+// hashimoto accesses or their witness doesn't match the epoch data.
+func (epoch *EpochData) hashimoto(hash common.Hash, nonce uint64, dataSetLookup, witnessForLookup []*big.Int) (*big.Int, bool) {
+	witnessLength := int((epoch.BranchDepth + 1) / 2)
+	if len(dataSetLookup) != dagLookups*wordsPerElement ||
+		len(witnessForLookup) != dagLookups*witnessLength {
+		return nil, false
+	}
+	valid := true
+	accessed := 0
+	element := []byte{}
+	lookup := func(index uint32) []uint32 {
+		// hashimoto looks up an element in 2 halves, 2*parent first
+		if index%2 == 0 {
+			words := dataSetLookup[accessed*wordsPerElement : (accessed+1)*wordsPerElement]
+			element = []byte{}
+			hashData := []byte{}
+			for _, w := range words {
+				chunk := common.LeftPadBytes(w.Bytes(), 32)
+				hashData = append(hashData, chunk...)
+				// contract receives each 32 bytes of the element reversed
+				for i := len(chunk) - 1; i >= 0; i-- {
+					element = append(element, chunk[i])
+				}
+			}
+			leaf := smartpool.SPHash{}
+			copy(leaf[:], crypto.Keccak256(hashData)[smartpool.HashLength:])
+			witness := witnessForLookup[accessed*witnessLength : (accessed+1)*witnessLength]
+			if !epoch.verifyDAGBranch(leaf, index/2, witness) {
+				valid = false
+			}
+			accessed++
+		}
+		half := element[index%2*64 : (index%2+1)*64]
+		data := make([]uint32, len(half)/4)
+		for i := range data {
+			data[i] = binary.LittleEndian.Uint32(half[i*4:])
+		}
+		return data
+	}
+	_, result := ethash.HashimotoWithLookup(
+		hash, nonce, epoch.FullSizeIn128Resolution*128, lookup)
+	return new(big.Int).SetBytes(result), valid
+}
+
+// verifyShare replays the checks verifyClaim of the contract does on the
+// share at shareIndex of claim with the calldata of the verification: the
+// share's header must be mined for minerID and contractAddr with a counter
+// in the claim's range, its augmented merkle branch must lead to the
+// claim's root and its DAG elements with their witness must give a pow
+// value below the claim's difficulty. A failed check returns the
+// ContractError the contract would log. A claim of difficulty 0 reverts the
+// contract's verification so it returns a plain error.
+func verifyShare(
+	contractAddr common.Address, minerID *big.Int, claim *contractClaim,
+	epochData func(epoch uint64) (*EpochData, error),
+	rlpHeader []byte, nonce *big.Int, shareIndex *big.Int,
+	dataSetLookup, witnessForLookup []*big.Int,
+	augCountersBranch, augHashesBranch []*big.Int) error {
+	if claim.difficulty == nil || claim.difficulty.Sign() == 0 {
+		// the contract's division by the difficulty reverts the tx
+		return fmt.Errorf("claim difficulty is 0")
+	}
+	header := headerWithoutNonce{}
+	if err := rlp.DecodeBytes(rlpHeader, &header); err != nil {
+		return fmt.Errorf("couldn't decode rlp header: %s", err)
+	}
+	if cerr := verifyExtraData(header.Extra, minerID, claim.difficulty); cerr != nil {
+		return cerr
+	}
+	if header.Coinbase != contractAddr {
+		return NewContractError(0x84000005, header.Coinbase.Big())
+	}
+	counter := new(big.Int).Lsh(header.Time, 64)
+	counter.Add(counter, nonce)
+	if counter.Cmp(claim.min) < 0 {
+		return NewContractError(0x84000006, counter)
+	}
+	if counter.Cmp(claim.max) > 0 {
+		return NewContractError(0x84000007, counter)
+	}
+	hash := crypto.Keccak256Hash(rlpHeader)
+	leaf := augNode{counter, counter, smartpool.SPHash{}}
+	copy(leaf.hash[:], hash[smartpool.HashLength:])
+	if !verifyAugBranch(leaf, shareIndex, augCountersBranch, augHashesBranch, claim) {
+		return NewContractError(0x84000008, big.NewInt(0))
+	}
+	epochNo := header.Number.Uint64() / 30000
+	epoch, err := epochData(epochNo)
+	if err != nil {
+		return err
+	}
+	value, valid := epoch.hashimoto(hash, nonce.Uint64(), dataSetLookup, witnessForLookup)
+	if !valid {
+		return NewContractError(0x84000009, big.NewInt(0))
+	}
+	if value.Cmp(new(big.Int).Div(maxUint256, claim.difficulty)) > 0 {
+		return NewContractError(0x84000009, value)
+	}
+	return nil
+}
+
+// VerifyDiagnostic is the calldata of a claim verification that failed the
+// local checks, with the claim and the error the contract would log.
+type VerifyDiagnostic struct {
+	Time              time.Time      `json:"time"`
+	Error             string         `json:"error"`
+	ErrorCode         string         `json:"error_code"`
+	ErrorInfo         string         `json:"error_info"`
+	Contract          common.Address `json:"contract"`
+	Miner             common.Address `json:"miner"`
+	NumShares         *hexutil.Big   `json:"num_shares"`
+	Difficulty        *hexutil.Big   `json:"difficulty"`
+	Min               *hexutil.Big   `json:"min_counter"`
+	Max               *hexutil.Big   `json:"max_counter"`
+	AugMerkle         *hexutil.Big   `json:"aug_merkle"`
+	SubmissionIndex   *hexutil.Big   `json:"submission_index"`
+	ShareIndex        *hexutil.Big   `json:"share_index"`
+	RlpHeader         hexutil.Bytes  `json:"rlp_header"`
+	Nonce             *hexutil.Big   `json:"nonce"`
+	DataSetLookup     []*hexutil.Big `json:"dataset_lookup"`
+	WitnessForLookup  []*hexutil.Big `json:"witness_for_lookup"`
+	AugCountersBranch []*hexutil.Big `json:"aug_counters_branch"`
+	AugHashesBranch   []*hexutil.Big `json:"aug_hashes_branch"`
+}
+
+func hexBigs(values []*big.Int) []*hexutil.Big {
+	result := []*hexutil.Big{}
+	for _, v := range values {
+		result = append(result, (*hexutil.Big)(v))
+	}
+	return result
+}
+
+// ClaimVerifier checks a claim verification locally before it is sent so
+// a share the contract would reject doesn't cost a tx. Epoch data comes
+// from epochs. When a check fails, a diagnostic bundle is written to
+// bundleDir.
+type ClaimVerifier struct {
+	contractAddr common.Address
+	miner        common.Address
+	epochs       ProofProvider
+	bundleDir    string
+}
+
+// Verify replays the checks of the contract on the calldata of a claim
+// verification. It returns a ContractError if the contract would reject
+// the verification and another error if the checks couldn't be done.
+func (v *ClaimVerifier) Verify(
+	submissionIndex *big.Int, shareIndex *big.Int, claim smartpool.Claim,
+	rlpHeader []byte, nonce *big.Int,
+	dataSetLookup, witnessForLookup []*big.Int,
+	augCountersBranch, augHashesBranch []*big.Int) error {
+	cc := &contractClaim{
+		claim.NumShares(), claim.Difficulty(), claim.Min(), claim.Max(),
+		claim.AugMerkle().Big(),
+	}
+	err := verifyShare(
+		v.contractAddr, minerID(v.miner), cc, v.epochs.EpochData, rlpHeader,
+		nonce, shareIndex, dataSetLookup, witnessForLookup,
+		augCountersBranch, augHashesBranch)
+	cerr, failed := err.(*ContractError)
+	if !failed {
+		return err
+	}
+	diagnostic := &VerifyDiagnostic{
+		time.Now(), cerr.Error(),
+		"0x" + cerr.Code.Text(16), "0x" + cerr.Info.Text(16),
+		v.contractAddr, v.miner,
+		(*hexutil.Big)(cc.numShares), (*hexutil.Big)(cc.difficulty),
+		(*hexutil.Big)(cc.min), (*hexutil.Big)(cc.max),
+		(*hexutil.Big)(cc.augMerkle),
+		(*hexutil.Big)(submissionIndex), (*hexutil.Big)(shareIndex),
+		rlpHeader, (*hexutil.Big)(nonce),
+		hexBigs(dataSetLookup), hexBigs(witnessForLookup),
+		hexBigs(augCountersBranch), hexBigs(augHashesBranch),
+	}
+	if path, werr := v.writeBundle(diagnostic); werr != nil {
+		logger.Warnf("Couldn't write diagnostic bundle: %s\n", werr)
+	} else {
+		logger.Warnf("Claim verification failed local checks. Diagnostic bundle is written to %s\n", path)
+	}
+	return cerr
+}
+
+func (v *ClaimVerifier) writeBundle(diagnostic *VerifyDiagnostic) (string, error) {
+	if err := os.MkdirAll(v.bundleDir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(diagnostic, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(v.bundleDir, fmt.Sprintf(
+		"verify-%s.json", diagnostic.Time.Format("20060102-150405.000000000")))
+	return path, ioutil.WriteFile(path, data, 0600)
+}
+
+func NewClaimVerifier(
+	contractAddr common.Address, miner common.Address, epochs ProofProvider,
+	bundleDir string) *ClaimVerifier {
+	return &ClaimVerifier{contractAddr, miner, epochs, bundleDir}
+}
@@ -0,0 +1,162 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testEpochs struct {
+	data *EpochData
+}
+
+func (te *testEpochs) DagProof(epoch uint64, indices []uint32) (*DagProof, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (te *testEpochs) EpochData(epoch uint64) (*EpochData, error) {
+	return te.data, nil
+}
+
+func TestClaimVerifierRefusesToSendRejectedVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataset := simulatedDataset()
+	sc := newSimulatedContract(t, dataset)
+	branchDepth := len(fmt.Sprintf("%b", len(dataset)-1))
+	epochs := &testEpochs{&EpochData{
+		uint64(len(dataset)), uint64(branchDepth - 10),
+		simulatedDagTree(dataset, []uint32{}).MerkleNodes(),
+	}}
+	verifier := NewClaimVerifier(simulatedContractAddr, simulatedMinerAddr, epochs, dir)
+	contract := NewContract(sc.NewClient(simulatedMinerAddr), simulatedMinerAddr)
+	contract.SetVerifier(verifier)
+	claim := newSimulatedClaim(dataset, 1, 2, 3, 4)
+	if err = contract.SubmitClaim(claim, true); err != nil {
+		t.Fatalf("submit claim failed: %s", err)
+	}
+	claimIndex, shareIndex, _ := contract.GetShareIndex(claim)
+	claim.SetEvidence(shareIndex)
+	share := claim.GetShare(int(shareIndex.Int64())).(*Share)
+	rlpHeader, _ := share.RlpHeaderWithoutNonce()
+	verify := func(nonce *big.Int, counters []*big.Int) error {
+		return verifier.Verify(
+			claimIndex, shareIndex, claim, rlpHeader, nonce,
+			share.DAGElementArray(), share.DAGProofArray(),
+			counters, claim.HashBranch())
+	}
+	if err = verify(share.NonceBig(), claim.CounterBranch()); err != nil {
+		t.Fatalf("valid verification failed local checks: %s", err)
+	}
+	counters := claim.CounterBranch()
+	counters[0] = new(big.Int).Add(counters[0], common.Big1)
+	if errorCode(verify(share.NonceBig(), counters)) != 0x84000008 {
+		t.Fail()
+	}
+	// nonce 9 gives a counter above the claim's max counter
+	if errorCode(verify(big.NewInt(9), claim.CounterBranch())) != 0x84000007 {
+		t.Fail()
+	}
+	// a rejected verification is not sent and its calldata is dumped
+	for i, node := range epochs.data.MerkleNodes {
+		epochs.data.MerkleNodes[i] = new(big.Int).Add(node, common.Big1)
+	}
+	blockNo := sc.BlockNumber()
+	err = contract.VerifyClaim(claimIndex, shareIndex, claim, nil)
+	if errorCode(err) != 0x84000009 || sc.BlockNumber() != blockNo {
+		t.Fatalf("expected verification to be refused, got %v", err)
+	}
+	bundles, _ := filepath.Glob(filepath.Join(dir, "verify-*.json"))
+	if len(bundles) != 3 {
+		t.Fatalf("expected 3 diagnostic bundles, got %d", len(bundles))
+	}
+	raw, _ := ioutil.ReadFile(bundles[0])
+	diagnostic := map[string]interface{}{}
+	if err = json.Unmarshal(raw, &diagnostic); err != nil || diagnostic["rlp_header"] == nil {
+		t.Fail()
+	}
+}
+
+func TestExtraDataRequiresClaimDifficulty(t *testing.T) {
+	id := minerID(simulatedMinerAddr)
+	extra := []byte(BuildExtraData(simulatedMinerAddr, big.NewInt(100000)))
+	if cerr := verifyExtraData(extra, id, big.NewInt(100000)); cerr != nil {
+		t.Fatalf("expected claim of the encoded difficulty to pass, got %s", cerr)
+	}
+	for _, diff := range []int64{50000, 400000} {
+		if cerr := verifyExtraData(extra, id, big.NewInt(diff)); cerr == nil || cerr.Code.Uint64() != 0x83000001 {
+			t.Fatalf("expected claim of difficulty %d to fail with 0x83000001, got %v", diff, cerr)
+		}
+	}
+}
+
+func TestClaimVerifierChecksEpochDataOnContract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataset := simulatedDataset()
+	branchDepth := len(fmt.Sprintf("%b", len(dataset)-1))
+	local := &testEpochs{&EpochData{
+		uint64(len(dataset)), uint64(branchDepth - 10),
+		simulatedDagTree(dataset, []uint32{}).MerkleNodes(),
+	}}
+	// a contract whose epoch data is not set yet
+	sc := NewSimulatedContract(simulatedContractAddr, simulatedOwner)
+	if err = sc.NewClient(simulatedMinerAddr).Register(simulatedMinerAddr); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	verifier := NewClaimVerifier(
+		simulatedContractAddr, simulatedMinerAddr,
+		NewContractEpochs(local, sc.NewClient(simulatedOwner)), dir)
+	contract := NewContract(sc.NewClient(simulatedMinerAddr), simulatedMinerAddr)
+	contract.SetVerifier(verifier)
+	claim := newSimulatedClaim(dataset, 1, 2, 3, 4)
+	if err = contract.SubmitClaim(claim, true); err != nil {
+		t.Fatalf("submit claim failed: %s", err)
+	}
+	claimIndex, shareIndex, _ := contract.GetShareIndex(claim)
+	claim.SetEvidence(shareIndex)
+	blockNo := sc.BlockNumber()
+	err = contract.VerifyClaim(claimIndex, shareIndex, claim, nil)
+	if errorCode(err) != 0x8400000a || sc.BlockNumber() != blockNo {
+		t.Fatalf("expected verification of an unset epoch to be refused, got %v", err)
+	}
+	_, _, err = sc.NewClient(simulatedOwner).SetEpochData(
+		big.NewInt(0), big.NewInt(int64(len(dataset))),
+		big.NewInt(int64(branchDepth-10)), local.data.MerkleNodes, big.NewInt(0))
+	if err != nil {
+		t.Fatalf("set epoch data failed: %s", err)
+	}
+	if err = contract.VerifyClaim(claimIndex, shareIndex, claim, nil); err != nil {
+		t.Fatalf("expected verification to pass once the epoch is set, got %s", err)
+	}
+}
+
+func TestVerifyShareRejectsClaimOfZeroDifficulty(t *testing.T) {
+	dataset := simulatedDataset()
+	claim := newSimulatedClaim(dataset, 1, 2)
+	share := claim.GetShare(0).(*Share)
+	rlpHeader, _ := share.RlpHeaderWithoutNonce()
+	cc := &contractClaim{
+		claim.NumShares(), big.NewInt(0), claim.Min(), claim.Max(),
+		claim.AugMerkle().Big(),
+	}
+	epochs := &testEpochs{&EpochData{}}
+	err := verifyShare(
+		simulatedContractAddr, minerID(simulatedMinerAddr), cc, epochs.EpochData,
+		rlpHeader, share.NonceBig(), big.NewInt(0),
+		[]*big.Int{}, []*big.Int{}, []*big.Int{}, []*big.Int{})
+	if err == nil || errorCode(err) != 0 {
+		t.Fatalf("expected a plain error for a claim of difficulty 0, got %v", err)
+	}
+}
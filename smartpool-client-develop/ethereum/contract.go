@@ -7,8 +7,9 @@ import (
 )
 
 type Contract struct {
-	client ContractClient
-	miner  common.Address
+	client   ContractClient
+	miner    common.Address
+	verifier *ClaimVerifier
 }
 
 func (c *Contract) Version() string {
@@ -28,7 +29,7 @@ func (c *Contract) Register(paymentAddress common.Address) error {
 }
 
 func (c *Contract) SubmitClaim(claim smartpool.Claim, lastClaim bool) error {
-	smartpool.Output.Printf("Min: 0x%s - Max: 0x%s - Diff: 0x%s\n", claim.Min().Text(16), claim.Max().Text(16), claim.Difficulty().Text(16))
+	logger.Debugf("Min: 0x%s - Max: 0x%s - Diff: 0x%s\n", claim.Min().Text(16), claim.Max().Text(16), claim.Difficulty().Text(16))
 	return c.client.SubmitClaim(
 		claim.NumShares(), claim.Difficulty(),
 		claim.Min(), claim.Max(), claim.AugMerkle().Big(), lastClaim)
@@ -48,7 +49,13 @@ func (c *Contract) ResetOpenClaims() error {
 	return c.client.ResetOpenClaims()
 }
 
-func (c *Contract) VerifyClaim(submissionIndex *big.Int, shareIndex *big.Int, claim smartpool.Claim) error {
+// SetVerifier makes VerifyClaim check verifications with verifier before
+// sending them. Verifications failing the checks are not sent.
+func (c *Contract) SetVerifier(verifier *ClaimVerifier) {
+	c.verifier = verifier
+}
+
+func (c *Contract) VerifyClaim(submissionIndex *big.Int, shareIndex *big.Int, claim smartpool.Claim, txSent func(tx common.Hash)) error {
 	share := claim.GetShare(int(shareIndex.Int64())).(*Share)
 	rlpHeader, _ := share.RlpHeaderWithoutNonce()
 	nonce := share.NonceBig()
@@ -57,6 +64,17 @@ func (c *Contract) VerifyClaim(submissionIndex *big.Int, shareIndex *big.Int, cl
 	augHashesBranch := claim.HashBranch()
 	dataSetLookup := share.DAGElementArray()
 	witnessForLookup := share.DAGProofArray()
+	if c.verifier != nil {
+		err := c.verifier.Verify(
+			submissionIndex, shareIndex, claim, rlpHeader, nonce,
+			dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch)
+		if _, failed := err.(*ContractError); failed {
+			return err
+		}
+		if err != nil {
+			logger.Warnf("Couldn't check claim verification locally: %s. Sending it anyway.\n", err)
+		}
+	}
 	return c.client.VerifyClaim(
 		rlpHeader,
 		nonce,
@@ -66,9 +84,14 @@ func (c *Contract) VerifyClaim(submissionIndex *big.Int, shareIndex *big.Int, cl
 		witnessForLookup,
 		augCountersBranch,
 		augHashesBranch,
+		txSent,
 	)
 }
 
+func (c *Contract) VerificationResult(tx common.Hash) (bool, error) {
+	return c.client.VerifyClaimResult(tx)
+}
+
 func NewContract(client ContractClient, miner common.Address) *Contract {
-	return &Contract{client, miner}
+	return &Contract{client, miner, nil}
 }
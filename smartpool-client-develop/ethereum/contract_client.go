@@ -6,12 +6,18 @@ import (
 )
 
 type EthashContractClient interface {
+	// EpochNode returns the merkle node at index of epoch's data on the
+	// contract. It is 0 if the node is not set.
+	EpochNode(epoch *big.Int, index *big.Int) (*big.Int, error)
+	// SetEpochData sets merkleNodes of epoch's data from index start in one
+	// tx. It returns gas used and fee paid by the tx if it was mined.
 	SetEpochData(
 		epoch *big.Int,
 		fullSizeIn128Resolution *big.Int,
 		branchDepth *big.Int,
 		merkleNodes []*big.Int,
-	) error
+		start *big.Int,
+	) (*big.Int, *big.Int, error)
 }
 
 type ContractClient interface {
@@ -38,5 +44,9 @@ type ContractClient interface {
 		dataSetLookup []*big.Int,
 		witnessForLookup []*big.Int,
 		augCountersBranch []*big.Int,
-		augHashesBranch []*big.Int) error
+		augHashesBranch []*big.Int,
+		txSent func(tx common.Hash)) error
+	// VerifyClaimResult returns true once verifyClaim tx is mined, with
+	// the error the contract rejected the claim with or nil.
+	VerifyClaimResult(tx common.Hash) (bool, error)
 }
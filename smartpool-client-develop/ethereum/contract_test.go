@@ -1,3 +1,187 @@
 package ethereum
 
-// TODO: Add tests for contract
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum/ethash"
+	"github.com/SmartPool/smartpool-client/mtree"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"testing"
+)
+
+// a small fake dataset is enough for the contract because it only knows the
+// epoch's size and merkle nodes
+const simulatedDatasetSize = 4096
+
+var (
+	simulatedContractAddr = common.HexToAddress("0x0398ae5a974fe8179b6b0ab9baf4d5f366e932bf")
+	simulatedOwner        = common.HexToAddress("0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96")
+	simulatedMinerAddr    = common.HexToAddress("0xe034afdcc2ba0441ff215ee9ba0da3e86450108d")
+)
+
+func simulatedDataset() []smartpool.Word {
+	result := []smartpool.Word{}
+	for i := 0; i < simulatedDatasetSize; i++ {
+		w := smartpool.Word{}
+		seed := big.NewInt(int64(i)).Bytes()
+		copy(w[:64], crypto.Keccak512(seed, []byte{0}))
+		copy(w[64:], crypto.Keccak512(seed, []byte{1}))
+		result = append(result, w)
+	}
+	return result
+}
+
+func simulatedDagTree(dataset []smartpool.Word, indices []uint32) *mtree.DagTree {
+	dt := mtree.NewDagTree()
+	dt.RegisterIndex(indices...)
+	branchDepth := len(fmt.Sprintf("%b", len(dataset)-1))
+	dt.RegisterStoredLevel(uint32(branchDepth), 10)
+	for i, w := range dataset {
+		dt.Insert(w, uint32(i))
+	}
+	dt.Finalize()
+	return dt
+}
+
+// lookupIndices returns the dataset elements hashimoto accesses for hash
+// and nonce.
+func lookupIndices(dataset []smartpool.Word, hash common.Hash, nonce uint64) []uint32 {
+	indices := []uint32{}
+	lookup := func(index uint32) []uint32 {
+		if index%2 == 0 {
+			indices = append(indices, index/2)
+		}
+		half := dataset[index/2][index%2*64 : (index%2+1)*64]
+		data := make([]uint32, len(half)/4)
+		for i := range data {
+			data[i] = binary.LittleEndian.Uint32(half[i*4:])
+		}
+		return data
+	}
+	ethash.HashimotoWithLookup(hash, nonce, uint64(len(dataset))*128, lookup)
+	return indices
+}
+
+func newSimulatedShare(dataset []smartpool.Word, nonce uint64) *Share {
+	diff := big.NewInt(1)
+	header := &types.Header{
+		Coinbase:   simulatedContractAddr,
+		Difficulty: big.NewInt(1000000),
+		Number:     big.NewInt(100),
+		GasLimit:   big.NewInt(4700000),
+		GasUsed:    big.NewInt(0),
+		Time:       big.NewInt(1500000000),
+		Extra:      []byte(BuildExtraData(simulatedMinerAddr, diff)),
+	}
+	s := NewShare(header, diff, simulatedMinerAddr.Hex())
+	s.nonce = types.EncodeNonce(nonce)
+	dt := simulatedDagTree(dataset, lookupIndices(dataset, header.HashNoNonce(), nonce))
+	s.proof = &DagProof{dt.AllDAGElements(), dt.AllBranchesArray()}
+	return s
+}
+
+func newSimulatedClaim(dataset []smartpool.Word, nonces ...uint64) *protocol.Claim {
+	claim := protocol.NewClaim()
+	for _, nonce := range nonces {
+		claim.AddShare(newSimulatedShare(dataset, nonce))
+	}
+	return claim
+}
+
+func newSimulatedContract(t *testing.T, dataset []smartpool.Word) *SimulatedContract {
+	sc := NewSimulatedContract(simulatedContractAddr, simulatedOwner)
+	dt := simulatedDagTree(dataset, []uint32{})
+	branchDepth := len(fmt.Sprintf("%b", len(dataset)-1))
+	_, _, err := sc.NewClient(simulatedOwner).SetEpochData(
+		big.NewInt(0), big.NewInt(int64(len(dataset))),
+		big.NewInt(int64(branchDepth-10)), dt.MerkleNodes(), big.NewInt(0))
+	if err != nil {
+		t.Fatalf("set epoch data failed: %s", err)
+	}
+	if err = sc.NewClient(simulatedMinerAddr).Register(simulatedMinerAddr); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	return sc
+}
+
+func errorCode(err error) uint64 {
+	if cerr, ok := err.(*ContractError); ok {
+		return cerr.Code.Uint64()
+	}
+	return 0
+}
+
+func TestContractVerifyClaimOnSimulatedContract(t *testing.T) {
+	dataset := simulatedDataset()
+	sc := newSimulatedContract(t, dataset)
+	contract := NewContract(sc.NewClient(simulatedMinerAddr), simulatedMinerAddr)
+	claim := newSimulatedClaim(dataset, 5, 1, 3, 2, 4)
+	if err := contract.SubmitClaim(claim, true); err != nil {
+		t.Fatalf("submit claim failed: %s", err)
+	}
+	claimIndex, shareIndex, err := contract.GetShareIndex(claim)
+	if err != nil {
+		t.Fatalf("get share index failed: %s", err)
+	}
+	if err = contract.VerifyClaim(claimIndex, shareIndex, claim, nil); err != nil {
+		t.Fatalf("verify claim failed: %s", err)
+	}
+	if open, _ := contract.NumOpenClaims(); open.Cmp(common.Big0) != 0 {
+		t.Fail()
+	}
+	if sc.VerifiedClaims(simulatedMinerAddr) != 1 {
+		t.Fail()
+	}
+}
+
+func TestSimulatedContractRejectsLowMinCounter(t *testing.T) {
+	dataset := simulatedDataset()
+	sc := newSimulatedContract(t, dataset)
+	contract := NewContract(sc.NewClient(simulatedMinerAddr), simulatedMinerAddr)
+	if err := contract.SubmitClaim(newSimulatedClaim(dataset, 3, 4), false); err != nil {
+		t.Fatalf("submit claim failed: %s", err)
+	}
+	err := contract.SubmitClaim(newSimulatedClaim(dataset, 4, 5), true)
+	if errorCode(err) != 0x81000001 {
+		t.Fail()
+	}
+}
+
+func TestSimulatedContractRejectsTamperedBranches(t *testing.T) {
+	dataset := simulatedDataset()
+	sc := newSimulatedContract(t, dataset)
+	client := sc.NewClient(simulatedMinerAddr)
+	contract := NewContract(client, simulatedMinerAddr)
+	claim := newSimulatedClaim(dataset, 1, 2, 3, 4)
+	if err := contract.SubmitClaim(claim, true); err != nil {
+		t.Fatalf("submit claim failed: %s", err)
+	}
+	claimIndex, shareIndex, _ := contract.GetShareIndex(claim)
+	claim.SetEvidence(shareIndex)
+	share := claim.GetShare(int(shareIndex.Int64())).(*Share)
+	rlpHeader, _ := share.RlpHeaderWithoutNonce()
+	verify := func(counters, dag []*big.Int) error {
+		return client.VerifyClaim(
+			rlpHeader, share.NonceBig(), claimIndex, shareIndex,
+			dag, share.DAGProofArray(), counters, claim.HashBranch(), nil)
+	}
+
+	counters := claim.CounterBranch()
+	counters[0] = new(big.Int).Add(counters[0], common.Big1)
+	if errorCode(verify(counters, share.DAGElementArray())) != 0x84000008 {
+		t.Fail()
+	}
+	dag := share.DAGElementArray()
+	dag[0] = new(big.Int).Add(dag[0], common.Big1)
+	if errorCode(verify(claim.CounterBranch(), dag)) != 0x84000009 {
+		t.Fail()
+	}
+	if err := verify(claim.CounterBranch(), share.DAGElementArray()); err != nil {
+		t.Fatalf("verify claim failed: %s", err)
+	}
+}
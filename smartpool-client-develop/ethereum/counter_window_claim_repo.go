@@ -0,0 +1,116 @@
+package ethereum
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"math/big"
+)
+
+// CounterBound knows the smallest counter shares accepted from now on can
+// have.
+type CounterBound interface {
+	// SafeCounterBound returns the bound or nil if it isn't known.
+	SafeCounterBound() *big.Int
+}
+
+// CounterWindowClaimRepo claims every share whose counter is below a safe
+// bound given by the works rigs are mining instead of holding back all
+// shares with the most recent timestamp like TimestampClaimRepo does.
+// The max counter of the last claim is kept as a watermark. Shares not above
+// it are rejected as stale because the contract only accepts claims having
+// min counter greater than the last claim's max.
+type CounterWindowClaimRepo struct {
+	*TimestampClaimRepo
+	bound     CounterBound
+	watermark *big.Int
+}
+
+// NewCounterWindowClaimRepo restores shares and claims of last session like
+// NewTimestampClaimRepo does. The watermark is restored from the latest
+// counter in storage and restored shares not above it are discarded.
+func NewCounterWindowClaimRepo(diff *big.Int, miner, coinbase string, ps smartpool.PersistentStorage, policy RestoreConflictPolicy, bound CounterBound) *CounterWindowClaimRepo {
+	watermark := big.NewInt(0)
+	loaded, err := ps.Load(watermark, protocol.COUNTER_FILE)
+	if loadedCounter, ok := loaded.(*big.Int); err == nil && ok && loadedCounter != nil {
+		watermark = loadedCounter
+	}
+	cr := &CounterWindowClaimRepo{
+		NewTimestampClaimRepo(diff, miner, coinbase, ps, policy),
+		bound,
+		watermark,
+	}
+	if discarded := cr.DiscardSharesUpTo(watermark); discarded > 0 {
+		logger.Warnf("Discarded %d shares from last session that are not above the latest counter 0x%s.\n", discarded, watermark.Text(16))
+	}
+	return cr
+}
+
+func (cr *CounterWindowClaimRepo) AddShare(s smartpool.Share) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	share := s.(*Share)
+	if share.Counter().Cmp(cr.watermark) <= 0 {
+		return fmt.Errorf(
+			"stale share (counter 0x%s is not above last claimed counter 0x%s)",
+			share.Counter().Text(16), cr.watermark.Text(16))
+	}
+	return cr.addShare(share)
+}
+
+// claimBound returns the counter shares have to be below to be claimed now.
+// Without a bound from the works, shares with the most recent timestamp are
+// held back like TimestampClaimRepo does. The caller must hold cr.mu.
+func (cr *CounterWindowClaimRepo) claimBound() *big.Int {
+	if bound := cr.bound.SafeCounterBound(); bound != nil {
+		return bound
+	}
+	return new(big.Int).Lsh(cr.recentTimestamp, 64)
+}
+
+// claimableShares returns shares below the claim bound. The caller must
+// hold cr.mu.
+func (cr *CounterWindowClaimRepo) claimableShares() []*Share {
+	bound := cr.claimBound()
+	result := []*Share{}
+	for _, s := range cr.activeShares {
+		if s.Counter().Cmp(bound) < 0 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// NoValidShares returns number of shares that can be put into a claim now.
+func (cr *CounterWindowClaimRepo) NoValidShares() uint64 {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return uint64(len(cr.claimableShares()))
+}
+
+func (cr *CounterWindowClaimRepo) GetCurrentClaim(threshold int) smartpool.Claim {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	shares := cr.claimableShares()
+	logger.Debugf("Have %d shares below the counter bound\n", len(shares))
+	logger.Debugf("Last claimed counter: 0x%s\n", cr.watermark.Text(16))
+	if len(shares) == 0 || len(shares) < threshold {
+		return nil
+	}
+	c := protocol.NewClaim()
+	for _, s := range shares {
+		c.AddShare(s)
+		delete(cr.activeShares, shareID(s))
+	}
+	cr.noShares = 0
+	cr.noRecentShares = 0
+	for _, s := range cr.activeShares {
+		if s.Timestamp().Cmp(cr.recentTimestamp) == 0 {
+			cr.noRecentShares++
+		} else {
+			cr.noShares++
+		}
+	}
+	cr.watermark = c.Max()
+	return c
+}
@@ -0,0 +1,135 @@
+package ethereum
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+// testWorks are the works rigs mine in a simulation. The last few works are
+// active and their smallest timestamp is the counter bound.
+type testWorks struct {
+	timestamps []int64
+	active     int
+}
+
+func (tw *testWorks) activeTimestamps() []int64 {
+	if len(tw.timestamps) <= tw.active {
+		return tw.timestamps
+	}
+	return tw.timestamps[len(tw.timestamps)-tw.active:]
+}
+
+func (tw *testWorks) SafeCounterBound() *big.Int {
+	active := tw.activeTimestamps()
+	if len(active) == 0 {
+		return nil
+	}
+	min := active[0]
+	for _, ts := range active {
+		if ts < min {
+			min = ts
+		}
+	}
+	return new(big.Int).Lsh(newJournalTestShare(0, min).Timestamp(), 64)
+}
+
+func newCounterWindowRepo(works *testWorks) *CounterWindowClaimRepo {
+	return NewCounterWindowClaimRepo(
+		big.NewInt(100000),
+		common.HexToAddress("0xe034afdcc2ba0441ff215ee9ba0da3e86450108d").Hex(),
+		common.HexToAddress("0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96").Hex(),
+		&testPersistentStorage{},
+		RestoreAbort,
+		works,
+	)
+}
+
+// simulateCounterWindow runs ops against a repo: new works with non
+// decreasing timestamps, shares of active works and claims. It returns false
+// if a claim's min counter is not greater than the previous claim's max, a
+// share of an active work is rejected, a claimable share is held back or a
+// share is lost.
+func simulateCounterWindow(t *testing.T, ops []uint16) bool {
+	works := &testWorks{[]int64{}, 3}
+	repo := newCounterWindowRepo(works)
+	latestCounter := big.NewInt(0)
+	var accepted, claimed uint64
+	for i, op := range ops {
+		switch op % 4 {
+		case 0:
+			ts := int64(0)
+			if len(works.timestamps) > 0 {
+				ts = works.timestamps[len(works.timestamps)-1]
+			}
+			works.timestamps = append(works.timestamps, ts+int64(op/4%3))
+		case 1, 2:
+			active := works.activeTimestamps()
+			if len(active) == 0 {
+				continue
+			}
+			ts := active[int(op/4)%len(active)]
+			share := newJournalTestShare(uint64(op)<<32|uint64(i), ts)
+			if err := repo.AddShare(share); err != nil {
+				t.Logf("share of an active work rejected: %s", err)
+				return false
+			}
+			accepted++
+		case 3:
+			bound := works.SafeCounterBound()
+			claim := repo.GetCurrentClaim(1)
+			if claim == nil {
+				continue
+			}
+			if claim.Min().Cmp(latestCounter) <= 0 {
+				t.Logf("claim min 0x%s is not above latest counter 0x%s", claim.Min().Text(16), latestCounter.Text(16))
+				return false
+			}
+			for _, s := range repo.activeShares {
+				if bound != nil && s.Counter().Cmp(bound) < 0 {
+					t.Logf("share 0x%s below bound 0x%s was held back", s.Counter().Text(16), bound.Text(16))
+					return false
+				}
+			}
+			latestCounter = claim.Max()
+			claimed += claim.NumShares().Uint64()
+		}
+	}
+	return accepted == claimed+repo.NoActiveShares()
+}
+
+func TestCounterWindowClaimMinAboveLatestCounter(t *testing.T) {
+	property := func(ops []uint16) bool {
+		return simulateCounterWindow(t, ops)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCounterWindowRejectsStaleShare(t *testing.T) {
+	works := &testWorks{[]int64{1, 2}, 1}
+	repo := newCounterWindowRepo(works)
+	if err := repo.AddShare(newJournalTestShare(5, 1)); err != nil {
+		t.Fatalf("couldn't add share: %s", err)
+	}
+	if err := repo.AddShare(newJournalTestShare(6, 2)); err != nil {
+		t.Fatalf("couldn't add share: %s", err)
+	}
+	if repo.NoValidShares() != 1 {
+		t.Fatalf("expected only the share below the bound to be claimable")
+	}
+	claim := repo.GetCurrentClaim(1)
+	if claim == nil || claim.NumShares().Int64() != 1 {
+		t.Fatalf("expected a claim of 1 share")
+	}
+	// a late share of an old work not above the claim is stale
+	if repo.AddShare(newJournalTestShare(4, 1)) == nil {
+		t.Fatalf("expected stale share to be rejected")
+	}
+	// but one above the claim can still be claimed
+	if err := repo.AddShare(newJournalTestShare(7, 1)); err != nil {
+		t.Fatalf("expected share above the claim to be accepted: %s", err)
+	}
+}
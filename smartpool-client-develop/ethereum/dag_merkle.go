@@ -0,0 +1,426 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum/ethash"
+	"github.com/SmartPool/smartpool-client/mtree"
+	mmap "github.com/edsrzf/mmap-go"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DAG_STORED_LEVEL is the number of levels of the DAG merkle tree below the
+// root whose nodes are stored in the contract as epoch data. Share proofs
+// only go up to that level.
+var DAG_STORED_LEVEL uint32 = 10
+
+var dagCacheMagic = []byte("SPDAGMT1")
+
+// the cache starts with the magic, number of elements and depth of the tree
+const dagCacheHeaderSize = 16
+
+var errDagMerkleClosed = errors.New("DAG merkle tree is closed")
+
+// DagProof holds the DAG elements a share's hashimoto accessed and their
+// merkle proof branches up to the stored level.
+type DagProof struct {
+	Elements []smartpool.Word
+	Branches []smartpool.BranchElement
+}
+
+// DagMerkle is the merkle tree of an epoch's DAG. All of its internal nodes
+// are built once into an on-disk cache next to the DAG file, which is memory
+// mapped afterwards, so proving an element only reads the element, its
+// sibling and one node per level.
+// The cache is built from the subtrees under the nodes of the stored level.
+// Subtrees are built by parallel workers holding one subtree of the dataset
+// in memory at a time, so the whole DAG never is.
+type DagMerkle struct {
+	dataset     io.ReaderAt
+	closer      io.Closer
+	numElements uint32
+	depth       uint32
+	// counts are the numbers of nodes of levels of the tree, from the
+	// elements up to the root. A level's last node is paired with a copy of
+	// itself if the level has an odd number of nodes.
+	counts []uint32
+	// offsets are where levels start in the cache. The elements' level is
+	// not cached.
+	offsets   []int64
+	cachePath string
+	workers   int
+	// useMu is held for reading while the cache is being read and for
+	// writing to close it
+	useMu     sync.RWMutex
+	cacheMu   sync.Mutex
+	cacheFile *os.File
+	cache     mmap.MMap
+	closed    bool
+}
+
+func dagMerkleCachePath(epoch uint64) string {
+	return ethash.PathToDAG(epoch, ethash.DefaultDir) + ".merkle"
+}
+
+// OpenDagMerkle generates the DAG of epoch if it doesn't exist yet and opens
+// it. The DagMerkle must be closed after use.
+func OpenDagMerkle(epoch uint64) *DagMerkle {
+	logger.Debugf("Checking DAG file. Generate if needed...\n")
+	ethash.MakeDAG(epoch*30000, ethash.DefaultDir)
+	fullSizeIn128Resolution := ethash.DAGSize(epoch*30000) / 128
+	path := ethash.PathToDAG(epoch, ethash.DefaultDir)
+	var f *os.File
+	var err error
+	for {
+		f, err = os.Open(path)
+		if err == nil {
+			break
+		}
+		logger.Warnf("Reading DAG file %s failed with %s. Retry in 10s...\n", path, err.Error())
+		time.Sleep(10 * time.Second)
+	}
+	dm := newDagMerkle(f, uint32(fullSizeIn128Resolution), dagMerkleCachePath(epoch))
+	dm.closer = f
+	return dm
+}
+
+// newDagMerkle creates a DagMerkle for numElements DAG elements read from
+// dataset, which starts with the 8 bytes magic number of DAG files. Its
+// nodes are cached in cachePath.
+func newDagMerkle(dataset io.ReaderAt, numElements uint32, cachePath string) *DagMerkle {
+	dm := &DagMerkle{
+		dataset:     dataset,
+		numElements: numElements,
+		depth:       uint32(len(fmt.Sprintf("%b", numElements-1))),
+		counts:      []uint32{numElements},
+		offsets:     []int64{0},
+		cachePath:   cachePath,
+		workers:     runtime.NumCPU(),
+	}
+	offset := int64(dagCacheHeaderSize)
+	for h := uint32(1); h <= dm.depth; h++ {
+		dm.counts = append(dm.counts, (dm.counts[h-1]+1)/2)
+		dm.offsets = append(dm.offsets, offset)
+		offset += int64(dm.counts[h]) * smartpool.HashLength
+	}
+	return dm
+}
+
+// Close unmaps the cache and closes the dataset.
+func (dm *DagMerkle) Close() error {
+	dm.useMu.Lock()
+	defer dm.useMu.Unlock()
+	dm.closed = true
+	if dm.cache != nil {
+		dm.cache.Unmap()
+		dm.cacheFile.Close()
+		dm.cache = nil
+	}
+	if dm.closer == nil {
+		return nil
+	}
+	return dm.closer.Close()
+}
+
+func (dm *DagMerkle) NumElements() uint32 {
+	return dm.numElements
+}
+
+// BranchDepth returns the depth of the tree, which is the length of an
+// element's full proof branch.
+func (dm *DagMerkle) BranchDepth() uint32 {
+	return dm.depth
+}
+
+func (dm *DagMerkle) storedLevel() uint32 {
+	return dm.depth - DAG_STORED_LEVEL
+}
+
+func (dm *DagMerkle) subtreeSize() uint32 {
+	return 1 << dm.storedLevel()
+}
+
+func (dm *DagMerkle) cacheSize() int64 {
+	return dm.offsets[dm.depth] + int64(dm.counts[dm.depth])*smartpool.HashLength
+}
+
+func (dm *DagMerkle) cacheHeader() []byte {
+	header := make([]byte, dagCacheHeaderSize)
+	copy(header, dagCacheMagic)
+	binary.LittleEndian.PutUint32(header[8:], dm.numElements)
+	binary.LittleEndian.PutUint32(header[12:], dm.depth)
+	return header
+}
+
+func (dm *DagMerkle) readElements(first, count uint32) ([]smartpool.Word, error) {
+	buf := make([]byte, int(count)*smartpool.WordLength)
+	// skip 8 bytes magic number at the beginning of dataset. See more at
+	// https://github.com/ethereum/wiki/wiki/Ethash-DAG-Disk-Storage-Format
+	n, err := dm.dataset.ReadAt(buf, 8+int64(first)*smartpool.WordLength)
+	if n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("malformed dataset: element %d is missing", first+uint32(n/smartpool.WordLength))
+		}
+		return nil, err
+	}
+	words := make([]smartpool.Word, count)
+	for i := range words {
+		copy(words[i][:], buf[i*smartpool.WordLength:])
+	}
+	return words, nil
+}
+
+// parentLevel returns the parents of nodes of a level.
+func parentLevel(nodes []mtree.DagData) []mtree.DagData {
+	if len(nodes)%2 == 1 {
+		nodes = append(nodes, nodes[len(nodes)-1])
+	}
+	parents := make([]mtree.DagData, len(nodes)/2)
+	for i := range parents {
+		parents[i] = mtree.DagNodeHash(nodes[i*2], nodes[i*2+1])
+	}
+	return parents
+}
+
+func writeLevel(w io.WriterAt, offset int64, nodes []mtree.DagData) error {
+	buf := make([]byte, 0, len(nodes)*smartpool.HashLength)
+	for _, node := range nodes {
+		buf = append(buf, node[:]...)
+	}
+	_, err := w.WriteAt(buf, offset)
+	return err
+}
+
+// buildSubtree writes nodes of the subtree under the stored level node at
+// position subtree to the cache and returns the stored level node.
+func (dm *DagMerkle) buildSubtree(w io.WriterAt, subtree uint32) (mtree.DagData, error) {
+	size := dm.subtreeSize()
+	first := subtree * size
+	count := size
+	if first+count > dm.numElements {
+		count = dm.numElements - first
+	}
+	words, err := dm.readElements(first, count)
+	if err != nil {
+		return mtree.DagData{}, err
+	}
+	nodes := make([]mtree.DagData, count)
+	for i, word := range words {
+		nodes[i] = mtree.DagElementHash(word)
+	}
+	for h := uint32(1); h <= dm.storedLevel(); h++ {
+		nodes = parentLevel(nodes)
+		offset := dm.offsets[h] + int64(first>>h)*smartpool.HashLength
+		if err = writeLevel(w, offset, nodes); err != nil {
+			return mtree.DagData{}, err
+		}
+	}
+	return nodes[0], nil
+}
+
+// buildCache builds all nodes of the tree into a temporary file and moves it
+// to the cache path once it is complete.
+func (dm *DagMerkle) buildCache() error {
+	if err := os.MkdirAll(filepath.Dir(dm.cachePath), 0755); err != nil {
+		return err
+	}
+	tmp := dm.cachePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	if err = f.Truncate(dm.cacheSize()); err != nil {
+		return err
+	}
+	if _, err = f.WriteAt(dm.cacheHeader(), 0); err != nil {
+		return err
+	}
+	start := time.Now()
+	stored := make([]mtree.DagData, dm.counts[dm.storedLevel()])
+	jobs := make(chan uint32)
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	for i := 0; i < dm.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for subtree := range jobs {
+				node, err := dm.buildSubtree(f, subtree)
+				if err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+					continue
+				}
+				stored[subtree] = node
+			}
+		}()
+	}
+	for i := range stored {
+		jobs <- uint32(i)
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	nodes := stored
+	for h := dm.storedLevel() + 1; h <= dm.depth; h++ {
+		nodes = parentLevel(nodes)
+		if err = writeLevel(f, dm.offsets[h], nodes); err != nil {
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, dm.cachePath); err != nil {
+		return err
+	}
+	logger.Infof("Built DAG merkle tree of %d elements in %s with %d workers.\n", dm.numElements, time.Since(start), dm.workers)
+	return nil
+}
+
+func (dm *DagMerkle) mapCache() error {
+	f, err := os.Open(dm.cachePath)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.Size() != dm.cacheSize() {
+		f.Close()
+		return fmt.Errorf("%s has %d bytes, expected %d", dm.cachePath, info.Size(), dm.cacheSize())
+	}
+	cache, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if !bytes.Equal(cache[:dagCacheHeaderSize], dm.cacheHeader()) {
+		cache.Unmap()
+		f.Close()
+		return fmt.Errorf("%s is not the cache of this DAG", dm.cachePath)
+	}
+	dm.cacheFile = f
+	dm.cache = cache
+	return nil
+}
+
+// openCache maps the cache, building it first if it doesn't exist or is
+// invalid. useMu must be held.
+func (dm *DagMerkle) openCache() error {
+	dm.cacheMu.Lock()
+	defer dm.cacheMu.Unlock()
+	if dm.closed {
+		return errDagMerkleClosed
+	}
+	if dm.cache != nil {
+		return nil
+	}
+	err := dm.mapCache()
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		logger.Warnf("Couldn't load DAG merkle cache (%s). Rebuilding it.\n", err)
+	}
+	if err = dm.buildCache(); err != nil {
+		return err
+	}
+	return dm.mapCache()
+}
+
+func (dm *DagMerkle) node(h, p uint32) mtree.DagData {
+	node := mtree.DagData{}
+	offset := dm.offsets[h] + int64(p)*smartpool.HashLength
+	copy(node[:], dm.cache[offset:])
+	return node
+}
+
+// sibling returns the node paired with node p of level h.
+func (dm *DagMerkle) sibling(h, p uint32) mtree.DagData {
+	if p^1 < dm.counts[h] {
+		p = p ^ 1
+	}
+	return dm.node(h, p)
+}
+
+// StoredNodes returns the nodes of the stored level in order.
+func (dm *DagMerkle) StoredNodes() ([]mtree.NodeData, error) {
+	dm.useMu.RLock()
+	defer dm.useMu.RUnlock()
+	if err := dm.openCache(); err != nil {
+		return nil, err
+	}
+	nodes := []mtree.NodeData{}
+	for p := uint32(0); p < dm.counts[dm.storedLevel()]; p++ {
+		nodes = append(nodes, dm.node(dm.storedLevel(), p))
+	}
+	return nodes, nil
+}
+
+// MerkleNodes returns the stored level nodes packed as the contract's epoch
+// data.
+func (dm *DagMerkle) MerkleNodes() ([]*big.Int, error) {
+	nodes, err := dm.StoredNodes()
+	if err != nil {
+		return nil, err
+	}
+	return mtree.PackMerkleNodes(nodes), nil
+}
+
+// Proof returns elements at indices and their proof branches in the same
+// order.
+func (dm *DagMerkle) Proof(indices []uint32) (*DagProof, error) {
+	dm.useMu.RLock()
+	defer dm.useMu.RUnlock()
+	if err := dm.openCache(); err != nil {
+		return nil, err
+	}
+	proof := &DagProof{[]smartpool.Word{}, []smartpool.BranchElement{}}
+	for _, index := range indices {
+		if index >= dm.numElements {
+			return nil, fmt.Errorf("DAG element %d is out of range", index)
+		}
+		// the element's sibling is read together with the element
+		first := index &^ 1
+		count := uint32(2)
+		if first+count > dm.numElements {
+			count = dm.numElements - first
+		}
+		words, err := dm.readElements(first, count)
+		if err != nil {
+			return nil, err
+		}
+		element := words[index-first]
+		sibling := element
+		if index^1 < dm.numElements {
+			sibling = words[(index^1)-first]
+		}
+		hashes := []mtree.NodeData{mtree.DagElementHash(sibling)}
+		for h := uint32(1); h < dm.storedLevel(); h++ {
+			hashes = append(hashes, dm.sibling(h, index>>h))
+		}
+		proof.Elements = append(proof.Elements, element)
+		proof.Branches = append(proof.Branches, mtree.PackBranch(hashes)...)
+	}
+	return proof, nil
+}
@@ -0,0 +1,117 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/SmartPool/smartpool-client"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func datasetReader(dataset []smartpool.Word) *bytes.Reader {
+	data := make([]byte, 8)
+	for _, w := range dataset {
+		data = append(data, w[:]...)
+	}
+	return bytes.NewReader(data)
+}
+
+func tempCachePath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dag-merkle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "full-R23.merkle"), func() { os.RemoveAll(dir) }
+}
+
+func TestDagMerkleMatchesSequentialTree(t *testing.T) {
+	full := simulatedDataset()
+	indices := []uint32{0, 3, 1500, 4, 2047, 3, 1023, 2048}
+	// sizes with and without a partial last subtree
+	for _, size := range []int{4096, 3001, 3072, 2049} {
+		cachePath, cleanup := tempCachePath(t)
+		defer cleanup()
+		dataset := full[:size]
+		expected := simulatedDagTree(dataset, indices)
+		dm := newDagMerkle(datasetReader(dataset), uint32(size), cachePath)
+		nodes, err := dm.MerkleNodes()
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !reflect.DeepEqual(nodes, expected.MerkleNodes()) {
+			t.Fatalf("size %d: merkle nodes differ from sequential tree", size)
+		}
+		proof, err := dm.Proof(indices)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !reflect.DeepEqual(proof.Elements, expected.AllDAGElements()) {
+			t.Fatalf("size %d: elements differ from sequential tree", size)
+		}
+		if !reflect.DeepEqual(proof.Branches, expected.AllBranchesArray()) {
+			t.Fatalf("size %d: branches differ from sequential tree", size)
+		}
+		dm.Close()
+	}
+}
+
+func TestDagMerkleReusesCache(t *testing.T) {
+	cachePath, cleanup := tempCachePath(t)
+	defer cleanup()
+	dataset := simulatedDataset()
+	dm := newDagMerkle(datasetReader(dataset), uint32(len(dataset)), cachePath)
+	nodes, err := dm.MerkleNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.Close()
+	// nodes come from the cache instead of the dataset
+	dm = newDagMerkle(bytes.NewReader([]byte{}), uint32(len(dataset)), cachePath)
+	cached, err := dm.MerkleNodes()
+	if err != nil || !reflect.DeepEqual(nodes, cached) {
+		t.Fail()
+	}
+	dm.Close()
+	// the cache of another dataset is rebuilt
+	dm = newDagMerkle(datasetReader(dataset[:3000]), 3000, cachePath)
+	if rebuilt, err := dm.MerkleNodes(); err != nil || reflect.DeepEqual(nodes, rebuilt) {
+		t.Fail()
+	}
+	dm.Close()
+	if _, err = dm.Proof([]uint32{1}); err != errDagMerkleClosed {
+		t.Fail()
+	}
+}
+
+func TestRemoteProofProvider(t *testing.T) {
+	cachePath, cleanup := tempCachePath(t)
+	defer cleanup()
+	dataset := simulatedDataset()
+	dm := newDagMerkle(datasetReader(dataset), uint32(len(dataset)), cachePath)
+	defer dm.Close()
+	indices := []uint32{7, 1024, 9}
+	expected, err := dm.Proof(indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proof/3" || r.URL.Query().Get("indices") != "7,1024,9" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(NewDagProofData(expected))
+	}))
+	defer server.Close()
+	proof, err := NewRemoteProofProvider(server.URL+"/").DagProof(3, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(proof, expected) {
+		t.Fail()
+	}
+}
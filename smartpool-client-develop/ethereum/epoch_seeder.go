@@ -0,0 +1,93 @@
+package ethereum
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"time"
+)
+
+const EPOCH_SEED_FILE string = "epochseed"
+
+// EpochSeeder sets data of the current and the next epochs on the Ethash
+// contract so claims never fail because the data of their epoch was not
+// set. The DAG of the next epoch is generated before the epoch starts.
+type EpochSeeder struct {
+	contract *EthashContract
+	node     RPCClient
+	proofs   *LocalProofProvider
+	storage  smartpool.PersistentStorage
+	interval time.Duration
+	seeded   map[uint64]bool
+}
+
+func epochSeedFile(epoch uint64) string {
+	return fmt.Sprintf("%s-%d", EPOCH_SEED_FILE, epoch)
+}
+
+// Progress returns how far setting data of epoch got, as saved by the last
+// run.
+func (s *EpochSeeder) Progress(epoch uint64) *SeedProgress {
+	loaded, err := s.storage.Load(NewSeedProgress(epoch), epochSeedFile(epoch))
+	progress, ok := loaded.(*SeedProgress)
+	if err != nil || !ok || progress.Epoch != epoch {
+		return NewSeedProgress(epoch)
+	}
+	return progress
+}
+
+func (s *EpochSeeder) saveProgress(progress *SeedProgress) {
+	if err := s.storage.Persist(progress, epochSeedFile(progress.Epoch)); err != nil {
+		logger.Warnf("Couldn't save seeding progress of epoch %d: %s\n", progress.Epoch, err)
+	}
+}
+
+// Seed sets nodes of dm, the DAG merkle tree of epoch, that are missing on
+// the contract and returns the progress with gas used by all txs sent for
+// the epoch.
+func (s *EpochSeeder) Seed(epoch uint64, dm *DagMerkle) (*SeedProgress, error) {
+	progress := s.Progress(epoch)
+	err := s.contract.SetEpochData(epoch, dm, progress, s.saveProgress)
+	if err != nil {
+		logger.Warnf("Setting data of epoch %d stopped at %d/%d nodes: %s\n",
+			epoch, progress.SetNodes, progress.NumNodes, err)
+		return progress, err
+	}
+	s.seeded[epoch] = true
+	logger.Infof("Data of epoch %d is set. %d txs used %s gas and paid %s wei.\n",
+		epoch, progress.Txs, progress.GasUsed.Text(10), progress.Fee.Text(10))
+	return progress, nil
+}
+
+func (s *EpochSeeder) seedEpoch(epoch uint64) {
+	if s.seeded[epoch] {
+		return
+	}
+	logger.Infof("Checking data of epoch %d on the contract...\n", epoch)
+	if _, err := s.Seed(epoch, s.proofs.DagMerkle(epoch)); err != nil {
+		logger.Warnf("Retry setting data of epoch %d in %s.\n", epoch, s.interval)
+	}
+}
+
+// Run checks the block height every interval and seeds the current and
+// the next epochs. It never returns.
+func (s *EpochSeeder) Run() {
+	for {
+		blockNo, err := s.node.BlockNumber()
+		if err != nil {
+			logger.Warnf("Couldn't get block number: %s\n", err)
+		} else {
+			epoch := blockNo.Uint64() / 30000
+			s.seedEpoch(epoch)
+			s.seedEpoch(epoch + 1)
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+func NewEpochSeeder(
+	contract *EthashContract, node RPCClient, proofs *LocalProofProvider,
+	storage smartpool.PersistentStorage, interval time.Duration) *EpochSeeder {
+	return &EpochSeeder{
+		contract, node, proofs, storage, interval, map[uint64]bool{},
+	}
+}
@@ -0,0 +1,100 @@
+package ethereum
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEpochSeederSetsOnlyMissingNodes(t *testing.T) {
+	cachePath, cleanup := tempCachePath(t)
+	defer cleanup()
+	dataset := simulatedDataset()
+	dm := newDagMerkle(datasetReader(dataset), uint32(len(dataset)), cachePath)
+	defer dm.Close()
+	nodes, err := dm.MerkleNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := NewSimulatedContract(simulatedContractAddr, simulatedOwner)
+	client := sc.NewClient(simulatedOwner)
+	// an upload that was interrupted after a few chunks
+	for _, start := range []int{0, 40, 100} {
+		_, _, err = client.SetEpochData(
+			big.NewInt(0), big.NewInt(int64(len(dataset))), big.NewInt(2),
+			nodes[start:start+SEED_CHUNK_SIZE], big.NewInt(int64(start)))
+		if err != nil {
+			t.Fatalf("set epoch data failed: %s", err)
+		}
+	}
+	blockNo := sc.BlockNumber()
+	seeder := NewEpochSeeder(
+		NewEthashContract(client), nil, nil, &testPersistentStorage{}, time.Second)
+	progress, err := seeder.Seed(0, dm)
+	if err != nil {
+		t.Fatalf("seed failed: %s", err)
+	}
+	// nodes 80-99 and 140-511 are missing
+	if progress.Txs != 11 || sc.BlockNumber()-blockNo != 11 || !progress.Done() {
+		t.Fatalf("expected 11 txs, got %d, progress %d/%d",
+			progress.Txs, progress.SetNodes, progress.NumNodes)
+	}
+	onChain := []*big.Int{}
+	for i := range nodes {
+		onChain = append(onChain, sc.EpochNode(0, uint64(i)))
+	}
+	if !reflect.DeepEqual(onChain, nodes) {
+		t.Fatalf("merkle nodes on the contract differ from the DAG merkle tree")
+	}
+	// a claim of the seeded epoch is verified
+	contract := NewContract(sc.NewClient(simulatedMinerAddr), simulatedMinerAddr)
+	if err = sc.NewClient(simulatedMinerAddr).Register(simulatedMinerAddr); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	claim := newSimulatedClaim(dataset, 1, 2)
+	if err = contract.SubmitClaim(claim, true); err != nil {
+		t.Fatalf("submit claim failed: %s", err)
+	}
+	claimIndex, shareIndex, _ := contract.GetShareIndex(claim)
+	if err = contract.VerifyClaim(claimIndex, shareIndex, claim, nil); err != nil {
+		t.Fatalf("verify claim failed: %s", err)
+	}
+	// nothing is sent once all nodes are set
+	blockNo = sc.BlockNumber()
+	if progress, err = seeder.Seed(0, dm); err != nil || sc.BlockNumber() != blockNo || !progress.Done() {
+		t.Fail()
+	}
+}
+
+func TestEpochSeederFailsOnWrongNodes(t *testing.T) {
+	cachePath, cleanup := tempCachePath(t)
+	defer cleanup()
+	dataset := simulatedDataset()
+	dm := newDagMerkle(datasetReader(dataset), uint32(len(dataset)), cachePath)
+	defer dm.Close()
+	nodes, err := dm.MerkleNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := NewSimulatedContract(simulatedContractAddr, simulatedOwner)
+	client := sc.NewClient(simulatedOwner)
+	chunk := append([]*big.Int{}, nodes[:SEED_CHUNK_SIZE]...)
+	chunk[5] = new(big.Int).Add(chunk[5], big.NewInt(1))
+	_, _, err = client.SetEpochData(
+		big.NewInt(0), big.NewInt(int64(len(dataset))), big.NewInt(2),
+		chunk, big.NewInt(0))
+	if err != nil {
+		t.Fatalf("set epoch data failed: %s", err)
+	}
+	blockNo := sc.BlockNumber()
+	seeder := NewEpochSeeder(
+		NewEthashContract(client), nil, nil, &testPersistentStorage{}, time.Second)
+	progress, err := seeder.Seed(0, dm)
+	if err == nil || progress.Done() {
+		t.Fatalf("expected seeding over a wrong node to fail")
+	}
+	if sc.BlockNumber() != blockNo {
+		t.Fatalf("expected no tx to be sent, %d were sent", sc.BlockNumber()-blockNo)
+	}
+}
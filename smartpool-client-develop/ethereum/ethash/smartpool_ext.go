@@ -41,6 +41,23 @@ func (ethash *Ethash) GetVerificationIndices(blockNumber uint64, hash common.Has
 	return hashimotoLightIndices(size, cache, hash.Bytes(), nonce)
 }
 
+// Hashimoto returns the mix digest and the final pow value of a header hash
+// and nonce. It uses the light verification cache of the block's epoch so it
+// doesn't require the full DAG.
+func (ethash *Ethash) Hashimoto(blockNumber uint64, hash common.Hash, nonce uint64) ([]byte, []byte) {
+	cache := ethash.cache(blockNumber)
+
+	size := datasetSize(blockNumber)
+	return hashimotoLight(size, cache, hash.Bytes(), nonce)
+}
+
+// HashimotoWithLookup returns the mix digest and the final pow value of a
+// header hash and nonce computed over the dataset items lookup returns. It
+// lets callers verify a pow with only the DAG elements it accessed.
+func HashimotoWithLookup(hash common.Hash, nonce uint64, size uint64, lookup func(index uint32) []uint32) ([]byte, []byte) {
+	return hashimoto(hash.Bytes(), nonce, size, lookup)
+}
+
 func hashimotoLightIndices(size uint64, cache []uint32, hash []byte, nonce uint64) []uint32 {
 	keccak512 := makeHasher(sha3.NewKeccak512())
 
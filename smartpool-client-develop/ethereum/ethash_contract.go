@@ -2,40 +2,133 @@ package ethereum
 
 import (
 	"fmt"
-	"github.com/SmartPool/smartpool-client"
-	"github.com/SmartPool/smartpool-client/ethereum/ethash"
-	"github.com/SmartPool/smartpool-client/mtree"
 	"math/big"
 )
 
+// SEED_CHUNK_SIZE is the max number of merkle nodes set by one setEpochData
+// tx.
+var SEED_CHUNK_SIZE int = 40
+
+// EpochChunk is a run of consecutive merkle nodes of an epoch's data that
+// are set by one tx.
+type EpochChunk struct {
+	Start int
+	Nodes []*big.Int
+}
+
+// SeedProgress is how far setting the data of an epoch got. Txs, GasUsed and
+// Fee add up all setEpochData txs sent for the epoch, including the ones
+// sent before a restart.
+type SeedProgress struct {
+	Epoch    uint64
+	NumNodes int
+	SetNodes int
+	Txs      int
+	GasUsed  *big.Int
+	Fee      *big.Int
+}
+
+func (p *SeedProgress) Done() bool {
+	return p.NumNodes > 0 && p.SetNodes == p.NumNodes
+}
+
+func NewSeedProgress(epoch uint64) *SeedProgress {
+	return &SeedProgress{epoch, 0, 0, 0, big.NewInt(0), big.NewInt(0)}
+}
+
 type EthashContract struct {
 	ethashClient EthashContractClient
 }
 
-func (c *EthashContract) SetEpochData(epoch int) error {
-	var err error
-	smartpool.Output.Printf("Checking DAG file. Generate if needed...\n")
-	ethash.MakeDAG(uint64(epoch*30000), ethash.DefaultDir)
-	fullSize := ethash.DAGSize(uint64(epoch * 30000))
-	fullSizeIn128Resolution := fullSize / 128
-	path := ethash.PathToDAG(uint64(epoch), ethash.DefaultDir)
-	branchDepth := len(fmt.Sprintf("%b", fullSizeIn128Resolution-1))
-	mt := mtree.NewDagTree()
-	// TODO: 10 is just an experimental level
-	mt.RegisterStoredLevel(uint32(branchDepth), 10)
-	processDuringRead(path, mt)
-	mt.Finalize()
-	err = c.ethashClient.SetEpochData(
-		big.NewInt(int64(epoch)),
-		big.NewInt(int64(fullSizeIn128Resolution)),
-		big.NewInt(int64(branchDepth-10)),
-		mt.MerkleNodes(),
-	)
+// MissingChunks checks which of the merkle nodes of epoch are not set on
+// the contract yet and splits them into chunks of at most SEED_CHUNK_SIZE
+// consecutive nodes. The contract doesn't let set nodes be set again so it
+// returns an error if any node on the contract differs from nodes.
+func (c *EthashContract) MissingChunks(epoch uint64, nodes []*big.Int) ([]*EpochChunk, error) {
+	chunks := []*EpochChunk{}
+	var chunk *EpochChunk
+	wrong := []int{}
+	for i, node := range nodes {
+		onChain, err := c.ethashClient.EpochNode(
+			new(big.Int).SetUint64(epoch), big.NewInt(int64(i)))
+		if err != nil {
+			return nil, err
+		}
+		if onChain.Cmp(node) == 0 {
+			chunk = nil
+			continue
+		}
+		if onChain.Sign() != 0 {
+			logger.Warnf("Merkle node %d of epoch %d on the contract is 0x%s, expected 0x%s.\n",
+				i, epoch, onChain.Text(16), node.Text(16))
+			wrong = append(wrong, i)
+			chunk = nil
+			continue
+		}
+		if chunk == nil || len(chunk.Nodes) == SEED_CHUNK_SIZE {
+			chunk = &EpochChunk{i, []*big.Int{}}
+			chunks = append(chunks, chunk)
+		}
+		chunk.Nodes = append(chunk.Nodes, node)
+	}
+	if len(wrong) > 0 {
+		return nil, fmt.Errorf(
+			"%d merkle nodes of epoch %d on the contract differ from the DAG, the first is node %d",
+			len(wrong), epoch, wrong[0])
+	}
+	return chunks, nil
+}
+
+// SetEpochData sets the merkle nodes of dm, the DAG merkle tree of epoch,
+// that are not set on the contract yet. Already set nodes are skipped so an
+// interrupted upload resumes where it stopped. progress is updated and
+// saved after every tx.
+func (c *EthashContract) SetEpochData(
+	epoch uint64, dm *DagMerkle, progress *SeedProgress,
+	save func(*SeedProgress)) error {
+	nodes, err := dm.MerkleNodes()
 	if err != nil {
-		fmt.Printf("Got error: %s\n", err)
 		return err
 	}
-	fmt.Printf("Done.\n")
+	chunks, err := c.MissingChunks(epoch, nodes)
+	if err != nil {
+		return err
+	}
+	missing := 0
+	for _, chunk := range chunks {
+		missing += len(chunk.Nodes)
+	}
+	progress.NumNodes = len(nodes)
+	progress.SetNodes = len(nodes) - missing
+	save(progress)
+	if missing == 0 {
+		return nil
+	}
+	logger.Infof("Setting %d of %d merkle nodes of epoch %d in %d txs...\n",
+		missing, len(nodes), epoch, len(chunks))
+	for i, chunk := range chunks {
+		gasUsed, fee, err := c.ethashClient.SetEpochData(
+			new(big.Int).SetUint64(epoch),
+			big.NewInt(int64(dm.NumElements())),
+			big.NewInt(int64(dm.BranchDepth()-DAG_STORED_LEVEL)),
+			chunk.Nodes,
+			big.NewInt(int64(chunk.Start)),
+		)
+		if gasUsed != nil {
+			progress.Txs++
+			progress.GasUsed.Add(progress.GasUsed, gasUsed)
+			progress.Fee.Add(progress.Fee, fee)
+		}
+		if err != nil {
+			save(progress)
+			return err
+		}
+		progress.SetNodes += len(chunk.Nodes)
+		save(progress)
+		logger.Infof("Set chunk %d/%d of epoch %d: nodes %d-%d (%d/%d).\n",
+			i+1, len(chunks), epoch, chunk.Start, chunk.Start+len(chunk.Nodes)-1,
+			progress.SetNodes, progress.NumNodes)
+	}
 	return nil
 }
 
@@ -0,0 +1,173 @@
+package ethminer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// AdminService serves the admin_ JSON-RPC namespace operators use to control
+// a running client. Requests must carry the admin token as a bearer token in
+// the Authorization header.
+type AdminService struct {
+	token     string
+	feePolicy *geth.FeePolicy
+	stratum   *StratumServer
+}
+
+type OpenClaimData struct {
+	Index      int      `json:"index"`
+	NumShares  *big.Int `json:"num_shares"`
+	Difficulty *big.Int `json:"difficulty"`
+	Min        string   `json:"min_counter"`
+	Max        string   `json:"max_counter"`
+}
+
+type OpenClaimsData struct {
+	SubmitterPaused bool             `json:"submitter_paused"`
+	SubmissionState string           `json:"submission_state"`
+	Claims          []*OpenClaimData `json:"claims"`
+}
+
+func (server *AdminService) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) == 1
+}
+
+func gweiArgument(arg json.RawMessage) (*big.Int, Error) {
+	var gwei uint64
+	if e := json.Unmarshal(arg, &gwei); e != nil {
+		return nil, &invalidParamsError{e.Error()}
+	}
+	return new(big.Int).Mul(new(big.Int).SetUint64(gwei), big.NewInt(1000000000)), nil
+}
+
+// parseGasPriceArguments parses gas price, max fee and priority fee in gwei.
+// Only gas price is required, a 0 gas price uses the node's suggestion.
+func parseGasPriceArguments(payload json.RawMessage) (*big.Int, *big.Int, *big.Int, Error) {
+	args := []json.RawMessage{}
+	if e := json.Unmarshal(payload, &args); e != nil {
+		return nil, nil, nil, &invalidParamsError{e.Error()}
+	}
+	if len(args) < 1 || len(args) > 3 {
+		return nil, nil, nil, &invalidParamsError{"expected gas price, max fee and priority fee in gwei"}
+	}
+	fees := []*big.Int{nil, nil, nil}
+	for i, arg := range args {
+		fee, err := gweiArgument(arg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		fees[i] = fee
+	}
+	if fees[0].Sign() == 0 {
+		fees[0] = nil
+	}
+	return fees[0], fees[1], fees[2], nil
+}
+
+func parseRigArgument(payload json.RawMessage) (string, Error) {
+	args := [1]string{}
+	if e := json.Unmarshal(payload, &args); e != nil {
+		return "", &invalidParamsError{e.Error()}
+	}
+	if args[0] == "" {
+		return "", &invalidParamsError{"missing rig"}
+	}
+	return args[0], nil
+}
+
+func (server *AdminService) openClaims() *OpenClaimsData {
+	result := &OpenClaimsData{
+		SmartPool.SubmitterPaused(),
+		SmartPool.SubmissionState(),
+		[]*OpenClaimData{},
+	}
+	for i, claim := range SmartPool.OpenClaims() {
+		result.Claims = append(result.Claims, &OpenClaimData{
+			i,
+			claim.NumShares(),
+			claim.Difficulty(),
+			"0x" + claim.Min().Text(16),
+			"0x" + claim.Max().Text(16),
+		})
+	}
+	return result
+}
+
+func (server *AdminService) call(method string, rawParams json.RawMessage) (interface{}, Error) {
+	switch method {
+	case "admin_forceSubmit":
+		if e := SmartPool.ForceSubmit(); e != nil {
+			return nil, &callbackError{e.Error()}
+		}
+	case "admin_pauseSubmitter":
+		SmartPool.PauseSubmitter()
+	case "admin_resumeSubmitter":
+		SmartPool.ResumeSubmitter()
+	case "admin_resetOpenClaims":
+		if e := SmartPool.ResetOpenClaims(); e != nil {
+			return nil, &callbackError{e.Error()}
+		}
+	case "admin_setGasPrice":
+		gasPrice, maxFee, priorityFee, err := parseGasPriceArguments(rawParams)
+		if err != nil {
+			return nil, err
+		}
+		server.feePolicy.SetGasPrice(gasPrice, maxFee, priorityFee)
+		logger.Infof("Gas price is changed on operator's request.\n")
+	case "admin_dropRig":
+		rig, err := parseRigArgument(rawParams)
+		if err != nil {
+			return nil, err
+		}
+		if server.stratum == nil {
+			return 0, nil
+		}
+		return server.stratum.DropRig(rig), nil
+	case "admin_listOpenClaims":
+		return server.openClaims(), nil
+	case "admin_persistNow":
+		SmartPool.Persist()
+	default:
+		return nil, &methodNotFoundError{method}
+	}
+	return true, nil
+}
+
+func (server *AdminService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !server.authorized(r) {
+		logger.Warnf("Rejected admin request from %s without valid token.\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		server.response(w, createErrorResponse(nil, &unauthorizedError{}))
+		return
+	}
+	method, rawParams, id, err := extractRPCMsg(r)
+	if err != nil {
+		server.response(w, createErrorResponse(id, err))
+		return
+	}
+	logger.Infof("Admin request %s from %s.\n", method, r.RemoteAddr)
+	res, err := server.call(method, rawParams)
+	if err != nil {
+		server.response(w, createErrorResponse(id, err))
+	} else {
+		server.response(w, createResponse(id, res))
+	}
+}
+
+func (server *AdminService) response(w http.ResponseWriter, resp interface{}) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(resp)
+}
+
+func NewAdminService(token string, feePolicy *geth.FeePolicy, stratum *StratumServer) *AdminService {
+	return &AdminService{token, feePolicy, stratum}
+}
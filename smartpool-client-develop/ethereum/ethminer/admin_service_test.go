@@ -0,0 +1,148 @@
+package ethminer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	adminTestContract = common.HexToAddress("0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96")
+	adminTestMiner    = common.HexToAddress("0xe034afdcc2ba0441ff215ee9ba0da3e86450108d")
+)
+
+// newAdminTestService sets SmartPool to a pool of a simulated contract
+// keeping its state in a temp dir.
+func newAdminTestService(t *testing.T) (*AdminService, *geth.FeePolicy, func()) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := storage.NewBoltStorage(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	sc := ethereum.NewSimulatedContract(adminTestContract, adminTestContract)
+	claimRepo := ethereum.NewTimestampClaimRepo(
+		big.NewInt(100000), adminTestMiner.Hex(), adminTestContract.Hex(),
+		ps, ethereum.RestoreAbort,
+	)
+	SmartPool = protocol.NewSmartPool(
+		nil, nil, nil, claimRepo, ps,
+		ethereum.NewContract(sc.NewClient(adminTestMiner), adminTestMiner),
+		nil, adminTestContract, adminTestMiner, "", time.Minute, 1, 1, true, nil,
+	)
+	feePolicy := &geth.FeePolicy{BumpPercent: 10}
+	return NewAdminService("secret", feePolicy, nil), feePolicy, func() {
+		SmartPool = nil
+		ps.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// adminCall sends a request of method with params to server and decodes
+// the response into result.
+func adminCall(t *testing.T, server *AdminService, token string, method string, params string, result interface{}) int {
+	body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":` + params + `}`
+	req := httptest.NewRequest("POST", "/admin", bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+		t.Fatalf("couldn't decode response %q: %s", w.Body.String(), err)
+	}
+	return w.Code
+}
+
+func TestAdminServiceRejectsRequestWithoutToken(t *testing.T) {
+	server, _, cleanup := newAdminTestService(t)
+	defer cleanup()
+	for _, token := range []string{"", "wrong"} {
+		resp := jsonErrResponse{}
+		code := adminCall(t, server, token, "admin_pauseSubmitter", "[]", &resp)
+		if code != http.StatusUnauthorized || resp.Error.Code != -32001 {
+			t.Fatalf("expected token %q to be unauthorized, got %d %+v", token, code, resp)
+		}
+	}
+	if SmartPool.SubmitterPaused() {
+		t.Fatalf("expected unauthorized request not to pause the submitter")
+	}
+}
+
+func TestAdminServiceResetsOpenClaimsOfPausedSubmitter(t *testing.T) {
+	server, _, cleanup := newAdminTestService(t)
+	defer cleanup()
+	errResp := jsonErrResponse{}
+	adminCall(t, server, "secret", "admin_resetOpenClaims", "[]", &errResp)
+	if errResp.Error.Code != -32000 {
+		t.Fatalf("expected reset to be refused while the submitter runs, got %+v", errResp)
+	}
+	resp := jsonSuccessResponse{}
+	adminCall(t, server, "secret", "admin_pauseSubmitter", "[]", &resp)
+	if resp.Result != true || !SmartPool.SubmitterPaused() {
+		t.Fatalf("expected the submitter to be paused, got %+v", resp)
+	}
+	resp = jsonSuccessResponse{}
+	adminCall(t, server, "secret", "admin_resetOpenClaims", "[]", &resp)
+	if resp.Result != true {
+		t.Fatalf("expected reset to succeed, got %+v", resp)
+	}
+	list := struct {
+		Result OpenClaimsData `json:"result"`
+	}{}
+	adminCall(t, server, "secret", "admin_listOpenClaims", "[]", &list)
+	if !list.Result.SubmitterPaused || list.Result.SubmissionState != protocol.SubmissionIdle ||
+		len(list.Result.Claims) != 0 {
+		t.Fatalf("unexpected open claims %+v", list.Result)
+	}
+}
+
+func TestAdminServiceSetsGasPrice(t *testing.T) {
+	server, feePolicy, cleanup := newAdminTestService(t)
+	defer cleanup()
+	resp := jsonSuccessResponse{}
+	adminCall(t, server, "secret", "admin_setGasPrice", "[2, 100, 3]", &resp)
+	if resp.Result != true ||
+		feePolicy.GasPrice.Cmp(big.NewInt(2000000000)) != 0 ||
+		feePolicy.MaxFeePerGas.Cmp(big.NewInt(100000000000)) != 0 ||
+		feePolicy.PriorityFee.Cmp(big.NewInt(3000000000)) != 0 {
+		t.Fatalf("expected fees to be set, got %+v", resp)
+	}
+	for _, params := range []string{"[]", `["2"]`, "[1, 2, 3, 4]"} {
+		errResp := jsonErrResponse{}
+		adminCall(t, server, "secret", "admin_setGasPrice", params, &errResp)
+		if errResp.Error.Code != -32602 {
+			t.Fatalf("expected params %s to be invalid, got %+v", params, errResp)
+		}
+	}
+}
+
+func TestAdminServiceRejectsUnknownMethod(t *testing.T) {
+	server, _, cleanup := newAdminTestService(t)
+	defer cleanup()
+	errResp := jsonErrResponse{}
+	adminCall(t, server, "secret", "admin_mine", "[]", &errResp)
+	if errResp.Error.Code != -32601 {
+		t.Fatalf("expected method not found, got %+v", errResp)
+	}
+	errResp = jsonErrResponse{}
+	adminCall(t, server, "secret", "admin_dropRig", `[""]`, &errResp)
+	if errResp.Error.Code != -32602 {
+		t.Fatalf("expected a missing rig to be invalid, got %+v", errResp)
+	}
+}
@@ -55,3 +55,26 @@ type shutdownError struct{}
 func (e *shutdownError) ErrorCode() int { return -32000 }
 
 func (e *shutdownError) Error() string { return "server is shutting down" }
+
+// request doesn't carry the admin token
+type unauthorizedError struct{}
+
+func (e *unauthorizedError) ErrorCode() int { return -32001 }
+
+func (e *unauthorizedError) Error() string { return "unauthorized" }
+
+// rig is banned or calls the server too often
+type throttledError struct{}
+
+func (e *throttledError) ErrorCode() int { return -32005 }
+
+func (e *throttledError) Error() string { return "rig is banned or throttled" }
+
+// requested method doesn't exist
+type methodNotFoundError struct{ method string }
+
+func (e *methodNotFoundError) ErrorCode() int { return -32601 }
+
+func (e *methodNotFoundError) Error() string {
+	return "the method " + e.method + " does not exist/is not available"
+}
@@ -0,0 +1,46 @@
+package ethminer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"net/http"
+)
+
+// number of events buffered for each dashboard before events are dropped
+var EVENTS_BUFFER = 100
+
+// EventsService streams SmartPool events to the dashboard as server-sent
+// events.
+type EventsService struct{}
+
+func (server *EventsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	events, unsubscribe := smartpool.SubscribeEvents(EVENTS_BUFFER)
+	defer unsubscribe()
+	closed := w.(http.CloseNotifier).CloseNotify()
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				logger.Warnf("Couldn't encode event %s: %s\n", e.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func NewEventsService() *EventsService {
+	return &EventsService{}
+}
@@ -0,0 +1,7 @@
+package ethminer
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+var logger = smartpool.NewLogger("ethminer")
@@ -0,0 +1,168 @@
+package ethminer
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"github.com/SmartPool/smartpool-client/ethereum/stat"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsService exposes farm, rig and protocol stats in Prometheus text
+// format.
+type MetricsService struct{}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricWriter writes metrics in Prometheus text exposition format.
+type metricWriter struct {
+	w io.Writer
+}
+
+func (mw *metricWriter) describe(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a value of metric name. labels are label names followed by
+// their values.
+func (mw *metricWriter) sample(name string, value float64, labels ...string) {
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(
+			"%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		name = fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(mw.w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func bigToFloat(n *big.Int) float64 {
+	result, _ := new(big.Float).SetInt(n).Float64()
+	return result
+}
+
+func unixTime(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+func writeFarmMetrics(mw *metricWriter, sr *stat.StatRecorder) {
+	farm := sr.FarmSnapshot()
+	mw.describe("smartpool_shares_total", "counter", "Number of shares submitted by rigs by status.")
+	mw.sample("smartpool_shares_total", float64(farm.MinedShare), "status", "mined")
+	mw.sample("smartpool_shares_total", float64(farm.ValidShare), "status", "valid")
+	mw.sample("smartpool_shares_total", float64(farm.RejectedShare), "status", "rejected")
+	mw.sample("smartpool_shares_total", float64(farm.StaleShare), "status", "stale")
+	mw.sample("smartpool_shares_total", float64(farm.OrphanedShare), "status", "orphaned")
+	mw.sample("smartpool_shares_total", float64(farm.BadMixShare), "status", "badmix")
+	mw.sample("smartpool_shares_total", float64(farm.VerifiedShare), "status", "verified")
+	mw.sample("smartpool_shares_total", float64(farm.BadShare), "status", "bad")
+	mw.sample("smartpool_shares_total", float64(farm.AbandonedShare), "status", "abandoned")
+	mw.describe("smartpool_being_validated_shares", "gauge", "Number of shares in submitted claims waiting for verification.")
+	mw.sample("smartpool_being_validated_shares", float64(farm.BeingValidatedShare))
+	mw.describe("smartpool_claims_total", "counter", "Number of claims by status.")
+	mw.sample("smartpool_claims_total", float64(farm.SubmittedClaim), "status", "submitted")
+	mw.sample("smartpool_claims_total", float64(farm.AcceptedClaim), "status", "accepted")
+	mw.sample("smartpool_claims_total", float64(farm.RejectedClaim), "status", "rejected")
+	mw.describe("smartpool_blocks_found_total", "counter", "Number of full block solutions found by the farm.")
+	mw.sample("smartpool_blocks_found_total", float64(farm.BlockFound))
+	mw.describe("smartpool_blocks_total", "counter", "Number of found blocks by their outcome once they matured.")
+	mw.sample("smartpool_blocks_total", float64(farm.CanonicalBlock), "status", "canonical")
+	mw.sample("smartpool_blocks_total", float64(farm.UncleBlock), "status", "uncle")
+	mw.sample("smartpool_blocks_total", float64(farm.LostBlock), "status", "lost")
+	mw.describe("smartpool_block_rewards_wei_total", "counter", "Static rewards in wei credited to the contract for found blocks.")
+	mw.sample("smartpool_block_rewards_wei_total", bigToFloat(farm.BlockReward))
+	mw.describe("smartpool_bans_total", "counter", "Number of times rigs were banned.")
+	mw.sample("smartpool_bans_total", float64(farm.Ban))
+	mw.describe("smartpool_throttled_requests_total", "counter", "Number of requests of rigs refused because of their request rate.")
+	mw.sample("smartpool_throttled_requests_total", float64(farm.ThrottledRequest))
+	mw.describe("smartpool_hashrate", "gauge", "Hashrate of the farm in hashes per second.")
+	mw.sample("smartpool_hashrate", bigToFloat(farm.ReportedHashrate), "type", "reported")
+	mw.sample("smartpool_hashrate", bigToFloat(farm.EffectiveHashrate), "type", "effective")
+	mw.describe("smartpool_last_valid_share_timestamp_seconds", "gauge", "Unix time of the last valid share. 0 if there is no valid share yet.")
+	mw.sample("smartpool_last_valid_share_timestamp_seconds", unixTime(farm.LastValidShare))
+
+	rigs := sr.RigSnapshots()
+	mw.describe("smartpool_rig_shares_total", "counter", "Number of shares submitted by the rig by status.")
+	for _, rig := range rigs {
+		mw.sample("smartpool_rig_shares_total", float64(rig.MinedShare), "rig", rig.ID, "ip", rig.IP, "status", "mined")
+		mw.sample("smartpool_rig_shares_total", float64(rig.ValidShare), "rig", rig.ID, "ip", rig.IP, "status", "valid")
+		mw.sample("smartpool_rig_shares_total", float64(rig.RejectedShare), "rig", rig.ID, "ip", rig.IP, "status", "rejected")
+		mw.sample("smartpool_rig_shares_total", float64(rig.StaleShare), "rig", rig.ID, "ip", rig.IP, "status", "stale")
+		mw.sample("smartpool_rig_shares_total", float64(rig.OrphanedShare), "rig", rig.ID, "ip", rig.IP, "status", "orphaned")
+		mw.sample("smartpool_rig_shares_total", float64(rig.BadMixShare), "rig", rig.ID, "ip", rig.IP, "status", "badmix")
+	}
+	mw.describe("smartpool_rig_stale_rate", "gauge", "Fraction of the rig's accepted shares mined on stale or orphaned works.")
+	for _, rig := range rigs {
+		mw.sample("smartpool_rig_stale_rate", rig.StaleRate, "rig", rig.ID, "ip", rig.IP)
+	}
+	mw.describe("smartpool_rig_blocks_found_total", "counter", "Number of full block solutions found by the rig.")
+	for _, rig := range rigs {
+		mw.sample("smartpool_rig_blocks_found_total", float64(rig.BlockFound), "rig", rig.ID, "ip", rig.IP)
+	}
+	mw.describe("smartpool_rig_banned", "gauge", "1 if the rig is banned, 0 otherwise.")
+	for _, rig := range rigs {
+		banned := 0.0
+		if rig.BannedUntil.After(time.Now()) {
+			banned = 1
+		}
+		mw.sample("smartpool_rig_banned", banned, "rig", rig.ID, "ip", rig.IP)
+	}
+	mw.describe("smartpool_rig_throttled_requests_total", "counter", "Number of requests of the rig refused because of its request rate.")
+	for _, rig := range rigs {
+		mw.sample("smartpool_rig_throttled_requests_total", float64(rig.ThrottledRequest), "rig", rig.ID, "ip", rig.IP)
+	}
+	mw.describe("smartpool_rig_hashrate", "gauge", "Hashrate of the rig in hashes per second.")
+	for _, rig := range rigs {
+		mw.sample("smartpool_rig_hashrate", bigToFloat(rig.ReportedHashrate), "rig", rig.ID, "ip", rig.IP, "type", "reported")
+		mw.sample("smartpool_rig_hashrate", bigToFloat(rig.EffectiveHashrate), "rig", rig.ID, "ip", rig.IP, "type", "effective")
+	}
+}
+
+func writeProtocolMetrics(mw *metricWriter) {
+	mw.describe("smartpool_open_claims", "gauge", "Number of submitted claims in the current claim batch.")
+	mw.sample("smartpool_open_claims", float64(SmartPool.ClaimRepo.NumOpenClaims()))
+	mw.describe("smartpool_pending_shares", "gauge", "Number of shares that are not in any claim yet.")
+	mw.sample("smartpool_pending_shares", float64(SmartPool.ClaimRepo.NoActiveShares()))
+	mw.describe("smartpool_latest_counter", "gauge", "Max counter of the last sealed claim.")
+	mw.sample("smartpool_latest_counter", bigToFloat(SmartPool.GetLatestCounter()))
+	if wp, ok := SmartPool.ShareReceiver.(*ethereum.WorkPool); ok {
+		mw.describe("smartpool_workpool_size", "gauge", "Number of works miners can submit solutions for.")
+		mw.sample("smartpool_workpool_size", float64(wp.Size()))
+	}
+	mw.describe("smartpool_tx_rebroadcasts_total", "counter", "Number of txs rebroadcasted with higher fees.")
+	mw.sample("smartpool_tx_rebroadcasts_total", float64(geth.RebroadcastCount()))
+	gasUsed, fee := geth.TotalTxCost()
+	mw.describe("smartpool_tx_gas_used_total", "counter", "Gas used by txs sent to the contract.")
+	mw.sample("smartpool_tx_gas_used_total", bigToFloat(gasUsed))
+	mw.describe("smartpool_tx_fees_wei_total", "counter", "Fees in wei paid by txs sent to the contract.")
+	mw.sample("smartpool_tx_fees_wei_total", bigToFloat(fee))
+	if batches := geth.BatchCosts(); len(batches) > 0 {
+		last := batches[len(batches)-1]
+		mw.describe("smartpool_last_batch_gas_used", "gauge", "Gas used by txs of the last verified claim batch.")
+		mw.sample("smartpool_last_batch_gas_used", bigToFloat(last.GasUsed))
+		mw.describe("smartpool_last_batch_fees_wei", "gauge", "Fees in wei paid by txs of the last verified claim batch.")
+		mw.sample("smartpool_last_batch_fees_wei", bigToFloat(last.Fee))
+	}
+}
+
+func (server *MetricsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mw := &metricWriter{w}
+	if sr, ok := SmartPool.StatRecorder.(*stat.StatRecorder); ok {
+		writeFarmMetrics(mw, sr)
+	}
+	writeProtocolMetrics(mw)
+}
+
+func NewMetricsService() *MetricsService {
+	return &MetricsService{}
+}
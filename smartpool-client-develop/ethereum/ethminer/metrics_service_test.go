@@ -0,0 +1,149 @@
+package ethminer
+
+import (
+	"bufio"
+	"github.com/SmartPool/smartpool-client/ethereum/stat"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"math/big"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type metricsClaimRepo struct {
+	protocol.ClaimRepo
+}
+
+func (cr *metricsClaimRepo) NumOpenClaims() uint64  { return 2 }
+func (cr *metricsClaimRepo) NoActiveShares() uint64 { return 11 }
+
+// newMetricsTestRecorder returns stats of a farm with one rig whose name
+// has to be escaped in labels.
+func newMetricsTestRecorder() *stat.StatRecorder {
+	farm := stat.NewFarmData()
+	farm.MinedShare = 10
+	farm.ValidShare = 7
+	farm.RejectedShare = 3
+	farm.StaleShare = 2
+	farm.BeingValidatedShare = 5
+	farm.SubmittedClaim = 4
+	farm.CanonicalBlock = 1
+	farm.BlockReward = new(big.Int).Mul(big.NewInt(3), big.NewInt(1e18))
+	farm.ReportedHashrate = big.NewInt(1000)
+	farm.EffectiveHashrate = big.NewInt(900)
+	farm.LastValidShare = time.Unix(1500000000, 0)
+	rigID := `rig"1-192.0.2.1`
+	farm.Rigs[rigID] = stat.NewRigHashrate("192.0.2.1")
+	rig := stat.NewRigData(rigID)
+	rig.MinedShare = 6
+	rig.StaleShare = 1
+	rig.StaleRate = 0.25
+	rig.BannedUntil = time.Now().Add(time.Hour)
+	rig.AverageReportedHashrate = big.NewInt(500)
+	return &stat.StatRecorder{
+		RigDatas: map[string]*stat.RigData{rigID: rig},
+		FarmData: farm,
+	}
+}
+
+// scrapeMetrics returns samples served by the metrics service by metric
+// name with labels and checks every sample follows HELP and TYPE lines of
+// its metric.
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	w := httptest.NewRecorder()
+	NewMetricsService().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	samples := map[string]float64{}
+	types := map[string]string{}
+	help := map[string]bool{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") {
+			help[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 || !help[fields[2]] {
+				t.Fatalf("expected TYPE after HELP of the metric, got %q", line)
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+		sep := strings.LastIndex(line, " ")
+		if sep < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		value, err := strconv.ParseFloat(line[sep+1:], 64)
+		if err != nil {
+			t.Fatalf("malformed value of %q: %s", line, err)
+		}
+		name := line[:sep]
+		if i := strings.Index(name, "{"); i >= 0 {
+			if !strings.HasSuffix(name, "}") {
+				t.Fatalf("malformed labels of %q", line)
+			}
+			name = name[:i]
+		}
+		if kind := types[name]; kind != "counter" && kind != "gauge" {
+			t.Fatalf("expected a type of %s before its samples, got %q", name, kind)
+		}
+		samples[line[:sep]] = value
+	}
+	return samples
+}
+
+func TestMetricsServiceExposesStats(t *testing.T) {
+	SmartPool = &protocol.SmartPool{
+		ClaimRepo:     &metricsClaimRepo{},
+		StatRecorder:  newMetricsTestRecorder(),
+		LatestCounter: big.NewInt(42),
+	}
+	defer func() { SmartPool = nil }()
+	samples := scrapeMetrics(t)
+	rigLabels := `rig="rig\"1-192.0.2.1",ip="192.0.2.1"`
+	expected := map[string]float64{
+		`smartpool_shares_total{status="mined"}`:                       10,
+		`smartpool_shares_total{status="valid"}`:                       7,
+		`smartpool_shares_total{status="rejected"}`:                    3,
+		`smartpool_shares_total{status="stale"}`:                       2,
+		`smartpool_shares_total{status="badmix"}`:                      0,
+		`smartpool_being_validated_shares`:                             5,
+		`smartpool_claims_total{status="submitted"}`:                   4,
+		`smartpool_blocks_total{status="canonical"}`:                   1,
+		`smartpool_block_rewards_wei_total`:                            3e18,
+		`smartpool_hashrate{type="reported"}`:                          1000,
+		`smartpool_hashrate{type="effective"}`:                         900,
+		`smartpool_last_valid_share_timestamp_seconds`:                 1500000000,
+		`smartpool_rig_shares_total{` + rigLabels + `,status="mined"}`: 6,
+		`smartpool_rig_shares_total{` + rigLabels + `,status="stale"}`: 1,
+		`smartpool_rig_stale_rate{` + rigLabels + `}`:                  0.25,
+		`smartpool_rig_banned{` + rigLabels + `}`:                      1,
+		`smartpool_rig_hashrate{` + rigLabels + `,type="reported"}`:    500,
+		`smartpool_open_claims`:                                        2,
+		`smartpool_pending_shares`:                                     11,
+		`smartpool_latest_counter`:                                     42,
+	}
+	for name, value := range expected {
+		got, ok := samples[name]
+		if !ok {
+			t.Fatalf("expected sample %s", name)
+		}
+		if got != value {
+			t.Fatalf("expected %s to be %g, got %g", name, value, got)
+		}
+	}
+	for _, name := range []string{"smartpool_tx_rebroadcasts_total", "smartpool_tx_gas_used_total", "smartpool_tx_fees_wei_total"} {
+		if _, ok := samples[name]; !ok {
+			t.Fatalf("expected sample %s", name)
+		}
+	}
+	if _, ok := samples["smartpool_workpool_size"]; ok {
+		t.Fatalf("expected no workpool size without a work pool")
+	}
+}
@@ -0,0 +1,91 @@
+package ethminer
+
+import (
+	"encoding/json"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MAX_PROOF_INDICES limits the DAG elements a proof request can ask for.
+// A share needs 64.
+var MAX_PROOF_INDICES int = 256
+
+// ProofService serves DAG proofs to SmartPool clients running with
+// --proof-server. Only epochs whose DAG merkle tree is already built are
+// served so requests can't make this machine generate DAGs.
+type ProofService struct {
+	proofs *ethereum.LocalProofProvider
+}
+
+// availableEpoch parses the epoch of a request. It responds with an error
+// and returns false if the epoch is invalid or not served.
+func availableEpoch(proofs *ethereum.LocalProofProvider, w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	epoch, err := strconv.ParseUint(r.URL.Query().Get(":epoch"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid epoch", http.StatusBadRequest)
+		return 0, false
+	}
+	if !proofs.HasEpoch(epoch) {
+		http.Error(w, "epoch is not available", http.StatusNotFound)
+		return 0, false
+	}
+	return epoch, true
+}
+
+func (server *ProofService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	epoch, ok := availableEpoch(server.proofs, w, r)
+	if !ok {
+		return
+	}
+	indices := []uint32{}
+	for _, s := range strings.Split(r.URL.Query().Get("indices"), ",") {
+		index, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid indices", http.StatusBadRequest)
+			return
+		}
+		indices = append(indices, uint32(index))
+	}
+	if len(indices) > MAX_PROOF_INDICES {
+		http.Error(w, "too many indices", http.StatusBadRequest)
+		return
+	}
+	proof, err := server.proofs.DagProof(epoch, indices)
+	if err != nil {
+		logger.Warnf("Couldn't prove DAG elements of epoch %d: %s\n", epoch, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(ethereum.NewDagProofData(proof))
+}
+
+func NewProofService(proofs *ethereum.LocalProofProvider) *ProofService {
+	return &ProofService{proofs}
+}
+
+// EpochDataService serves the data of epochs set on the Ethash contract so
+// clients running with --proof-server can check their DAG proofs before
+// verifying claims.
+type EpochDataService struct {
+	proofs *ethereum.LocalProofProvider
+}
+
+func (server *EpochDataService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	epoch, ok := availableEpoch(server.proofs, w, r)
+	if !ok {
+		return
+	}
+	data, err := server.proofs.EpochData(epoch)
+	if err != nil {
+		logger.Warnf("Couldn't get data of epoch %d: %s\n", epoch, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(ethereum.NewEpochDataJSON(data))
+}
+
+func NewEpochDataService(proofs *ethereum.LocalProofProvider) *EpochDataService {
+	return &EpochDataService{proofs}
+}
@@ -30,13 +30,25 @@ func (server *RPCService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		ip = parts[0]
 	}
-	service := NewSmartPoolService(rigName, ip)
 	method, rawParams, id, err := extractRPCMsg(r)
 	if err != nil {
 		res = createErrorResponse(id, err)
 		server.response(w, res)
 		return
 	}
+	sp := SmartPool
+	if minerName := r.URL.Query().Get(":miner"); minerName != "" {
+		if sp = minerPool(minerName); sp == nil {
+			server.response(w, createErrorResponse(
+				id, &invalidRequestError{"unknown miner " + minerName}))
+			return
+		}
+	}
+	service := NewSmartPoolService(sp, rigName, ip)
+	if !service.Allowed() {
+		server.response(w, createErrorResponse(id, &throttledError{}))
+		return
+	}
 	if method == "eth_getWork" {
 		res, e = service.GetWork()
 		if e != nil {
@@ -3,6 +3,9 @@ package ethminer
 import (
 	"fmt"
 	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/geth"
+	"github.com/SmartPool/smartpool-client/protocol"
 	"github.com/bmizerany/pat"
 	"net/http"
 	"os"
@@ -16,45 +19,123 @@ type Server struct {
 	rpcServer *RPCService
 	server    *http.Server
 	output    smartpool.UserOutput
+	stratum   *StratumServer
 }
 
 func (s *Server) Start() {
 	if SmartPool == nil {
 		panic("SmartPool instance must be initialized first.")
 	}
-	if SmartPool.Run() {
-		go func() {
-			<-SmartPool.SubmitterStopped
-			os.Exit(1)
-		}()
-		s.output.Printf("RPC Server is running...\n")
-		s.output.Printf("You can start mining now by running ethminer using following command:\n")
-		s.output.Printf("--------------------------\n")
-		s.output.Printf("ethminer -F localhost:1633/:worker_name/\n")
-		s.output.Printf("Change :worker_name to whichever name you want.\n")
-		s.output.Printf("--------------------------\n")
-		err := s.server.ListenAndServe()
-		if err != nil {
-			s.output.Printf("Stopped because of: %s\n", err.Error())
+	pools := smartPools()
+	for _, sp := range pools {
+		if !sp.Run() {
+			s.output.Printf("SmartPool couldn't run for miner %s. Exit.\n", sp.MinerAddress.Hex())
+			return
+		}
+	}
+	go exitWhenStopped(pools)
+	s.output.Printf("RPC Server is running...\n")
+	s.output.Printf("You can start mining now by running ethminer using following command:\n")
+	s.output.Printf("--------------------------\n")
+	s.output.Printf("ethminer -F localhost:%d/:worker_name/\n", s.Port)
+	s.output.Printf("Change :worker_name to whichever name you want.\n")
+	if len(pools) > 1 {
+		s.output.Printf("Rigs mine for %s by default. To mine for another miner, use:\n", SmartPool.MinerAddress.Hex())
+		for name, sp := range Miners {
+			if sp != SmartPool {
+				s.output.Printf("ethminer -F localhost:%d/%s/:worker_name/ for %s\n", s.Port, name, sp.MinerAddress.Hex())
+			}
 		}
-	} else {
-		s.output.Printf("SmartPool couldn't run. Exit.\n")
+	}
+	s.output.Printf("--------------------------\n")
+	if s.stratum != nil {
+		s.startStratum()
+	}
+	err := s.server.ListenAndServe()
+	if err != nil {
+		s.output.Printf("Stopped because of: %s\n", err.Error())
 	}
 }
 
-func NewServer(output smartpool.UserOutput, port uint16) *Server {
+// exitWhenStopped exits once the submitter of any miner stopped. Submitters
+// of the other miners are stopped first so all of them persist their state.
+func exitWhenStopped(pools []*protocol.SmartPool) {
+	stopped := make(chan *protocol.SmartPool, len(pools))
+	for _, sp := range pools {
+		go func(sp *protocol.SmartPool) {
+			<-sp.SubmitterStopped
+			stopped <- sp
+		}(sp)
+	}
+	first := <-stopped
+	for _, sp := range pools {
+		if sp != first {
+			sp.Stop()
+		}
+	}
+	for i := 1; i < len(pools); i++ {
+		<-stopped
+	}
+	os.Exit(1)
+}
+
+func (s *Server) startStratum() {
+	nc, ok := SmartPool.NetworkClient.(*ethereum.NetworkClient)
+	if !ok {
+		s.output.Printf("Stratum server requires ethereum network client. Stratum is disabled.\n")
+		return
+	}
+	s.output.Printf("Stratum server is running...\n")
+	s.output.Printf("Stratum miners can connect to stratum+tcp://localhost:%d\n", s.stratum.Port)
+	go func() {
+		err := s.stratum.Start(nc.SubscribeWork())
+		if err != nil {
+			s.output.Printf("Stratum server stopped because of: %s\n", err.Error())
+		}
+	}()
+}
+
+// NewServer creates the miner facing server listening on port of bind
+// address. When stratumPort is not 0, a stratum server is also started on
+// that port. When adminToken is not empty, the admin API is served at /admin
+// to requests carrying the token. It changes fees of feePolicy. When proofs
+// is not nil, DAG proofs of its epochs are served at /proof/:epoch and
+// their epoch data at /proof/:epoch/nodes. Rigs mine for the default miner
+// at /:rig/ and for any miner in Miners at /:miner/:rig/. Stats of a miner
+// are served at /:miner/:method/:scope.
+func NewServer(
+	output smartpool.UserOutput, bind string, port uint16, stratumPort uint16,
+	adminToken string, feePolicy *geth.FeePolicy,
+	proofs *ethereum.LocalProofProvider) *Server {
 	mux := pat.New()
 	rpcService := NewRPCService()
 	statService := NewStatService()
 	statusService := NewStatusService()
+	metricsService := NewMetricsService()
+	eventsService := NewEventsService()
 	webDir, _ := os.Executable()
 	statsDir := path.Join(path.Dir(webDir), "ethereum", "ethminer", "statistic")
+	var stratum *StratumServer
+	if stratumPort != 0 {
+		stratum = NewStratumServer(output, bind, stratumPort)
+	}
 	mux.Get("/stats/", http.StripPrefix("/stats/", http.FileServer(http.Dir(statsDir))))
+	if adminToken != "" {
+		mux.Post("/admin", NewAdminService(adminToken, feePolicy, stratum))
+	}
+	mux.Post("/:miner/:rig/", rpcService)
 	mux.Post("/:rig/", rpcService)
 	mux.Get("/status", statusService)
+	mux.Get("/metrics", metricsService)
+	mux.Get("/events", eventsService)
+	if proofs != nil {
+		mux.Get("/proof/:epoch/nodes", NewEpochDataService(proofs))
+		mux.Get("/proof/:epoch", NewProofService(proofs))
+	}
 	mux.Get("/:method/:scope", statService)
+	mux.Get("/:miner/:method/:scope", statService)
 	return &Server{port, rpcService, &http.Server{
-		Addr:    fmt.Sprintf("0.0.0.0:%d", port),
+		Addr:    fmt.Sprintf("%s:%d", bind, port),
 		Handler: mux,
-	}, output}
+	}, output, stratum}
 }
@@ -0,0 +1,102 @@
+package ethminer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hashrateRecorder records rigs submitting hashrate to its pool.
+type hashrateRecorder struct {
+	smartpool.StatRecorder
+	rigs []string
+}
+
+func (r *hashrateRecorder) RecordHashrate(hashrate hexutil.Uint64, id common.Hash, rig smartpool.Rig) {
+	r.rigs = append(r.rigs, rig.ID())
+}
+
+type hashrateClient struct {
+	smartpool.NetworkClient
+}
+
+func (c *hashrateClient) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
+	return true
+}
+
+func newRoutingTestPool(address string) (*protocol.SmartPool, *hashrateRecorder) {
+	recorder := &hashrateRecorder{}
+	return &protocol.SmartPool{
+		NetworkClient: &hashrateClient{},
+		StatRecorder:  recorder,
+		MinerAddress:  common.HexToAddress(address),
+	}, recorder
+}
+
+func submitHashrate(t *testing.T, server *Server, path string) jsonErrResponse {
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_submitHashrate","params":["0x10","0x0000000000000000000000000000000000000000000000000000000000000001"]}`
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewBufferString(body)))
+	resp := jsonErrResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("couldn't decode response %q of %s: %s", w.Body.String(), path, err)
+	}
+	return resp
+}
+
+func TestServerRoutesRigsToTheirMiners(t *testing.T) {
+	def, defRecorder := newRoutingTestPool("0x0000000000000000000000000000000000000001")
+	alice, aliceRecorder := newRoutingTestPool("0x00000000000000000000000000000000000000aB")
+	SmartPool = def
+	Miners = map[string]*protocol.SmartPool{"default": def, "alice": alice}
+	defer func() {
+		SmartPool = nil
+		Miners = map[string]*protocol.SmartPool{}
+	}()
+	server := NewServer(nil, "127.0.0.1", 1633, 0, "", nil, nil)
+	for _, path := range []string{"/rig1/", "/alice/rig2/", "/0x00000000000000000000000000000000000000ab/rig3/", "/default/rig4/"} {
+		if resp := submitHashrate(t, server, path); resp.Error.Code != 0 {
+			t.Fatalf("expected %s to be served, got %+v", path, resp.Error)
+		}
+	}
+	ip := "192.0.2.1"
+	expect := func(recorder *hashrateRecorder, rigs ...string) {
+		if len(recorder.rigs) != len(rigs) {
+			t.Fatalf("expected rigs %v, got %v", rigs, recorder.rigs)
+		}
+		for i, rig := range rigs {
+			if id := ethereum.NewRig(rig, ip).ID(); recorder.rigs[i] != id {
+				t.Fatalf("expected rig %s, got %s", id, recorder.rigs[i])
+			}
+		}
+	}
+	expect(defRecorder, "rig1", "rig4")
+	expect(aliceRecorder, "rig2", "rig3")
+	resp := submitHashrate(t, server, "/bob/rig5/")
+	if resp.Error.Code != -32600 || !strings.Contains(resp.Error.Message, "unknown miner bob") {
+		t.Fatalf("expected unknown miner to be refused, got %+v", resp.Error)
+	}
+}
+
+func TestMinerPool(t *testing.T) {
+	alice, _ := newRoutingTestPool("0x00000000000000000000000000000000000000aB")
+	Miners = map[string]*protocol.SmartPool{"alice": alice}
+	defer func() { Miners = map[string]*protocol.SmartPool{} }()
+	for _, name := range []string{"alice", alice.MinerAddress.Hex(), strings.ToLower(alice.MinerAddress.Hex())} {
+		if minerPool(name) != alice {
+			t.Fatalf("expected %s to be alice", name)
+		}
+	}
+	for _, name := range []string{"bob", "Alice", "0x01"} {
+		if minerPool(name) != nil {
+			t.Fatalf("expected %s not to be hosted", name)
+		}
+	}
+}
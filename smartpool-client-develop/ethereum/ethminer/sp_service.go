@@ -1,23 +1,71 @@
 package ethminer
 
 import (
+	"github.com/SmartPool/smartpool-client"
 	"github.com/SmartPool/smartpool-client/ethereum"
 	"github.com/SmartPool/smartpool-client/protocol"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"math/big"
+	"sort"
+	"strings"
 )
 
+// SmartPool is the default miner identity. Rigs posting to /:rig/ and
+// stratum miners mine for it.
 var SmartPool *protocol.SmartPool
 
+// Miners are the miner identities the client hosts by name, including the
+// default one. Rigs mine for one of them by posting to /:miner/:rig/.
+var Miners = map[string]*protocol.SmartPool{}
+
+// minerPool returns the SmartPool of the miner identity with the name or
+// address. It returns nil if the client doesn't host it.
+func minerPool(name string) *protocol.SmartPool {
+	if sp, ok := Miners[name]; ok {
+		return sp
+	}
+	for _, sp := range Miners {
+		if strings.EqualFold(sp.MinerAddress.Hex(), name) {
+			return sp
+		}
+	}
+	return nil
+}
+
+// smartPools returns SmartPool of every miner identity, the default one
+// first.
+func smartPools() []*protocol.SmartPool {
+	names := []string{}
+	for name, sp := range Miners {
+		if sp != SmartPool {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pools := []*protocol.SmartPool{SmartPool}
+	for _, name := range names {
+		pools = append(pools, Miners[name])
+	}
+	return pools
+}
+
 type SmartPoolService struct {
+	sp  *protocol.SmartPool
 	rig *ethereum.Rig
 }
 
+// Allowed tells whether the rig can make a request now. Rigs are only
+// limited when the SmartPool has a smartpool.RigPolicy.
+func (sps *SmartPoolService) Allowed() bool {
+	policy, ok := sps.sp.BanPolicy.(smartpool.RigPolicy)
+	return !ok || policy.Allow(sps.rig)
+}
+
 func (sps *SmartPoolService) GetWork() ([3]string, error) {
 	var res [3]string
-	w := SmartPool.GetWork(sps.rig).(*ethereum.Work)
+	w := sps.sp.GetWork(sps.rig).(*ethereum.Work)
 	res[0] = w.PoWHash().Hex()
 	res[1] = w.SeedHash
 	n := big.NewInt(1)
@@ -31,7 +79,7 @@ func (sps *SmartPoolService) GetWork() ([3]string, error) {
 func (sps *SmartPoolService) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
 	// nc := SmartPool.NetworkClient.(*ethereum.NetworkClient)
 	// return nc.SubmitHashrate(sps.rig, hashrate, id)
-	return SmartPool.SubmitHashrate(sps.rig, hashrate, id)
+	return sps.sp.SubmitHashrate(sps.rig, hashrate, id)
 }
 
 func (sps *SmartPoolService) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) bool {
@@ -40,9 +88,9 @@ func (sps *SmartPoolService) SubmitWork(nonce types.BlockNonce, hash, mixDigest
 		Hash:      hash,
 		MixDigest: mixDigest,
 	}
-	return SmartPool.AcceptSolution(sps.rig, sol)
+	return sps.sp.AcceptSolution(sps.rig, sol)
 }
 
-func NewSmartPoolService(rigName string, rigIP string) *SmartPoolService {
-	return &SmartPoolService{ethereum.NewRig(rigName, rigIP)}
+func NewSmartPoolService(sp *protocol.SmartPool, rigName string, rigIP string) *SmartPoolService {
+	return &SmartPoolService{sp, ethereum.NewRig(rigName, rigIP)}
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/SmartPool/smartpool-client"
 	"github.com/SmartPool/smartpool-client/ethereum"
 	"github.com/SmartPool/smartpool-client/ethereum/stat"
+	"github.com/SmartPool/smartpool-client/protocol"
 	"github.com/gorilla/websocket"
 	"net/http"
 	"time"
@@ -12,14 +13,14 @@ import (
 
 type StatService struct{}
 
-func farmStat() map[string]interface{} {
+func farmStat(sp *protocol.SmartPool) map[string]interface{} {
 	t := time.Now()
 	curPeriod := stat.TimeToPeriod(t)
 	shortWindow := stat.TimeToPeriod(t.Add(-time.Duration(stat.ShortWindow) * time.Second))
 	longWindow := stat.TimeToPeriod(t.Add(-time.Duration(stat.LongWindow) * time.Second))
-	overall := SmartPool.StatRecorder.OverallFarmStat()
-	shortWindowStat := SmartPool.StatRecorder.FarmStat(shortWindow, curPeriod)
-	longWindowStat := SmartPool.StatRecorder.FarmStat(longWindow, curPeriod)
+	overall := sp.StatRecorder.OverallFarmStat()
+	shortWindowStat := sp.StatRecorder.FarmStat(shortWindow, curPeriod)
+	longWindowStat := sp.StatRecorder.FarmStat(longWindow, curPeriod)
 	return map[string]interface{}{
 		"overall":               overall,
 		"short_window_sample":   shortWindowStat,
@@ -31,14 +32,14 @@ func farmStat() map[string]interface{} {
 	}
 }
 
-func rigStat(rig smartpool.Rig) map[string]interface{} {
+func rigStat(sp *protocol.SmartPool, rig smartpool.Rig) map[string]interface{} {
 	t := time.Now()
 	curPeriod := stat.TimeToPeriod(t)
 	shortWindow := stat.TimeToPeriod(t.Add(-time.Duration(stat.ShortWindow) * time.Second))
 	longWindow := stat.TimeToPeriod(t.Add(-time.Duration(stat.LongWindow) * time.Second))
-	overall := SmartPool.StatRecorder.OverallRigStat(rig)
-	shortWindowStat := SmartPool.StatRecorder.RigStat(rig, shortWindow, curPeriod)
-	longWindowStat := SmartPool.StatRecorder.RigStat(rig, longWindow, curPeriod)
+	overall := sp.StatRecorder.OverallRigStat(rig)
+	shortWindowStat := sp.StatRecorder.RigStat(rig, shortWindow, curPeriod)
+	longWindowStat := sp.StatRecorder.RigStat(rig, longWindow, curPeriod)
 	return map[string]interface{}{
 		"overall":               overall,
 		"short_window_sample":   shortWindowStat,
@@ -51,21 +52,35 @@ func rigStat(rig smartpool.Rig) map[string]interface{} {
 
 func (server *StatService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	sp := SmartPool
+	if minerName := r.URL.Query().Get(":miner"); minerName != "" {
+		if sp = minerPool(minerName); sp == nil {
+			http.Error(w, "Unknown miner "+minerName, 404)
+			return
+		}
+	}
 	method := r.URL.Query().Get(":method")
 	if method == "json" {
 		scope := r.URL.Query().Get(":scope")
 		if scope == "farm" {
-			result := farmStat()
+			result := farmStat(sp)
 			encoder := json.NewEncoder(w)
 			encoder.Encode(&result)
 		} else if scope == "rig" {
 			rigID := r.URL.Query().Get(":rig")
 			rig := ethereum.NewRig(rigID, r.RemoteAddr)
-			result := rigStat(rig)
+			result := rigStat(sp, rig)
+			encoder := json.NewEncoder(w)
+			encoder.Encode(&result)
+		} else if scope == "bans" {
+			result := []*stat.Ban{}
+			if guard, ok := sp.BanPolicy.(*stat.RigGuard); ok {
+				result = guard.Bans()
+			}
 			encoder := json.NewEncoder(w)
 			encoder.Encode(&result)
 		} else {
-			http.Error(w, "Only /json/farm and /json/rig/:id are supported", 404)
+			http.Error(w, "Only /json/farm, /json/rig/:id and /json/bans are supported", 404)
 		}
 	} else if method == "ws" {
 		if r.Header.Get("Origin") != "http://"+r.Host {
@@ -77,13 +92,15 @@ func (server *StatService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Could not open websocket connection", http.StatusBadRequest)
 		}
 		defer conn.Close()
-		server.handleMessages(conn)
+		server.handleMessages(conn, sp)
 	} else {
 		http.Error(w, "Only /farm and /rig/:id are supported", 404)
 	}
 }
 
-func (server *StatService) handleMessages(conn *websocket.Conn) {
+// handleMessages answers farm and rig info requests of a websocket. They
+// are about miner sp unless they name another miner.
+func (server *StatService) handleMessages(conn *websocket.Conn, sp *protocol.SmartPool) {
 	startTime := time.Now()
 	for {
 		if time.Since(startTime).Seconds() > 600 {
@@ -93,13 +110,20 @@ func (server *StatService) handleMessages(conn *websocket.Conn) {
 		err := conn.ReadJSON(&m)
 		if err == nil {
 			startTime = time.Now()
+			minerSP := sp
+			if m["miner"] != "" {
+				if minerSP = minerPool(m["miner"]); minerSP == nil {
+					conn.WriteJSON(map[string]string{"error": "unknown miner " + m["miner"]})
+					continue
+				}
+			}
 			if m["action"] == "getFarmInfo" {
-				conn.WriteJSON(farmStat())
+				conn.WriteJSON(farmStat(minerSP))
 			} else if m["action"] == "getRigInfo" {
 				rigID := m["rigId"]
 				rigIP := m["rigIP"]
 				rig := ethereum.NewRig(rigID, rigIP)
-				conn.WriteJSON(rigStat(rig))
+				conn.WriteJSON(rigStat(minerSP, rig))
 			}
 		} else {
 			break
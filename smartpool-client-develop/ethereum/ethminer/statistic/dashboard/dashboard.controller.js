@@ -572,6 +572,10 @@
 
         function applyAdvanceInfo(response) {
             vm.advance.total_block_found = response.overall.total_block_found;
+            vm.advance.total_canonical_block = response.overall.total_canonical_block || 0;
+            vm.advance.total_uncle_block = response.overall.total_uncle_block || 0;
+            vm.advance.total_lost_block = response.overall.total_lost_block || 0;
+            vm.advance.total_block_reward = (response.overall.total_block_reward || 0) / 1e18;
             vm.advance.start_time = response.overall.start_time;
             vm.advance.last_block = response.overall.last_block;
             vm.advance.last_valid_share = response.overall.last_valid_share;
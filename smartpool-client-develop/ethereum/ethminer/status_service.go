@@ -13,6 +13,7 @@ type StatusData struct {
 	ShareThreshold int      `json:"share_threshold"`
 	ClaimThreshold int      `json:"claim_threshold"`
 	ShareDiff      *big.Int `json:"share_difficulty"`
+	MaxShareDiff   *big.Int `json:"max_share_difficulty"`
 	Contract       string   `json:"contract_address"`
 	Miner          string   `json:"miner_address"`
 	Extra          string   `json:"extra_data"`
@@ -26,6 +27,7 @@ func (server *StatusService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		SmartPool.Input.ShareThreshold(),
 		SmartPool.Input.ClaimThreshold(),
 		SmartPool.Input.ShareDifficulty(),
+		SmartPool.Input.MaxShareDifficulty(),
 		SmartPool.Input.ContractAddress(),
 		SmartPool.Input.MinerAddress(),
 		SmartPool.Input.ExtraData(),
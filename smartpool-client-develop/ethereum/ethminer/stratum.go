@@ -0,0 +1,365 @@
+package ethminer
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/ethereum/ethash"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	stratumVersion = "EthereumStratum/1.0.0"
+	// extranonce is 2 bytes so miners only search the remaining 6 bytes
+	// of the nonce space.
+	extraNonceSize = 2
+	// number of recent jobs a miner can still submit solutions for
+	maxStratumJobs = 16
+)
+
+// NiceHash difficulty 1 corresponds to 2^32 hashes
+var stratumDiff1 = new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 32))
+
+type stratumRequest struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params []string        `json:"params"`
+}
+
+type stratumResponse struct {
+	Id     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+type stratumNotification struct {
+	Id     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumSession is one TCP connection from a miner.
+type stratumSession struct {
+	conn       net.Conn
+	mu         sync.Mutex
+	ip         string
+	extraNonce string
+	rig        *ethereum.Rig
+	difficulty *big.Int
+}
+
+func (ss *stratumSession) send(msg interface{}) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = ss.conn.Write(append(data, '\n'))
+	return err
+}
+
+// identity returns the rig the session is authorized as, nil before it is
+// authorized, and its extranonce.
+func (ss *stratumSession) identity() (*ethereum.Rig, string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.rig, ss.extraNonce
+}
+
+// StratumServer serves miners speaking EthereumStratum/1.0 (NiceHash) over
+// TCP. Unlike the getwork endpoint, it pushes a new job to every miner as
+// soon as the network client has a new work.
+type StratumServer struct {
+	Bind       string
+	Port       uint16
+	output     smartpool.UserOutput
+	mu         sync.RWMutex
+	sessions   map[*stratumSession]bool
+	jobs       map[string]*ethereum.Work
+	jobOrder   []string
+	extraNonce uint16
+	digester   ethereum.MixDigester
+}
+
+func stratumDifficulty(shareDiff *big.Int) float64 {
+	diff := new(big.Float).SetInt(shareDiff)
+	result, _ := diff.Quo(diff, stratumDiff1).Float64()
+	return result
+}
+
+func jobID(w *ethereum.Work) string {
+	return strings.TrimPrefix(w.Hash, "0x")
+}
+
+func (s *StratumServer) addJob(w *ethereum.Work) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := jobID(w)
+	if _, exist := s.jobs[id]; !exist {
+		s.jobs[id] = w
+		s.jobOrder = append(s.jobOrder, id)
+		if len(s.jobOrder) > maxStratumJobs {
+			delete(s.jobs, s.jobOrder[0])
+			s.jobOrder = s.jobOrder[1:]
+		}
+	}
+	return id
+}
+
+func (s *StratumServer) getJob(id string) *ethereum.Work {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.jobs[id]
+}
+
+// nextExtraNonce returns the next extranonce that no connected miner
+// uses. Extranonces wrap around, so a miner connected for long keeps its
+// extranonce. It returns false when all of them are used. s.mu must be
+// held.
+func (s *StratumServer) nextExtraNonce() (string, bool) {
+	used := map[string]bool{}
+	for ss := range s.sessions {
+		used[ss.extraNonce] = true
+	}
+	for i := 0; i < 1<<(extraNonceSize*8); i++ {
+		s.extraNonce++
+		extraNonce := fmt.Sprintf("%0*x", extraNonceSize*2, s.extraNonce)
+		if !used[extraNonce] {
+			return extraNonce, true
+		}
+	}
+	return "", false
+}
+
+// addSession registers the session of a new connection with an extranonce
+// of its own.
+func (s *StratumServer) addSession(conn net.Conn, ip string) (*stratumSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	extraNonce, ok := s.nextExtraNonce()
+	if !ok {
+		return nil, errors.New("all extranonces are in use")
+	}
+	ss := &stratumSession{conn: conn, ip: ip, extraNonce: extraNonce}
+	s.sessions[ss] = true
+	return ss, nil
+}
+
+func (s *StratumServer) notify(ss *stratumSession, w *ethereum.Work) error {
+	id := s.addJob(w)
+	ss.mu.Lock()
+	changedDiff := ss.difficulty == nil || ss.difficulty.Cmp(w.ShareDifficulty) != 0
+	ss.difficulty = w.ShareDifficulty
+	ss.mu.Unlock()
+	if changedDiff {
+		err := ss.send(&stratumNotification{
+			nil, "mining.set_difficulty",
+			[]interface{}{stratumDifficulty(w.ShareDifficulty)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return ss.send(&stratumNotification{
+		nil, "mining.notify",
+		[]interface{}{
+			id,
+			strings.TrimPrefix(w.SeedHash, "0x"),
+			strings.TrimPrefix(w.Hash, "0x"),
+			true,
+		},
+	})
+}
+
+func (s *StratumServer) broadcast(w *ethereum.Work) {
+	s.mu.RLock()
+	sessions := []*stratumSession{}
+	for ss := range s.sessions {
+		sessions = append(sessions, ss)
+	}
+	s.mu.RUnlock()
+	for _, ss := range sessions {
+		if rig, _ := ss.identity(); rig != nil {
+			// the rig might be on a different share difficulty than w
+			rigWork := SmartPool.GetWork(rig).(*ethereum.Work)
+			if err := s.notify(ss, rigWork); err != nil {
+				ss.conn.Close()
+			}
+		}
+	}
+}
+
+func (s *StratumServer) runNotifier(works <-chan *ethereum.Work) {
+	for w := range works {
+		s.broadcast(w)
+	}
+}
+
+func workerName(login string) string {
+	// miners usually login with "wallet.worker"
+	parts := strings.SplitN(login, ".", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		return parts[1]
+	}
+	return login
+}
+
+func (s *StratumServer) submit(rig *ethereum.Rig, extraNonce string, params []string) (bool, error) {
+	if len(params) < 3 {
+		return false, fmt.Errorf("invalid submit params")
+	}
+	w := s.getJob(params[1])
+	if w == nil {
+		return false, fmt.Errorf("job not found")
+	}
+	nonceHex := extraNonce + strings.TrimPrefix(params[2], "0x")
+	nonceBytes, err := hex.DecodeString(nonceHex)
+	if err != nil || len(nonceBytes) != 8 {
+		return false, fmt.Errorf("invalid nonce")
+	}
+	nonce := types.BlockNonce{}
+	copy(nonce[:], nonceBytes)
+	mixDigest, _ := s.digester.Hashimoto(
+		w.BlockHeader.Number.Uint64(),
+		w.PoWHash(),
+		nonce.Uint64(),
+	)
+	sol := &ethereum.Solution{
+		Nonce:     nonce,
+		Hash:      w.PoWHash(),
+		MixDigest: common.BytesToHash(mixDigest),
+	}
+	return SmartPool.AcceptSolution(rig, sol), nil
+}
+
+func (s *StratumServer) handle(ss *stratumSession, req *stratumRequest) error {
+	rig, extraNonce := ss.identity()
+	switch req.Method {
+	case "mining.subscribe":
+		return ss.send(&stratumResponse{
+			req.Id,
+			[]interface{}{
+				[]string{"mining.notify", extraNonce, stratumVersion},
+				extraNonce,
+			},
+			nil,
+		})
+	case "mining.extranonce.subscribe":
+		return ss.send(&stratumResponse{req.Id, true, nil})
+	case "mining.authorize":
+		if len(req.Params) < 1 {
+			return ss.send(&stratumResponse{req.Id, false, "missing worker name"})
+		}
+		rig = ethereum.NewRig(workerName(req.Params[0]), ss.ip)
+		ss.mu.Lock()
+		ss.rig = rig
+		ss.mu.Unlock()
+		if err := ss.send(&stratumResponse{req.Id, true, nil}); err != nil {
+			return err
+		}
+		w := SmartPool.GetWork(rig).(*ethereum.Work)
+		return s.notify(ss, w)
+	case "mining.submit":
+		if rig == nil {
+			return ss.send(&stratumResponse{req.Id, false, "unauthorized worker"})
+		}
+		if policy, ok := SmartPool.BanPolicy.(smartpool.RigPolicy); ok && !policy.Allow(rig) {
+			return ss.send(&stratumResponse{req.Id, false, (&throttledError{}).Error()})
+		}
+		ok, err := s.submit(rig, extraNonce, req.Params)
+		if err != nil {
+			return ss.send(&stratumResponse{req.Id, false, err.Error()})
+		}
+		return ss.send(&stratumResponse{req.Id, ok, nil})
+	default:
+		return ss.send(&stratumResponse{req.Id, nil, "unsupported method"})
+	}
+}
+
+func (s *StratumServer) serve(conn net.Conn) {
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		ip = "unknown"
+	}
+	ss, err := s.addSession(conn, ip)
+	if err != nil {
+		s.output.Printf("Refused stratum connection from %s: %s\n", ip, err)
+		conn.Close()
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, ss)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		req := &stratumRequest{}
+		if err := json.Unmarshal(scanner.Bytes(), req); err != nil {
+			s.output.Printf("Malformed stratum message from %s: %s\n", ip, err)
+			return
+		}
+		if err := s.handle(ss, req); err != nil {
+			return
+		}
+	}
+}
+
+// DropRig disconnects miners logged in as rig, which is a worker name or a
+// rig id. It returns the number of disconnected sessions. Miners are free to
+// reconnect.
+func (s *StratumServer) DropRig(rig string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dropped := 0
+	for ss := range s.sessions {
+		ss.mu.Lock()
+		match := ss.rig != nil && (ss.rig.Name() == rig || ss.rig.ID() == rig)
+		ss.mu.Unlock()
+		if match {
+			ss.conn.Close()
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// Start listens for stratum connections and pushes every work coming from
+// works to the connected miners. It blocks until the listener fails.
+func (s *StratumServer) Start(works <-chan *ethereum.Work) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", s.Bind, s.Port))
+	if err != nil {
+		return err
+	}
+	go s.runNotifier(works)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func NewStratumServer(output smartpool.UserOutput, bind string, port uint16) *StratumServer {
+	return &StratumServer{
+		Bind:     bind,
+		Port:     port,
+		output:   output,
+		sessions: map[*stratumSession]bool{},
+		jobs:     map[string]*ethereum.Work{},
+		jobOrder: []string{},
+		digester: ethash.Instance,
+	}
+}
@@ -0,0 +1,187 @@
+package ethminer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+)
+
+const (
+	stratumTestHash = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+	stratumTestSeed = "0x00000000000000000000000000000000000000000000000000000000000000bb"
+)
+
+var stratumTestMix = common.HexToHash("0xcc")
+
+type stratumClient struct {
+	smartpool.NetworkClient
+	work *ethereum.Work
+}
+
+func (c *stratumClient) GetWork() smartpool.Work {
+	return c.work
+}
+
+// solutionRecorder records solutions and rejects them all.
+type solutionRecorder struct {
+	mu        sync.Mutex
+	solutions []*ethereum.Solution
+}
+
+func (r *solutionRecorder) AcceptSolution(s smartpool.Solution) smartpool.Share {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.solutions = append(r.solutions, s.(*ethereum.Solution))
+	return nil
+}
+
+func (r *solutionRecorder) Persist(storage smartpool.PersistentStorage) error {
+	return nil
+}
+
+type nopStatRecorder struct {
+	smartpool.StatRecorder
+}
+
+func (r *nopStatRecorder) RecordShare(status string, share smartpool.Share, rig smartpool.Rig) {}
+
+// stratumDigester gives stratumTestMix as the mix digest of every nonce.
+type stratumDigester struct{}
+
+func (d stratumDigester) Hashimoto(blockNumber uint64, hash common.Hash, nonce uint64) ([]byte, []byte) {
+	return stratumTestMix.Bytes(), nil
+}
+
+// stratumMiner is a miner connected to a StratumServer over a pipe.
+type stratumMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (m *stratumMiner) call(id int, method string, params ...string) {
+	if params == nil {
+		params = []string{}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if _, err := m.conn.Write(append(data, '\n')); err != nil {
+		m.t.Fatalf("couldn't send %s: %s", method, err)
+	}
+}
+
+func (m *stratumMiner) read() map[string]interface{} {
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("couldn't read from the server: %s", err)
+	}
+	msg := map[string]interface{}{}
+	if err = json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("couldn't decode %q: %s", line, err)
+	}
+	return msg
+}
+
+// newStratumTest connects a miner to a StratumServer of a pool whose work
+// has share difficulty 2 in NiceHash units.
+func newStratumTest(t *testing.T) (*stratumMiner, *solutionRecorder, func()) {
+	work := ethereum.NewWork(
+		&types.Header{Number: big.NewInt(1)}, stratumTestHash, stratumTestSeed,
+		new(big.Int).Lsh(big.NewInt(2), 32), "0x01",
+	)
+	recorder := &solutionRecorder{}
+	SmartPool = &protocol.SmartPool{
+		NetworkClient: &stratumClient{work: work},
+		ShareReceiver: recorder,
+		StatRecorder:  &nopStatRecorder{},
+	}
+	s := NewStratumServer(nil, "127.0.0.1", 0)
+	s.digester = stratumDigester{}
+	client, server := net.Pipe()
+	go s.serve(server)
+	return &stratumMiner{t, client, bufio.NewReader(client)}, recorder, func() {
+		client.Close()
+		SmartPool = nil
+	}
+}
+
+func TestStratumServerServesSubscribedMiner(t *testing.T) {
+	miner, recorder, cleanup := newStratumTest(t)
+	defer cleanup()
+	miner.call(1, "mining.subscribe", "ethminer", stratumVersion)
+	result := miner.read()["result"].([]interface{})
+	if result[1] != "0001" || result[0].([]interface{})[1] != "0001" {
+		t.Fatalf("expected extranonce 0001, got %v", result)
+	}
+	miner.call(2, "mining.authorize", "0xwallet.rig1", "x")
+	if resp := miner.read(); resp["result"] != true {
+		t.Fatalf("expected the miner to be authorized, got %v", resp)
+	}
+	diff := miner.read()
+	if diff["method"] != "mining.set_difficulty" || diff["params"].([]interface{})[0] != 2.0 {
+		t.Fatalf("expected difficulty 2, got %v", diff)
+	}
+	notify := miner.read()
+	params := notify["params"].([]interface{})
+	if notify["method"] != "mining.notify" || params[0] != stratumTestHash[2:] ||
+		params[1] != stratumTestSeed[2:] || params[2] != stratumTestHash[2:] || params[3] != true {
+		t.Fatalf("unexpected job %v", notify)
+	}
+	miner.call(3, "mining.submit", "0xwallet.rig1", stratumTestHash[2:], "000000000042")
+	if resp := miner.read(); resp["error"] != nil {
+		t.Fatalf("expected the solution to be submitted, got %v", resp)
+	}
+	if len(recorder.solutions) != 1 {
+		t.Fatalf("expected one solution, got %d", len(recorder.solutions))
+	}
+	sol := recorder.solutions[0]
+	if sol.Nonce.Uint64() != 0x0001000000000042 || sol.Hash.Hex() != stratumTestHash ||
+		sol.MixDigest != stratumTestMix {
+		t.Fatalf("unexpected solution %x %s %s", sol.Nonce, sol.Hash.Hex(), sol.MixDigest.Hex())
+	}
+	miner.call(4, "mining.submit", "0xwallet.rig1", "dd", "000000000042")
+	if resp := miner.read(); resp["result"] != false || resp["error"] != "job not found" {
+		t.Fatalf("expected solution of an unknown job to be refused, got %v", resp)
+	}
+	if len(recorder.solutions) != 1 {
+		t.Fatalf("expected no solution of an unknown job")
+	}
+}
+
+func TestStratumServerRefusesUnauthorizedSubmit(t *testing.T) {
+	miner, recorder, cleanup := newStratumTest(t)
+	defer cleanup()
+	miner.call(1, "mining.submit", "0xwallet.rig1", stratumTestHash[2:], "000000000042")
+	if resp := miner.read(); resp["result"] != false || resp["error"] != "unauthorized worker" {
+		t.Fatalf("expected submit before authorize to be refused, got %v", resp)
+	}
+	if len(recorder.solutions) != 0 {
+		t.Fatalf("expected no solution of an unauthorized miner")
+	}
+}
+
+func TestStratumServerSkipsExtraNoncesInUse(t *testing.T) {
+	s := NewStratumServer(nil, "127.0.0.1", 0)
+	s.sessions[&stratumSession{extraNonce: "0000"}] = true
+	s.sessions[&stratumSession{extraNonce: "0001"}] = true
+	s.extraNonce = 0xfffe
+	for _, expected := range []string{"ffff", "0002"} {
+		if extraNonce, ok := s.nextExtraNonce(); !ok || extraNonce != expected {
+			t.Fatalf("expected extranonce %s, got %s", expected, extraNonce)
+		}
+	}
+	for i := 0; i < 1<<16; i++ {
+		s.sessions[&stratumSession{extraNonce: fmt.Sprintf("%04x", i)}] = true
+	}
+	if _, ok := s.nextExtraNonce(); ok {
+		t.Fatalf("expected no extranonce when all of them are in use")
+	}
+}
@@ -1,24 +1,22 @@
 package geth
 
 import (
-	"github.com/SmartPool/smartpool-client"
-	"github.com/ethereum/go-ethereum/core/types"
 	"math/rand"
 	"time"
 )
 
-type TxProducer func() (*types.Transaction, error)
+type TxProducer func() (*Tx, error)
 
-func EnsureTx(producer TxProducer, lower, upper int, action string) *types.Transaction {
+func EnsureTx(producer TxProducer, lower, upper int, action string) *Tx {
 	var (
-		tx  *types.Transaction
+		tx  *Tx
 		err error
 	)
 	for {
 		tx, err = producer()
 		if err != nil {
 			waitTime := rand.Int()%(upper-lower) + lower
-			smartpool.Output.Printf("%s failed. Error: %s. Retry in %d millisecond\n", action, err, waitTime)
+			logger.Warnf("%s failed. Error: %s. Retry in %d millisecond\n", action, err, waitTime)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			break
@@ -0,0 +1,152 @@
+package geth
+
+import (
+	"context"
+	"fmt"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CodeAt and the other bind.ContractBackend methods of MultiRPC let
+// contract calls fail over between its nodes like its other calls do.
+func (m *MultiRPC) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := m.first(func(node *rpcNode) error {
+		client, err := node.ethClient()
+		if err != nil {
+			return err
+		}
+		result, err = client.CodeAt(ctx, contract, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) CallContract(ctx context.Context, call goethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := m.first(func(node *rpcNode) error {
+		client, err := node.ethClient()
+		if err != nil {
+			return err
+		}
+		result, err = client.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	var result []byte
+	err := m.first(func(node *rpcNode) error {
+		client, err := node.ethClient()
+		if err != nil {
+			return err
+		}
+		result, err = client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	var result uint64
+	err := m.first(func(node *rpcNode) error {
+		client, err := node.ethClient()
+		if err != nil {
+			return err
+		}
+		result, err = client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var result *big.Int
+	err := m.first(func(node *rpcNode) error {
+		client, err := node.ethClient()
+		if err != nil {
+			return err
+		}
+		result, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) EstimateGas(ctx context.Context, call goethereum.CallMsg) (*big.Int, error) {
+	var result *big.Int
+	err := m.first(func(node *rpcNode) error {
+		client, err := node.ethClient()
+		if err != nil {
+			return err
+		}
+		result, err = client.EstimateGas(ctx, call)
+		return err
+	})
+	return result, err
+}
+
+// SendTransaction sends tx to all healthy nodes at once. It only fails when
+// none of them accepted the tx.
+func (m *MultiRPC) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	var (
+		mu   sync.Mutex
+		errs []string
+	)
+	succeeded := m.all(func(node *rpcNode) bool {
+		client, err := node.ethClient()
+		if err == nil {
+			err = client.SendTransaction(ctx, tx)
+		}
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, node.endpoint+": "+err.Error())
+			mu.Unlock()
+			return false
+		}
+		return true
+	})
+	if succeeded == 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("no node accepted the tx (%s)", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// contractBackend returns the node itself when it can make contract calls,
+// e.g. a MultiRPC, so contract calls share its failover and health check.
+// Otherwise it connects to rpc.
+func contractBackend(node ethereum.RPCClient, rpc string) (bind.ContractBackend, error) {
+	if backend, ok := node.(bind.ContractBackend); ok {
+		return backend, nil
+	}
+	return getClient(rpc)
+}
+
+// getClient connects to rpc which can be a comma separated list of
+// endpoints. In that case, contract calls go through a MultiRPC of the
+// endpoints.
+func getClient(rpc string) (bind.ContractBackend, error) {
+	if !strings.Contains(strings.Trim(rpc, ", "), ",") {
+		client, err := ethclient.Dial(strings.Trim(rpc, ", "))
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	m, err := NewMultiRPC(rpc, "", "", big.NewInt(0), "")
+	if err != nil {
+		return nil, err
+	}
+	go m.RunHealthCheck()
+	return m, nil
+}
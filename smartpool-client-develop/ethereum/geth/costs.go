@@ -0,0 +1,109 @@
+package geth
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+)
+
+// number of claim batches costTracker remembers
+var MAX_BATCH_COSTS = 100
+
+// TxCost is gas a mined tx used and fee it paid in wei.
+type TxCost struct {
+	Hash    common.Hash
+	GasUsed *big.Int
+	Fee     *big.Int
+}
+
+// BatchCost is what txs of a claim batch cost from its first claim to its
+// verification. ClaimFees are fees of each submitted claim in order.
+type BatchCost struct {
+	ClaimFees []*big.Int
+	GasUsed   *big.Int
+	Fee       *big.Int
+}
+
+func newBatchCost() *BatchCost {
+	return &BatchCost{[]*big.Int{}, big.NewInt(0), big.NewInt(0)}
+}
+
+func (bc *BatchCost) add(cost *TxCost) {
+	bc.GasUsed.Add(bc.GasUsed, cost.GasUsed)
+	bc.Fee.Add(bc.Fee, cost.Fee)
+}
+
+func (bc *BatchCost) copy() *BatchCost {
+	fees := []*big.Int{}
+	for _, fee := range bc.ClaimFees {
+		fees = append(fees, new(big.Int).Set(fee))
+	}
+	return &BatchCost{
+		fees, new(big.Int).Set(bc.GasUsed), new(big.Int).Set(bc.Fee),
+	}
+}
+
+// costTracker adds up costs of mined txs into the current claim batch.
+// The batch is closed when its verification is mined without error.
+type costTracker struct {
+	mu       sync.Mutex
+	current  *BatchCost
+	batches  []*BatchCost
+	totalGas *big.Int
+	totalFee *big.Int
+}
+
+func (ct *costTracker) record(event *big.Int, cost *TxCost, errCode *big.Int) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.totalGas.Add(ct.totalGas, cost.GasUsed)
+	ct.totalFee.Add(ct.totalFee, cost.Fee)
+	ct.current.add(cost)
+	succeeded := errCode != nil && errCode.Cmp(common.Big0) == 0
+	if event.Cmp(SubmitClaimEventTopic) == 0 && succeeded {
+		ct.current.ClaimFees = append(ct.current.ClaimFees, new(big.Int).Set(cost.Fee))
+		logger.Infof("Claim tx %s used %s gas and paid %s wei.\n",
+			cost.Hash.Hex(), cost.GasUsed.Text(10), cost.Fee.Text(10))
+	}
+	if event.Cmp(VerifyClaimEventTopic) == 0 && succeeded {
+		logger.Infof(
+			"Claim batch of %d claims used %s gas and paid %s wei in total.\n",
+			len(ct.current.ClaimFees), ct.current.GasUsed.Text(10), ct.current.Fee.Text(10))
+		ct.batches = append(ct.batches, ct.current)
+		if len(ct.batches) > MAX_BATCH_COSTS {
+			ct.batches = ct.batches[len(ct.batches)-MAX_BATCH_COSTS:]
+		}
+		ct.current = newBatchCost()
+	}
+}
+
+var costs = &costTracker{
+	sync.Mutex{}, newBatchCost(), []*BatchCost{}, big.NewInt(0), big.NewInt(0),
+}
+
+// TotalTxCost returns gas used and fees paid by all txs mined since the
+// client started.
+func TotalTxCost() (*big.Int, *big.Int) {
+	costs.mu.Lock()
+	defer costs.mu.Unlock()
+	return new(big.Int).Set(costs.totalGas), new(big.Int).Set(costs.totalFee)
+}
+
+// BatchCosts returns costs of the last verified claim batches, oldest
+// first.
+func BatchCosts() []*BatchCost {
+	costs.mu.Lock()
+	defer costs.mu.Unlock()
+	result := []*BatchCost{}
+	for _, batch := range costs.batches {
+		result = append(result, batch.copy())
+	}
+	return result
+}
+
+// CurrentBatchCost returns costs of the claim batch being built.
+func CurrentBatchCost() *BatchCost {
+	costs.mu.Lock()
+	defer costs.mu.Unlock()
+	return costs.current.copy()
+}
@@ -0,0 +1,75 @@
+package geth
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func newTestCosts() *costTracker {
+	return &costTracker{
+		sync.Mutex{}, newBatchCost(), []*BatchCost{}, big.NewInt(0), big.NewInt(0),
+	}
+}
+
+func testTxCost(gas, fee int64) *TxCost {
+	return &TxCost{common.Hash{}, big.NewInt(gas), big.NewInt(fee)}
+}
+
+func TestCostTrackerAddsUpClaimBatch(t *testing.T) {
+	ct := newTestCosts()
+	ct.record(SubmitClaimEventTopic, testTxCost(100, 1000), big.NewInt(0))
+	// a failed claim is paid for but is not a claim of the batch
+	ct.record(SubmitClaimEventTopic, testTxCost(50, 500), big.NewInt(1))
+	ct.record(SubmitClaimEventTopic, testTxCost(100, 2000), big.NewInt(0))
+	if len(ct.batches) != 0 {
+		t.Fatalf("expected the batch to be open until its verification")
+	}
+	ct.record(VerifyClaimEventTopic, testTxCost(300, 6000), big.NewInt(0))
+	if len(ct.batches) != 1 {
+		t.Fatalf("expected a verified batch, got %d", len(ct.batches))
+	}
+	batch := ct.batches[0]
+	if len(batch.ClaimFees) != 2 || batch.ClaimFees[0].Int64() != 1000 ||
+		batch.ClaimFees[1].Int64() != 2000 {
+		t.Fatalf("expected fees of 2 claims, got %v", batch.ClaimFees)
+	}
+	if batch.GasUsed.Int64() != 550 || batch.Fee.Int64() != 9500 {
+		t.Fatalf("expected batch to use 550 gas and pay 9500, got %s and %s", batch.GasUsed, batch.Fee)
+	}
+	if ct.current.GasUsed.Sign() != 0 || len(ct.current.ClaimFees) != 0 {
+		t.Fatalf("expected a new batch after the verification")
+	}
+	// a failed verification leaves the batch open
+	ct.record(VerifyClaimEventTopic, testTxCost(10, 100), big.NewInt(1))
+	ct.record(VerifyClaimEventTopic, testTxCost(10, 100), nil)
+	if len(ct.batches) != 1 || ct.current.Fee.Int64() != 200 {
+		t.Fatalf("expected failed verifications to be paid by the open batch")
+	}
+	if ct.totalGas.Int64() != 570 || ct.totalFee.Int64() != 9700 {
+		t.Fatalf("expected totals of 570 gas and 9700, got %s and %s", ct.totalGas, ct.totalFee)
+	}
+}
+
+func TestCostTrackerKeepsLastBatches(t *testing.T) {
+	ct := newTestCosts()
+	for i := int64(1); i <= int64(MAX_BATCH_COSTS)+5; i++ {
+		ct.record(VerifyClaimEventTopic, testTxCost(i, i), big.NewInt(0))
+	}
+	if len(ct.batches) != MAX_BATCH_COSTS || ct.batches[0].Fee.Int64() != 6 {
+		t.Fatalf("expected the last %d batches, got %d from %s",
+			MAX_BATCH_COSTS, len(ct.batches), ct.batches[0].Fee)
+	}
+}
+
+func TestBatchCostsAreCopies(t *testing.T) {
+	costs.record(SubmitClaimEventTopic, testTxCost(100, 1000), big.NewInt(0))
+	current := CurrentBatchCost()
+	current.Fee.SetInt64(0)
+	current.ClaimFees[len(current.ClaimFees)-1].SetInt64(0)
+	if CurrentBatchCost().Fee.Sign() == 0 ||
+		CurrentBatchCost().ClaimFees[len(current.ClaimFees)-1].Sign() == 0 {
+		t.Fatalf("expected callers not to change costs of the current batch")
+	}
+}
@@ -1,110 +1,105 @@
 package geth
 
 import (
-	"fmt"
-	"github.com/SmartPool/smartpool-client"
+	"errors"
 	"github.com/SmartPool/smartpool-client/ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
-	"os"
 )
 
 type EthashContractClient struct {
-	contract   *Ethash
-	transactor *bind.TransactOpts
-	node       ethereum.RPCClient
-	sender     common.Address
+	contract *Ethash
+	txSender *txSender
+	node     ethereum.RPCClient
+	sender   common.Address
+}
+
+// EpochNode returns the merkle node at index of epoch's data. getEpochData
+// returns the node with the epoch's full size and branch depth.
+func (cc *EthashContractClient) EpochNode(epoch *big.Int, index *big.Int) (*big.Int, error) {
+	data, err := cc.contract.GetEpochData(nil, epoch, index)
+	if err != nil {
+		return nil, err
+	}
+	return data[0], nil
 }
 
 func (cc *EthashContractClient) SetEpochData(
 	epoch *big.Int,
 	fullSizeIn128Resolution *big.Int,
 	branchDepth *big.Int,
-	merkleNodes []*big.Int) error {
-
-	nodes := []*big.Int{}
-	start := big.NewInt(0)
-	fmt.Printf("No meaningful nodes: %d\n", len(merkleNodes))
-	for k, n := range merkleNodes {
-		nodes = append(nodes, n)
-		if len(nodes) == 40 || k == len(merkleNodes)-1 {
-			mnlen := big.NewInt(int64(len(nodes)))
-			blockNo, err := cc.node.BlockNumber()
-			blockNo.Add(blockNo, big.NewInt(1))
-			if err != nil {
-				smartpool.Output.Printf("Setting epoch data. Error: %s\n", err)
-				return err
-			}
-			fmt.Printf("Going to do tx\n")
-			fmt.Printf("Block Number: %d\n", blockNo.Int64())
+	merkleNodes []*big.Int,
+	start *big.Int) (*big.Int, *big.Int, error) {
 
-			if k < 440 && epoch.Uint64() == 128 {
-				start.Add(start, mnlen)
-				nodes = []*big.Int{}
-				continue
-			}
-
-			tx, err := cc.contract.SetEpochData(
-				cc.transactor, epoch, fullSizeIn128Resolution,
-				branchDepth, nodes, start, mnlen)
-			if err != nil {
-				smartpool.Output.Printf("Setting optimized epoch data. Error: %s\n", err)
-				return err
-			}
-			errCode, errInfo, err := GetTxResult(
-				tx, cc.transactor, cc.node, blockNo, SetEpochDataEventTopic,
-				cc.sender.Big())
-			if err != nil {
-				smartpool.Output.Printf("Tx: %s was not approved by the network in time.\n", tx.Hash().Hex())
-				start.Add(start, mnlen)
-				nodes = []*big.Int{}
-				continue
-			}
-			if errCode.Cmp(common.Big0) != 0 {
-				smartpool.Output.Printf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
-				// return errors.New(ErrorMsg(errCode, errInfo))
-			}
-			start.Add(start, mnlen)
-			nodes = []*big.Int{}
-		}
+	if cc.txSender == nil {
+		return nil, nil, errors.New("the Ethash contract client is read only")
+	}
+	blockNo, err := cc.node.BlockNumber()
+	if err != nil {
+		logger.Warnf("Setting epoch data. Error: %s\n", err)
+		return nil, nil, err
+	}
+	tx, err := cc.txSender.Send("setEpochData",
+		epoch, fullSizeIn128Resolution,
+		branchDepth, merkleNodes, start, big.NewInt(int64(len(merkleNodes))))
+	if err != nil {
+		logger.Warnf("Setting epoch data. Error: %s\n", err)
+		return nil, nil, err
 	}
-	return nil
+	logger.Debugf("Setting %d merkle nodes of epoch %s from %s in tx %s.\n",
+		len(merkleNodes), epoch.Text(10), start.Text(10), tx.Hash().Hex())
+	errCode, errInfo, cost, err := GetTxResultAndCost(
+		tx, cc.txSender, cc.node, blockNo.Add(blockNo, common.Big1),
+		SetEpochDataEventTopic, cc.sender.Big())
+	if err != nil {
+		logger.Warnf("Tx: %s was not approved by the network in time.\n", tx.Hash().Hex())
+		return nil, nil, err
+	}
+	gasUsed, fee := big.NewInt(0), big.NewInt(0)
+	if cost != nil {
+		gasUsed, fee = cost.GasUsed, cost.Fee
+	}
+	if errCode.Cmp(common.Big0) != 0 {
+		logger.Warnf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
+		return gasUsed, fee, errors.New(ErrorMsg(errCode, errInfo))
+	}
+	return gasUsed, fee, nil
 }
 
 func NewEthashContractClient(
-	contractAddr common.Address, node ethereum.RPCClient, miner common.Address,
-	ipc, keystorePath, passphrase string, gasprice uint64) (*EthashContractClient, error) {
-	client, err := getClient(ipc)
+	contractAddr common.Address, node ethereum.RPCClient, ipc string,
+	signer Signer, policy *FeePolicy) (*EthashContractClient, error) {
+	client, err := contractBackend(node, ipc)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't connect to Geth/Parity. Error: %s\n", err)
+		logger.Warnf("Couldn't connect to Geth/Parity. Error: %s\n", err)
 		return nil, err
 	}
 	ethash, err := NewEthash(contractAddr, client)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
+		logger.Warnf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
 		return nil, err
 	}
-	account := GetAccount(keystorePath, miner, passphrase)
-	if account == nil {
-		smartpool.Output.Printf("Couldn't get any account from key store.\n")
+	ts, err := newTxSender(contractAddr, EthashABI, client, node, signer, policy)
+	if err != nil {
+		logger.Warnf("Failed to create tx sender: %s\n", err)
 		return nil, err
 	}
-	keyio, err := os.Open(account.KeyFile())
+	logger.Debugf("Done.\n")
+	return &EthashContractClient{ethash, ts, node, signer.Address()}, nil
+}
+
+// NewEthashContractReader connects to the Ethash contract to read epoch
+// data. It can't send txs.
+func NewEthashContractReader(contractAddr common.Address, ipc string) (*EthashContractClient, error) {
+	client, err := getClient(ipc)
 	if err != nil {
-		smartpool.Output.Printf("Failed to open key file: %s\n", err)
+		logger.Warnf("Couldn't connect to Geth/Parity. Error: %s\n", err)
 		return nil, err
 	}
-	smartpool.Output.Printf("Unlocking account...\n")
-	auth, err := bind.NewTransactor(keyio, account.PassPhrase())
+	ethash, err := NewEthash(contractAddr, client)
 	if err != nil {
-		smartpool.Output.Printf("Failed to create authorized transactor: %s\n", err)
+		logger.Warnf("Couldn't get Ethash contract information from Ethereum Blockchain. Error: %s\n", err)
 		return nil, err
 	}
-	if gasprice != 0 {
-		auth.GasPrice = big.NewInt(int64(gasprice * 1000000000))
-		smartpool.Output.Printf("Gas price is set to: %s wei.\n", auth.GasPrice.Text(10))
-	}
-	smartpool.Output.Printf("Done.\n")
-	return &EthashContractClient{ethash, auth, node, miner}, nil
+	return &EthashContractClient{ethash, nil, nil, common.Address{}}, nil
 }
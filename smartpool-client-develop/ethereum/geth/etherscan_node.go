@@ -2,7 +2,6 @@ package geth
 
 import (
 	"fmt"
-	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rpc"
 	"math/rand"
@@ -20,7 +19,7 @@ func SendRawTransaction(data []byte) error {
 			fmt.Sprintf("0x%s", common.Bytes2Hex(data)))
 		if err != nil {
 			waitTime := rand.Int()%10000 + 1000
-			smartpool.Output.Printf("Failed rebroadcasting via public node. Error: %s\n", err)
+			logger.Warnf("Failed rebroadcasting via public node. Error: %s\n", err)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			break
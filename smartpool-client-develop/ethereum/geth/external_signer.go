@@ -0,0 +1,143 @@
+package geth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"time"
+)
+
+// SIGNER_TIMEOUT is the longest time the external signer can take to sign
+// a tx. It is long enough for an operator to approve the tx on the signer.
+var SIGNER_TIMEOUT = 2 * time.Minute
+
+// ExternalSigner delegates signing to an external signer speaking clef's
+// JSON-RPC API so keys stay on the signer's host and never enter SmartPool's
+// memory.
+type ExternalSigner struct {
+	client  *rpc.Client
+	address common.Address
+	chainID *big.Int
+}
+
+// signTxArgs is the tx account_signTransaction signs.
+type signTxArgs struct {
+	From                 string         `json:"from"`
+	To                   string         `json:"to"`
+	Gas                  hexutil.Uint64 `json:"gas"`
+	GasPrice             *hexutil.Big   `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big   `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big   `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big   `json:"value"`
+	Nonce                hexutil.Uint64 `json:"nonce"`
+	Data                 hexutil.Bytes  `json:"data"`
+	ChainID              *hexutil.Big   `json:"chainId"`
+}
+
+// signTxResult is the result of account_signTransaction. Raw is the signed
+// tx encoded to be sent by eth_sendRawTransaction.
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func (s *ExternalSigner) Address() common.Address { return s.address }
+func (s *ExternalSigner) ChainID() *big.Int       { return s.chainID }
+
+func (s *ExternalSigner) call(timeout time.Duration, result interface{}, method string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.client.CallContext(ctx, result, method, args...)
+}
+
+// Sign asks the external signer to sign the tx. It fails if the signer
+// rejects the tx, e.g. because the operator or its rules didn't approve it,
+// or returns a tx other than the one it was asked to sign.
+func (s *ExternalSigner) Sign(tx *Tx) error {
+	args := signTxArgs{
+		From:    s.address.Hex(),
+		To:      tx.To.Hex(),
+		Gas:     hexutil.Uint64(tx.Gas.Uint64()),
+		Value:   (*hexutil.Big)(tx.Value),
+		Nonce:   hexutil.Uint64(tx.Nonce),
+		Data:    tx.Data,
+		ChainID: (*hexutil.Big)(s.chainID),
+	}
+	if tx.IsDynamicFee() {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.MaxFeePerGas)
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.MaxPriorityFeePerGas)
+	} else {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice)
+	}
+	result := signTxResult{}
+	if err := s.call(SIGNER_TIMEOUT, &result, "account_signTransaction", args); err != nil {
+		return fmt.Errorf("external signer didn't sign the tx: %s", err)
+	}
+	if len(result.Raw) == 0 {
+		return errors.New("external signer returned an empty tx")
+	}
+	// the signer may be compromised or buggy, the tx it signed is sent only
+	// if it is the tx it was asked to sign
+	signed, chainID, sender, err := decodeTx(result.Raw)
+	if err != nil {
+		return fmt.Errorf("couldn't decode the tx signed by external signer: %s", err)
+	}
+	if chainID.Cmp(s.chainID) != 0 {
+		return fmt.Errorf("external signer signed the tx for chain %s instead of %s", chainID, s.chainID)
+	}
+	if sender != s.address {
+		return fmt.Errorf("external signer signed the tx by %s instead of %s", sender.Hex(), s.address.Hex())
+	}
+	if field := signed.differsFrom(tx); field != "" {
+		return fmt.Errorf("external signer changed %s of the tx", field)
+	}
+	tx.raw = signed.raw
+	tx.hash = signed.hash
+	return nil
+}
+
+// Accounts returns the accounts the external signer can sign for.
+func (s *ExternalSigner) Accounts() ([]common.Address, error) {
+	result := []string{}
+	if err := s.call(RPC_TIMEOUT, &result, "account_list"); err != nil {
+		return nil, err
+	}
+	accounts := []common.Address{}
+	for _, account := range result {
+		accounts = append(accounts, common.HexToAddress(account))
+	}
+	return accounts, nil
+}
+
+// SignerAccounts returns the accounts the external signer at endpoint can
+// sign for.
+func SignerAccounts(endpoint string) ([]common.Address, error) {
+	client, err := rpc.DialHTTP(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return (&ExternalSigner{client, common.Address{}, nil}).Accounts()
+}
+
+// NewExternalSigner connects to the external signer at endpoint to sign txs
+// of chainID by address. It fails if the signer can't sign for address.
+func NewExternalSigner(endpoint string, address common.Address, chainID *big.Int) (*ExternalSigner, error) {
+	client, err := rpc.DialHTTP(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	signer := &ExternalSigner{client, address, chainID}
+	accounts, err := signer.Accounts()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list accounts of external signer: %s", err)
+	}
+	for _, account := range accounts {
+		if account == address {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("external signer can't sign for %s", address.Hex())
+}
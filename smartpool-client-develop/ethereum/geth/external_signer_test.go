@@ -0,0 +1,148 @@
+package geth
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// mockClef answers account_list and account_signTransaction like clef does,
+// signing with a key held by the mock. A compromised mock tampers with txs
+// before it signs them with forger's key.
+type mockClef struct {
+	signer *TxSigner
+	reject bool
+	tamper func(tx *Tx)
+	forger *TxSigner
+}
+
+func (m *mockClef) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	switch {
+	case req.Method == "account_list":
+		resp["result"] = []string{m.signer.Address().Hex()}
+	case req.Method == "account_signTransaction" && m.reject:
+		resp["error"] = map[string]interface{}{"code": -32000, "message": "Request denied"}
+	case req.Method == "account_signTransaction":
+		args := signTxArgs{}
+		json.Unmarshal(req.Params[0], &args)
+		tx := &Tx{
+			uint64(args.Nonce), common.HexToAddress(args.To),
+			(*big.Int)(args.Value), new(big.Int).SetUint64(uint64(args.Gas)),
+			args.Data, (*big.Int)(args.GasPrice), (*big.Int)(args.MaxFeePerGas),
+			(*big.Int)(args.MaxPriorityFeePerGas), nil, common.Hash{},
+		}
+		signer := m.signer
+		if m.tamper != nil {
+			m.tamper(tx)
+		}
+		if m.forger != nil {
+			signer = m.forger
+		}
+		signer.Sign(tx)
+		resp["result"] = signTxResult{hexutil.Bytes(tx.Raw())}
+	default:
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestExternalSignerSignsLikeKeystore(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	local := NewTxSigner(key, big.NewInt(3))
+	clef := &mockClef{local, false, nil, nil}
+	server := httptest.NewServer(clef)
+	defer server.Close()
+	if _, err := NewExternalSigner(server.URL, common.HexToAddress("0x01"), big.NewInt(3)); err == nil {
+		t.Fatalf("expected unknown account to be refused")
+	}
+	external, err := NewExternalSigner(server.URL, local.Address(), big.NewInt(3))
+	if err != nil {
+		t.Fatalf("couldn't connect to signer: %s", err)
+	}
+	legacy := &Tx{
+		Nonce: 7, To: common.HexToAddress("0x02"), Value: big.NewInt(0),
+		Gas: big.NewInt(21000), Data: []byte{1, 2, 3}, GasPrice: big.NewInt(10),
+	}
+	dynamic := legacy.copy()
+	dynamic.GasPrice = nil
+	dynamic.MaxFeePerGas = big.NewInt(60)
+	dynamic.MaxPriorityFeePerGas = big.NewInt(1)
+	for _, tx := range []*Tx{legacy, dynamic} {
+		signed := tx.copy()
+		if err = local.Sign(tx); err != nil {
+			t.Fatal(err)
+		}
+		if err = external.Sign(signed); err != nil {
+			t.Fatalf("external signer failed: %s", err)
+		}
+		if !bytes.Equal(signed.Raw(), tx.Raw()) || signed.Hash() != tx.Hash() {
+			t.Fatalf("external signer signed a different tx")
+		}
+	}
+	clef.reject = true
+	if err = external.Sign(legacy.copy()); err == nil {
+		t.Fatalf("expected rejected tx to fail")
+	}
+}
+
+func TestExternalSignerRejectsTamperedTx(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	local := NewTxSigner(key, big.NewInt(3))
+	other, _ := crypto.GenerateKey()
+	clef := &mockClef{local, false, nil, nil}
+	server := httptest.NewServer(clef)
+	defer server.Close()
+	external, err := NewExternalSigner(server.URL, local.Address(), big.NewInt(3))
+	if err != nil {
+		t.Fatalf("couldn't connect to signer: %s", err)
+	}
+	tx := &Tx{
+		Nonce: 7, To: common.HexToAddress("0x02"), Value: big.NewInt(0),
+		Gas: big.NewInt(21000), Data: []byte{1, 2, 3},
+		MaxFeePerGas: big.NewInt(60), MaxPriorityFeePerGas: big.NewInt(1),
+	}
+	tests := []struct {
+		name   string
+		tamper func(tx *Tx)
+		forger *TxSigner
+	}{
+		{"nonce", func(tx *Tx) { tx.Nonce = 8 }, nil},
+		{"to", func(tx *Tx) { tx.To = common.HexToAddress("0x03") }, nil},
+		{"data", func(tx *Tx) { tx.Data = []byte{1, 2, 4} }, nil},
+		{"value", func(tx *Tx) { tx.Value = big.NewInt(1) }, nil},
+		{"fee", func(tx *Tx) { tx.MaxFeePerGas = big.NewInt(6000) }, nil},
+		{"legacy", func(tx *Tx) { tx.GasPrice, tx.MaxFeePerGas, tx.MaxPriorityFeePerGas = big.NewInt(60), nil, nil }, nil},
+		{"chain id", nil, NewTxSigner(key, big.NewInt(1))},
+		{"sender", nil, NewTxSigner(other, big.NewInt(3))},
+	}
+	for _, test := range tests {
+		clef.tamper, clef.forger = test.tamper, test.forger
+		signed := tx.copy()
+		if err = external.Sign(signed); err == nil {
+			t.Fatalf("expected tx with tampered %s to be rejected", test.name)
+		}
+		if signed.Raw() != nil {
+			t.Fatalf("expected tx with tampered %s not to be signed", test.name)
+		}
+	}
+	clef.tamper, clef.forger = func(tx *Tx) {}, nil
+	if err = external.Sign(tx.copy()); err != nil {
+		t.Fatalf("expected untampered tx to be signed: %s", err)
+	}
+}
@@ -0,0 +1,164 @@
+package geth
+
+import (
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+)
+
+var (
+	// number of recent blocks eth_feeHistory looks at to estimate fees
+	FEE_HISTORY_BLOCKS = 10
+	// percentile of priority fees paid in recent blocks to tip with
+	FEE_HISTORY_PERCENTILE = 50.0
+)
+
+// FeePolicy decides fees of txs sent to the contract and how the fees are
+// bumped when a tx is not mined in time.
+type FeePolicy struct {
+	// GasPrice is the gas price of legacy txs. The node's suggestion is used
+	// if it is nil.
+	GasPrice *big.Int
+	// MaxFeePerGas caps gas price or max fee per gas of txs and their
+	// replacements. Txs are not replaced any more once they reach it.
+	MaxFeePerGas *big.Int
+	// PriorityFee is the min priority fee per gas of dynamic fee txs.
+	PriorityFee *big.Int
+	// BumpPercent is how many percent fees of a replacement tx are higher
+	// than the tx it replaces. Nodes reject replacements bumped less than
+	// 10%.
+	BumpPercent int
+	// Legacy makes txs legacy txs even on networks supporting EIP-1559.
+	Legacy bool
+	mu     sync.RWMutex
+	// override is the last fees set by SetGasPrice. They win over the
+	// settings given to Update.
+	override *feeOverride
+}
+
+type feeOverride struct {
+	gasPrice    *big.Int
+	maxFee      *big.Int
+	priorityFee *big.Int
+}
+
+var DefaultFeePolicy = &FeePolicy{
+	nil, big.NewInt(60000000000), big.NewInt(1000000000), 10, false,
+	sync.RWMutex{}, nil,
+}
+
+// Update replaces the policy's settings with from's. Txs sent or replaced
+// afterwards use the new settings. Fees set by SetGasPrice are kept.
+func (p *FeePolicy) Update(from *FeePolicy) {
+	from.mu.RLock()
+	gasPrice, maxFee, priorityFee := from.GasPrice, from.MaxFeePerGas, from.PriorityFee
+	bumpPercent, legacy := from.BumpPercent, from.Legacy
+	from.mu.RUnlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.GasPrice = gasPrice
+	p.MaxFeePerGas = maxFee
+	p.PriorityFee = priorityFee
+	p.BumpPercent = bumpPercent
+	p.Legacy = legacy
+	p.applyOverride()
+}
+
+// SetGasPrice changes gas price of legacy txs and, unless they are nil, max
+// fee and priority fee per gas of dynamic fee txs. A nil gasPrice uses the
+// node's suggestion. The fees outlive later calls of Update.
+func (p *FeePolicy) SetGasPrice(gasPrice, maxFee, priorityFee *big.Int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.override = &feeOverride{gasPrice, maxFee, priorityFee}
+	p.applyOverride()
+}
+
+func (p *FeePolicy) applyOverride() {
+	if p.override == nil {
+		return
+	}
+	p.GasPrice = p.override.gasPrice
+	if p.override.maxFee != nil {
+		p.MaxFeePerGas = p.override.maxFee
+	}
+	if p.override.priorityFee != nil {
+		p.PriorityFee = p.override.priorityFee
+	}
+}
+
+func minBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) > 0 {
+		return a
+	}
+	return b
+}
+
+// Price sets fees of tx. It uses dynamic fees estimated from recent blocks
+// if the network supports EIP-1559 and legacy gas price otherwise.
+func (p *FeePolicy) Price(node ethereum.RPCClient, tx *Tx) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.Legacy {
+		baseFee, tip, err := node.FeeHistory(FEE_HISTORY_BLOCKS, FEE_HISTORY_PERCENTILE)
+		if err == nil {
+			tip = minBig(maxBig(tip, p.PriorityFee), p.MaxFeePerGas)
+			// leave room for base fee to double before the tx is mined
+			maxFee := new(big.Int).Mul(baseFee, big.NewInt(2))
+			maxFee.Add(maxFee, tip)
+			tx.MaxFeePerGas = minBig(maxFee, p.MaxFeePerGas)
+			tx.MaxPriorityFeePerGas = tip
+			tx.GasPrice = nil
+			return nil
+		}
+		logger.Warnf("Couldn't estimate dynamic fees (%s). Using legacy tx.\n", err)
+	}
+	gasPrice := p.GasPrice
+	if gasPrice == nil {
+		var err error
+		if gasPrice, err = node.GasPrice(); err != nil {
+			return err
+		}
+	}
+	tx.GasPrice = minBig(gasPrice, p.MaxFeePerGas)
+	tx.MaxFeePerGas = nil
+	tx.MaxPriorityFeePerGas = nil
+	return nil
+}
+
+func (p *FeePolicy) bumped(fee *big.Int) *big.Int {
+	result := new(big.Int).Mul(fee, big.NewInt(int64(100+p.BumpPercent)))
+	result.Div(result, big.NewInt(100))
+	// make sure small fees are still bumped
+	if result.Cmp(fee) <= 0 {
+		result.Add(fee, common.Big1)
+	}
+	return minBig(result, p.MaxFeePerGas)
+}
+
+// Bump returns an unsigned replacement of tx with higher fees. It returns
+// false if the tx already pays MaxFeePerGas.
+func (p *FeePolicy) Bump(tx *Tx) (*Tx, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if tx.FeeCap().Cmp(p.MaxFeePerGas) >= 0 {
+		return nil, false
+	}
+	result := tx.copy()
+	if tx.IsDynamicFee() {
+		result.MaxFeePerGas = p.bumped(tx.MaxFeePerGas)
+		result.MaxPriorityFeePerGas = minBig(
+			p.bumped(tx.MaxPriorityFeePerGas), result.MaxFeePerGas)
+	} else {
+		result.GasPrice = p.bumped(tx.GasPrice)
+	}
+	return result, true
+}
@@ -0,0 +1,141 @@
+package geth
+
+import (
+	"errors"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+// feeNode is a node suggesting fixed fees. A nil baseFee makes it a node
+// without EIP-1559.
+type feeNode struct {
+	ethereum.RPCClient
+	baseFee  *big.Int
+	tip      *big.Int
+	gasPrice *big.Int
+}
+
+func (n *feeNode) FeeHistory(blocks int, percentile float64) (*big.Int, *big.Int, error) {
+	if n.baseFee == nil {
+		return nil, nil, errors.New("the method eth_feeHistory does not exist")
+	}
+	return n.baseFee, n.tip, nil
+}
+
+func (n *feeNode) GasPrice() (*big.Int, error) {
+	if n.gasPrice == nil {
+		return nil, errors.New("node is down")
+	}
+	return n.gasPrice, nil
+}
+
+func TestFeePolicyPrice(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      *FeePolicy
+		node        *feeNode
+		gasPrice    int64
+		maxFee      int64
+		priorityFee int64
+		fails       bool
+	}{
+		{"dynamic fee", &FeePolicy{nil, big.NewInt(1000), big.NewInt(2), 10, false, sync.RWMutex{}, nil},
+			&feeNode{nil, big.NewInt(100), big.NewInt(5), nil}, 0, 205, 5, false},
+		{"min priority fee", &FeePolicy{nil, big.NewInt(1000), big.NewInt(8), 10, false, sync.RWMutex{}, nil},
+			&feeNode{nil, big.NewInt(100), big.NewInt(5), nil}, 0, 208, 8, false},
+		{"capped max fee", &FeePolicy{nil, big.NewInt(150), big.NewInt(2), 10, false, sync.RWMutex{}, nil},
+			&feeNode{nil, big.NewInt(100), big.NewInt(5), nil}, 0, 150, 5, false},
+		{"capped priority fee", &FeePolicy{nil, big.NewInt(150), big.NewInt(200), 10, false, sync.RWMutex{}, nil},
+			&feeNode{nil, big.NewInt(100), big.NewInt(5), nil}, 0, 150, 150, false},
+		{"no EIP-1559", &FeePolicy{nil, big.NewInt(1000), big.NewInt(2), 10, false, sync.RWMutex{}, nil},
+			&feeNode{nil, nil, nil, big.NewInt(50)}, 50, 0, 0, false},
+		{"legacy", &FeePolicy{big.NewInt(70), big.NewInt(1000), big.NewInt(2), 10, true, sync.RWMutex{}, nil},
+			&feeNode{nil, big.NewInt(100), big.NewInt(5), big.NewInt(50)}, 70, 0, 0, false},
+		{"capped gas price", &FeePolicy{nil, big.NewInt(40), big.NewInt(2), 10, true, sync.RWMutex{}, nil},
+			&feeNode{nil, nil, nil, big.NewInt(50)}, 40, 0, 0, false},
+		{"no gas price", &FeePolicy{nil, big.NewInt(1000), big.NewInt(2), 10, true, sync.RWMutex{}, nil},
+			&feeNode{nil, nil, nil, nil}, 0, 0, 0, true},
+	}
+	for _, test := range tests {
+		tx := &Tx{GasPrice: big.NewInt(1), MaxFeePerGas: big.NewInt(1), MaxPriorityFeePerGas: big.NewInt(1)}
+		err := test.policy.Price(test.node, tx)
+		if test.fails {
+			if err == nil {
+				t.Fatalf("%s: expected pricing to fail", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: pricing failed: %s", test.name, err)
+		}
+		if test.gasPrice != 0 {
+			if tx.IsDynamicFee() || tx.MaxPriorityFeePerGas != nil ||
+				tx.GasPrice.Cmp(big.NewInt(test.gasPrice)) != 0 {
+				t.Fatalf("%s: expected legacy tx with gas price %d, got %s", test.name, test.gasPrice, tx.Fees())
+			}
+			continue
+		}
+		if tx.GasPrice != nil ||
+			tx.MaxFeePerGas.Cmp(big.NewInt(test.maxFee)) != 0 ||
+			tx.MaxPriorityFeePerGas.Cmp(big.NewInt(test.priorityFee)) != 0 {
+			t.Fatalf("%s: expected max fee %d and priority fee %d, got %s",
+				test.name, test.maxFee, test.priorityFee, tx.Fees())
+		}
+	}
+}
+
+func TestFeePolicyBump(t *testing.T) {
+	tests := []struct {
+		name        string
+		tx          *Tx
+		gasPrice    int64
+		maxFee      int64
+		priorityFee int64
+		bumped      bool
+	}{
+		{"legacy", &Tx{GasPrice: big.NewInt(100)}, 110, 0, 0, true},
+		{"small fee", &Tx{GasPrice: big.NewInt(5)}, 6, 0, 0, true},
+		{"capped gas price", &Tx{GasPrice: big.NewInt(950)}, 1000, 0, 0, true},
+		{"max gas price", &Tx{GasPrice: big.NewInt(1000)}, 0, 0, 0, false},
+		{"dynamic fee", &Tx{MaxFeePerGas: big.NewInt(200), MaxPriorityFeePerGas: big.NewInt(20)}, 0, 220, 22, true},
+		{"capped max fee", &Tx{MaxFeePerGas: big.NewInt(990), MaxPriorityFeePerGas: big.NewInt(990)}, 0, 1000, 1000, true},
+		{"max fee", &Tx{MaxFeePerGas: big.NewInt(1000), MaxPriorityFeePerGas: big.NewInt(20)}, 0, 0, 0, false},
+	}
+	policy := &FeePolicy{nil, big.NewInt(1000), big.NewInt(2), 10, false, sync.RWMutex{}, nil}
+	for _, test := range tests {
+		test.tx.Nonce = 3
+		result, bumped := policy.Bump(test.tx)
+		if bumped != test.bumped {
+			t.Fatalf("%s: expected bumped to be %t", test.name, test.bumped)
+		}
+		if !bumped {
+			continue
+		}
+		if result == test.tx || result.Nonce != 3 || result.Raw() != nil {
+			t.Fatalf("%s: expected an unsigned replacement of the tx", test.name)
+		}
+		if test.gasPrice != 0 && result.GasPrice.Cmp(big.NewInt(test.gasPrice)) != 0 {
+			t.Fatalf("%s: expected gas price %d, got %s", test.name, test.gasPrice, result.Fees())
+		}
+		if test.maxFee != 0 && (result.MaxFeePerGas.Cmp(big.NewInt(test.maxFee)) != 0 ||
+			result.MaxPriorityFeePerGas.Cmp(big.NewInt(test.priorityFee)) != 0) {
+			t.Fatalf("%s: expected max fee %d and priority fee %d, got %s",
+				test.name, test.maxFee, test.priorityFee, result.Fees())
+		}
+	}
+}
+
+func TestFeePolicyUpdateKeepsSetGasPrice(t *testing.T) {
+	policy := &FeePolicy{nil, big.NewInt(1000), big.NewInt(2), 10, false, sync.RWMutex{}, nil}
+	policy.SetGasPrice(big.NewInt(70), nil, big.NewInt(3))
+	policy.Update(&FeePolicy{big.NewInt(50), big.NewInt(900), big.NewInt(1), 20, true, sync.RWMutex{}, nil})
+	if policy.GasPrice.Int64() != 70 || policy.PriorityFee.Int64() != 3 {
+		t.Fatalf("expected fees set by SetGasPrice to be kept, got gas price %s and priority fee %s",
+			policy.GasPrice, policy.PriorityFee)
+	}
+	if policy.MaxFeePerGas.Int64() != 900 || policy.BumpPercent != 20 || !policy.Legacy {
+		t.Fatalf("expected other settings to be updated, got %+v", policy)
+	}
+}
@@ -2,40 +2,41 @@ package geth
 
 import (
 	"errors"
-	"github.com/SmartPool/smartpool-client"
 	"github.com/SmartPool/smartpool-client/ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"math/big"
 	"math/rand"
-	"os"
 	"time"
 )
 
 type GethContractClient struct {
 	// the contract implementation that holds all underlying
 	// communication with Ethereum Contract
-	pool       *SmartPool
-	transactor *bind.TransactOpts
-	node       ethereum.RPCClient
-	sender     common.Address
+	pool     *SmartPool
+	txSender *txSender
+	node     ethereum.RPCClient
+	sender   common.Address
 }
 
 func (cc *GethContractClient) Version() string {
 	v, err := cc.pool.Version(nil)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't get contract version: %s\n", err)
+		logger.Warnf("Couldn't get contract version: %s\n", err)
 		return ""
 	}
 	return v
 }
 
+// EthashContract returns the address of the Ethash contract the SmartPool
+// contract verifies DAG elements with.
+func (cc *GethContractClient) EthashContract() (common.Address, error) {
+	return cc.pool.EthashContract(nil)
+}
+
 func (cc *GethContractClient) IsRegistered() bool {
 	ok, err := cc.pool.IsRegistered(nil, cc.sender)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't check the address's registration: %s\n", err)
+		logger.Warnf("Couldn't check the address's registration: %s\n", err)
 		return false
 	}
 	return ok
@@ -44,7 +45,7 @@ func (cc *GethContractClient) IsRegistered() bool {
 func (cc *GethContractClient) CanRegister() bool {
 	ok, err := cc.pool.CanRegister(nil, cc.sender)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't check slot availability for the address: %s\n", err)
+		logger.Warnf("Couldn't check slot availability for the address: %s\n", err)
 		return false
 	}
 	return ok
@@ -56,25 +57,25 @@ func (cc *GethContractClient) Register(paymentAddress common.Address) error {
 		return err
 	}
 	tx := EnsureTx(
-		func() (*types.Transaction, error) {
-			return cc.pool.Register(cc.transactor, paymentAddress)
+		func() (*Tx, error) {
+			return cc.txSender.Send("register", paymentAddress)
 		},
 		1000,
 		10000,
 		"Registering miner address to SmartPool contract",
 	)
-	errCode, errInfo, err := GetTxResult(tx, cc.transactor, cc.node, blockNo.Add(blockNo, common.Big1), RegisterEventTopic,
+	errCode, errInfo, err := GetTxResult(tx, cc.txSender, cc.node, blockNo.Add(blockNo, common.Big1), RegisterEventTopic,
 		cc.sender.Big())
 
 	if err != nil {
-		smartpool.Output.Printf("Tx: %s was not approved by the network in time.\n", tx.Hash().Hex())
+		logger.Warnf("Tx: %s was not approved by the network in time.\n", tx.Hash().Hex())
 		return err
 	}
 	if errCode.Cmp(common.Big0) != 0 {
-		smartpool.Output.Printf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
+		logger.Warnf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
 		return errors.New(ErrorMsg(errCode, errInfo))
 	}
-	smartpool.Output.Printf("Registered address %s to SmartPool contract. Tx %s is confirmed\n", paymentAddress.Hex(), tx.Hash().Hex())
+	logger.Infof("Registered address %s to SmartPool contract. Tx %s is confirmed\n", paymentAddress.Hex(), tx.Hash().Hex())
 	return nil
 }
 
@@ -87,7 +88,7 @@ func (cc *GethContractClient) NumOpenClaims(sender common.Address) (*big.Int, er
 		data, err := cc.pool.DebugGetNumPendingSubmissions(nil, sender)
 		if err != nil {
 			waitTime := rand.Int()%10000 + 1000
-			smartpool.Output.Printf("Failed getting number of open claims in contract. Error: %s\n", err)
+			logger.Warnf("Failed getting number of open claims in contract. Error: %s\n", err)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			return data, nil
@@ -98,25 +99,25 @@ func (cc *GethContractClient) NumOpenClaims(sender common.Address) (*big.Int, er
 func (cc *GethContractClient) ResetOpenClaims() error {
 	blockNo, err := cc.node.BlockNumber()
 	if err != nil {
-		smartpool.Output.Printf("Submitting claim failed. Error: %s\n", err)
+		logger.Warnf("Submitting claim failed. Error: %s\n", err)
 		return err
 	}
 	tx := EnsureTx(
-		func() (*types.Transaction, error) {
-			return cc.pool.DebugResetSubmissions(cc.transactor)
+		func() (*Tx, error) {
+			return cc.txSender.Send("debugResetSubmissions")
 		},
 		1000,
 		10000,
 		"Resetting submissions",
 	)
 	errCode, errInfo, err := GetTxResult(
-		tx, cc.transactor, cc.node, blockNo.Add(blockNo, common.Big1), ResetOpenClaimsEventTopic,
+		tx, cc.txSender, cc.node, blockNo.Add(blockNo, common.Big1), ResetOpenClaimsEventTopic,
 		cc.sender.Big())
 	if err != nil {
 		return err
 	}
 	if errCode.Cmp(common.Big0) != 0 {
-		smartpool.Output.Printf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
+		logger.Warnf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
 		return errors.New(ErrorMsg(errCode, errInfo))
 	}
 	return nil
@@ -125,19 +126,19 @@ func (cc *GethContractClient) ResetOpenClaims() error {
 func (cc *GethContractClient) StoreClaimSeed() error {
 	blockNo, err := cc.node.BlockNumber()
 	if err != nil {
-		smartpool.Output.Printf("Storing claim failed. Error: %s\n", err)
+		logger.Warnf("Storing claim failed. Error: %s\n", err)
 		return err
 	}
 	tx := EnsureTx(
-		func() (*types.Transaction, error) {
-			return cc.pool.StoreClaimSeed(cc.transactor, cc.sender)
+		func() (*Tx, error) {
+			return cc.txSender.Send("storeClaimSeed", cc.sender)
 		},
 		1000,
 		10000,
 		"Storing claim seed",
 	)
 	_, _, err = GetTxResult(
-		tx, cc.transactor, cc.node, blockNo.Add(blockNo, common.Big1), ResetOpenClaimsEventTopic,
+		tx, cc.txSender, cc.node, blockNo.Add(blockNo, common.Big1), ResetOpenClaimsEventTopic,
 		cc.sender.Big())
 	return err
 }
@@ -150,7 +151,7 @@ func (cc *GethContractClient) GetClaimSeed() *big.Int {
 	for {
 		seed, err = cc.pool.GetClaimSeed(nil, cc.sender)
 		if err != nil {
-			smartpool.Output.Printf("Getting claim seed failed. Error: %s\n", err)
+			logger.Warnf("Getting claim seed failed. Error: %s\n", err)
 		} else {
 			if seed.Cmp(common.Big0) != 0 {
 				cc.StoreClaimSeed()
@@ -168,7 +169,7 @@ func (cc *GethContractClient) SubmitClaim(
 	min *big.Int, max *big.Int,
 	augMerkle *big.Int, lastClaim bool) error {
 	var (
-		tx      *types.Transaction
+		tx      *Tx
 		blockNo *big.Int
 		err     error
 	)
@@ -176,15 +177,15 @@ func (cc *GethContractClient) SubmitClaim(
 		blockNo, err = cc.node.BlockNumber()
 		if err != nil {
 			waitTime := rand.Int()%10000 + 1000
-			smartpool.Output.Printf("Submitting claim failed. Error: %s\n", err)
+			logger.Warnf("Submitting claim failed. Error: %s\n", err)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			break
 		}
 	}
 	tx = EnsureTx(
-		func() (*types.Transaction, error) {
-			return cc.pool.SubmitClaim(cc.transactor,
+		func() (*Tx, error) {
+			return cc.txSender.Send("submitClaim",
 				numShares, difficulty, min, max, augMerkle, lastClaim)
 		},
 		1000,
@@ -192,13 +193,13 @@ func (cc *GethContractClient) SubmitClaim(
 		"Submitting claim",
 	)
 	errCode, errInfo, err := GetTxResult(
-		tx, cc.transactor, cc.node, blockNo.Add(blockNo, common.Big1), SubmitClaimEventTopic,
+		tx, cc.txSender, cc.node, blockNo.Add(blockNo, common.Big1), SubmitClaimEventTopic,
 		cc.sender.Big())
 	if err != nil {
 		return err
 	}
 	if errCode.Cmp(common.Big0) != 0 {
-		smartpool.Output.Printf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
+		logger.Warnf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
 		return errors.New(ErrorMsg(errCode, errInfo))
 	}
 	return nil
@@ -212,25 +213,26 @@ func (cc *GethContractClient) VerifyClaim(
 	dataSetLookup []*big.Int,
 	witnessForLookup []*big.Int,
 	augCountersBranch []*big.Int,
-	augHashesBranch []*big.Int) error {
+	augHashesBranch []*big.Int,
+	txSent func(tx common.Hash)) error {
 	var (
 		blockNo *big.Int
 		err     error
-		tx      *types.Transaction
+		tx      *Tx
 	)
 	for {
 		blockNo, err = cc.node.BlockNumber()
 		if err != nil {
 			waitTime := rand.Int()%10000 + 1000
-			smartpool.Output.Printf("Verifying claim failed. Error: %s\n", err)
+			logger.Warnf("Verifying claim failed. Error: %s\n", err)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			break
 		}
 	}
 	tx = EnsureTx(
-		func() (*types.Transaction, error) {
-			return cc.pool.VerifyClaim(cc.transactor,
+		func() (*Tx, error) {
+			return cc.txSender.Send("verifyClaim",
 				rlpHeader, nonce, submissionIndex, shareIndex, dataSetLookup,
 				witnessForLookup, augCountersBranch, augHashesBranch)
 		},
@@ -238,56 +240,59 @@ func (cc *GethContractClient) VerifyClaim(
 		10000,
 		"Verifying claim",
 	)
+	if txSent != nil {
+		txSent(tx.Hash())
+	}
 	errCode, errInfo, err := GetTxResult(
-		tx, cc.transactor, cc.node, blockNo.Add(blockNo, common.Big1), VerifyClaimEventTopic,
+		tx, cc.txSender, cc.node, blockNo.Add(blockNo, common.Big1), VerifyClaimEventTopic,
 		cc.sender.Big())
 	if err != nil {
 		return err
 	}
 	if errCode.Cmp(common.Big0) != 0 {
-		smartpool.Output.Printf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
+		logger.Warnf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
 		return errors.New(ErrorMsg(errCode, errInfo))
 	}
 	return nil
 }
 
-func getClient(rpc string) (*ethclient.Client, error) {
-	return ethclient.Dial(rpc)
+func (cc *GethContractClient) VerifyClaimResult(tx common.Hash) (bool, error) {
+	mined, errCode, errInfo, err := cc.node.TxLog(tx, VerifyClaimEventTopic)
+	if err != nil || !mined {
+		return false, err
+	}
+	if errCode == nil {
+		return true, errors.New("Contract unexpectedly threw.")
+	}
+	if errCode.Cmp(common.Big0) != 0 {
+		logger.Warnf("Error code: 0x%s - Error info: 0x%s\n", errCode.Text(16), errInfo.Text(16))
+		return true, errors.New(ErrorMsg(errCode, errInfo))
+	}
+	return true, nil
 }
 
 func NewGethContractClient(
-	contractAddr common.Address, node ethereum.RPCClient, miner common.Address,
-	ipc, keystorePath, passphrase string, gasprice uint64) (*GethContractClient, error) {
-	client, err := getClient(ipc)
+	contractAddr common.Address, node ethereum.RPCClient, ipc string,
+	signer Signer, policy *FeePolicy) (*GethContractClient, error) {
+	client, err := contractBackend(node, ipc)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't connect to Geth/Parity. Error: %s\n", err)
+		logger.Warnf("Couldn't connect to Geth/Parity. Error: %s\n", err)
 		return nil, err
 	}
 	pool, err := NewSmartPool(contractAddr, client)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
-		return nil, err
-	}
-	account := GetAccount(keystorePath, miner, passphrase)
-	if account == nil {
-		smartpool.Output.Printf("Couldn't get any account from key store.\n")
-		return nil, err
-	}
-	keyio, err := os.Open(account.KeyFile())
-	if err != nil {
-		smartpool.Output.Printf("Failed to open key file: %s\n", err)
+		logger.Warnf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
 		return nil, err
 	}
-	smartpool.Output.Printf("Unlocking account...\n")
-	auth, err := bind.NewTransactor(keyio, account.PassPhrase())
+	ts, err := newTxSender(contractAddr, SmartPoolABI, client, node, signer, policy)
 	if err != nil {
-		smartpool.Output.Printf("Failed to create authorized transactor: %s\n", err)
+		logger.Warnf("Failed to create tx sender: %s\n", err)
 		return nil, err
 	}
-	if gasprice != 0 {
-		auth.GasPrice = big.NewInt(int64(gasprice * 1000000000))
-		smartpool.Output.Printf("Gas price is set to: %s wei.\n", auth.GasPrice.Text(10))
+	if policy.GasPrice != nil {
+		logger.Infof("Gas price is set to: %s wei.\n", policy.GasPrice.Text(10))
 	}
-	smartpool.Output.Printf("Done.\n")
-	return &GethContractClient{pool, auth, node, miner}, nil
+	logger.Infof("Signing txs for chain id %s.\n", signer.ChainID().Text(10))
+	logger.Debugf("Done.\n")
+	return &GethContractClient{pool, ts, node, signer.Address()}, nil
 }
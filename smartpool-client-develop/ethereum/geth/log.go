@@ -0,0 +1,7 @@
+package geth
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+var logger = smartpool.NewLogger("geth")
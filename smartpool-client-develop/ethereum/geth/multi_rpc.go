@@ -0,0 +1,475 @@
+package geth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// HEALTH_CHECK_INTERVAL is how often MultiRPC checks its nodes.
+	HEALTH_CHECK_INTERVAL = 15 * time.Second
+	// MAX_BLOCK_LAG is how many blocks a node can be behind the best node
+	// and still be considered healthy.
+	MAX_BLOCK_LAG = int64(3)
+)
+
+type rpcNode struct {
+	*GethRPC
+	endpoint string
+	healthy  bool
+}
+
+type nodeStatus struct {
+	blockNo   *big.Int
+	peerCount uint64
+	err       error
+}
+
+func (node *rpcNode) status() nodeStatus {
+	blockNo, err := node.BlockNumber()
+	if err != nil {
+		return nodeStatus{nil, 0, err}
+	}
+	peerCount, err := node.peerCount()
+	return nodeStatus{blockNo, peerCount, err}
+}
+
+// MultiRPC is an ethereum.RPCClient talking to several Ethereum nodes.
+// Calls go to the primary node and fail over to the next healthy node when
+// the primary fails. Solutions and txs are sent to every healthy node at
+// once so a block is propagated as fast as possible. A node is healthy when
+// it responds, has peers and is not more than MAX_BLOCK_LAG blocks behind
+// the best node.
+type MultiRPC struct {
+	mu        sync.RWMutex
+	nodes     []*rpcNode
+	primary   int
+	etherbase *common.Address
+	extradata *string
+}
+
+// orderedNodes returns healthy nodes starting from the primary. If there
+// isn't any healthy node, all nodes are returned so calls are still tried.
+func (m *MultiRPC) orderedNodes() []*rpcNode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	healthy := []*rpcNode{}
+	all := []*rpcNode{}
+	for i := range m.nodes {
+		node := m.nodes[(m.primary+i)%len(m.nodes)]
+		all = append(all, node)
+		if node.healthy {
+			healthy = append(healthy, node)
+		}
+	}
+	if len(healthy) == 0 {
+		return all
+	}
+	return healthy
+}
+
+func (m *MultiRPC) healthyNodes() []*rpcNode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*rpcNode{}
+	for _, node := range m.nodes {
+		if node.healthy {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+func (m *MultiRPC) use(node *rpcNode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nodes[m.primary] == node {
+		return
+	}
+	for i, n := range m.nodes {
+		if n == node {
+			m.primary = i
+			logger.Infof("Switched primary node to %s.\n", node.endpoint)
+			return
+		}
+	}
+}
+
+func (m *MultiRPC) failed(node *rpcNode, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if node.healthy {
+		node.healthy = false
+		logger.Warnf("Node %s failed: %s. Failing over to next node.\n", node.endpoint, err)
+	}
+}
+
+// first calls fn on nodes one by one until it succeeds on one of them.
+func (m *MultiRPC) first(fn func(node *rpcNode) error) error {
+	var err error
+	for _, node := range m.orderedNodes() {
+		if err = fn(node); err == nil {
+			m.use(node)
+			return nil
+		}
+		if err != errWorkNotReady {
+			m.failed(node, err)
+		}
+	}
+	return err
+}
+
+// all calls fn on all healthy nodes at once and returns number of nodes
+// where fn succeeded.
+func (m *MultiRPC) all(fn func(node *rpcNode) bool) int {
+	nodes := m.orderedNodes()
+	results := make(chan bool, len(nodes))
+	for _, node := range nodes {
+		go func(node *rpcNode) {
+			results <- fn(node)
+		}(node)
+	}
+	succeeded := 0
+	for range nodes {
+		if <-results {
+			succeeded++
+		}
+	}
+	return succeeded
+}
+
+func (m *MultiRPC) configure(node *rpcNode) {
+	m.mu.RLock()
+	etherbase, extradata := m.etherbase, m.extradata
+	m.mu.RUnlock()
+	if etherbase != nil {
+		if err := node.SetEtherbase(*etherbase); err != nil {
+			logger.Warnf("Couldn't set etherbase on node %s: %s\n", node.endpoint, err)
+		}
+	}
+	if extradata != nil {
+		if err := node.SetExtradata(*extradata); err != nil {
+			logger.Warnf("Couldn't set extradata on node %s: %s\n", node.endpoint, err)
+		}
+	}
+}
+
+// CheckHealth checks all nodes and updates their health. Nodes coming back
+// are configured with the etherbase and extradata set earlier.
+func (m *MultiRPC) CheckHealth() {
+	statuses := make([]nodeStatus, len(m.nodes))
+	var wg sync.WaitGroup
+	for i, node := range m.nodes {
+		wg.Add(1)
+		go func(i int, node *rpcNode) {
+			defer wg.Done()
+			statuses[i] = node.status()
+		}(i, node)
+	}
+	wg.Wait()
+	best := big.NewInt(0)
+	for _, status := range statuses {
+		if status.err == nil && status.blockNo.Cmp(best) > 0 {
+			best = status.blockNo
+		}
+	}
+	for i, node := range m.nodes {
+		status := statuses[i]
+		reason := ""
+		if status.err != nil {
+			reason = status.err.Error()
+		} else if status.peerCount == 0 {
+			reason = "no peers"
+		} else if new(big.Int).Sub(best, status.blockNo).Int64() > MAX_BLOCK_LAG {
+			reason = "block " + status.blockNo.Text(10) + " is behind best block " + best.Text(10)
+		}
+		m.mu.Lock()
+		wasHealthy := node.healthy
+		node.healthy = reason == ""
+		m.mu.Unlock()
+		if wasHealthy && reason != "" {
+			logger.Warnf("Node %s is unhealthy: %s.\n", node.endpoint, reason)
+			logger.Emit(smartpool.NodeHealthEvent, smartpool.Fields{
+				"node": node.endpoint, "healthy": false, "reason": reason,
+			})
+		} else if !wasHealthy && reason == "" {
+			logger.Infof("Node %s is healthy again.\n", node.endpoint)
+			logger.Emit(smartpool.NodeHealthEvent, smartpool.Fields{
+				"node": node.endpoint, "healthy": true,
+			})
+			m.configure(node)
+		}
+	}
+}
+
+func (m *MultiRPC) RunHealthCheck() {
+	ticker := time.Tick(HEALTH_CHECK_INTERVAL)
+	for _ = range ticker {
+		m.CheckHealth()
+	}
+}
+
+func (m *MultiRPC) ClientVersion() (string, error) {
+	var result string
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		result, err = node.ClientVersion()
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) GetWork() *ethereum.Work {
+	var w *ethereum.Work
+	for {
+		err := m.first(func(node *rpcNode) error {
+			var err error
+			w, err = node.getWork()
+			return err
+		})
+		if err == nil {
+			return w
+		}
+		if err != errWorkNotReady {
+			logger.Warnf("Couldn't get work from any node: %s. Retry in 1s...\n", err)
+		}
+		retryWait(err)
+	}
+}
+
+func (m *MultiRPC) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
+	return m.all(func(node *rpcNode) bool {
+		return node.SubmitHashrate(hashrate, id)
+	}) > 0
+}
+
+// SubmitWork submits the solution to all healthy nodes at once. It returns
+// true if any of them accepted it.
+func (m *MultiRPC) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) bool {
+	return m.all(func(node *rpcNode) bool {
+		return node.SubmitWork(nonce, hash, mixDigest)
+	}) > 0
+}
+
+// IsVerified returns true when the tx is mined according to the majority
+// of healthy nodes.
+func (m *MultiRPC) IsVerified(h common.Hash) bool {
+	nodes := len(m.healthyNodes())
+	verified := m.all(func(node *rpcNode) bool {
+		return node.IsVerified(h)
+	})
+	return verified > 0 && verified*2 > nodes
+}
+
+func (m *MultiRPC) Syncing() bool {
+	m.CheckHealth()
+	return len(m.healthyNodes()) == 0
+}
+
+func (m *MultiRPC) BlockNumber() (*big.Int, error) {
+	var result *big.Int
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		result, err = node.BlockNumber()
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) BlockByNumber(number *big.Int) (*ethereum.ChainBlock, error) {
+	var result *ethereum.ChainBlock
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		result, err = node.BlockByNumber(number)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) UncleByBlockNumber(number *big.Int, index int) (*ethereum.ChainBlock, error) {
+	var result *ethereum.ChainBlock
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		result, err = node.UncleByBlockNumber(number, index)
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) GetLog(txs []common.Hash, from *big.Int, event *big.Int, sender *big.Int) (*big.Int, *big.Int) {
+	var result logs
+	for {
+		err := m.first(func(node *rpcNode) error {
+			var err error
+			result, err = node.getLogs(logFilter(from, event, sender))
+			return err
+		})
+		if err == nil {
+			break
+		}
+		waitTime := rand.Int()%10000 + 1000
+		logger.Warnf("Failed getting logs. Error: %s\n", err)
+		time.Sleep(time.Duration(waitTime) * time.Millisecond)
+	}
+	return findTxLog(txs, result, from, event, sender)
+}
+
+func (m *MultiRPC) ChainID() (*big.Int, error) {
+	var result *big.Int
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		result, err = node.ChainID()
+		return err
+	})
+	return result, err
+}
+
+func (m *MultiRPC) GasPrice() (*big.Int, error) {
+	var result *big.Int
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		result, err = node.GasPrice()
+		return err
+	})
+	return result, err
+}
+
+// FeeHistory only asks the primary node. Pre EIP-1559 nodes fail it and
+// must not be marked unhealthy for that.
+func (m *MultiRPC) FeeHistory(blocks int, percentile float64) (*big.Int, *big.Int, error) {
+	return m.orderedNodes()[0].FeeHistory(blocks, percentile)
+}
+
+func (m *MultiRPC) TxFee(h common.Hash) (*big.Int, *big.Int, error) {
+	var gasUsed, price *big.Int
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		gasUsed, price, err = node.TxFee(h)
+		return err
+	})
+	return gasUsed, price, err
+}
+
+func (m *MultiRPC) TxLog(h common.Hash, event *big.Int) (bool, *big.Int, *big.Int, error) {
+	var (
+		mined            bool
+		errCode, errInfo *big.Int
+	)
+	err := m.first(func(node *rpcNode) error {
+		var err error
+		mined, errCode, errInfo, err = node.TxLog(h, event)
+		return err
+	})
+	return mined, errCode, errInfo, err
+}
+
+// SetEtherbase sets etherbase on all nodes because any of them can give
+// works. It only fails when none of the nodes accepted it.
+func (m *MultiRPC) SetEtherbase(etherbase common.Address) error {
+	m.mu.Lock()
+	m.etherbase = &etherbase
+	m.mu.Unlock()
+	var (
+		mu      sync.Mutex
+		lastErr error
+	)
+	succeeded := m.all(func(node *rpcNode) bool {
+		if err := node.SetEtherbase(etherbase); err != nil {
+			logger.Warnf("Couldn't set etherbase on node %s: %s\n", node.endpoint, err)
+			mu.Lock()
+			lastErr = err
+			mu.Unlock()
+			return false
+		}
+		return true
+	})
+	if succeeded == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (m *MultiRPC) SetExtradata(extradata string) error {
+	m.mu.Lock()
+	m.extradata = &extradata
+	m.mu.Unlock()
+	var (
+		mu      sync.Mutex
+		lastErr error
+	)
+	succeeded := m.all(func(node *rpcNode) bool {
+		if err := node.SetExtradata(extradata); err != nil {
+			logger.Warnf("Couldn't set extradata on node %s: %s\n", node.endpoint, err)
+			mu.Lock()
+			lastErr = err
+			mu.Unlock()
+			return false
+		}
+		return true
+	})
+	if succeeded == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// Broadcast sends the raw tx to all healthy nodes at once. It returns the
+// tx hash if any of them accepted it and the errors of every node
+// otherwise.
+func (m *MultiRPC) Broadcast(raw []byte) (common.Hash, error) {
+	var (
+		mu   sync.Mutex
+		hash common.Hash
+		errs []string
+	)
+	succeeded := m.all(func(node *rpcNode) bool {
+		h, err := node.Broadcast(raw)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, node.endpoint+": "+err.Error())
+			return false
+		}
+		hash = h
+		return true
+	})
+	if succeeded == 0 {
+		sort.Strings(errs)
+		return common.Hash{}, fmt.Errorf("no node accepted the tx (%s)", strings.Join(errs, "; "))
+	}
+	return hash, nil
+}
+
+// NewMultiRPC creates a MultiRPC for comma separated endpoints. The first
+// endpoint is the primary node. A node that can't be dialed starts
+// unhealthy and is dialed again by the health check.
+func NewMultiRPC(endpoints, contractAddr, extraData string, diff *big.Int, miner string) (*MultiRPC, error) {
+	nodes := []*rpcNode{}
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		node := newGethRPC(endpoint, contractAddr, extraData, diff, miner)
+		_, err := node.rpcClient()
+		if err != nil {
+			logger.Warnf("Couldn't connect to node %s: %s. It is retried by the health check.\n", endpoint, err)
+		}
+		nodes = append(nodes, &rpcNode{node, endpoint, err == nil})
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no RPC endpoint is given")
+	}
+	return &MultiRPC{sync.RWMutex{}, nodes, 0, nil, nil}, nil
+}
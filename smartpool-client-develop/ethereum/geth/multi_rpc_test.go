@@ -0,0 +1,289 @@
+package geth
+
+import (
+	"context"
+	"encoding/json"
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNode is an Ethereum node answering the JSON-RPC calls MultiRPC
+// makes. A down node fails every call.
+type fakeNode struct {
+	mu        sync.Mutex
+	version   string
+	down      bool
+	blockNo   int64
+	peers     int64
+	txErr     string
+	broadcast int
+	receipt   interface{}
+}
+
+func (n *fakeNode) set(f func(n *fakeNode)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f(n)
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}{}
+	json.NewDecoder(r.Body).Decode(&req)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		http.Error(w, "node is down", http.StatusServiceUnavailable)
+		return
+	}
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	switch req.Method {
+	case "web3_clientVersion":
+		resp["result"] = n.version
+	case "eth_blockNumber":
+		resp["result"] = "0x" + big.NewInt(n.blockNo).Text(16)
+	case "net_peerCount":
+		resp["result"] = "0x" + big.NewInt(n.peers).Text(16)
+	case "eth_sendRawTransaction":
+		n.broadcast++
+		if n.txErr != "" {
+			resp["error"] = map[string]interface{}{"code": -32000, "message": n.txErr}
+		} else {
+			resp["result"] = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+		}
+	case "eth_getTransactionReceipt":
+		resp["result"] = n.receipt
+	case "eth_call":
+		resp["result"] = "0x2a"
+	default:
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newTestMultiRPC returns a MultiRPC of fake nodes named by versions. The
+// first one is the primary.
+func newTestMultiRPC(t *testing.T, versions ...string) (*MultiRPC, []*fakeNode, func()) {
+	nodes := []*fakeNode{}
+	servers := []*httptest.Server{}
+	endpoints := []string{}
+	for _, version := range versions {
+		node := &fakeNode{version: version, blockNo: 100, peers: 5}
+		server := httptest.NewServer(node)
+		nodes = append(nodes, node)
+		servers = append(servers, server)
+		endpoints = append(endpoints, server.URL)
+	}
+	cleanup := func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+	m, err := NewMultiRPC(strings.Join(endpoints, ","), "0x01", "", big.NewInt(1), "0x02")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return m, nodes, cleanup
+}
+
+func TestMultiRPCFailsOverWhenPrimaryFails(t *testing.T) {
+	m, nodes, cleanup := newTestMultiRPC(t, "primary", "backup")
+	defer cleanup()
+	if version, err := m.ClientVersion(); err != nil || version != "primary" {
+		t.Fatalf("expected the primary node to answer, got %q (%v)", version, err)
+	}
+	nodes[0].set(func(n *fakeNode) { n.down = true })
+	if version, err := m.ClientVersion(); err != nil || version != "backup" {
+		t.Fatalf("expected the backup node to answer, got %q (%v)", version, err)
+	}
+	if m.primary != 1 || m.nodes[0].healthy {
+		t.Fatalf("expected the failed primary to be replaced by the backup node")
+	}
+	// the failed node is not asked again until it recovers
+	nodes[0].set(func(n *fakeNode) { n.down = false })
+	if version, _ := m.ClientVersion(); version != "backup" {
+		t.Fatalf("expected the backup node to stay primary, got %q", version)
+	}
+	nodes[1].set(func(n *fakeNode) { n.down = true })
+	if _, err := m.ClientVersion(); err == nil {
+		t.Fatalf("expected calls to fail when no node answers")
+	}
+}
+
+func TestMultiRPCCheckHealthRecoversNodes(t *testing.T) {
+	m, nodes, cleanup := newTestMultiRPC(t, "a", "b", "c")
+	defer cleanup()
+	nodes[0].set(func(n *fakeNode) { n.down = true })
+	nodes[1].set(func(n *fakeNode) { n.peers = 0 })
+	nodes[2].set(func(n *fakeNode) { n.blockNo = 100 + MAX_BLOCK_LAG + 1 })
+	m.CheckHealth()
+	if healthy := m.healthyNodes(); len(healthy) != 1 || healthy[0] != m.nodes[2] {
+		t.Fatalf("expected only the best node to be healthy, got %d healthy nodes", len(healthy))
+	}
+	if m.Syncing() {
+		t.Fatalf("expected a healthy node not to be syncing")
+	}
+	nodes[0].set(func(n *fakeNode) { n.down, n.blockNo = false, 100+MAX_BLOCK_LAG })
+	nodes[1].set(func(n *fakeNode) { n.peers, n.blockNo = 1, 100+MAX_BLOCK_LAG })
+	m.CheckHealth()
+	if len(m.healthyNodes()) != 3 {
+		t.Fatalf("expected recovered nodes to be healthy, got %d", len(m.healthyNodes()))
+	}
+	for _, node := range nodes {
+		node.set(func(n *fakeNode) { n.down = true })
+	}
+	if !m.Syncing() {
+		t.Fatalf("expected MultiRPC to be syncing without healthy nodes")
+	}
+	if len(m.orderedNodes()) != 3 {
+		t.Fatalf("expected all nodes to be tried without healthy nodes")
+	}
+}
+
+func TestMultiRPCBroadcastsToAllNodes(t *testing.T) {
+	m, nodes, cleanup := newTestMultiRPC(t, "a", "b")
+	defer cleanup()
+	nodes[0].set(func(n *fakeNode) { n.txErr = "nonce too low" })
+	hash, err := m.Broadcast([]byte{1})
+	if err != nil || hash.Big().Int64() != 0xaa {
+		t.Fatalf("expected the tx accepted by one node to be broadcast, got %s (%v)", hash.Hex(), err)
+	}
+	if nodes[0].broadcast != 1 || nodes[1].broadcast != 1 {
+		t.Fatalf("expected the tx to be sent to every node")
+	}
+	nodes[1].set(func(n *fakeNode) { n.txErr = "replacement transaction underpriced" })
+	if _, err = m.Broadcast([]byte{1}); err == nil {
+		t.Fatalf("expected the tx rejected by all nodes to fail")
+	}
+	for i, msg := range []string{"nonce too low", "replacement transaction underpriced"} {
+		if !strings.Contains(err.Error(), m.nodes[i].endpoint+": "+msg) {
+			t.Fatalf("expected error of node %d in %q", i, err)
+		}
+	}
+}
+
+func TestMultiRPCTxLogDecodesReceipt(t *testing.T) {
+	m, nodes, cleanup := newTestMultiRPC(t, "a")
+	defer cleanup()
+	tx := common.HexToHash("0xaa")
+	if mined, _, _, err := m.TxLog(tx, VerifyClaimEventTopic); mined || err != nil {
+		t.Fatalf("expected the tx not to be mined, got %v (%v)", mined, err)
+	}
+	data := "0x" + common.BigToHash(big.NewInt(0x84000009)).Hex()[2:] + common.BigToHash(big.NewInt(7)).Hex()[2:]
+	nodes[0].set(func(n *fakeNode) {
+		n.receipt = map[string]interface{}{
+			"blockHash": "0x00000000000000000000000000000000000000000000000000000000000000bb",
+			"logs": []map[string]interface{}{
+				{"topics": []string{common.BigToHash(SubmitClaimEventTopic).Hex()}, "data": "0x"},
+				{"topics": []string{common.BigToHash(VerifyClaimEventTopic).Hex()}, "data": data},
+			},
+		}
+	})
+	mined, errCode, errInfo, err := m.TxLog(tx, VerifyClaimEventTopic)
+	if !mined || err != nil {
+		t.Fatalf("expected the tx to be mined, got %v (%v)", mined, err)
+	}
+	if errCode == nil || errCode.Int64() != 0x84000009 || errInfo.Int64() != 7 {
+		t.Fatalf("expected error code 0x84000009 with info 7, got %v %v", errCode, errInfo)
+	}
+	// a tx that threw has no log
+	if mined, errCode, _, _ := m.TxLog(tx, RegisterEventTopic); !mined || errCode != nil {
+		t.Fatalf("expected the tx to be mined without log, got %v %v", mined, errCode)
+	}
+}
+
+// FakeIPCNode answers the health check over IPC. The rpc server only
+// serves exported types.
+type FakeIPCNode struct{}
+
+func (FakeIPCNode) ClientVersion() string { return "ipc" }
+func (FakeIPCNode) BlockNumber() string   { return "0x64" }
+func (FakeIPCNode) PeerCount() string     { return "0x5" }
+
+func TestMultiRPCRedialsNodeThatCouldNotBeDialed(t *testing.T) {
+	node := &fakeNode{version: "http", blockNo: 100, peers: 5}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "multi_rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ipc := filepath.Join(dir, "node.ipc")
+	m, err := NewMultiRPC(ipc+","+server.URL, "0x01", "", big.NewInt(1), "0x02")
+	if err != nil {
+		t.Fatalf("expected a node that can't be dialed not to fail MultiRPC, got %s", err)
+	}
+	if m.nodes[0].healthy || !m.nodes[1].healthy {
+		t.Fatalf("expected only the node that can't be dialed to start unhealthy")
+	}
+	if version, err := m.ClientVersion(); err != nil || version != "http" {
+		t.Fatalf("expected the dialed node to answer, got %q (%v)", version, err)
+	}
+	srv := rpc.NewServer()
+	for _, name := range []string{"web3", "eth", "net"} {
+		if err = srv.RegisterName(name, FakeIPCNode{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer srv.Stop()
+	l, err := net.Listen("unix", ipc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go srv.ServeListener(l)
+	m.CheckHealth()
+	if !m.nodes[0].healthy {
+		t.Fatalf("expected the health check to dial the node again")
+	}
+	node.set(func(n *fakeNode) { n.down = true })
+	if version, err := m.ClientVersion(); err != nil || version != "ipc" {
+		t.Fatalf("expected the redialed node to answer, got %q (%v)", version, err)
+	}
+}
+
+func TestMultiRPCContractCallsFailOver(t *testing.T) {
+	m, nodes, cleanup := newTestMultiRPC(t, "a", "b")
+	defer cleanup()
+	nodes[0].set(func(n *fakeNode) { n.down = true })
+	contract := common.HexToAddress("0x01")
+	result, err := m.CallContract(context.Background(), goethereum.CallMsg{To: &contract}, nil)
+	if err != nil || len(result) != 1 || result[0] != 0x2a {
+		t.Fatalf("expected the backup node to answer the contract call, got %x (%v)", result, err)
+	}
+	if m.primary != 1 || m.nodes[0].healthy {
+		t.Fatalf("expected the failed primary to be replaced by the backup node")
+	}
+	nodes[0].set(func(n *fakeNode) { n.down = false })
+	m.CheckHealth()
+	tx := types.NewTransaction(0, contract, big.NewInt(0), big.NewInt(21000), big.NewInt(1), nil)
+	if err = m.SendTransaction(context.Background(), tx); err != nil {
+		t.Fatalf("expected the tx to be sent, got %s", err)
+	}
+	if nodes[0].broadcast != 1 || nodes[1].broadcast != 1 {
+		t.Fatalf("expected the tx to be sent to every node")
+	}
+	for _, node := range nodes {
+		node.set(func(n *fakeNode) { n.txErr = "nonce too low" })
+	}
+	if err = m.SendTransaction(context.Background(), tx); err == nil {
+		t.Fatalf("expected the tx rejected by all nodes to fail")
+	}
+}
@@ -1,7 +1,6 @@
 package geth
 
 import (
-	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"strconv"
 	"strings"
@@ -19,7 +18,7 @@ type PoolMonitor struct {
 func (pm *PoolMonitor) ContractAddress() common.Address {
 	address, err := pm.client.PoolContract(nil)
 	if err != nil {
-		smartpool.Output.Printf("Getting pool contract from gateway failed. Error: %s\n", err)
+		logger.Warnf("Getting pool contract from gateway failed. Error: %s\n", err)
 	}
 	return address
 }
@@ -27,7 +26,7 @@ func (pm *PoolMonitor) ContractAddress() common.Address {
 func (pm *PoolMonitor) RequireClientUpdate() bool {
 	version, err := pm.client.ClientVersion(nil)
 	if err != nil {
-		smartpool.Output.Printf("Getting client version from gateway failed. Error: %s\n", err)
+		logger.Warnf("Getting client version from gateway failed. Error: %s\n", err)
 		return false
 	}
 	if version[0] != pm.version[0] || version[1] != pm.version[1] {
@@ -39,7 +38,7 @@ func (pm *PoolMonitor) RequireClientUpdate() bool {
 func (pm *PoolMonitor) RequireContractUpdate() bool {
 	addr, err := pm.client.PoolContract(nil)
 	if err != nil {
-		smartpool.Output.Printf("Getting contract address from gateway failed. Error: %s\n", err)
+		logger.Warnf("Getting contract address from gateway failed. Error: %s\n", err)
 		return false
 	}
 	return addr != pm.contract
@@ -58,12 +57,12 @@ func NewPoolMonitor(
 	version string, ipc string) (*PoolMonitor, error) {
 	client, err := getClient(ipc)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't connect to Geth via IPC file. Error: %s\n", err)
+		logger.Warnf("Couldn't connect to Geth via IPC file. Error: %s\n", err)
 		return nil, err
 	}
 	poolMonitor, err := NewPoolMonitorClient(gatewayAddr, client)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
+		logger.Warnf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
 		return nil, err
 	}
 	return &PoolMonitor{
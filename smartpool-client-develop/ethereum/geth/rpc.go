@@ -1,17 +1,20 @@
 package geth
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/SmartPool/smartpool-client"
 	"github.com/SmartPool/smartpool-client/ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"math/big"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -33,30 +36,71 @@ type jsonHeader struct {
 	Nonce       *types.BlockNonce `json:"nonce"`
 }
 
+// RPC_TIMEOUT is the longest time a call to a node can take so a stalled
+// node doesn't block SmartPool forever.
+var RPC_TIMEOUT = 30 * time.Second
+
+var errWorkNotReady = errors.New("pending block is not ready")
+
 type GethRPC struct {
+	mu              sync.Mutex
 	client          *rpc.Client
+	endpoint        string
 	ContractAddr    common.Address
 	ExtraData       []byte
 	ShareDifficulty *big.Int
 	MinerAddress    string
 }
 
+// rpcClient returns the connection to the node. If the node couldn't be
+// dialed before, it is dialed again.
+func (g *GethRPC) rpcClient() (*rpc.Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client == nil {
+		client, err := rpc.Dial(g.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		g.client = client
+	}
+	return g.client, nil
+}
+
+func (g *GethRPC) ethClient() (*ethclient.Client, error) {
+	client, err := g.rpcClient()
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(client), nil
+}
+
+func (g *GethRPC) call(result interface{}, method string, args ...interface{}) error {
+	client, err := g.rpcClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RPC_TIMEOUT)
+	defer cancel()
+	return client.CallContext(ctx, result, method, args...)
+}
+
 func (g *GethRPC) ClientVersion() (string, error) {
 	result := ""
-	err := g.client.Call(&result, "web3_clientVersion")
+	err := g.call(&result, "web3_clientVersion")
 	return result, err
 }
 
 func (g *GethRPC) BlockNumber() (*big.Int, error) {
 	str := ""
-	err := g.client.Call(&str, "eth_blockNumber")
+	err := g.call(&str, "eth_blockNumber")
 	result := common.HexToHash(str).Big()
 	return result, err
 }
 
 func (g *GethRPC) GetPendingBlockHeader() (*types.Header, error) {
 	header := jsonHeader{}
-	err := g.client.Call(&header, "eth_getBlockByNumber", "pending", false)
+	err := g.call(&header, "eth_getBlockByNumber", "pending", false)
 	if err != nil {
 		return nil, err
 	}
@@ -86,46 +130,113 @@ func (g *GethRPC) GetPendingBlockHeader() (*types.Header, error) {
 
 func (g *GethRPC) GetBlockHeader(number int) *types.Header {
 	header := types.Header{}
-	err := g.client.Call(&header, "eth_getBlockByNumber", number, false)
+	err := g.call(&header, "eth_getBlockByNumber", number, false)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't get latest block: %v", err)
+		logger.Warnf("Couldn't get latest block: %v", err)
 		return nil
 	}
 	return &header
 }
 
+type jsonBlock struct {
+	Hash      common.Hash      `json:"hash"`
+	Number    *hexutil.Big     `json:"number"`
+	Nonce     types.BlockNonce `json:"nonce"`
+	MixDigest common.Hash      `json:"mixHash"`
+	Uncles    []common.Hash    `json:"uncles"`
+}
+
+func (b *jsonBlock) chainBlock() *ethereum.ChainBlock {
+	return &ethereum.ChainBlock{
+		Hash:      b.Hash,
+		Number:    (*big.Int)(b.Number),
+		Nonce:     b.Nonce,
+		MixDigest: b.MixDigest,
+		Uncles:    b.Uncles,
+	}
+}
+
+func (g *GethRPC) BlockByNumber(number *big.Int) (*ethereum.ChainBlock, error) {
+	var result *jsonBlock
+	err := g.call(&result, "eth_getBlockByNumber", hexutil.EncodeBig(number), false)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || result.Number == nil {
+		return nil, fmt.Errorf("block %s not found", number)
+	}
+	return result.chainBlock(), nil
+}
+
+func (g *GethRPC) UncleByBlockNumber(number *big.Int, index int) (*ethereum.ChainBlock, error) {
+	var result *jsonBlock
+	err := g.call(
+		&result, "eth_getUncleByBlockNumberAndIndex",
+		hexutil.EncodeBig(number), hexutil.EncodeUint64(uint64(index)))
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || result.Number == nil {
+		return nil, fmt.Errorf("uncle %d of block %s not found", index, number)
+	}
+	return result.chainBlock(), nil
+}
+
 type gethWork [3]string
 
 func (w gethWork) PoWHash() string { return w[0] }
 
-func (g *GethRPC) GetWork() *ethereum.Work {
+// getWork tries to get work once. It returns errWorkNotReady when the node
+// gives a work that doesn't match its pending block which happens when
+// the pending block changes between the two calls.
+func (g *GethRPC) getWork() (*ethereum.Work, error) {
 	w := gethWork{}
-	var h *types.Header
-	var err error
+	h, err := g.GetPendingBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	if err = g.call(&w, "eth_getWork"); err != nil {
+		return nil, err
+	}
+	if w.PoWHash() == "" || w.PoWHash() != h.HashNoNonce().Hex() {
+		return nil, errWorkNotReady
+	}
+	return ethereum.NewWork(h, w[0], w[1], g.ShareDifficulty, g.MinerAddress), nil
+}
+
+func (g *GethRPC) GetWork() *ethereum.Work {
 	for {
-		h, err = g.GetPendingBlockHeader()
-		g.client.Call(&w, "eth_getWork")
-		if err == nil && w.PoWHash() != "" && w.PoWHash() == h.HashNoNonce().Hex() {
-			break
+		w, err := g.getWork()
+		if err == nil {
+			return w
 		}
-		if err != nil {
-			smartpool.Output.Printf("getting pending block failed: %s. Retry in 1s...", err.Error())
+		if err != errWorkNotReady {
+			logger.Warnf("getting pending block failed: %s. Retry in 1s...", err.Error())
 		}
-		waitTime := rand.Int()%2000 + 1000
-		time.Sleep(time.Duration(waitTime) * time.Millisecond)
+		retryWait(err)
 	}
-	return ethereum.NewWork(h, w[0], w[1], g.ShareDifficulty, g.MinerAddress)
+}
+
+// retryWait waits before getting work again. A work that is not ready
+// usually is a moment later, e.g. right after a new head is announced, so
+// it is retried sooner than a failed node.
+func retryWait(err error) {
+	waitTime := rand.Int()%2000 + 1000
+	if err == errWorkNotReady {
+		waitTime = rand.Int()%100 + 100
+	}
+	time.Sleep(time.Duration(waitTime) * time.Millisecond)
 }
 
 func (g *GethRPC) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
 	var result bool
-	g.client.Call(&result, "eth_submitHashrate", hashrate, id)
+	g.call(&result, "eth_submitHashrate", hashrate, id)
 	return result
 }
 
 func (g *GethRPC) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) bool {
 	var result bool
-	g.client.Call(&result, "eth_submitWork", nonce, hash, mixDigest)
+	g.call(&result, "eth_submitWork", nonce, hash, mixDigest)
 	return result
 }
 
@@ -148,11 +259,8 @@ type elog struct {
 
 type logs []elog
 
-func (g *GethRPC) GetLog(
-	txs []*types.Transaction,
-	from *big.Int, event *big.Int,
-	sender *big.Int) (*big.Int, *big.Int) {
-	param := filter{
+func logFilter(from *big.Int, event *big.Int, sender *big.Int) filter {
+	return filter{
 		fmt.Sprintf("0x%s", from.Text(16)),
 		"latest",
 		[]string{
@@ -160,29 +268,53 @@ func (g *GethRPC) GetLog(
 			common.BigToHash(sender).Hex(),
 		},
 	}
+}
+
+func (g *GethRPC) getLogs(param filter) (logs, error) {
 	result := logs{}
+	err := g.call(&result, "eth_getLogs", param)
+	return result, err
+}
+
+func (g *GethRPC) GetLog(
+	txs []common.Hash,
+	from *big.Int, event *big.Int,
+	sender *big.Int) (*big.Int, *big.Int) {
+	var (
+		result logs
+		err    error
+	)
 	for {
-		err := g.client.Call(&result, "eth_getLogs", param)
+		result, err = g.getLogs(logFilter(from, event, sender))
 		if err != nil {
 			waitTime := rand.Int()%10000 + 1000
-			smartpool.Output.Printf("Failed getting logs. Error: %s\n", err)
+			logger.Warnf("Failed getting logs. Error: %s\n", err)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			break
 		}
 	}
+	return findTxLog(txs, result, from, event, sender)
+}
+
+// findTxLog returns error code and error info of the log emitted by one
+// of txs.
+func findTxLog(
+	txs []common.Hash, result logs,
+	from *big.Int, event *big.Int,
+	sender *big.Int) (*big.Int, *big.Int) {
 	var theLog elog
 Loop:
 	for _, l := range result {
 		for _, tx := range txs {
-			if common.HexToHash(l.TransactionHash).Big().Cmp(tx.Hash().Big()) == 0 {
+			if common.HexToHash(l.TransactionHash) == tx {
 				theLog = l
 				break Loop
 			}
 		}
 	}
 	if theLog.BlockNumber == "" {
-		smartpool.Output.Printf(
+		logger.Warnf(
 			"Log not found. Contract unexpectedly threw. Topic: %v, sender: %s, from: %s %v\n",
 			len(result),
 			common.BigToHash(event).Hex(),
@@ -191,19 +323,25 @@ Loop:
 		)
 		return nil, nil
 	} else {
-		dataInByte, err := hex.DecodeString(theLog.Data[2:])
-		if err != nil {
-			smartpool.Output.Printf(
-				"Error while converting log data to bytes. Log(%s), Error(%v)\n",
-				theLog.Data, err,
-			)
-		}
-		errCode := big.NewInt(0)
-		errCode.SetBytes(dataInByte[:32])
-		errInfo := big.NewInt(0)
-		errInfo.SetBytes(dataInByte[32:])
-		return errCode, errInfo
+		return logResult(theLog)
+	}
+}
+
+// logResult returns error code and error info a SmartPool event log
+// carries in its data.
+func logResult(l elog) (*big.Int, *big.Int) {
+	dataInByte, err := hex.DecodeString(l.Data[2:])
+	if err != nil {
+		logger.Warnf(
+			"Error while converting log data to bytes. Log(%s), Error(%v)\n",
+			l.Data, err,
+		)
 	}
+	errCode := big.NewInt(0)
+	errCode.SetBytes(dataInByte[:32])
+	errInfo := big.NewInt(0)
+	errInfo.SetBytes(dataInByte[32:])
+	return errCode, errInfo
 }
 
 type jsonTransaction struct {
@@ -212,15 +350,19 @@ type jsonTransaction struct {
 
 func (g *GethRPC) IsVerified(h common.Hash) bool {
 	result := jsonTransaction{}
-	g.client.Call(&result, "eth_getTransactionByHash", h)
+	g.call(&result, "eth_getTransactionByHash", h)
 	return result.BlockHash != "" && result.BlockHash != "0x0000000000000000000000000000000000000000000000000000000000000000"
 }
 
-func (g *GethRPC) Syncing() bool {
+func (g *GethRPC) peerCount() (uint64, error) {
 	result := ""
-	g.client.Call(&result, "net_peerCount")
-	peerCount := common.HexToHash(result).Big().Uint64()
-	smartpool.Output.Printf("peerCount: %d\n", peerCount)
+	err := g.call(&result, "net_peerCount")
+	return common.HexToHash(result).Big().Uint64(), err
+}
+
+func (g *GethRPC) Syncing() bool {
+	peerCount, _ := g.peerCount()
+	logger.Debugf("peerCount: %d\n", peerCount)
 	return peerCount == uint64(0)
 }
 
@@ -231,10 +373,10 @@ func (g *GethRPC) SetEtherbase(etherbase common.Address) error {
 	}
 	result := false
 	if strings.HasPrefix(client, "Geth") {
-		err = g.client.Call(&result, "miner_setEtherbase", etherbase)
+		err = g.call(&result, "miner_setEtherbase", etherbase)
 	} else {
 		// Client must be Parity
-		err = g.client.Call(&result, "parity_setAuthor", etherbase)
+		err = g.call(&result, "parity_setAuthor", etherbase)
 	}
 	return err
 }
@@ -246,10 +388,10 @@ func (g *GethRPC) SetExtradata(extradata string) error {
 	}
 	result := false
 	if strings.HasPrefix(client, "Geth") {
-		err = g.client.Call(&result, "miner_setExtra", extradata)
+		err = g.call(&result, "miner_setExtra", extradata)
 	} else {
 		// Client must be Parity
-		err = g.client.Call(&result, "parity_setExtraData",
+		err = g.call(&result, "parity_setExtraData",
 			common.StringToHash(extradata))
 	}
 	return err
@@ -257,15 +399,148 @@ func (g *GethRPC) SetExtradata(extradata string) error {
 
 func (g *GethRPC) Broadcast(data []byte) (common.Hash, error) {
 	hash := common.Hash{}
-	err := g.client.Call(&hash, "eth_sendRawTransaction",
+	err := g.call(&hash, "eth_sendRawTransaction",
 		fmt.Sprintf("0x%s", common.Bytes2Hex(data)))
 	return hash, err
 }
 
-func NewGethRPC(endpoint, contractAddr, extraData string, diff *big.Int, miner string) (*GethRPC, error) {
-	client, err := rpc.DialHTTP(endpoint)
+// ChainID returns the chain id txs are signed for. Nodes not supporting
+// eth_chainId return it as network id.
+func (g *GethRPC) ChainID() (*big.Int, error) {
+	result := ""
+	if err := g.call(&result, "eth_chainId"); err == nil {
+		return hexutil.DecodeBig(result)
+	}
+	if err := g.call(&result, "net_version"); err != nil {
+		return nil, err
+	}
+	id, ok := new(big.Int).SetString(result, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid network id: %s", result)
+	}
+	return id, nil
+}
+
+func (g *GethRPC) GasPrice() (*big.Int, error) {
+	result := ""
+	if err := g.call(&result, "eth_gasPrice"); err != nil {
+		return nil, err
+	}
+	return hexutil.DecodeBig(result)
+}
+
+type feeHistory struct {
+	BaseFeePerGas []string   `json:"baseFeePerGas"`
+	Reward        [][]string `json:"reward"`
+}
+
+// FeeHistory returns base fee of the next block and average priority fee
+// paid at percentile in the last blocks. It fails if the network doesn't
+// support EIP-1559.
+func (g *GethRPC) FeeHistory(blocks int, percentile float64) (*big.Int, *big.Int, error) {
+	result := feeHistory{}
+	err := g.call(&result, "eth_feeHistory",
+		fmt.Sprintf("0x%x", blocks), "latest", []float64{percentile})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result.BaseFeePerGas) == 0 {
+		return nil, nil, errors.New("no base fee in fee history")
+	}
+	baseFee, err := hexutil.DecodeBig(result.BaseFeePerGas[len(result.BaseFeePerGas)-1])
 	if err != nil {
+		return nil, nil, err
+	}
+	if baseFee.Cmp(common.Big0) == 0 {
+		return nil, nil, errors.New("network doesn't have base fee")
+	}
+	tip := big.NewInt(0)
+	for _, rewards := range result.Reward {
+		if len(rewards) == 0 {
+			continue
+		}
+		reward, err := hexutil.DecodeBig(rewards[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		tip.Add(tip, reward)
+	}
+	if len(result.Reward) > 0 {
+		tip.Div(tip, big.NewInt(int64(len(result.Reward))))
+	}
+	return baseFee, tip, nil
+}
+
+type jsonReceipt struct {
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+}
+
+// TxFee returns gas used by a mined tx and the price it paid per gas. The
+// price is nil if the node doesn't report it.
+func (g *GethRPC) TxFee(h common.Hash) (*big.Int, *big.Int, error) {
+	result := jsonReceipt{}
+	if err := g.call(&result, "eth_getTransactionReceipt", h); err != nil {
+		return nil, nil, err
+	}
+	if result.GasUsed == "" {
+		return nil, nil, fmt.Errorf("no receipt for tx %s", h.Hex())
+	}
+	gasUsed, err := hexutil.DecodeBig(result.GasUsed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.EffectiveGasPrice == "" {
+		return gasUsed, nil, nil
+	}
+	price, err := hexutil.DecodeBig(result.EffectiveGasPrice)
+	return gasUsed, price, err
+}
+
+type jsonLogReceipt struct {
+	BlockHash string `json:"blockHash"`
+	Logs      logs   `json:"logs"`
+}
+
+// TxLog returns true once tx h is mined, with error code and error info of
+// the log it emitted with event. They are nil if the tx threw.
+func (g *GethRPC) TxLog(h common.Hash, event *big.Int) (bool, *big.Int, *big.Int, error) {
+	result := jsonLogReceipt{}
+	if err := g.call(&result, "eth_getTransactionReceipt", h); err != nil {
+		return false, nil, nil, err
+	}
+	return receiptLog(result, event)
+}
+
+func receiptLog(receipt jsonLogReceipt, event *big.Int) (bool, *big.Int, *big.Int, error) {
+	if receipt.BlockHash == "" {
+		return false, nil, nil, nil
+	}
+	topic := common.BigToHash(event).Hex()
+	for _, l := range receipt.Logs {
+		if len(l.Topics) > 0 && strings.EqualFold(l.Topics[0], topic) {
+			errCode, errInfo := logResult(l)
+			return true, errCode, errInfo, nil
+		}
+	}
+	return true, nil, nil, nil
+}
+
+// NewGethRPC connects to the node at endpoint which is an HTTP or WebSocket
+// URL or the path of an IPC socket. Only WebSocket and IPC connections can
+// subscribe to work changes.
+func NewGethRPC(endpoint, contractAddr, extraData string, diff *big.Int, miner string) (*GethRPC, error) {
+	g := newGethRPC(endpoint, contractAddr, extraData, diff, miner)
+	if _, err := g.rpcClient(); err != nil {
 		return nil, err
 	}
-	return &GethRPC{client, common.HexToAddress(contractAddr), []byte(extraData), diff, miner}, nil
+	return g, nil
+}
+
+// newGethRPC returns a GethRPC that dials the node on its first call.
+func newGethRPC(endpoint, contractAddr, extraData string, diff *big.Int, miner string) *GethRPC {
+	return &GethRPC{
+		sync.Mutex{}, nil, endpoint, common.HexToAddress(contractAddr),
+		[]byte(extraData), diff, miner,
+	}
 }
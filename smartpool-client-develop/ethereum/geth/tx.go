@@ -0,0 +1,266 @@
+package geth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+)
+
+// type of EIP-1559 txs in EIP-2718 tx envelope
+const dynamicFeeTxType = 0x02
+
+// Tx is a tx sent to the contract. It is a legacy tx priced by GasPrice or,
+// when MaxFeePerGas is set, an EIP-1559 dynamic fee tx. The go-ethereum
+// version SmartPool is built with doesn't know dynamic fee txs so they are
+// encoded and signed here.
+type Tx struct {
+	Nonce                uint64
+	To                   common.Address
+	Value                *big.Int
+	Gas                  *big.Int
+	Data                 []byte
+	GasPrice             *big.Int
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	raw                  []byte
+	hash                 common.Hash
+}
+
+func (tx *Tx) IsDynamicFee() bool {
+	return tx.MaxFeePerGas != nil
+}
+
+// FeeCap returns the max price per gas the tx can pay.
+func (tx *Tx) FeeCap() *big.Int {
+	if tx.IsDynamicFee() {
+		return tx.MaxFeePerGas
+	}
+	return tx.GasPrice
+}
+
+// Fees describes the tx's fees in gwei for logging.
+func (tx *Tx) Fees() string {
+	gwei := func(wei *big.Int) string {
+		return new(big.Float).Quo(
+			new(big.Float).SetInt(wei), big.NewFloat(1000000000)).Text('f', 2)
+	}
+	if tx.IsDynamicFee() {
+		return fmt.Sprintf("max fee %s gwei, priority fee %s gwei",
+			gwei(tx.MaxFeePerGas), gwei(tx.MaxPriorityFeePerGas))
+	}
+	return fmt.Sprintf("gas price %s gwei", gwei(tx.GasPrice))
+}
+
+// Hash returns hash of the signed tx.
+func (tx *Tx) Hash() common.Hash {
+	return tx.hash
+}
+
+// Raw returns the signed tx encoded to be sent by eth_sendRawTransaction.
+func (tx *Tx) Raw() []byte {
+	return tx.raw
+}
+
+// copy returns an unsigned copy of the tx.
+func (tx *Tx) copy() *Tx {
+	return &Tx{
+		tx.Nonce, tx.To, tx.Value, tx.Gas, tx.Data,
+		tx.GasPrice, tx.MaxFeePerGas, tx.MaxPriorityFeePerGas,
+		nil, common.Hash{},
+	}
+}
+
+// signedDynamicFeeTx is a signed EIP-1559 tx as it is RLP encoded after
+// its type.
+type signedDynamicFeeTx struct {
+	ChainID              *big.Int
+	Nonce                uint64
+	MaxPriorityFeePerGas *big.Int
+	MaxFeePerGas         *big.Int
+	Gas                  *big.Int
+	To                   common.Address
+	Value                *big.Int
+	Data                 []byte
+	AccessList           []rlp.RawValue
+	V                    uint64
+	R                    *big.Int
+	S                    *big.Int
+}
+
+func (d *signedDynamicFeeTx) sender() (common.Address, error) {
+	if len(d.AccessList) != 0 {
+		return common.Address{}, errors.New("tx has an access list")
+	}
+	if d.V > 1 || d.R.BitLen() > 256 || d.S.BitLen() > 256 {
+		return common.Address{}, errors.New("invalid signature")
+	}
+	unsigned, err := rlp.EncodeToBytes([]interface{}{
+		d.ChainID, d.Nonce, d.MaxPriorityFeePerGas, d.MaxFeePerGas,
+		d.Gas, d.To, d.Value, d.Data, d.AccessList,
+	})
+	if err != nil {
+		return common.Address{}, err
+	}
+	sig := make([]byte, 65)
+	copy(sig[32-len(d.R.Bytes()):32], d.R.Bytes())
+	copy(sig[64-len(d.S.Bytes()):64], d.S.Bytes())
+	sig[64] = byte(d.V)
+	pub, err := crypto.SigToPub(
+		crypto.Keccak256(append([]byte{dynamicFeeTxType}, unsigned...)), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// decodeTx decodes a raw signed tx. It returns the tx, the chain it is
+// signed for and the account that signed it. Legacy txs without EIP-155
+// replay protection are refused.
+func decodeTx(raw []byte) (*Tx, *big.Int, common.Address, error) {
+	if len(raw) > 0 && raw[0] == dynamicFeeTxType {
+		d := signedDynamicFeeTx{}
+		if err := rlp.DecodeBytes(raw[1:], &d); err != nil {
+			return nil, nil, common.Address{}, err
+		}
+		sender, err := d.sender()
+		if err != nil {
+			return nil, nil, common.Address{}, err
+		}
+		return &Tx{
+			d.Nonce, d.To, d.Value, d.Gas, d.Data,
+			nil, d.MaxFeePerGas, d.MaxPriorityFeePerGas,
+			raw, crypto.Keccak256Hash(raw),
+		}, d.ChainID, sender, nil
+	}
+	legacy := &types.Transaction{}
+	if err := rlp.DecodeBytes(raw, legacy); err != nil {
+		return nil, nil, common.Address{}, err
+	}
+	if !legacy.Protected() {
+		return nil, nil, common.Address{}, errors.New("tx is not replay protected")
+	}
+	sender, err := types.Sender(types.NewEIP155Signer(legacy.ChainId()), legacy)
+	if err != nil {
+		return nil, nil, common.Address{}, err
+	}
+	to := common.Address{}
+	if legacy.To() != nil {
+		to = *legacy.To()
+	}
+	return &Tx{
+		legacy.Nonce(), to, legacy.Value(), legacy.Gas(), legacy.Data(),
+		legacy.GasPrice(), nil, nil, raw, legacy.Hash(),
+	}, legacy.ChainId(), sender, nil
+}
+
+// differsFrom returns the first field the signed tx doesn't have like the
+// tx it was signed from or "" if they are the same tx.
+func (tx *Tx) differsFrom(unsigned *Tx) string {
+	cmp := func(a, b *big.Int) bool {
+		if a == nil || b == nil {
+			return a == nil && b == nil
+		}
+		return a.Cmp(b) == 0
+	}
+	switch {
+	case tx.Nonce != unsigned.Nonce:
+		return "nonce"
+	case tx.To != unsigned.To:
+		return "to"
+	case !cmp(tx.Value, unsigned.Value):
+		return "value"
+	case !cmp(tx.Gas, unsigned.Gas):
+		return "gas"
+	case !bytes.Equal(tx.Data, unsigned.Data):
+		return "data"
+	case !cmp(tx.GasPrice, unsigned.GasPrice):
+		return "gas price"
+	case !cmp(tx.MaxFeePerGas, unsigned.MaxFeePerGas):
+		return "max fee per gas"
+	case !cmp(tx.MaxPriorityFeePerGas, unsigned.MaxPriorityFeePerGas):
+		return "max priority fee per gas"
+	}
+	return ""
+}
+
+// Signer signs txs sent to the contracts with EIP-155 replay protection so
+// they can't be replayed on other chains. Sign sets the raw signed tx and
+// its hash on the tx.
+type Signer interface {
+	// Address returns the account txs are signed by.
+	Address() common.Address
+	ChainID() *big.Int
+	Sign(tx *Tx) error
+}
+
+// TxSigner signs txs with a key decrypted from the keystore and kept in
+// memory.
+type TxSigner struct {
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	chainID *big.Int
+}
+
+func (s *TxSigner) Address() common.Address { return s.from }
+func (s *TxSigner) ChainID() *big.Int       { return s.chainID }
+
+func (s *TxSigner) signLegacy(tx *Tx) error {
+	signed, err := types.SignTx(
+		types.NewTransaction(tx.Nonce, tx.To, tx.Value, tx.Gas, tx.GasPrice, tx.Data),
+		types.NewEIP155Signer(s.chainID), s.key)
+	if err != nil {
+		return err
+	}
+	raw, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		return err
+	}
+	tx.raw = raw
+	tx.hash = signed.Hash()
+	return nil
+}
+
+func (s *TxSigner) signDynamicFee(tx *Tx) error {
+	fields := []interface{}{
+		s.chainID, tx.Nonce, tx.MaxPriorityFeePerGas, tx.MaxFeePerGas,
+		tx.Gas, tx.To, tx.Value, tx.Data, []interface{}{},
+	}
+	unsigned, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return err
+	}
+	sig, err := crypto.Sign(
+		crypto.Keccak256(append([]byte{dynamicFeeTxType}, unsigned...)), s.key)
+	if err != nil {
+		return err
+	}
+	fields = append(fields,
+		uint64(sig[64]),
+		new(big.Int).SetBytes(sig[:32]),
+		new(big.Int).SetBytes(sig[32:64]))
+	signed, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return err
+	}
+	tx.raw = append([]byte{dynamicFeeTxType}, signed...)
+	tx.hash = crypto.Keccak256Hash(tx.raw)
+	return nil
+}
+
+// Sign signs the tx in place.
+func (s *TxSigner) Sign(tx *Tx) error {
+	if tx.IsDynamicFee() {
+		return s.signDynamicFee(tx)
+	}
+	return s.signLegacy(tx)
+}
+
+func NewTxSigner(key *ecdsa.PrivateKey, chainID *big.Int) *TxSigner {
+	return &TxSigner{key, crypto.PubkeyToAddress(key.PublicKey), chainID}
+}
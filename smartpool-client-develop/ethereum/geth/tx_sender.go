@@ -0,0 +1,98 @@
+package geth
+
+import (
+	"context"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// txSender builds, prices, signs and broadcasts txs calling methods of a
+// contract. Generated bindings can't be used for that because they only
+// sign legacy txs without chain id.
+type txSender struct {
+	contract common.Address
+	abi      abi.ABI
+	backend  bind.ContractBackend
+	node     ethereum.RPCClient
+	signer   Signer
+	policy   *FeePolicy
+}
+
+func (ts *txSender) From() common.Address {
+	return ts.signer.Address()
+}
+
+// Send sends a tx calling method of the contract with args.
+func (ts *txSender) Send(method string, args ...interface{}) (*Tx, error) {
+	data, err := ts.abi.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RPC_TIMEOUT)
+	defer cancel()
+	nonce, err := ts.backend.PendingNonceAt(ctx, ts.From())
+	if err != nil {
+		return nil, err
+	}
+	gas, err := ts.backend.EstimateGas(ctx, goethereum.CallMsg{
+		From: ts.From(), To: &ts.contract, Data: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tx := &Tx{
+		Nonce: nonce, To: ts.contract, Value: big.NewInt(0),
+		Gas: gas, Data: data,
+	}
+	if err = ts.policy.Price(ts.node, tx); err != nil {
+		return nil, err
+	}
+	if err = ts.signer.Sign(tx); err != nil {
+		return nil, err
+	}
+	if _, err = ts.node.Broadcast(tx.Raw()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// UnlockKeystore decrypts the key of address in the keystore and returns a
+// signer for the node's chain.
+func UnlockKeystore(keystorePath string, address common.Address, passphrase string, node ethereum.RPCClient) (*TxSigner, error) {
+	return unlockAccount(GetAccount(keystorePath, address, passphrase), node)
+}
+
+// unlockAccount decrypts the key of account and returns a signer for the
+// node's chain.
+func unlockAccount(account *MinerAccount, node ethereum.RPCClient) (*TxSigner, error) {
+	keyjson, err := ioutil.ReadFile(account.KeyFile())
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(keyjson, account.PassPhrase())
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := node.ChainID()
+	if err != nil {
+		return nil, err
+	}
+	return NewTxSigner(key.PrivateKey, chainID), nil
+}
+
+func newTxSender(
+	contract common.Address, contractABI string, backend bind.ContractBackend,
+	node ethereum.RPCClient, signer Signer, policy *FeePolicy) (*txSender, error) {
+	parsed, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		return nil, err
+	}
+	return &txSender{contract, parsed, backend, node, signer, policy}, nil
+}
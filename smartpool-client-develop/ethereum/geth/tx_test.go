@@ -0,0 +1,75 @@
+package geth
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+	"testing"
+)
+
+// signed by an EIP-1559 implementation independent of this package with
+// the key below
+var dynamicFeeTxVector = "0x02f8700307843b9aca00850df847580083030d4094893dc419776635f8fd1b1fa9934bf529aef256078084deadbeefc080a001043607c98d1b7bbc2e08b142f0aa34e49bd5f3b3852206eecb618f2843d96fa0300e5f595db05e94ca4b3321bb2d7f18c20cae848b56a38acc224aa0d5046374"
+
+func TestTxSignerSignsDynamicFeeTx(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	signer := NewTxSigner(key, big.NewInt(3))
+	tx := &Tx{
+		Nonce: 7, To: common.HexToAddress("0x893DC419776635F8FD1b1fa9934BF529aeF25607"),
+		Value: big.NewInt(0), Gas: big.NewInt(200000), Data: []byte{0xde, 0xad, 0xbe, 0xef},
+		MaxFeePerGas: big.NewInt(60000000000), MaxPriorityFeePerGas: big.NewInt(1000000000),
+	}
+	if err := signer.Sign(tx); err != nil {
+		t.Fatal(err)
+	}
+	if hexutil.Encode(tx.Raw()) != dynamicFeeTxVector {
+		t.Fatalf("expected raw tx %s, got %s", dynamicFeeTxVector, hexutil.Encode(tx.Raw()))
+	}
+	if tx.Hash() != crypto.Keccak256Hash(tx.Raw()) {
+		t.Fatalf("expected tx hash to be hash of the raw tx")
+	}
+	if tx.Raw()[0] != dynamicFeeTxType {
+		t.Fatalf("expected tx type %d, got %d", dynamicFeeTxType, tx.Raw()[0])
+	}
+	decoded, chainID, sender, err := decodeTx(tx.Raw())
+	if err != nil {
+		t.Fatalf("couldn't decode signed tx: %s", err)
+	}
+	if chainID.Cmp(big.NewInt(3)) != 0 || decoded.differsFrom(tx) != "" ||
+		decoded.Hash() != tx.Hash() {
+		t.Fatalf("unexpected signed tx %+v of chain %s", decoded, chainID)
+	}
+	if sender != common.HexToAddress("0x71562b71999873DB5b286dF957af199Ec94617F7") {
+		t.Fatalf("expected tx to be signed by the key, got %s", sender.Hex())
+	}
+}
+
+func TestDecodeTxRecoversSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := NewTxSigner(key, big.NewInt(3))
+	tx := &Tx{
+		Nonce: 1, To: common.HexToAddress("0x02"), Value: big.NewInt(0),
+		Gas: big.NewInt(21000), Data: []byte{1}, GasPrice: big.NewInt(10),
+	}
+	if err := signer.Sign(tx); err != nil {
+		t.Fatal(err)
+	}
+	decoded, chainID, sender, err := decodeTx(tx.Raw())
+	if err != nil {
+		t.Fatalf("couldn't decode signed tx: %s", err)
+	}
+	if chainID.Cmp(big.NewInt(3)) != 0 || sender != signer.Address() ||
+		decoded.differsFrom(tx) != "" || decoded.Hash() != tx.Hash() {
+		t.Fatalf("unexpected signed tx %+v by %s of chain %s", decoded, sender.Hex(), chainID)
+	}
+	unprotected, _ := types.SignTx(
+		types.NewTransaction(tx.Nonce, tx.To, tx.Value, tx.Gas, tx.GasPrice, tx.Data),
+		types.HomesteadSigner{}, key)
+	raw, _ := rlp.EncodeToBytes(unprotected)
+	if _, _, _, err = decodeTx(raw); err == nil {
+		t.Fatalf("expected tx without replay protection to be refused")
+	}
+}
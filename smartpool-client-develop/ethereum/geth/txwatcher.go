@@ -1,45 +1,51 @@
 package geth
 
 import (
-	"bytes"
 	"errors"
 	"github.com/SmartPool/smartpool-client"
 	"github.com/SmartPool/smartpool-client/ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
 	"math/big"
 	"math/rand"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-var (
-	GAS_INCREMENT       = 110
-	GAS_PRICE_LIMIT     = big.NewInt(60000000000)
-	WAIT_IN_MILLISECOND = 600000
-)
+var WAIT_IN_MILLISECOND = 600000
+
+// number of txs rebroadcasted with higher fees since the client started
+var rebroadcastCount uint64
+
+// RebroadcastCount returns number of txs that were rebroadcasted because
+// they were not mined in time.
+func RebroadcastCount() uint64 {
+	return atomic.LoadUint64(&rebroadcastCount)
+}
 
 // TxWatcher keeps track of pending transactions
 // and acknowledge corresponding channel when a transaction is
 // confirmed.
 // It also captures event information emitted during the transaction
-// and retry with higher gas price when the tx is not confirmed in time
+// and retry with higher fees when the tx is not confirmed in time
 type TxWatcher struct {
-	txs        []*types.Transaction
-	verifiedTx *types.Transaction
-	transactor *bind.TransactOpts
+	txs        []*Tx
+	verifiedTx *Tx
+	txSender   *txSender
 	node       ethereum.RPCClient
 	block      *big.Int
 	event      *big.Int
 	sender     *big.Int
 	verChan    chan bool
+	// cost of the mined tx, nil until it is known
+	cost *TxCost
 }
 
 func (tw *TxWatcher) isVerified() bool {
 	for _, tx := range tw.txs {
 		if tw.node.IsVerified(tx.Hash()) {
 			tw.verifiedTx = tx
-			smartpool.Output.Printf("tx %s is verified.\n", tx.Hash().Hex())
+			logger.Debugf("tx %s is verified.\n", tx.Hash().Hex())
 			return true
 		}
 	}
@@ -63,53 +69,61 @@ func (tw *TxWatcher) loop(timeout chan bool) {
 	}
 }
 
-func (tw *TxWatcher) lastTx() *types.Transaction {
-	return tw.txs[len(tw.txs)-1]
+func (tw *TxWatcher) txHashes() string {
+	hashes := []string{}
+	for _, tx := range tw.txs {
+		hashes = append(hashes, tx.Hash().Hex())
+	}
+	return strings.Join(hashes, ", ")
 }
 
-func (tw *TxWatcher) newTx(oldTx *types.Transaction) (*types.Transaction, error) {
-	newGasPrice := new(big.Int).Set(oldTx.GasPrice())
-	// setting new gas price as 25% higher than old gas price
-	newGasPrice.Mul(newGasPrice, big.NewInt(int64(GAS_INCREMENT)))
-	newGasPrice.Div(newGasPrice, big.NewInt(100))
-	newTx := types.NewTransaction(
-		oldTx.Nonce(), *oldTx.To(), oldTx.Value(),
-		oldTx.Gas(), newGasPrice, oldTx.Data())
-	return tw.transactor.Signer(types.HomesteadSigner{}, tw.transactor.From, newTx)
+func (tw *TxWatcher) lastTx() *Tx {
+	return tw.txs[len(tw.txs)-1]
 }
 
-func (tw *TxWatcher) rebroadcastByPublicNode(signedTx *types.Transaction) error {
-	buff := bytes.NewBuffer([]byte{})
-	if err := signedTx.EncodeRLP(buff); err != nil {
-		return err
+// newTx returns oldTx signed again with bumped fees. It returns nil if
+// oldTx already pays the max fee of the fee policy.
+func (tw *TxWatcher) newTx(oldTx *Tx) (*Tx, error) {
+	newTx, ok := tw.txSender.policy.Bump(oldTx)
+	if !ok {
+		return nil, nil
 	}
-	return SendRawTransaction(buff.Bytes())
+	if err := tw.txSender.signer.Sign(newTx); err != nil {
+		return nil, err
+	}
+	return newTx, nil
 }
 
-func (tw *TxWatcher) rebroadcast(oldTx, signedTx *types.Transaction) error {
-	buff := bytes.NewBuffer([]byte{})
-	if err := signedTx.EncodeRLP(buff); err != nil {
-		return err
-	}
+func (tw *TxWatcher) rebroadcastByPublicNode(signedTx *Tx) error {
+	return SendRawTransaction(signedTx.Raw())
+}
+
+func (tw *TxWatcher) rebroadcast(oldTx, signedTx *Tx) error {
 	var (
 		hash common.Hash
 		err  error
 	)
 	for {
-		hash, err = tw.node.Broadcast(buff.Bytes())
+		hash, err = tw.node.Broadcast(signedTx.Raw())
 		if err != nil {
 			waitTime := rand.Int()%10000 + 1000
-			smartpool.Output.Printf("Broadcast error: %s\n", err)
+			logger.Warnf("Broadcast error: %s\n", err)
 			time.Sleep(time.Duration(waitTime) * time.Millisecond)
 		} else {
 			break
 		}
 	}
-	smartpool.Output.Printf(
-		"Rebroadcast tx: %s by tx: %s with gas price %d...\n",
+	atomic.AddUint64(&rebroadcastCount, 1)
+	logger.Infof(
+		"Rebroadcast tx: %s by tx: %s with %s...\n",
 		oldTx.Hash().Hex(),
 		signedTx.Hash().Hex(),
-		signedTx.GasPrice().Uint64())
+		signedTx.Fees())
+	logger.Emit(smartpool.TxRebroadcastEvent, smartpool.Fields{
+		"old_tx": oldTx.Hash().Hex(),
+		"tx":     signedTx.Hash().Hex(),
+		"fees":   signedTx.Fees(),
+	})
 	if hash.Big().Cmp(common.Big0) == 0 {
 		return errors.New("Rebroadcast tx got 0 tx hash. This is not supposed to happend.")
 	}
@@ -117,15 +131,15 @@ func (tw *TxWatcher) rebroadcast(oldTx, signedTx *types.Transaction) error {
 }
 
 func (tw *TxWatcher) WaitAndRetry() (*big.Int, *big.Int, error) {
-	var oldTx *types.Transaction
+	var oldTx *Tx
 	for {
 		oldTx = tw.lastTx()
 		errCode, errInfo, err := tw.Wait()
 		if err != nil {
-			if oldTx.GasPrice().Cmp(GAS_PRICE_LIMIT) >= 0 {
+			signedTx, err := tw.newTx(oldTx)
+			if err == nil && signedTx == nil {
 				break
 			}
-			signedTx, err := tw.newTx(oldTx)
 			if err == nil {
 				err = tw.rebroadcast(oldTx, signedTx)
 				if err == nil {
@@ -153,7 +167,7 @@ func (tw *TxWatcher) WaitAndRetry() (*big.Int, *big.Int, error) {
 	// 	// if err != nil {
 	// 	// 	err = tw.rebroadcastByPublicNode(signedTx)
 	// 	// 	if err != nil {
-	// 	// 		smartpool.Output.Printf("Rebroadcast by public node of tx: %s failed. Error: %s\n", signedTx.Hash().Hex(), err)
+	// 	// 		logger.Warnf("Rebroadcast by public node of tx: %s failed. Error: %s\n", signedTx.Hash().Hex(), err)
 	// 	// 	}
 	// 	// }
 	// 	// errCode, errInfo, err = tw.Wait()
@@ -162,11 +176,7 @@ func (tw *TxWatcher) WaitAndRetry() (*big.Int, *big.Int, error) {
 }
 
 func (tw *TxWatcher) Wait() (*big.Int, *big.Int, error) {
-	smartpool.Output.Printf("Waiting for txs: [")
-	for _, tx := range tw.txs {
-		smartpool.Output.Printf("%s, ", tx.Hash().Hex())
-	}
-	smartpool.Output.Printf("] to be mined...\n")
+	logger.Debugf("Waiting for txs: [%s] to be mined...\n", tw.txHashes())
 	timeout := make(chan bool, 1)
 	go tw.loop(timeout)
 	go func() {
@@ -181,29 +191,58 @@ func (tw *TxWatcher) Wait() (*big.Int, *big.Int, error) {
 	case <-timeout:
 		return nil, nil, errors.New("timeout error")
 	}
-	errCode, errInfo := tw.node.GetLog(tw.txs, tw.block, tw.event, tw.sender)
+	hashes := []common.Hash{}
+	for _, tx := range tw.txs {
+		hashes = append(hashes, tx.Hash())
+	}
+	errCode, errInfo := tw.node.GetLog(hashes, tw.block, tw.event, tw.sender)
+	tw.recordCost(errCode)
 	return errCode, errInfo, nil
 }
 
-func GetTxResult(tx *types.Transaction, opts *bind.TransactOpts, node ethereum.RPCClient,
+// recordCost adds what the mined tx cost to the claim costs.
+func (tw *TxWatcher) recordCost(errCode *big.Int) {
+	if tw.verifiedTx == nil {
+		return
+	}
+	gasUsed, price, err := tw.node.TxFee(tw.verifiedTx.Hash())
+	if err != nil {
+		logger.Warnf("Couldn't get fee of tx %s: %s\n", tw.verifiedTx.Hash().Hex(), err)
+		return
+	}
+	if price == nil {
+		price = tw.verifiedTx.FeeCap()
+	}
+	tw.cost = &TxCost{
+		tw.verifiedTx.Hash(), gasUsed, new(big.Int).Mul(gasUsed, price),
+	}
+	costs.record(tw.event, tw.cost, errCode)
+}
+
+func GetTxResult(tx *Tx, ts *txSender, node ethereum.RPCClient,
 	blockNo *big.Int, event *big.Int, sender *big.Int) (*big.Int, *big.Int, error) {
 
-	txWatcher := NewTxWatcher(tx, opts, node, blockNo, event, sender)
+	errCode, errInfo, _, err := GetTxResultAndCost(tx, ts, node, blockNo, event, sender)
+	return errCode, errInfo, err
+}
+
+// GetTxResultAndCost is GetTxResult that also returns what the mined tx
+// cost. The cost is nil if it couldn't be fetched.
+func GetTxResultAndCost(tx *Tx, ts *txSender, node ethereum.RPCClient,
+	blockNo *big.Int, event *big.Int, sender *big.Int) (*big.Int, *big.Int, *TxCost, error) {
+
+	txWatcher := NewTxWatcher(tx, ts, node, blockNo, event, sender)
 	errCode, errInfo, err := txWatcher.WaitAndRetry()
 	if err != nil {
-		smartpool.Output.Printf("No tx in: [")
-		for _, tx := range txWatcher.txs {
-			smartpool.Output.Printf("%s, ", tx.Hash().Hex())
-		}
-		smartpool.Output.Printf("] was approved by the network in time.\n")
+		logger.Warnf("No tx in: [%s] was approved by the network in time.\n", txWatcher.txHashes())
 	}
-	return errCode, errInfo, err
+	return errCode, errInfo, txWatcher.cost, err
 }
 
 func NewTxWatcher(
-	tx *types.Transaction, opts *bind.TransactOpts, node ethereum.RPCClient,
+	tx *Tx, ts *txSender, node ethereum.RPCClient,
 	blockNo *big.Int, event *big.Int, sender *big.Int) *TxWatcher {
 	return &TxWatcher{
-		[]*types.Transaction{tx}, nil,
-		opts, node, blockNo, event, sender, make(chan bool)}
+		[]*Tx{tx}, nil,
+		ts, node, blockNo, event, sender, make(chan bool), nil}
 }
@@ -0,0 +1,137 @@
+package geth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"sync"
+)
+
+// workSubscription forwards new heads and pending block changes announced
+// by eth_subscribe subscriptions of one or more nodes. It fails as soon as
+// one of the subscriptions fails.
+type workSubscription struct {
+	subs []*rpc.ClientSubscription
+	err  chan error
+	quit chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+}
+
+func newWorkSubscription() *workSubscription {
+	return &workSubscription{
+		err:  make(chan error, 1),
+		quit: make(chan struct{}),
+	}
+}
+
+func (s *workSubscription) Err() <-chan error {
+	return s.err
+}
+
+func (s *workSubscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.quit)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, sub := range s.subs {
+			sub.Unsubscribe()
+		}
+	})
+}
+
+func announce(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// forward signals out on every notification of sub until sub fails or s is
+// unsubscribed.
+func (s *workSubscription) forward(sub *rpc.ClientSubscription, notifications <-chan json.RawMessage, out chan<- struct{}) {
+	s.mu.Lock()
+	s.subs = append(s.subs, sub)
+	s.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case <-notifications:
+				announce(out)
+			case err := <-sub.Err():
+				// Err is closed without an error on Unsubscribe
+				if err != nil {
+					select {
+					case s.err <- err:
+					default:
+					}
+				}
+				return
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// subscribe subscribes to new heads of the node and, if the node supports
+// it, to its new pending txs which change its pending block. It fails if
+// the node doesn't support subscriptions, e.g. when it is connected over
+// HTTP.
+func (g *GethRPC) subscribe(s *workSubscription, heads, pending chan<- struct{}) error {
+	client, err := g.rpcClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RPC_TIMEOUT)
+	defer cancel()
+	headCh := make(chan json.RawMessage)
+	headSub, err := client.EthSubscribe(ctx, headCh, "newHeads")
+	if err != nil {
+		return err
+	}
+	s.forward(headSub, headCh, heads)
+	pendingCh := make(chan json.RawMessage)
+	pendingSub, err := client.EthSubscribe(ctx, pendingCh, "newPendingTransactions")
+	if err != nil {
+		logger.Debugf("Node doesn't announce pending txs: %s\n", err)
+		return nil
+	}
+	s.forward(pendingSub, pendingCh, pending)
+	return nil
+}
+
+// SubscribeWorkChanges subscribes to new heads and pending txs of the node.
+// It fails if the node is not connected over WebSocket or IPC.
+func (g *GethRPC) SubscribeWorkChanges(heads, pending chan<- struct{}) (ethereum.WorkSubscription, error) {
+	s := newWorkSubscription()
+	if err := g.subscribe(s, heads, pending); err != nil {
+		s.Unsubscribe()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SubscribeWorkChanges subscribes to work changes of every node connected
+// over WebSocket or IPC so a new head is noticed as soon as any node has
+// it. Nodes connected over HTTP are skipped. It fails if no node could be
+// subscribed.
+func (m *MultiRPC) SubscribeWorkChanges(heads, pending chan<- struct{}) (ethereum.WorkSubscription, error) {
+	s := newWorkSubscription()
+	var lastErr error
+	subscribed := 0
+	for _, node := range m.orderedNodes() {
+		if err := node.subscribe(s, heads, pending); err != nil {
+			lastErr = err
+			continue
+		}
+		logger.Debugf("Subscribed to work changes of node %s.\n", node.endpoint)
+		subscribed++
+	}
+	if subscribed == 0 {
+		s.Unsubscribe()
+		return nil, lastErr
+	}
+	return s, nil
+}
@@ -0,0 +1,7 @@
+package ethereum
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+var logger = smartpool.NewLogger("ethereum")
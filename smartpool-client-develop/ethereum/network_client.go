@@ -6,37 +6,156 @@ import (
 	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
 	"math/rand"
 	"strings"
 	"sync"
 	"time"
 )
 
+var (
+	// WORK_POLL_INTERVAL is how often work is polled from nodes that can't
+	// announce work changes.
+	WORK_POLL_INTERVAL = 50 * time.Millisecond
+	// SUBSCRIBED_POLL_INTERVAL is how often work is still polled while
+	// nodes announce work changes in case an announcement is missed.
+	SUBSCRIBED_POLL_INTERVAL = 10 * time.Second
+	// PENDING_WORK_INTERVAL is the shortest time between fetches caused by
+	// pending block changes because they are announced for every new tx.
+	PENDING_WORK_INTERVAL = time.Second
+	// RESUBSCRIBE_INTERVAL is how long work is polled before subscribing to
+	// work changes again after the subscription failed.
+	RESUBSCRIBE_INTERVAL = time.Minute
+)
+
 type NetworkClient struct {
 	rpc        RPCClient
 	workpool   *WorkPool
 	cachedWork *Work
 	mu         sync.RWMutex
-	ticker     <-chan time.Time
+	subMu      sync.Mutex
+	subs       []chan *Work
+	tierMu     sync.Mutex
+	tierBase   string
+	tierWorks  map[string]*Work
+	tracker    *BlockTracker
 }
 
 func (nc *NetworkClient) fetchNewWork() {
 	nc.mu.Lock()
 	defer nc.mu.Unlock()
 	work := nc.rpc.GetWork()
+	changed := nc.cachedWork == nil || nc.cachedWork.ID() != work.ID()
 	nc.cachedWork = work
 	nc.workpool.AddWork(work)
+	if changed {
+		logger.Emit(smartpool.NewWorkEvent, smartpool.Fields{
+			"hash":   work.ID(),
+			"number": work.BlockHeader.Number.Text(10),
+		})
+		nc.notifyNewWork(work)
+	}
+}
+
+// SubscribeWork returns a channel that receives every new work fetched from
+// the network. Only the latest work is kept for a slow subscriber so it never
+// blocks the fetcher.
+func (nc *NetworkClient) SubscribeWork() <-chan *Work {
+	nc.subMu.Lock()
+	defer nc.subMu.Unlock()
+	ch := make(chan *Work, 1)
+	nc.subs = append(nc.subs, ch)
+	return ch
+}
+
+func (nc *NetworkClient) notifyNewWork(work *Work) {
+	nc.subMu.Lock()
+	defer nc.subMu.Unlock()
+	for _, ch := range nc.subs {
+		// drop the work the subscriber hasn't picked up yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- work
+	}
+}
+
+// fetchWork fetches work whenever nodes announce a work change. Work is
+// polled while nodes can't announce changes.
+func (nc *NetworkClient) fetchWork() {
+	subscriber, ok := nc.rpc.(WorkChangeSubscriber)
+	if !ok {
+		nc.pollWork(nil)
+		return
+	}
+	for {
+		heads := make(chan struct{}, 1)
+		pending := make(chan struct{}, 1)
+		sub, err := subscriber.SubscribeWorkChanges(heads, pending)
+		if err == nil {
+			logger.Infof("Fetching work when the node announces a new head or pending block.\n")
+			err = nc.fetchOnAnnouncements(sub, heads, pending)
+			logger.Warnf("Work subscription failed: %s. Polling work for %s.\n", err, RESUBSCRIBE_INTERVAL)
+		} else {
+			logger.Debugf("Couldn't subscribe to work changes: %s. Polling work for %s.\n", err, RESUBSCRIBE_INTERVAL)
+		}
+		nc.pollWork(time.After(RESUBSCRIBE_INTERVAL))
+	}
+}
+
+// pollWork polls work every WORK_POLL_INTERVAL until done.
+func (nc *NetworkClient) pollWork(done <-chan time.Time) {
+	ticker := time.NewTicker(WORK_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			nc.fetchNewWork()
+		case <-done:
+			return
+		}
+	}
 }
 
-func (nc *NetworkClient) fetchOnTick() {
-	for _ = range nc.ticker {
-		nc.fetchNewWork()
+// fetchOnAnnouncements fetches work on each announced head right away and
+// on pending block changes at most once every PENDING_WORK_INTERVAL. It
+// returns when the subscription fails.
+func (nc *NetworkClient) fetchOnAnnouncements(sub WorkSubscription, heads, pending <-chan struct{}) error {
+	defer sub.Unsubscribe()
+	nc.fetchNewWork()
+	ticker := time.NewTicker(SUBSCRIBED_POLL_INTERVAL)
+	defer ticker.Stop()
+	var pendingFetch <-chan time.Time
+	for {
+		select {
+		case <-heads:
+			nc.fetchNewWork()
+		case <-pending:
+			if pendingFetch == nil {
+				pendingFetch = time.After(PENDING_WORK_INTERVAL)
+			}
+		case <-pendingFetch:
+			pendingFetch = nil
+			nc.fetchNewWork()
+		case <-ticker.C:
+			nc.fetchNewWork()
+		case err := <-sub.Err():
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return err
+		}
 	}
 }
 
 func (nc *NetworkClient) fetchFromCache() smartpool.Work {
 	nc.mu.RLock()
 	defer nc.mu.RUnlock()
+	// a nil *Work would be a non nil smartpool.Work
+	if nc.cachedWork == nil {
+		return nil
+	}
 	return nc.cachedWork
 }
 
@@ -52,13 +171,47 @@ func (nc *NetworkClient) GetWork() smartpool.Work {
 	}
 }
 
+// GetWorkWithDifficulty returns current work with share difficulty diff.
+// The derived work is added to the workpool so its solutions are accepted.
+func (nc *NetworkClient) GetWorkWithDifficulty(diff *big.Int) smartpool.Work {
+	work := nc.GetWork().(*Work)
+	if diff == nil || diff.Cmp(work.ShareDifficulty) == 0 {
+		return work
+	}
+	nc.tierMu.Lock()
+	defer nc.tierMu.Unlock()
+	if nc.tierBase != work.ID() {
+		nc.tierBase = work.ID()
+		nc.tierWorks = map[string]*Work{}
+	}
+	tierWork := nc.tierWorks[diff.Text(16)]
+	if tierWork == nil {
+		tierWork = work.ForDifficulty(diff)
+		nc.workpool.AddWork(tierWork)
+		nc.tierWorks[diff.Text(16)] = tierWork
+	}
+	return tierWork
+}
+
 func (nc *NetworkClient) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
 	return nc.rpc.SubmitHashrate(hashrate, id)
 }
 
 func (nc *NetworkClient) SubmitSolution(s smartpool.Solution) bool {
 	sol := s.(*Solution)
-	return nc.rpc.SubmitWork(sol.Nonce, sol.Hash, sol.MixDigest)
+	accepted := nc.rpc.SubmitWork(sol.Nonce, sol.Hash, sol.MixDigest)
+	if accepted && nc.tracker != nil {
+		if work := nc.workpool.Work(sol.WorkID()); work != nil {
+			nc.tracker.Track(work, sol)
+		}
+	}
+	return accepted
+}
+
+// SetBlockTracker makes full block solutions accepted by the node tracked
+// until they mature.
+func (nc *NetworkClient) SetBlockTracker(tracker *BlockTracker) {
+	nc.tracker = tracker
 }
 
 func (nc *NetworkClient) ReadyToMine() bool {
@@ -71,39 +224,39 @@ func (nc *NetworkClient) Configure(etherbase common.Address, extradata string) e
 		return err
 	}
 	if strings.HasPrefix(client, "Geth") {
-		smartpool.Output.Printf("Trying to set etherbase to SmartPool contract address: %s...\n", etherbase.Hex())
+		logger.Infof("Trying to set etherbase to SmartPool contract address: %s...\n", etherbase.Hex())
 		err = nc.rpc.SetEtherbase(etherbase)
 		if err != nil {
-			smartpool.Output.Printf("Trying to set etherbase to SmartPool contract address failed: %s\n", err)
-			smartpool.Output.Printf("Please make sure you used Geth option --rpcapi \"db,eth,net,web3,miner\"\n")
+			logger.Warnf("Trying to set etherbase to SmartPool contract address failed: %s\n", err)
+			logger.Warnf("Please make sure you used Geth option --rpcapi \"db,eth,net,web3,miner\"\n")
 			return err
 		}
-		smartpool.Output.Printf("Done.\n")
-		smartpool.Output.Printf("Trying to set extradata to SmartPool extradata convention: %s...\n", extradata)
+		logger.Debugf("Done.\n")
+		logger.Infof("Trying to set extradata to SmartPool extradata convention: %s...\n", extradata)
 		err = nc.rpc.SetExtradata(extradata)
 		if err != nil {
-			smartpool.Output.Printf("Trying to set extra data to SmartPool extradata convention failed: %s\n", err)
-			smartpool.Output.Printf("Please make sure you used Geth option --rpcapi \"db,eth,net,web3,miner\"\n")
+			logger.Warnf("Trying to set extra data to SmartPool extradata convention failed: %s\n", err)
+			logger.Warnf("Please make sure you used Geth option --rpcapi \"db,eth,net,web3,miner\"\n")
 			return err
 		}
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
 	} else if strings.HasPrefix(client, "Parity") {
-		smartpool.Output.Printf("Trying to set etherbase to SmartPool contract address: %s...\n", etherbase.Hex())
+		logger.Infof("Trying to set etherbase to SmartPool contract address: %s...\n", etherbase.Hex())
 		err = nc.rpc.SetEtherbase(etherbase)
 		if err != nil {
-			smartpool.Output.Printf("Trying to set author to SmartPool contract address failed: %s\n", err)
-			smartpool.Output.Printf("Please make sure you used Parity option --jsonrpc-apis \"web3,eth,net,parity,traces,rpc,parity_set\"\n")
+			logger.Warnf("Trying to set author to SmartPool contract address failed: %s\n", err)
+			logger.Warnf("Please make sure you used Parity option --jsonrpc-apis \"web3,eth,net,parity,traces,rpc,parity_set\"\n")
 			return err
 		}
-		smartpool.Output.Printf("Done.\n")
-		smartpool.Output.Printf("Trying to set extradata to SmartPool extradata convention: %s...\n", extradata)
+		logger.Debugf("Done.\n")
+		logger.Infof("Trying to set extradata to SmartPool extradata convention: %s...\n", extradata)
 		err = nc.rpc.SetExtradata(extradata)
 		if err != nil {
-			smartpool.Output.Printf("Trying to set extra data to SmartPool extradata convention failed: %s\n", err)
-			smartpool.Output.Printf("Please make sure you used Parity option --jsonrpc-apis \"web3,eth,net,parity,traces,rpc,parity_set\"\n")
+			logger.Warnf("Trying to set extra data to SmartPool extradata convention failed: %s\n", err)
+			logger.Warnf("Please make sure you used Parity option --jsonrpc-apis \"web3,eth,net,parity,traces,rpc,parity_set\"\n")
 			return err
 		}
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
 	} else {
 		return errors.New(
 			fmt.Sprintf("Unsupported client: %s", client))
@@ -113,8 +266,10 @@ func (nc *NetworkClient) Configure(etherbase common.Address, extradata string) e
 
 func NewNetworkClient(rpc RPCClient, workpool *WorkPool) *NetworkClient {
 	networkClient := &NetworkClient{
-		rpc, workpool, nil, sync.RWMutex{}, time.Tick(50 * time.Millisecond),
+		rpc, workpool, nil, sync.RWMutex{},
+		sync.Mutex{}, []chan *Work{},
+		sync.Mutex{}, "", map[string]*Work{}, nil,
 	}
-	go networkClient.fetchOnTick()
+	go networkClient.fetchWork()
 	return networkClient
 }
@@ -0,0 +1,152 @@
+package ethereum
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testWorkSubscription struct {
+	err chan error
+}
+
+func (s *testWorkSubscription) Err() <-chan error { return s.err }
+func (s *testWorkSubscription) Unsubscribe()      {}
+
+// testSubscribingRPC gives a new work on every call to GetWork and lets the
+// test announce work changes.
+type testSubscribingRPC struct {
+	RPCClient
+	mu      sync.Mutex
+	fetched int
+	heads   chan<- struct{}
+	sub     *testWorkSubscription
+	ready   chan bool
+	polled  chan struct{}
+}
+
+func (r *testSubscribingRPC) GetWork() *Work {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetched++
+	w := newTestWork()
+	w.Hash = fmt.Sprintf("0x%x", r.fetched)
+	select {
+	case r.polled <- struct{}{}:
+	default:
+	}
+	return w
+}
+
+func (r *testSubscribingRPC) Fetched() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fetched
+}
+
+// awaitFetched waits until work is fetched n times. It returns false if it
+// isn't within timeout.
+func (r *testSubscribingRPC) awaitFetched(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for r.Fetched() < n {
+		select {
+		case <-r.polled:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+func (r *testSubscribingRPC) SubscribeWorkChanges(heads, pending chan<- struct{}) (WorkSubscription, error) {
+	r.heads = heads
+	r.sub = &testWorkSubscription{make(chan error, 1)}
+	r.ready <- true
+	return r.sub, nil
+}
+
+func TestNetworkClientFetchesWorkOnAnnouncedHead(t *testing.T) {
+	defer func(poll, subscribedPoll, resubscribe time.Duration) {
+		WORK_POLL_INTERVAL = poll
+		SUBSCRIBED_POLL_INTERVAL = subscribedPoll
+		RESUBSCRIBE_INTERVAL = resubscribe
+	}(WORK_POLL_INTERVAL, SUBSCRIBED_POLL_INTERVAL, RESUBSCRIBE_INTERVAL)
+	WORK_POLL_INTERVAL = time.Millisecond
+	SUBSCRIBED_POLL_INTERVAL = time.Hour
+	RESUBSCRIBE_INTERVAL = time.Hour
+	rpc := &testSubscribingRPC{ready: make(chan bool, 1), polled: make(chan struct{}, 1)}
+	nc := NewNetworkClient(rpc, &WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil})
+	works := nc.SubscribeWork()
+	<-rpc.ready
+	first := nc.GetWork().ID()
+	rpc.heads <- struct{}{}
+	timeout := time.After(time.Second)
+	for next := first; next == first; {
+		select {
+		case w := <-works:
+			next = w.ID()
+		case <-timeout:
+			t.Fatalf("work wasn't fetched on the announced head")
+		}
+	}
+	if rpc.Fetched() != 2 {
+		t.Fatalf("expected work to be fetched only when announced, fetched %d times", rpc.Fetched())
+	}
+	// polling takes over when the subscription fails
+	rpc.sub.err <- errors.New("connection lost")
+	if !rpc.awaitFetched(10, 5*time.Second) {
+		t.Fatalf("expected work to be polled, fetched %d times", rpc.Fetched())
+	}
+}
+
+// testIssuingRPC issues one work.
+type testIssuingRPC struct {
+	RPCClient
+	work *Work
+}
+
+func (r *testIssuingRPC) GetWork() *Work {
+	return r.work
+}
+
+func TestNetworkClientGivesEachTierItsOwnExtraData(t *testing.T) {
+	work := newTestWork()
+	work.ShareDifficulty = big.NewInt(2)
+	work.MinerAddress = simulatedMinerAddr.Hex()
+	work.BlockHeader.Extra = []byte(BuildExtraData(simulatedMinerAddr, work.ShareDifficulty))
+	work.Hash = work.BlockHeader.HashNoNonce().Hex()
+	wp := &WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
+	nc := NewNetworkClient(&testIssuingRPC{work: work}, wp)
+	tierDiff := new(big.Int).Mul(work.ShareDifficulty, big.NewInt(4))
+	tierWork := nc.GetWorkWithDifficulty(tierDiff).(*Work)
+	if tierWork.PoWHash() == work.PoWHash() {
+		t.Fatalf("expected work of the tier to have its own hash")
+	}
+	if nc.GetWorkWithDifficulty(tierDiff) != tierWork {
+		t.Fatalf("expected the same work of the tier until the node's work changes")
+	}
+	extra := string(tierWork.BlockHeader.Extra)
+	if extra != BuildExtraData(simulatedMinerAddr, tierDiff) {
+		t.Fatalf("expected extradata encoding difficulty %s, got %s", tierDiff, extra)
+	}
+	// solutions are taken for the work they were mined on
+	sol := newTestSolution()
+	sol.Hash = tierWork.PoWHash()
+	for nonce := uint64(0); tierWork.AcceptSolution(sol).(*Share).SolutionState == InvalidShare; nonce++ {
+		sol.Nonce = types.EncodeNonce(nonce)
+	}
+	share, err := wp.CheckSolution(sol)
+	if err != nil {
+		t.Fatalf("expected solution of the tier to be accepted: %s", err)
+	}
+	if share.ShareDifficulty().Cmp(tierDiff) != 0 {
+		t.Fatalf("expected share of difficulty %s, got %s", tierDiff, share.ShareDifficulty())
+	}
+	if string(share.(*Share).BlockHeader().Extra) != extra {
+		t.Fatalf("expected share of the tier's header")
+	}
+}
@@ -0,0 +1,295 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProofProvider provides DAG elements at indices of an epoch's DAG with
+// their merkle proofs, which shares need to be verified by the contract.
+type ProofProvider interface {
+	DagProof(epoch uint64, indices []uint32) (*DagProof, error)
+	// EpochData returns the data of epoch that is set on the Ethash
+	// contract.
+	EpochData(epoch uint64) (*EpochData, error)
+}
+
+// MAX_OPEN_EPOCHS is the number of epochs whose DAG merkle trees
+// LocalProofProvider keeps open.
+var MAX_OPEN_EPOCHS int = 2
+
+// LocalProofProvider proves DAG elements with DAG files and their merkle
+// caches on this machine, generating them when needed.
+type LocalProofProvider struct {
+	mu     sync.Mutex
+	epochs map[uint64]*DagMerkle
+}
+
+// DagMerkle returns the merkle tree of epoch's DAG. Trees of the oldest
+// epochs are closed when more than MAX_OPEN_EPOCHS are open.
+func (p *LocalProofProvider) DagMerkle(epoch uint64) *DagMerkle {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if dm, ok := p.epochs[epoch]; ok {
+		return dm
+	}
+	dm := OpenDagMerkle(epoch)
+	p.epochs[epoch] = dm
+	for len(p.epochs) > MAX_OPEN_EPOCHS {
+		oldest := epoch
+		for e := range p.epochs {
+			if e < oldest {
+				oldest = e
+			}
+		}
+		if oldest == epoch {
+			break
+		}
+		p.epochs[oldest].Close()
+		delete(p.epochs, oldest)
+	}
+	return dm
+}
+
+// HasEpoch returns true if the merkle tree of epoch's DAG is open or cached
+// so proving its elements doesn't generate the DAG or the cache.
+func (p *LocalProofProvider) HasEpoch(epoch uint64) bool {
+	p.mu.Lock()
+	_, ok := p.epochs[epoch]
+	p.mu.Unlock()
+	if ok {
+		return true
+	}
+	_, err := os.Stat(dagMerkleCachePath(epoch))
+	return err == nil
+}
+
+func (p *LocalProofProvider) DagProof(epoch uint64, indices []uint32) (*DagProof, error) {
+	proof, err := p.DagMerkle(epoch).Proof(indices)
+	if err == errDagMerkleClosed {
+		// the tree was closed for a newer epoch in the meantime
+		return p.DagMerkle(epoch).Proof(indices)
+	}
+	return proof, err
+}
+
+func (p *LocalProofProvider) EpochData(epoch uint64) (*EpochData, error) {
+	dm := p.DagMerkle(epoch)
+	nodes, err := dm.MerkleNodes()
+	if err == errDagMerkleClosed {
+		dm = p.DagMerkle(epoch)
+		nodes, err = dm.MerkleNodes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &EpochData{
+		uint64(dm.NumElements()),
+		uint64(dm.BranchDepth() - DAG_STORED_LEVEL),
+		nodes,
+	}, nil
+}
+
+func NewLocalProofProvider() *LocalProofProvider {
+	return &LocalProofProvider{sync.Mutex{}, map[uint64]*DagMerkle{}}
+}
+
+// ContractEpochs is a ProofProvider giving the epoch data that is set on
+// the Ethash contract so the verification of a share of an epoch that is
+// not set, or set with other nodes, fails the local checks like it fails
+// the contract's. DAG proofs and the size of epochs come from proofs.
+// Epochs are cached once all of their nodes are set.
+type ContractEpochs struct {
+	proofs ProofProvider
+	client EthashContractClient
+	mu     sync.Mutex
+	epochs map[uint64]*EpochData
+}
+
+func (p *ContractEpochs) DagProof(epoch uint64, indices []uint32) (*DagProof, error) {
+	return p.proofs.DagProof(epoch, indices)
+}
+
+// EpochData returns the merkle nodes of epoch on the contract. It returns
+// ContractError 0x8400000a if none of them is set.
+func (p *ContractEpochs) EpochData(epoch uint64) (*EpochData, error) {
+	p.mu.Lock()
+	data, ok := p.epochs[epoch]
+	p.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+	local, err := p.proofs.EpochData(epoch)
+	if err != nil {
+		return nil, err
+	}
+	data = &EpochData{local.FullSizeIn128Resolution, local.BranchDepth, []*big.Int{}}
+	set := 0
+	for i := range local.MerkleNodes {
+		node, err := p.client.EpochNode(
+			new(big.Int).SetUint64(epoch), big.NewInt(int64(i)))
+		if err != nil {
+			return nil, err
+		}
+		if node.Sign() != 0 {
+			set++
+		}
+		data.MerkleNodes = append(data.MerkleNodes, node)
+	}
+	if set == 0 {
+		return nil, NewContractError(0x8400000a, new(big.Int).SetUint64(epoch))
+	}
+	if set < len(local.MerkleNodes) {
+		logger.Warnf("Only %d of %d merkle nodes of epoch %d are set on the Ethash contract.\n",
+			set, len(local.MerkleNodes), epoch)
+		return data, nil
+	}
+	p.mu.Lock()
+	p.epochs[epoch] = data
+	p.mu.Unlock()
+	return data, nil
+}
+
+func NewContractEpochs(proofs ProofProvider, client EthashContractClient) *ContractEpochs {
+	return &ContractEpochs{proofs, client, sync.Mutex{}, map[uint64]*EpochData{}}
+}
+
+// DagProofData is the JSON form of DagProof a proof server responds with.
+type DagProofData struct {
+	Elements []hexutil.Bytes `json:"elements"`
+	Branches []hexutil.Bytes `json:"branches"`
+}
+
+func NewDagProofData(proof *DagProof) *DagProofData {
+	data := &DagProofData{[]hexutil.Bytes{}, []hexutil.Bytes{}}
+	for i := range proof.Elements {
+		data.Elements = append(data.Elements, hexutil.Bytes(proof.Elements[i][:]))
+	}
+	for i := range proof.Branches {
+		data.Branches = append(data.Branches, hexutil.Bytes(proof.Branches[i][:]))
+	}
+	return data
+}
+
+func (data *DagProofData) DagProof() (*DagProof, error) {
+	proof := &DagProof{[]smartpool.Word{}, []smartpool.BranchElement{}}
+	for _, e := range data.Elements {
+		if len(e) != smartpool.WordLength {
+			return nil, fmt.Errorf("DAG element has %d bytes, expected %d", len(e), smartpool.WordLength)
+		}
+		w := smartpool.Word{}
+		copy(w[:], e)
+		proof.Elements = append(proof.Elements, w)
+	}
+	for _, b := range data.Branches {
+		if len(b) != smartpool.BranchElementLength {
+			return nil, fmt.Errorf("branch element has %d bytes, expected %d", len(b), smartpool.BranchElementLength)
+		}
+		be := smartpool.BranchElement{}
+		copy(be[:], b)
+		proof.Branches = append(proof.Branches, be)
+	}
+	return proof, nil
+}
+
+// EpochDataJSON is the JSON form of EpochData a proof server responds with.
+type EpochDataJSON struct {
+	FullSizeIn128Resolution hexutil.Uint64 `json:"full_size_in_128_resolution"`
+	BranchDepth             hexutil.Uint64 `json:"branch_depth"`
+	MerkleNodes             []*hexutil.Big `json:"merkle_nodes"`
+}
+
+func NewEpochDataJSON(data *EpochData) *EpochDataJSON {
+	result := &EpochDataJSON{
+		hexutil.Uint64(data.FullSizeIn128Resolution),
+		hexutil.Uint64(data.BranchDepth),
+		[]*hexutil.Big{},
+	}
+	for _, node := range data.MerkleNodes {
+		result.MerkleNodes = append(result.MerkleNodes, (*hexutil.Big)(node))
+	}
+	return result
+}
+
+func (data *EpochDataJSON) EpochData() *EpochData {
+	result := &EpochData{
+		uint64(data.FullSizeIn128Resolution),
+		uint64(data.BranchDepth),
+		[]*big.Int{},
+	}
+	for _, node := range data.MerkleNodes {
+		result.MerkleNodes = append(result.MerkleNodes, (*big.Int)(node))
+	}
+	return result
+}
+
+// RemoteProofProvider fetches proofs from a SmartPool proof server so this
+// machine doesn't need DAG files or their merkle caches.
+type RemoteProofProvider struct {
+	url    string
+	client *http.Client
+}
+
+func (p *RemoteProofProvider) get(path string, result interface{}) error {
+	resp, err := p.client.Get(p.url + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proof server responded %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (p *RemoteProofProvider) EpochData(epoch uint64) (*EpochData, error) {
+	data := &EpochDataJSON{}
+	if err := p.get(fmt.Sprintf("/proof/%d/nodes", epoch), data); err != nil {
+		return nil, err
+	}
+	return data.EpochData(), nil
+}
+
+func (p *RemoteProofProvider) DagProof(epoch uint64, indices []uint32) (*DagProof, error) {
+	strIndices := []string{}
+	for _, index := range indices {
+		strIndices = append(strIndices, fmt.Sprintf("%d", index))
+	}
+	data := &DagProofData{}
+	err := p.get(fmt.Sprintf(
+		"/proof/%d?indices=%s", epoch, strings.Join(strIndices, ",")), data)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := data.DagProof()
+	if err != nil {
+		return nil, err
+	}
+	if len(proof.Elements) != len(indices) {
+		return nil, fmt.Errorf("proof server returned %d elements for %d indices", len(proof.Elements), len(indices))
+	}
+	return proof, nil
+}
+
+func NewRemoteProofProvider(url string) *RemoteProofProvider {
+	return &RemoteProofProvider{
+		strings.TrimRight(url, "/"),
+		&http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// LocalDagProofs proves elements of DAGs on this machine. It is used to set
+// epoch data and to serve proofs.
+var LocalDagProofs = NewLocalProofProvider()
+
+// DagProofs provides proofs of shares' DAG elements. It is LocalDagProofs
+// unless SmartPool is configured to fetch proofs from a proof server.
+var DagProofs ProofProvider = LocalDagProofs
@@ -0,0 +1,129 @@
+package ethereum
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/protocol"
+	"github.com/SmartPool/smartpool-client/storage"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var ARCHIVE_INDEX_FILE string = "archives"
+
+// RestoreConflictPolicy decides what happens to shares from last session
+// that were mined for a different miner address or share difficulty than
+// the current session's.
+type RestoreConflictPolicy int
+
+const (
+	// RestoreDiscard drops the shares and claims from last session.
+	RestoreDiscard RestoreConflictPolicy = iota
+	// RestoreAbort stops SmartPool so it can be rerun with the old settings.
+	RestoreAbort
+	// RestoreArchive moves the shares, claims and counter from last session
+	// into an archive namespace of the storage so they can be submitted
+	// later under their original miner and difficulty.
+	RestoreArchive
+)
+
+var restoreConflictPolicyNames = []string{"discard", "abort", "archive"}
+
+func (p RestoreConflictPolicy) String() string {
+	return restoreConflictPolicyNames[p]
+}
+
+func ParseRestoreConflictPolicy(name string) (RestoreConflictPolicy, error) {
+	for i, n := range restoreConflictPolicyNames {
+		if n == name {
+			return RestoreConflictPolicy(i), nil
+		}
+	}
+	return RestoreAbort, fmt.Errorf(
+		"unknown restore conflict policy %s (expected %s)",
+		name, strings.Join(restoreConflictPolicyNames, ", "))
+}
+
+// Archive is a session's shares, claims and counter that were set aside
+// because a later session ran with a different miner or difficulty.
+type Archive struct {
+	Namespace  string
+	Miner      string
+	Difficulty *big.Int
+	NumShares  int
+	Created    int64
+}
+
+// Storage returns the storage holding the archived session. A claim repo
+// created on it with the archive's miner and difficulty restores the
+// archived shares and claims.
+func (a *Archive) Storage(ps smartpool.PersistentStorage) smartpool.PersistentStorage {
+	return storage.NewNamespaceStorage(ps, a.Namespace)
+}
+
+// LoadArchives returns sessions archived in ps, oldest first.
+func LoadArchives(ps smartpool.PersistentStorage) ([]*Archive, error) {
+	archives := []*Archive{}
+	loaded, err := ps.Load(&archives, ARCHIVE_INDEX_FILE)
+	if err != nil {
+		return []*Archive{}, err
+	}
+	if loadedArchives, ok := loaded.(*[]*Archive); ok {
+		archives = *loadedArchives
+	}
+	return archives, nil
+}
+
+// archiveSession moves shares and claims of last session into a new archive
+// namespace of ps together with the latest counter. The session's data in
+// ps is emptied in the same batch so it isn't archived twice.
+func archiveSession(
+	ps smartpool.PersistentStorage, miner string, diff *big.Int,
+	shares map[string]*Share, activeClaims, openClaims []smartpool.Claim) (*Archive, error) {
+	archives, err := LoadArchives(ps)
+	if err != nil {
+		logger.Warnf("Couldn't load archive index (%s). Creating a new one.\n", err)
+	}
+	// sessions can be archived within the same second so the namespace
+	// has nanoseconds and the archive's sequence number
+	created := time.Now()
+	archive := &Archive{
+		fmt.Sprintf(
+			"archive-%s-%d",
+			created.Format("20060102-150405.000000000"), len(archives)+1),
+		miner, diff, len(shares), created.Unix(),
+	}
+	batch := storage.NewBatch(ps)
+	old := &TimestampClaimRepo{
+		activeShares: shares,
+		activeClaims: activeClaims,
+		openClaims:   openClaims,
+	}
+	if err = old.Persist(archive.Storage(batch)); err != nil {
+		return nil, err
+	}
+	counter := big.NewInt(0)
+	loaded, err := ps.Load(counter, protocol.COUNTER_FILE)
+	if loadedCounter, ok := loaded.(*big.Int); err == nil && ok {
+		if err = archive.Storage(batch).Persist(loadedCounter, protocol.COUNTER_FILE); err != nil {
+			return nil, err
+		}
+	}
+	empty := &TimestampClaimRepo{
+		activeShares: map[string]*Share{},
+		activeClaims: []smartpool.Claim{},
+		openClaims:   []smartpool.Claim{},
+	}
+	if err = empty.Persist(batch); err != nil {
+		return nil, err
+	}
+	archives = append(archives, archive)
+	if err = batch.Persist(&archives, ARCHIVE_INDEX_FILE); err != nil {
+		return nil, err
+	}
+	if err = batch.Commit(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
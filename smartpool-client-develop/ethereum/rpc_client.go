@@ -15,8 +15,56 @@ type RPCClient interface {
 	IsVerified(h common.Hash) bool
 	Syncing() bool
 	BlockNumber() (*big.Int, error)
-	GetLog(txs []*types.Transaction, from *big.Int, event *big.Int, sender *big.Int) (*big.Int, *big.Int)
+	GetLog(txs []common.Hash, from *big.Int, event *big.Int, sender *big.Int) (*big.Int, *big.Int)
 	SetEtherbase(etherbase common.Address) error
 	SetExtradata(extradata string) error
 	Broadcast(raw []byte) (common.Hash, error)
+	ChainID() (*big.Int, error)
+	GasPrice() (*big.Int, error)
+	// FeeHistory returns base fee of the next block and average priority
+	// fee paid at percentile in the last blocks.
+	FeeHistory(blocks int, percentile float64) (*big.Int, *big.Int, error)
+	// TxFee returns gas used by a mined tx and the price it paid per gas.
+	TxFee(h common.Hash) (*big.Int, *big.Int, error)
+	// TxLog returns true once tx h is mined, with error code and error info
+	// of the log it emitted with event. They are nil if it emitted none.
+	TxLog(h common.Hash, event *big.Int) (bool, *big.Int, *big.Int, error)
+}
+
+// WorkSubscription announces work changes of nodes until it fails or is
+// unsubscribed.
+type WorkSubscription interface {
+	// Err receives an error when the subscription fails. It is closed on
+	// Unsubscribe.
+	Err() <-chan error
+	Unsubscribe()
+}
+
+// WorkChangeSubscriber is an RPCClient whose nodes can announce when their
+// work may have changed so work is fetched only then.
+type WorkChangeSubscriber interface {
+	// SubscribeWorkChanges signals heads when a node announces a new head
+	// and pending when its pending block changes. Signals are dropped while
+	// earlier ones are not received yet. It fails if no node supports
+	// subscriptions.
+	SubscribeWorkChanges(heads, pending chan<- struct{}) (WorkSubscription, error)
+}
+
+// ChainBlock is a block or an uncle as a node reports it.
+type ChainBlock struct {
+	Hash      common.Hash
+	Number    *big.Int
+	Nonce     types.BlockNonce
+	MixDigest common.Hash
+	Uncles    []common.Hash
+}
+
+// BlockReader reads blocks of the node's canonical chain.
+type BlockReader interface {
+	BlockNumber() (*big.Int, error)
+	// BlockByNumber returns the canonical block at number.
+	BlockByNumber(number *big.Int) (*ChainBlock, error)
+	// UncleByBlockNumber returns the uncle at index of the canonical block
+	// at number.
+	UncleByBlockNumber(number *big.Int, index int) (*ChainBlock, error)
 }
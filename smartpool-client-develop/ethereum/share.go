@@ -1,20 +1,15 @@
 package ethereum
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
 	"fmt"
 	"github.com/SmartPool/smartpool-client"
 	"github.com/SmartPool/smartpool-client/ethereum/ethash"
-	"github.com/SmartPool/smartpool-client/mtree"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
-	"io"
-	"log"
 	"math/big"
-	"os"
 	"time"
 )
 
@@ -25,7 +20,8 @@ type Share struct {
 	shareDifficulty *big.Int
 	minerAddress    string
 	SolutionState   int
-	dt              *mtree.DagTree
+	proof           *DagProof
+	workStatus      string
 }
 
 func (s *Share) Difficulty() *big.Int      { return s.blockHeader.Difficulty }
@@ -45,6 +41,16 @@ func (s *Share) FullSolution() bool {
 	return s.SolutionState == 2
 }
 
+// WorkStatus returns the status of the share's work on the canonical chain
+// when the share was accepted. Shares restored from storage are considered
+// current.
+func (s *Share) WorkStatus() string {
+	if s.workStatus == "" {
+		return smartpool.CurrentWork
+	}
+	return s.workStatus
+}
+
 func (s *Share) BlockHeader() *types.Header {
 	return s.blockHeader
 }
@@ -91,86 +97,41 @@ func (s *Share) Hash() (result smartpool.SPHash) {
 	return
 }
 
-func processDuringRead(
-	datasetPath string, mt *mtree.DagTree) {
-	var f *os.File
-	var err error
-	for {
-		f, err = os.Open(datasetPath)
-		if err == nil {
-			break
-		} else {
-			smartpool.Output.Printf("Reading DAG file %s failed with %s. Retry in 10s...\n", datasetPath, err.Error())
-			time.Sleep(10 * time.Second)
-		}
-	}
-	r := bufio.NewReader(f)
-	buf := [128]byte{}
-	// ignore first 8 bytes magic number at the beginning
-	// of dataset. See more at https://gopkg.in/ethereum/wiki/wiki/Ethash-DAG-Disk-Storage-Format
-	_, err = io.ReadFull(r, buf[:8])
-	if err != nil {
-		log.Fatal(err)
-	}
-	var i uint32 = 0
-	for {
-		n, err := io.ReadFull(r, buf[:128])
-		if n == 0 {
-			if err == nil {
-				continue
-			}
-			if err == io.EOF {
-				break
-			}
-			log.Fatal(err)
-		}
-		if n != 128 {
-			log.Fatal("Malformed dataset")
-		}
-		mt.Insert(smartpool.Word(buf), i)
-		if err != nil && err != io.EOF {
-			log.Fatal(err)
-		}
-		i++
-	}
-}
-
-func (s *Share) buildDagTree() {
+func (s *Share) buildDagProof() {
 	indices := ethash.Instance.GetVerificationIndices(
 		s.NumberU64(),
 		s.BlockHeader().HashNoNonce(),
 		s.Nonce(),
 	)
-	fmt.Printf("indices: %v\n", indices)
-	s.dt = mtree.NewDagTree()
-	s.dt.RegisterIndex(indices...)
-	ethash.MakeDAG(s.NumberU64(), ethash.DefaultDir)
-	fullSize := ethash.DAGSize(s.NumberU64())
-	fullSizeIn128Resolution := fullSize / 128
-	branchDepth := len(fmt.Sprintf("%b", fullSizeIn128Resolution-1))
-	s.dt.RegisterStoredLevel(uint32(branchDepth), uint32(10))
-	path := ethash.PathToDAG(uint64(s.NumberU64()/30000), ethash.DefaultDir)
-	processDuringRead(path, s.dt)
-	s.dt.Finalize()
+	logger.Debugf("indices: %v\n", indices)
+	for {
+		proof, err := DagProofs.DagProof(s.NumberU64()/30000, indices)
+		if err == nil {
+			s.proof = proof
+			return
+		}
+		logger.Warnf("Couldn't get DAG proof of share (%s). Retry in 10s...\n", err)
+		time.Sleep(10 * time.Second)
+	}
 }
 
 func (s *Share) DAGElementArray() []*big.Int {
-	if s.dt == nil {
-		s.buildDagTree()
+	if s.proof == nil {
+		s.buildDagProof()
 	}
 	result := []*big.Int{}
-	for _, w := range s.dt.AllDAGElements() {
+	for _, w := range s.proof.Elements {
 		result = append(result, w.ToUint256Array()...)
 	}
 	return result
 }
 
 func (s *Share) DAGProofArray() []*big.Int {
-	if s.dt == nil {
-		s.buildDagTree()
+	if s.proof == nil {
+		s.buildDagProof()
 	}
 	result := []*big.Int{}
-	for _, be := range s.dt.AllBranchesArray() {
+	for _, be := range s.proof.Branches {
 		result = append(result, be.Big())
 	}
 	return result
@@ -185,5 +146,6 @@ func NewShare(h *types.Header, dif *big.Int, miner string) *Share {
 		miner,
 		0,
 		nil,
+		"",
 	}
 }
@@ -0,0 +1,301 @@
+package ethereum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/storage"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	SHARE_JOURNAL_FILE    string = "share_journal"
+	SHARE_CHECKPOINT_FILE string = "share_checkpoint"
+	// MAX_JOURNAL_RECORD is the largest record a journal reads. A bigger
+	// length can only come from a corrupted record.
+	MAX_JOURNAL_RECORD uint32 = 1 << 20
+)
+
+// journalRecord is a share accepted after the share snapshot was taken.
+// Seq orders the records and tells them apart from the ones already in the
+// snapshot.
+type journalRecord struct {
+	Seq   uint64
+	Share gobShare
+}
+
+func (r *journalRecord) share() *Share {
+	return &Share{
+		r.Share.BlockHeader,
+		r.Share.Nonce,
+		r.Share.MixDigest,
+		r.Share.ShareDifficulty,
+		r.Share.MinerAddress,
+		r.Share.SolutionState,
+		nil,
+		"",
+	}
+}
+
+// journalCheckpoint is persisted together with the share snapshot. Seq is
+// the last journal record in the snapshot and RecentTimestamp is the claim
+// repo's most recent timestamp at the time.
+type journalCheckpoint struct {
+	Seq             uint64
+	RecentTimestamp *big.Int
+}
+
+// ShareJournal is an append-only log of shares accepted since the last
+// share snapshot so they survive a crash. Each record is its length and
+// crc32 checksum in 4 bytes each followed by the gob encoded record. On
+// open, a record torn by a crash or failing its checksum is dropped
+// together with everything after it.
+type ShareJournal struct {
+	path string
+	file *os.File
+	seq  uint64
+	mu   sync.Mutex
+}
+
+func encodeJournalRecord(record *journalRecord) ([]byte, error) {
+	payload := bytes.NewBuffer([]byte{})
+	if err := gob.NewEncoder(payload).Encode(record); err != nil {
+		return nil, err
+	}
+	result := make([]byte, 8, 8+payload.Len())
+	binary.BigEndian.PutUint32(result[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(result[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(result, payload.Bytes()...), nil
+}
+
+// readJournalRecords returns the valid records of the journal at path and
+// the length of the journal they take. The rest of the journal is corrupted.
+func readJournalRecords(path string) ([]*journalRecord, int64, error) {
+	records := []*journalRecord{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return records, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	var valid int64
+	head := make([]byte, 8)
+	for {
+		if _, err = io.ReadFull(reader, head); err != nil {
+			break
+		}
+		length := binary.BigEndian.Uint32(head[0:4])
+		if length > MAX_JOURNAL_RECORD {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(head[4:8]) {
+			break
+		}
+		record := &journalRecord{}
+		if err = gob.NewDecoder(bytes.NewReader(payload)).Decode(record); err != nil {
+			break
+		}
+		records = append(records, record)
+		valid += int64(8 + length)
+	}
+	return records, valid, nil
+}
+
+// OpenShareJournal opens the journal at path and returns the records in
+// it. A corrupted tail is cut off so later records are appended right after
+// the last valid one.
+func OpenShareJournal(path string) (*ShareJournal, []*journalRecord, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0766); err != nil {
+		return nil, nil, err
+	}
+	records, valid, err := readJournalRecords(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info.Size() > valid {
+		logger.Warnf("Share journal %s has %d corrupted bytes at the end. Dropping them.\n", path, info.Size()-valid)
+		if err = file.Truncate(valid); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	journal := &ShareJournal{path: path, file: file}
+	if len(records) > 0 {
+		journal.seq = records[len(records)-1].Seq
+	}
+	return journal, records, nil
+}
+
+// Seq returns the sequence number of the last appended record.
+func (j *ShareJournal) Seq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// advance makes sure later records are numbered after seq.
+func (j *ShareJournal) advance(seq uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.seq < seq {
+		j.seq = seq
+	}
+}
+
+// Append writes the share to the journal and syncs it to disk.
+func (j *ShareJournal) Append(s *Share) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	raw, err := encodeJournalRecord(&journalRecord{j.seq + 1, gobShare{
+		s.BlockHeader(),
+		s.nonce,
+		s.mixDigest,
+		s.shareDifficulty,
+		s.minerAddress,
+		s.SolutionState,
+	}})
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(raw); err != nil {
+		return err
+	}
+	if err = j.file.Sync(); err != nil {
+		return err
+	}
+	j.seq++
+	return nil
+}
+
+// Compact drops records up to seq because a committed snapshot has them.
+// The journal is rewritten to a temp file which then replaces it so a
+// crash while compacting leaves either the old or the new journal.
+func (j *ShareJournal) Compact(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	records, _, err := readJournalRecords(j.path)
+	if err != nil {
+		return err
+	}
+	kept := []byte{}
+	dropped := 0
+	for _, record := range records {
+		if record.Seq <= seq {
+			dropped++
+			continue
+		}
+		raw, err := encodeJournalRecord(record)
+		if err != nil {
+			return err
+		}
+		kept = append(kept, raw...)
+	}
+	if dropped == 0 {
+		return nil
+	}
+	temp := j.path + ".temp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(kept); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(temp, j.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	logger.Debugf("Compacted %d shares out of share journal %s.\n", dropped, j.path)
+	return nil
+}
+
+func (j *ShareJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+func loadJournalCheckpoint(ps smartpool.PersistentStorage) (*journalCheckpoint, error) {
+	checkpoint := &journalCheckpoint{}
+	loaded, err := ps.Load(checkpoint, SHARE_CHECKPOINT_FILE)
+	if err != nil {
+		return &journalCheckpoint{}, err
+	}
+	if loadedCheckpoint, ok := loaded.(*journalCheckpoint); ok && loadedCheckpoint != nil {
+		checkpoint = loadedCheckpoint
+	}
+	return checkpoint, nil
+}
+
+// openShareJournal opens the share journal kept next to ps and returns the
+// records that are not in the share snapshot of ps yet. The journal is nil
+// if ps doesn't keep its data on the local file system.
+func openShareJournal(ps smartpool.PersistentStorage) (*ShareJournal, *journalCheckpoint, []*journalRecord) {
+	path, ok := storage.FilePath(ps, SHARE_JOURNAL_FILE)
+	if !ok {
+		return nil, &journalCheckpoint{}, []*journalRecord{}
+	}
+	checkpoint, err := loadJournalCheckpoint(ps)
+	if err != nil {
+		logger.Debugf("No share checkpoint from last session (%s). Replaying the whole share journal.\n", err)
+	}
+	journal, records, err := OpenShareJournal(path)
+	if err != nil {
+		logger.Warnf("Couldn't open share journal %s (%s). Shares are only persisted every minute.\n", path, err)
+		return nil, checkpoint, []*journalRecord{}
+	}
+	journal.advance(checkpoint.Seq)
+	result := []*journalRecord{}
+	for _, record := range records {
+		if record.Seq > checkpoint.Seq {
+			result = append(result, record)
+		}
+	}
+	return journal, checkpoint, result
+}
+
+// persistCheckpoint compacts records of the last committed snapshot out of
+// the journal and persists a checkpoint for the snapshot being persisted to
+// ps. Records of the new snapshot are only compacted next time because ps
+// may be a batch that is not committed yet.
+func (j *ShareJournal) persistCheckpoint(ps smartpool.PersistentStorage, recentTimestamp *big.Int) error {
+	if committed, err := loadJournalCheckpoint(ps); err == nil {
+		if err = j.Compact(committed.Seq); err != nil {
+			logger.Warnf("Couldn't compact share journal (%s).\n", err)
+		}
+	}
+	return ps.Persist(&journalCheckpoint{
+		j.Seq(), new(big.Int).Set(recentTimestamp),
+	}, SHARE_CHECKPOINT_FILE)
+}
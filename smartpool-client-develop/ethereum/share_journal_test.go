@@ -0,0 +1,119 @@
+package ethereum
+
+import (
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newJournalTestShare(nonce uint64, time int64) *Share {
+	s := newTestShare()
+	s.nonce = types.EncodeNonce(nonce)
+	s.blockHeader.Time.Add(s.blockHeader.Time, big.NewInt(time))
+	return s
+}
+
+func TestShareJournalDropsTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, SHARE_JOURNAL_FILE)
+	journal, _, err := OpenShareJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Append(newJournalTestShare(1, 1))
+	journal.Append(newJournalTestShare(2, 2))
+	journal.Close()
+	info, _ := os.Stat(path)
+	// a crash in the middle of writing the second record
+	os.Truncate(path, info.Size()-5)
+	journal, records, err := OpenShareJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].share().Nonce() != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	journal.Append(newJournalTestShare(3, 3))
+	journal.Close()
+	_, records, _ = OpenShareJournal(path)
+	if len(records) != 2 || records[1].Seq != 2 || records[1].share().Nonce() != 3 {
+		t.Fatalf("expected the share to be appended after the last valid record")
+	}
+}
+
+func TestTimestampClaimRepoReplaysShareJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the journal is kept next to the database so the test doesn't touch
+	// storage.SmartPoolDir
+	ps, err := storage.NewBoltStorage(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	restore := func() *TimestampClaimRepo {
+		return NewTimestampClaimRepo(
+			big.NewInt(100000),
+			// miner as the test shares have it, coinbase as the
+			// share's header does
+			"0xe034afdcc2ba0441ff215ee9ba0da3e86450108d",
+			common.HexToAddress("0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96").Hex(),
+			ps, RestoreAbort,
+		)
+	}
+	check := func(repo *TimestampClaimRepo) {
+		restored := restore()
+		if restored.noShares != repo.noShares ||
+			restored.noRecentShares != repo.noRecentShares ||
+			restored.recentTimestamp.Cmp(repo.recentTimestamp) != 0 ||
+			len(restored.activeShares) != len(repo.activeShares) {
+			t.Fatalf(
+				"restored %d/%d shares at 0x%s, expected %d/%d shares at 0x%s",
+				restored.noShares, restored.noRecentShares, restored.recentTimestamp.Text(16),
+				repo.noShares, repo.noRecentShares, repo.recentTimestamp.Text(16))
+		}
+		restored.journal.Close()
+	}
+	repo := restore()
+	var nonce uint64
+	add := func(times ...int64) {
+		for _, time := range times {
+			nonce++
+			if err := repo.AddShare(newJournalTestShare(nonce, time)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add(1, 2, 2)
+	check(repo)
+	repo.Persist(ps)
+	add(3, 1, 3)
+	check(repo)
+	// shares of a claim are gone from the snapshot and the journal
+	if repo.GetCurrentClaim(1) == nil {
+		t.Fatalf("expected a claim")
+	}
+	repo.Persist(ps)
+	check(repo)
+	add(2, 4, 4)
+	repo.Persist(ps)
+	add(1, 5)
+	check(repo)
+	// a crash while writing a share loses only that share
+	f, _ := os.OpenFile(filepath.Join(dir, SHARE_JOURNAL_FILE), os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+	check(repo)
+}
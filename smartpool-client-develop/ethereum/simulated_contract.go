@@ -0,0 +1,605 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/ethereum/ethash"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+	"sync"
+)
+
+type simulatedClaim struct {
+	numShares  *big.Int
+	difficulty *big.Int
+	min        *big.Int
+	max        *big.Int
+	augMerkle  *big.Int
+}
+
+type simulatedMiner struct {
+	id             *big.Int
+	paymentAddress common.Address
+	lastCounter    *big.Int
+	claims         []*simulatedClaim
+	// block number of the tx submitting the last claim of the batch. 0 if
+	// the batch is not closed yet.
+	lastSubmissionBlockNo uint64
+	verifiedClaims        uint64
+}
+
+type simulatedEpoch struct {
+	fullSizeIn128Resolution uint64
+	branchDepth             uint64
+	merkleNodes             []*big.Int
+}
+
+// SimulatedContract is an in-process SmartPool contract. It enforces the
+// rules the contract checks with the error codes in ErrorMap so claims and
+// their proofs can be tested end to end without a chain. The checks are
+// implemented apart from ClaimVerifier's so tests catch mistakes in either. Every transaction
+// is mined in its own block. Payments are not simulated.
+type SimulatedContract struct {
+	mu       sync.Mutex
+	address  common.Address
+	owner    common.Address
+	blockNo  uint64
+	miners   map[common.Address]*simulatedMiner
+	minerIDs map[string]common.Address
+	epochs   map[uint64]*simulatedEpoch
+	// verifications are results of mined verifyClaim txs by tx hash
+	verifications map[common.Hash]error
+}
+
+func (sc *SimulatedContract) Address() common.Address {
+	return sc.address
+}
+
+// BlockNumber returns the number of the latest simulated block.
+func (sc *SimulatedContract) BlockNumber() uint64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.blockNo
+}
+
+// VerifiedClaims returns number of claims of sender that were verified.
+func (sc *SimulatedContract) VerifiedClaims(sender common.Address) uint64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if miner := sc.miners[sender]; miner != nil {
+		return miner.verifiedClaims
+	}
+	return 0
+}
+
+// mine mines a new block and returns its number.
+func (sc *SimulatedContract) mine() uint64 {
+	sc.blockNo++
+	return sc.blockNo
+}
+
+func (sc *SimulatedContract) blockHash(blockNo uint64) common.Hash {
+	return crypto.Keccak256Hash(
+		sc.address.Bytes(), new(big.Int).SetUint64(blockNo).Bytes())
+}
+
+// claimSeed is the hash of the block after the one including the last
+// claim. It is 0 until that block is mined.
+func (sc *SimulatedContract) claimSeed(miner *simulatedMiner) *big.Int {
+	if miner.lastSubmissionBlockNo == 0 || sc.blockNo <= miner.lastSubmissionBlockNo+1 {
+		return big.NewInt(0)
+	}
+	return sc.blockHash(miner.lastSubmissionBlockNo + 1).Big()
+}
+
+// submissionIndex picks a share over all open claims of the miner with
+// probability proportional to number of shares of the claims.
+func (sc *SimulatedContract) submissionIndex(sender common.Address, seed *big.Int) ([2]*big.Int, *ContractError) {
+	result := [2]*big.Int{big.NewInt(0), big.NewInt(0)}
+	miner := sc.miners[sender]
+	if miner == nil || len(miner.claims) == 0 {
+		return result, NewContractError(0x84000003, big.NewInt(0))
+	}
+	if seed.Cmp(common.Big0) == 0 {
+		return result, NewContractError(0x84000001, big.NewInt(0))
+	}
+	total := big.NewInt(0)
+	for _, claim := range miner.claims {
+		total.Add(total, claim.numShares)
+	}
+	if total.Cmp(common.Big0) == 0 {
+		return result, NewContractError(0x84000003, big.NewInt(0))
+	}
+	selected := crypto.Keccak256Hash(
+		common.BigToHash(seed).Bytes(), sender.Bytes()).Big()
+	selected.Mod(selected, total)
+	for i, claim := range miner.claims {
+		if selected.Cmp(claim.numShares) < 0 {
+			result[0] = big.NewInt(int64(i))
+			result[1] = selected
+			break
+		}
+		selected.Sub(selected, claim.numShares)
+	}
+	return result, nil
+}
+
+func (sc *SimulatedContract) register(sender, paymentAddress common.Address) *ContractError {
+	sc.mine()
+	id := minerID(sender)
+	if owner, used := sc.minerIDs[id.String()]; used && owner != sender {
+		return NewContractError(0x80000000, id)
+	}
+	if paymentAddress.Big().Cmp(common.Big0) == 0 {
+		return NewContractError(0x80000001, big.NewInt(0))
+	}
+	if miner := sc.miners[sender]; miner != nil {
+		miner.paymentAddress = paymentAddress
+		return nil
+	}
+	sc.miners[sender] = &simulatedMiner{
+		id, paymentAddress, big.NewInt(0), []*simulatedClaim{}, 0, 0,
+	}
+	sc.minerIDs[id.String()] = sender
+	return nil
+}
+
+func (sc *SimulatedContract) submitClaim(sender common.Address, claim *simulatedClaim, lastClaim bool) *ContractError {
+	blockNo := sc.mine()
+	miner := sc.miners[sender]
+	if miner == nil {
+		return NewContractError(0x81000000, big.NewInt(0))
+	}
+	if claim.min.Cmp(miner.lastCounter) <= 0 {
+		return NewContractError(0x81000001, new(big.Int).Set(miner.lastCounter))
+	}
+	miner.claims = append(miner.claims, claim)
+	miner.lastCounter = new(big.Int).Set(claim.max)
+	if lastClaim {
+		miner.lastSubmissionBlockNo = blockNo
+	}
+	return nil
+}
+
+// setEpochData sets merkleNodes of epoch from index start. None of them
+// must be set already.
+func (sc *SimulatedContract) setEpochData(
+	sender common.Address, epoch uint64, fullSizeIn128Resolution uint64,
+	branchDepth uint64, merkleNodes []*big.Int, start uint64) *ContractError {
+	sc.mine()
+	if sender != sc.owner {
+		return NewContractError(0x82000000, sender.Big())
+	}
+	data := sc.epochs[epoch]
+	if data == nil {
+		data = &simulatedEpoch{0, 0, []*big.Int{}}
+	}
+	for i := range merkleNodes {
+		if data.node(start+uint64(i)).Sign() != 0 {
+			return NewContractError(0x82000001, new(big.Int).SetUint64(epoch))
+		}
+	}
+	for uint64(len(data.merkleNodes)) < start+uint64(len(merkleNodes)) {
+		data.merkleNodes = append(data.merkleNodes, big.NewInt(0))
+	}
+	for i, node := range merkleNodes {
+		data.merkleNodes[start+uint64(i)] = new(big.Int).Set(node)
+	}
+	data.fullSizeIn128Resolution = fullSizeIn128Resolution
+	data.branchDepth = branchDepth
+	sc.epochs[epoch] = data
+	return nil
+}
+
+// EpochNode returns the merkle node at index of epoch, 0 if it is not set.
+func (sc *SimulatedContract) EpochNode(epoch uint64, index uint64) *big.Int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if data := sc.epochs[epoch]; data != nil {
+		return new(big.Int).Set(data.node(index))
+	}
+	return big.NewInt(0)
+}
+
+// checkExtraData checks the extra data is SmartPool- followed by base 62
+// miner id and the claim difficulty.
+func (sc *SimulatedContract) checkExtraData(extra []byte, id *big.Int, difficulty *big.Int) *ContractError {
+	idEnd := len(extraDataPrefix) + 11
+	if len(extra) <= idEnd || !bytes.HasPrefix(extra, []byte(extraDataPrefix)) {
+		return NewContractError(0x84000004, smartpool.BytesToBig(extra))
+	}
+	extraID, ok := smartpool.Base62ToBig(string(extra[len(extraDataPrefix):idEnd]))
+	if !ok {
+		return NewContractError(0x84000004, smartpool.BytesToBig(extra))
+	}
+	if extraID.Cmp(id) != 0 {
+		return NewContractError(0x83000000, extraID)
+	}
+	extraDiff, ok := smartpool.Base62ToBig(string(extra[idEnd:]))
+	if !ok {
+		return NewContractError(0x84000004, smartpool.BytesToBig(extra))
+	}
+	if extraDiff.Cmp(difficulty) != 0 {
+		return NewContractError(0x83000001, extraDiff)
+	}
+	return nil
+}
+
+func simulatedAugHash(leftMin, leftMax *big.Int, leftHash smartpool.SPHash, rightMin, rightMax *big.Int, rightHash smartpool.SPHash) smartpool.SPHash {
+	keccak := crypto.Keccak256(
+		common.LeftPadBytes(leftMax.Bytes(), 16), common.LeftPadBytes(leftMin.Bytes(), 16),
+		common.LeftPadBytes(leftHash[:], 32),
+		common.LeftPadBytes(rightMax.Bytes(), 16), common.LeftPadBytes(rightMin.Bytes(), 16),
+		common.LeftPadBytes(rightHash[:], 32),
+	)
+	result := smartpool.SPHash{}
+	copy(result[:], keccak[smartpool.HashLength:])
+	return result
+}
+
+// verifyAugBranch climbs from the leaf of counter and hash to the root of
+// the claim's augmented merkle tree. Each counters element is the
+// sibling's max and min in 16 bytes each, each hashes element is the
+// sibling's hash. Counters must be strictly increasing from left to right.
+func (claim *simulatedClaim) verifyAugBranch(counter *big.Int, hash smartpool.SPHash, index *big.Int, counters, hashes []*big.Int) bool {
+	if len(counters) != len(hashes) {
+		return false
+	}
+	min, max := counter, counter
+	for i := range counters {
+		siblingMin := new(big.Int).And(counters[i], lower128Bits)
+		siblingMax := new(big.Int).Rsh(counters[i], 128)
+		siblingHash := smartpool.SPHash{}
+		copy(siblingHash[:], common.LeftPadBytes(hashes[i].Bytes(), 32)[smartpool.HashLength:])
+		if index.Bit(i) == 1 {
+			if siblingMax.Cmp(min) >= 0 {
+				return false
+			}
+			hash = simulatedAugHash(siblingMin, siblingMax, siblingHash, min, max, hash)
+			min = siblingMin
+		} else {
+			if max.Cmp(siblingMin) >= 0 {
+				return false
+			}
+			hash = simulatedAugHash(min, max, hash, siblingMin, siblingMax, siblingHash)
+			max = siblingMax
+		}
+	}
+	return min.Cmp(claim.min) == 0 &&
+		max.Cmp(claim.max) == 0 &&
+		hash.Big().Cmp(claim.augMerkle) == 0
+}
+
+func simulatedDAGHash(left, right smartpool.SPHash) smartpool.SPHash {
+	keccak := crypto.Keccak256(
+		common.LeftPadBytes(left[:], 32), common.LeftPadBytes(right[:], 32))
+	result := smartpool.SPHash{}
+	copy(result[:], keccak[smartpool.HashLength:])
+	return result
+}
+
+// simulatedHalfOf returns the lower (second == false) or higher 16 bytes of
+// a branch element packed by smartpool.BranchElementFromHash.
+func simulatedHalfOf(element *big.Int, second bool) smartpool.SPHash {
+	raw := common.LeftPadBytes(element.Bytes(), 32)
+	result := smartpool.SPHash{}
+	if second {
+		copy(result[:], raw[:smartpool.HashLength])
+	} else {
+		copy(result[:], raw[smartpool.HashLength:])
+	}
+	return result
+}
+
+func (epoch *simulatedEpoch) node(index uint64) *big.Int {
+	if index < uint64(len(epoch.merkleNodes)) {
+		return epoch.merkleNodes[index]
+	}
+	return big.NewInt(0)
+}
+
+// verifyDAGBranch climbs from the dataset element at index to the stored
+// merkle node of the epoch. Each witness element packs two levels of the
+// branch.
+func (epoch *simulatedEpoch) verifyDAGBranch(leaf smartpool.SPHash, index uint32, witness []*big.Int) bool {
+	node := leaf
+	for level := uint64(0); level < epoch.branchDepth; level++ {
+		sibling := simulatedHalfOf(witness[level/2], level%2 == 1)
+		if (index>>level)&1 == 1 {
+			node = simulatedDAGHash(sibling, node)
+		} else {
+			node = simulatedDAGHash(node, sibling)
+		}
+	}
+	stored := uint64(index) >> epoch.branchDepth
+	if stored/2 >= uint64(len(epoch.merkleNodes)) {
+		return false
+	}
+	return simulatedHalfOf(epoch.merkleNodes[stored/2], stored%2 == 1) == node
+}
+
+// hashimoto computes the pow value of the header hash and nonce with the
+// given dataset elements. It returns false if the elements are not the ones
+// hashimoto accesses or their witness doesn't match the epoch data.
+func (epoch *simulatedEpoch) hashimoto(hash common.Hash, nonce uint64, dataSetLookup, witnessForLookup []*big.Int) (*big.Int, bool) {
+	witnessLength := int((epoch.branchDepth + 1) / 2)
+	if len(dataSetLookup) != dagLookups*wordsPerElement ||
+		len(witnessForLookup) != dagLookups*witnessLength {
+		return nil, false
+	}
+	valid := true
+	accessed := 0
+	element := []byte{}
+	lookup := func(index uint32) []uint32 {
+		// hashimoto looks up an element in 2 halves, 2*parent first
+		if index%2 == 0 {
+			words := dataSetLookup[accessed*wordsPerElement : (accessed+1)*wordsPerElement]
+			element = []byte{}
+			hashData := []byte{}
+			for _, w := range words {
+				chunk := common.LeftPadBytes(w.Bytes(), 32)
+				hashData = append(hashData, chunk...)
+				// contract receives each 32 bytes of the element reversed
+				for i := len(chunk) - 1; i >= 0; i-- {
+					element = append(element, chunk[i])
+				}
+			}
+			leaf := smartpool.SPHash{}
+			copy(leaf[:], crypto.Keccak256(hashData)[smartpool.HashLength:])
+			witness := witnessForLookup[accessed*witnessLength : (accessed+1)*witnessLength]
+			if !epoch.verifyDAGBranch(leaf, index/2, witness) {
+				valid = false
+			}
+			accessed++
+		}
+		half := element[index%2*64 : (index%2+1)*64]
+		data := make([]uint32, len(half)/4)
+		for i := range data {
+			data[i] = binary.LittleEndian.Uint32(half[i*4:])
+		}
+		return data
+	}
+	_, result := ethash.HashimotoWithLookup(
+		hash, nonce, epoch.fullSizeIn128Resolution*128, lookup)
+	return new(big.Int).SetBytes(result), valid
+}
+
+func (sc *SimulatedContract) verifyClaim(
+	sender common.Address, rlpHeader []byte, nonce *big.Int,
+	submissionIndex *big.Int, shareIndex *big.Int,
+	dataSetLookup, witnessForLookup []*big.Int,
+	augCountersBranch, augHashesBranch []*big.Int) error {
+	expected, cerr := sc.submissionIndex(sender, sc.claimSeedOf(sender))
+	if cerr != nil {
+		return cerr
+	}
+	if expected[0].Cmp(submissionIndex) != 0 {
+		return NewContractError(0x84000002, expected[0])
+	}
+	if expected[1].Cmp(shareIndex) != 0 {
+		return NewContractError(0x84000002, expected[1])
+	}
+	miner := sc.miners[sender]
+	claim := miner.claims[submissionIndex.Int64()]
+	header := headerWithoutNonce{}
+	if err := rlp.DecodeBytes(rlpHeader, &header); err != nil {
+		return fmt.Errorf("couldn't decode rlp header: %s", err)
+	}
+	if cerr = sc.checkExtraData(header.Extra, miner.id, claim.difficulty); cerr != nil {
+		return cerr
+	}
+	if header.Coinbase != sc.address {
+		return NewContractError(0x84000005, header.Coinbase.Big())
+	}
+	counter := new(big.Int).Lsh(header.Time, 64)
+	counter.Add(counter, nonce)
+	if counter.Cmp(claim.min) < 0 {
+		return NewContractError(0x84000006, counter)
+	}
+	if counter.Cmp(claim.max) > 0 {
+		return NewContractError(0x84000007, counter)
+	}
+	hash := crypto.Keccak256Hash(rlpHeader)
+	leafHash := smartpool.SPHash{}
+	copy(leafHash[:], hash[smartpool.HashLength:])
+	if !claim.verifyAugBranch(counter, leafHash, shareIndex, augCountersBranch, augHashesBranch) {
+		return NewContractError(0x84000008, big.NewInt(0))
+	}
+	epochNo := header.Number.Uint64() / 30000
+	epoch := sc.epochs[epochNo]
+	if epoch == nil {
+		return NewContractError(0x8400000a, new(big.Int).SetUint64(epochNo))
+	}
+	value, valid := epoch.hashimoto(hash, nonce.Uint64(), dataSetLookup, witnessForLookup)
+	if !valid {
+		return NewContractError(0x84000009, big.NewInt(0))
+	}
+	if claim.difficulty.Sign() == 0 {
+		// the contract's division by the difficulty reverts the tx
+		return fmt.Errorf("claim difficulty is 0")
+	}
+	if value.Cmp(new(big.Int).Div(maxUint256, claim.difficulty)) > 0 {
+		return NewContractError(0x84000009, value)
+	}
+	miner.verifiedClaims += uint64(len(miner.claims))
+	miner.claims = []*simulatedClaim{}
+	miner.lastSubmissionBlockNo = 0
+	return nil
+}
+
+func (sc *SimulatedContract) claimSeedOf(sender common.Address) *big.Int {
+	if miner := sc.miners[sender]; miner != nil {
+		return sc.claimSeed(miner)
+	}
+	return big.NewInt(0)
+}
+
+// NewClient returns a client sending txs to the contract from sender.
+func (sc *SimulatedContract) NewClient(sender common.Address) *SimulatedContractClient {
+	return &SimulatedContractClient{sc, sender}
+}
+
+// NewSimulatedContract creates a contract at address. Only owner can set
+// epoch data.
+func NewSimulatedContract(address, owner common.Address) *SimulatedContract {
+	return &SimulatedContract{
+		sync.Mutex{}, address, owner, 0,
+		map[common.Address]*simulatedMiner{},
+		map[string]common.Address{},
+		map[uint64]*simulatedEpoch{},
+		map[common.Hash]error{},
+	}
+}
+
+// SimulatedContractClient is a ContractClient and EthashContractClient
+// sending txs to a SimulatedContract.
+type SimulatedContractClient struct {
+	contract *SimulatedContract
+	sender   common.Address
+}
+
+func (cc *SimulatedContractClient) Version() string {
+	return smartpool.VERSION
+}
+
+func (cc *SimulatedContractClient) IsRegistered() bool {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	return cc.contract.miners[cc.sender] != nil
+}
+
+func (cc *SimulatedContractClient) CanRegister() bool {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	owner, used := cc.contract.minerIDs[minerID(cc.sender).String()]
+	return !used || owner == cc.sender
+}
+
+func (cc *SimulatedContractClient) Register(paymentAddress common.Address) error {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	if err := cc.contract.register(cc.sender, paymentAddress); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetClaimSeed mines blocks until the seed of the last claim is available.
+// It returns 0 if there is no closed claim batch.
+func (cc *SimulatedContractClient) GetClaimSeed() *big.Int {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	miner := cc.contract.miners[cc.sender]
+	if miner == nil || miner.lastSubmissionBlockNo == 0 {
+		return big.NewInt(0)
+	}
+	for cc.contract.blockNo <= miner.lastSubmissionBlockNo+1 {
+		cc.contract.mine()
+	}
+	return cc.contract.claimSeed(miner)
+}
+
+func (cc *SimulatedContractClient) NumOpenClaims(sender common.Address) (*big.Int, error) {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	if miner := cc.contract.miners[sender]; miner != nil {
+		return big.NewInt(int64(len(miner.claims))), nil
+	}
+	return big.NewInt(0), nil
+}
+
+func (cc *SimulatedContractClient) ResetOpenClaims() error {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	cc.contract.mine()
+	if miner := cc.contract.miners[cc.sender]; miner != nil {
+		miner.claims = []*simulatedClaim{}
+		miner.lastSubmissionBlockNo = 0
+	}
+	return nil
+}
+
+func (cc *SimulatedContractClient) CalculateSubmissionIndex(sender common.Address, seed *big.Int) ([2]*big.Int, error) {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	result, err := cc.contract.submissionIndex(sender, seed)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (cc *SimulatedContractClient) SubmitClaim(
+	numShares *big.Int, difficulty *big.Int,
+	min *big.Int, max *big.Int,
+	augMerkle *big.Int, lastClaim bool) error {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	claim := &simulatedClaim{
+		new(big.Int).Set(numShares), new(big.Int).Set(difficulty),
+		new(big.Int).Set(min), new(big.Int).Set(max),
+		new(big.Int).Set(augMerkle),
+	}
+	if err := cc.contract.submitClaim(cc.sender, claim, lastClaim); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cc *SimulatedContractClient) VerifyClaim(
+	rlpHeader []byte,
+	nonce *big.Int,
+	submissionIndex *big.Int,
+	shareIndex *big.Int,
+	dataSetLookup []*big.Int,
+	witnessForLookup []*big.Int,
+	augCountersBranch []*big.Int,
+	augHashesBranch []*big.Int,
+	txSent func(tx common.Hash)) error {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	blockNo := cc.contract.mine()
+	tx := crypto.Keccak256Hash(
+		cc.sender.Bytes(), new(big.Int).SetUint64(blockNo).Bytes(), rlpHeader)
+	if txSent != nil {
+		txSent(tx)
+	}
+	err := cc.contract.verifyClaim(
+		cc.sender, rlpHeader, nonce, submissionIndex, shareIndex,
+		dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch)
+	cc.contract.verifications[tx] = err
+	return err
+}
+
+func (cc *SimulatedContractClient) VerifyClaimResult(tx common.Hash) (bool, error) {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	err, mined := cc.contract.verifications[tx]
+	return mined, err
+}
+
+func (cc *SimulatedContractClient) EpochNode(epoch *big.Int, index *big.Int) (*big.Int, error) {
+	return cc.contract.EpochNode(epoch.Uint64(), index.Uint64()), nil
+}
+
+// SetEpochData sets epoch data in a simulated tx. Simulated txs use no gas.
+func (cc *SimulatedContractClient) SetEpochData(
+	epoch *big.Int,
+	fullSizeIn128Resolution *big.Int,
+	branchDepth *big.Int,
+	merkleNodes []*big.Int,
+	start *big.Int) (*big.Int, *big.Int, error) {
+	cc.contract.mu.Lock()
+	defer cc.contract.mu.Unlock()
+	err := cc.contract.setEpochData(
+		cc.sender, epoch.Uint64(), fullSizeIn128Resolution.Uint64(),
+		branchDepth.Uint64(), merkleNodes, start.Uint64())
+	if err != nil {
+		return big.NewInt(0), big.NewInt(0), err
+	}
+	return big.NewInt(0), big.NewInt(0), nil
+}
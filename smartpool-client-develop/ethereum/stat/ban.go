@@ -0,0 +1,82 @@
+package stat
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"sync"
+	"time"
+)
+
+var (
+	// BadMixBanThreshold is how many shares with a bad mix digest a rig
+	// can submit within BadMixWindow before it is banned
+	BadMixBanThreshold uint64 = 3
+	// BadMixWindow is how far back bad mix digests of a rig are counted.
+	// It is rounded down to the start of a stat period.
+	BadMixWindow = 10 * time.Minute
+	// BanDuration is how long a rig stays banned. It is longer than
+	// BadMixWindow plus a stat period so the shares that got a rig banned
+	// are out of the window when the ban expires.
+	BanDuration = 30 * time.Minute
+)
+
+// BadMixBan bans rigs submitting shares with bad mix digests. Such shares
+// cost the pool a verification and would get the claim containing them
+// rejected by the contract. It implements smartpool.BanPolicy.
+type BadMixBan struct {
+	mu       sync.Mutex
+	recorder *StatRecorder
+	banned   map[string]time.Time
+}
+
+// Bans returns rigs that are banned now.
+func (bb *BadMixBan) Bans() []*Ban {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	t := time.Now()
+	result := []*Ban{}
+	for id, until := range bb.banned {
+		if t.Before(until) {
+			result = append(result, &Ban{id, "bad mix digests", until})
+		}
+	}
+	return result
+}
+
+func (bb *BadMixBan) Banned(rig smartpool.Rig) bool {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	t := time.Now()
+	if until, ok := bb.banned[rig.ID()]; ok {
+		if t.Before(until) {
+			return true
+		}
+		delete(bb.banned, rig.ID())
+		logger.Infof("Ban of rig %s expired.\n", rig.ID())
+	}
+	badMix := bb.recorder.RigBadMixShares(rig, t.Add(-BadMixWindow))
+	if badMix < BadMixBanThreshold {
+		return false
+	}
+	logger.Warnf(
+		"Banned rig %s for %s after %d shares with bad mix digest.\n",
+		rig.ID(), BanDuration, badMix)
+	until := t.Add(BanDuration)
+	reason := fmt.Sprintf("%d bad mix digests", badMix)
+	bb.banned[rig.ID()] = until
+	logger.Emit(smartpool.RigBannedEvent, smartpool.Fields{
+		"subject": rig.ID(),
+		"reason":  reason,
+		"until":   until,
+	})
+	bb.recorder.RecordBan(rig, reason, until)
+	return true
+}
+
+func NewBadMixBan(recorder *StatRecorder) *BadMixBan {
+	return &BadMixBan{
+		mu:       sync.Mutex{},
+		recorder: recorder,
+		banned:   map[string]time.Time{},
+	}
+}
@@ -0,0 +1,23 @@
+package stat
+
+import (
+	"testing"
+)
+
+func TestBadMixBanBansRigAtThreshold(t *testing.T) {
+	recorder := newStatRecorder()
+	bb := NewBadMixBan(recorder)
+	for i := uint64(1); i < BadMixBanThreshold; i++ {
+		recorder.RecordShare("badmix", nil, rig)
+	}
+	if bb.Banned(rig) {
+		t.Fatalf("expected rig not to be banned below the threshold")
+	}
+	recorder.RecordShare("badmix", nil, rig)
+	if !bb.Banned(rig) {
+		t.Fatalf("expected rig to be banned at the threshold")
+	}
+	if bb.Banned(rig2) {
+		t.Fatalf("expected other rigs not to be banned")
+	}
+}
@@ -80,6 +80,11 @@ type OverallFarmData struct {
 	TotalValidDifficulty   *big.Int                `json:"total_valid_difficulty"`
 	AverageShareDifficulty *big.Int                `json:"average_share_difficulty"`
 	RejectedShare          uint64                  `json:"rejected_share"`
+	StaleShare             uint64                  `json:"stale_share"`
+	OrphanedShare          uint64                  `json:"orphaned_share"`
+	BadMixShare            uint64                  `json:"bad_mix_share"`
+	Ban                    uint64                  `json:"total_ban"`
+	ThrottledRequest       uint64                  `json:"total_throttled_request"`
 	LastSubmittedClaim     time.Time               `json:"last_submitted_claim"`
 	LastAcceptedClaim      time.Time               `json:"last_accepted_claim"`
 	LastRejectedClaim      time.Time               `json:"last_rejected_claim"`
@@ -90,6 +95,12 @@ type OverallFarmData struct {
 	EffectiveHashrate      *big.Int                `json:"effective_hashrate"`
 	Rigs                   map[string]*RigHashrate `json:"rigs"`
 	BlockFound             uint64                  `json:"total_block_found"`
+	// Outcomes of found blocks once they matured and the static rewards
+	// credited to the contract for them
+	CanonicalBlock uint64   `json:"total_canonical_block"`
+	UncleBlock     uint64   `json:"total_uncle_block"`
+	LostBlock      uint64   `json:"total_lost_block"`
+	BlockReward    *big.Int `json:"total_block_reward"`
 	// A share is pending when it is not included in any claim
 	PendingShare uint64 `json:"pending_share"`
 	// A share is considered as abandoned when it is discarded while being
@@ -118,6 +129,7 @@ func NewFarmData() *FarmData {
 			ReportedHashrate:       big.NewInt(0),
 			EffectiveHashrate:      big.NewInt(0),
 			Rigs:                   map[string]*RigHashrate{},
+			BlockReward:            big.NewInt(0),
 		},
 	}
 }
@@ -192,6 +204,12 @@ func (fd *FarmData) AddShare(rig smartpool.Rig, status string, share smartpool.S
 		curPeriodData.updateAvgShareDifficulty(t)
 		curPeriodData.updateAvgEffHashrate(t)
 		curPeriodData.BlockFound++
+	} else if status == "stale" {
+		fd.StaleShare++
+	} else if status == "orphaned" {
+		fd.OrphanedShare++
+	} else if status == "badmix" {
+		fd.BadMixShare++
 	}
 }
 
@@ -225,27 +243,33 @@ func (fd *FarmData) AddClaim(status string, claim smartpool.Claim, t time.Time)
 	}
 }
 
-func (fd *FarmData) ShareRestored(noShares uint64) {
+func (fd *FarmData) AddBlock(status string, reward *big.Int) {
+	if fd.BlockReward == nil {
+		// stats of a version without block rewards
+		fd.BlockReward = big.NewInt(0)
+	}
+	if status == "canonical" {
+		fd.CanonicalBlock++
+	} else if status == "uncle" {
+		fd.UncleBlock++
+	} else if status == "lost" {
+		fd.LostBlock++
+	}
+	fd.BlockReward.Add(fd.BlockReward, reward)
+}
+
+func (fd *FarmData) ShareRestored(noShares uint64, noUnverified uint64) {
 	numAbandoned := fd.PendingShare - noShares
 	fd.PendingShare -= numAbandoned
 	fd.AbandonedShare += numAbandoned
-	// TODO: We currently discard last unverified claim
-	// on client startup.
-	// So we increase RejectedClaim and consider all its shares
-	// as AbandonedShare
-	// NOTE: There is a case that the verification tx was
-	// already sent out. It would be likely to be mined and the
-	// claim is actually verified and its share shouldn't be
-	// consider as abandoned.
-	// SOLUTION:
-	// 1. Recover claim which is waiting to verify
-	// 2. Prevent the client from shutting down when its waiting
-	// to verify last claim
-	if fd.BeingValidatedShare != 0 {
+	// Submitted claims are resumed by SmartPool so only shares that
+	// SmartPool couldn't restore are abandoned. In that case, we increase
+	// RejectedClaim as the claim batch is lost.
+	if fd.BeingValidatedShare > noUnverified {
 		fd.RejectedClaim++
+		fd.AbandonedShare += fd.BeingValidatedShare - noUnverified
 	}
-	fd.AbandonedShare += fd.BeingValidatedShare
-	fd.BeingValidatedShare = 0
+	fd.BeingValidatedShare = noUnverified
 }
 
 func (fd *FarmData) UpdateRigHashrate(
@@ -0,0 +1,7 @@
+package stat
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+var logger = smartpool.NewLogger("stat")
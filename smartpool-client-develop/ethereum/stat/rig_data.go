@@ -15,6 +15,10 @@ type PeriodRigData struct {
 	TotalValidDifficulty     *big.Int  `json:"-"`
 	AverageShareDifficulty   *big.Int  `json:"average_share_difficulty"`
 	RejectedShare            uint64    `json:"rejected_share"`
+	StaleShare               uint64    `json:"stale_share"`
+	OrphanedShare            uint64    `json:"orphaned_share"`
+	BadMixShare              uint64    `json:"bad_mix_share"`
+	StaleRate                float64   `json:"stale_rate"`
 	TotalHashrate            *big.Int  `json:"-"`
 	NoHashrateSubmission     uint64    `json:"-"`
 	AverageReportedHashrate  *big.Int  `json:"reported_hashrate"`
@@ -51,6 +55,10 @@ func (prd *PeriodRigData) updateAvgEffHashrate(t time.Time) {
 	)
 }
 
+func (prd *PeriodRigData) updateStaleRate() {
+	prd.StaleRate = staleRate(prd.StaleShare, prd.OrphanedShare, prd.ValidShare)
+}
+
 func (prd *PeriodRigData) updateAvgShareDifficulty(t time.Time) {
 	if prd.ValidShare > 0 {
 		prd.AverageShareDifficulty.Div(
@@ -70,11 +78,19 @@ type OverallRigData struct {
 	TotalValidDifficulty     *big.Int  `json:"total_accepted_difficulty"`
 	AverageShareDifficulty   *big.Int  `json:"average_share_difficulty"`
 	RejectedShare            uint64    `json:"total_rejected_share"`
+	StaleShare               uint64    `json:"total_stale_share"`
+	OrphanedShare            uint64    `json:"total_orphaned_share"`
+	BadMixShare              uint64    `json:"total_bad_mix_share"`
+	StaleRate                float64   `json:"stale_rate"`
 	TotalHashrate            *big.Int  `json:"total_hashrate"`
 	NoHashrateSubmission     uint64    `json:"no_hashrate_submission"`
 	AverageReportedHashrate  *big.Int  `json:"reported_hashrate"`
 	AverageEffectiveHashrate *big.Int  `json:"effective_hashrate"`
 	BlockFound               uint64    `json:"total_block_found"`
+	Ban                      uint64    `json:"total_ban"`
+	BannedUntil              time.Time `json:"banned_until"`
+	BanReason                string    `json:"ban_reason"`
+	ThrottledRequest         uint64    `json:"total_throttled_request"`
 	StartTime                time.Time `json:"start_time"`
 }
 
@@ -160,7 +176,68 @@ func (rd *RigData) AddShare(status string, share smartpool.Share, t time.Time) {
 		curPeriodData.updateAvgShareDifficulty(t)
 		curPeriodData.updateAvgEffHashrate(t)
 		curPeriodData.BlockFound++
+	} else if status == "stale" {
+		rd.StaleShare++
+		curPeriodData.StaleShare++
+	} else if status == "orphaned" {
+		rd.OrphanedShare++
+		curPeriodData.OrphanedShare++
+	} else if status == "badmix" {
+		rd.BadMixShare++
+		curPeriodData.BadMixShare++
+	}
+	rd.updateStaleRate()
+	curPeriodData.updateStaleRate()
+}
+
+// staleRate returns the fraction of accepted shares that were mined on
+// stale or orphaned works.
+func staleRate(stale, orphaned, valid uint64) float64 {
+	if valid == 0 {
+		return 0
 	}
+	return float64(stale+orphaned) / float64(valid)
+}
+
+// RecentEffectiveHashrate returns the rig's effective hashrate based on its
+// accepted shares since the time period containing start.
+func (rd *RigData) RecentEffectiveHashrate(start time.Time, t time.Time) *big.Int {
+	startPeriod := TimeToPeriod(start)
+	totalDifficulty := big.NewInt(0)
+	for period, data := range rd.Datas {
+		if period >= startPeriod {
+			totalDifficulty.Add(totalDifficulty, data.TotalValidDifficulty)
+		}
+	}
+	from := time.Unix(int64(startPeriod)*BaseTimePeriod, 0)
+	if rd.StartTime.After(from) {
+		from = rd.StartTime
+	}
+	duration := int64(t.Sub(from).Seconds())
+	if duration <= 0 {
+		return big.NewInt(0)
+	}
+	return totalDifficulty.Div(totalDifficulty, big.NewInt(duration))
+}
+
+// AddBan records that the rig is banned until the time for the reason.
+func (rd *RigData) AddBan(reason string, until time.Time) {
+	rd.Ban++
+	rd.BannedUntil = until
+	rd.BanReason = reason
+}
+
+// RecentBadMixShares returns number of shares with a bad mix digest the rig
+// submitted since the time period containing start.
+func (rd *RigData) RecentBadMixShares(start time.Time) uint64 {
+	startPeriod := TimeToPeriod(start)
+	var result uint64
+	for period, data := range rd.Datas {
+		if period >= startPeriod {
+			result += data.BadMixShare
+		}
+	}
+	return result
 }
 
 func (rd *RigData) PeriodReportedHashrate(t time.Time) *big.Int {
@@ -207,6 +284,10 @@ func (rd *RigData) updateAvgEffHashrate(t time.Time) {
 	)
 }
 
+func (rd *RigData) updateStaleRate() {
+	rd.StaleRate = staleRate(rd.StaleShare, rd.OrphanedShare, rd.ValidShare)
+}
+
 func (rd *RigData) updateAvgShareDifficulty(t time.Time) {
 	if rd.ValidShare > 0 {
 		rd.AverageShareDifficulty.Div(
@@ -0,0 +1,317 @@
+package stat
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// PolicyWindow is how long solutions of a rig or an IP are counted
+	// before its counters start over
+	PolicyWindow = 10 * time.Minute
+	// MinPolicyShares is how many solutions a rig or an IP has to submit
+	// within PolicyWindow before its invalid share ratio is judged
+	MinPolicyShares uint64 = 20
+	// InvalidShareBanRatio is the fraction of invalid solutions within
+	// PolicyWindow that gets a rig or an IP banned
+	InvalidShareBanRatio = 0.5
+	// DuplicateBanThreshold is how many duplicated shares a rig or an IP
+	// can submit within PolicyWindow before it is banned
+	DuplicateBanThreshold uint64 = 5
+	// RequestBurst is how many seconds worth of its request rate a rig or
+	// an IP can spend at once
+	RequestBurst = 5.0
+)
+
+// Ban is a rig or an IP whose requests are refused until a time.
+type Ban struct {
+	Subject string    `json:"subject"`
+	Reason  string    `json:"reason"`
+	Until   time.Time `json:"until"`
+}
+
+// offender is what a RigGuard knows about a rig or an IP.
+type offender struct {
+	windowStart time.Time
+	shares      uint64
+	invalid     uint64
+	duplicated  uint64
+	tokens      float64
+	refilled    time.Time
+	ban         *Ban
+}
+
+func (o *offender) banned(t time.Time) bool {
+	return o.ban != nil && t.Before(o.ban.Until)
+}
+
+// burstOf returns how many requests can be made at once at rate.
+func burstOf(rate float64) float64 {
+	burst := rate * RequestBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return burst
+}
+
+// take takes one of the request tokens refilled at rate per second. It
+// returns false when none is left. A rate of 0 doesn't limit requests.
+func (o *offender) take(rate float64, t time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	burst := burstOf(rate)
+	if o.refilled.IsZero() {
+		o.tokens = burst
+	} else {
+		o.tokens += t.Sub(o.refilled).Seconds() * rate
+		if o.tokens > burst {
+			o.tokens = burst
+		}
+	}
+	o.refilled = t
+	if o.tokens < 1 {
+		return false
+	}
+	o.tokens--
+	return true
+}
+
+// count counts a solution with the outcome. It returns why the offender
+// has to be banned or an empty string if it doesn't.
+func (o *offender) count(outcome string, t time.Time) string {
+	if t.Sub(o.windowStart) >= PolicyWindow {
+		o.windowStart = t
+		o.shares, o.invalid, o.duplicated = 0, 0, 0
+	}
+	o.shares++
+	if outcome == "invalid" {
+		o.invalid++
+	} else if outcome == "duplicated" {
+		o.duplicated++
+	}
+	if o.duplicated >= DuplicateBanThreshold {
+		return fmt.Sprintf("%d duplicated shares", o.duplicated)
+	}
+	if o.shares >= MinPolicyShares &&
+		float64(o.invalid) >= InvalidShareBanRatio*float64(o.shares) {
+		return fmt.Sprintf("%d invalid shares out of %d", o.invalid, o.shares)
+	}
+	return ""
+}
+
+// forgettable tells if forgetting o at t changes nothing: it isn't banned,
+// its solutions don't count any more and its request tokens are refilled.
+func (o *offender) forgettable(rate float64, t time.Time) bool {
+	if o.banned(t) || t.Sub(o.windowStart) < PolicyWindow {
+		return false
+	}
+	return rate <= 0 || o.refilled.IsZero() ||
+		o.tokens+t.Sub(o.refilled).Seconds()*rate >= burstOf(rate)
+}
+
+func listed(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// RigGuard bans rigs and IPs submitting too many invalid or duplicated
+// shares for BanDuration and throttles those calling the miner facing
+// server too often. Rig names are chosen by the miners so rigs are also
+// judged by their IP. IPs in the allow list are never banned nor throttled
+// and IPs in the deny list are always banned. Rigs and IPs are forgotten
+// once their window and ban are over. It implements smartpool.RigPolicy.
+type RigGuard struct {
+	mu       sync.Mutex
+	recorder *StatRecorder
+	badMix   *BadMixBan
+	rigRate  float64
+	ipRate   float64
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	rigs     map[string]*offender
+	ips      map[string]*offender
+	pruned   time.Time
+}
+
+func getOffender(offenders map[string]*offender, key string) *offender {
+	o := offenders[key]
+	if o == nil {
+		o = &offender{}
+		offenders[key] = o
+	}
+	return o
+}
+
+// banned tells if rig is banned. The caller must hold rg.mu.
+func (rg *RigGuard) banned(rig smartpool.Rig, t time.Time) bool {
+	if listed(rg.allow, rig.IP()) {
+		return false
+	}
+	if listed(rg.deny, rig.IP()) {
+		return true
+	}
+	if o := rg.rigs[rig.ID()]; o != nil && o.banned(t) {
+		return true
+	}
+	if o := rg.ips[rig.IP()]; o != nil && o.banned(t) {
+		return true
+	}
+	return rg.badMix != nil && rg.badMix.Banned(rig)
+}
+
+// prune forgets rigs and IPs whose window and ban are over at most once
+// every PolicyWindow so rigs coming and going don't pile up. The caller must
+// hold rg.mu.
+func (rg *RigGuard) prune(t time.Time) {
+	if t.Sub(rg.pruned) < PolicyWindow {
+		return
+	}
+	rg.pruned = t
+	for key, o := range rg.rigs {
+		if o.forgettable(rg.rigRate, t) {
+			delete(rg.rigs, key)
+		}
+	}
+	for key, o := range rg.ips {
+		if o.forgettable(rg.ipRate, t) {
+			delete(rg.ips, key)
+		}
+	}
+}
+
+func (rg *RigGuard) Banned(rig smartpool.Rig) bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	return rg.banned(rig, time.Now())
+}
+
+func (rg *RigGuard) Allow(rig smartpool.Rig) bool {
+	return rg.allowAt(rig, time.Now())
+}
+
+func (rg *RigGuard) allowAt(rig smartpool.Rig, t time.Time) bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if listed(rg.allow, rig.IP()) {
+		return true
+	}
+	rg.prune(t)
+	if rg.banned(rig, t) {
+		return false
+	}
+	rigAllowed := getOffender(rg.rigs, rig.ID()).take(rg.rigRate, t)
+	ipAllowed := getOffender(rg.ips, rig.IP()).take(rg.ipRate, t)
+	if rigAllowed && ipAllowed {
+		return true
+	}
+	logger.Debugf("Throttled request of rig %s.\n", rig.ID())
+	rg.recorder.RecordThrottledRequest(rig)
+	return false
+}
+
+// ban bans the rig or the IP subject of o because of reason. The caller
+// must hold rg.mu.
+func (rg *RigGuard) ban(rig smartpool.Rig, o *offender, subject, reason string, t time.Time) {
+	until := t.Add(BanDuration)
+	o.ban = &Ban{subject, reason, until}
+	// the solutions that got it banned don't count after the ban
+	o.windowStart = time.Time{}
+	logger.Warnf("Banned %s for %s because of %s.\n", subject, BanDuration, reason)
+	logger.Emit(smartpool.RigBannedEvent, smartpool.Fields{
+		"subject": subject,
+		"reason":  reason,
+		"until":   until,
+	})
+	if subject != rig.ID() {
+		reason = fmt.Sprintf("%s from %s", reason, subject)
+	}
+	rg.recorder.RecordBan(rig, reason, until)
+}
+
+func (rg *RigGuard) ShareChecked(rig smartpool.Rig, outcome string) {
+	rg.shareCheckedAt(rig, outcome, time.Now())
+}
+
+func (rg *RigGuard) shareCheckedAt(rig smartpool.Rig, outcome string, t time.Time) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if listed(rg.allow, rig.IP()) {
+		return
+	}
+	rg.prune(t)
+	o := getOffender(rg.rigs, rig.ID())
+	if reason := o.count(outcome, t); reason != "" {
+		rg.ban(rig, o, rig.ID(), reason, t)
+	}
+	o = getOffender(rg.ips, rig.IP())
+	if reason := o.count(outcome, t); reason != "" {
+		rg.ban(rig, o, rig.IP(), reason, t)
+	}
+}
+
+// Bans returns rigs and IPs that are banned now ordered by subject. IPs in
+// the deny list are not included.
+func (rg *RigGuard) Bans() []*Ban {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	t := time.Now()
+	bans := map[string]*Ban{}
+	for _, offenders := range []map[string]*offender{rg.rigs, rg.ips} {
+		for _, o := range offenders {
+			if o.banned(t) {
+				bans[o.ban.Subject] = o.ban
+			}
+		}
+	}
+	if rg.badMix != nil {
+		for _, ban := range rg.badMix.Bans() {
+			bans[ban.Subject] = ban
+		}
+	}
+	subjects := []string{}
+	for subject := range bans {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	result := []*Ban{}
+	for _, subject := range subjects {
+		result = append(result, bans[subject])
+	}
+	return result
+}
+
+// SetBadMixBan makes rigs banned by bb banned by rg too.
+func (rg *RigGuard) SetBadMixBan(bb *BadMixBan) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.badMix = bb
+}
+
+// NewRigGuard creates a RigGuard letting each rig and each IP make
+// rigRate and ipRate requests per second. A rate of 0 doesn't limit
+// requests.
+func NewRigGuard(recorder *StatRecorder, rigRate, ipRate float64, allow, deny []*net.IPNet) *RigGuard {
+	return &RigGuard{
+		mu:       sync.Mutex{},
+		recorder: recorder,
+		rigRate:  rigRate,
+		ipRate:   ipRate,
+		allow:    allow,
+		deny:     deny,
+		rigs:     map[string]*offender{},
+		ips:      map[string]*offender{},
+	}
+}
@@ -0,0 +1,105 @@
+package stat
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client/ethereum"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRigGuardBansRigSendingDuplicatedShares(t *testing.T) {
+	recorder := newStatRecorder()
+	rg := NewRigGuard(recorder, 0, 0, nil, nil)
+	for i := uint64(1); i < DuplicateBanThreshold; i++ {
+		rg.ShareChecked(rig, "duplicated")
+	}
+	if rg.Banned(rig) {
+		t.Fatalf("expected rig not to be banned below the threshold")
+	}
+	rg.ShareChecked(rig, "duplicated")
+	if !rg.Banned(rig) || rg.Allow(rig) {
+		t.Fatalf("expected rig to be banned at the threshold")
+	}
+	if rg.Banned(rig2) {
+		t.Fatalf("expected rigs of other IPs not to be banned")
+	}
+	if len(rg.Bans()) != 2 {
+		t.Fatalf("expected the rig and its IP to be banned, got %d bans", len(rg.Bans()))
+	}
+	if recorder.OverallRigStat(rig).(*OverallRigData).BanReason == "" {
+		t.Fatalf("expected the ban in the rig's stats")
+	}
+}
+
+func TestRigGuardBansIPOfInvalidShares(t *testing.T) {
+	rg := NewRigGuard(newStatRecorder(), 0, 0, nil, nil)
+	// each rig submits few invalid shares but all come from one IP
+	for i := uint64(0); i < MinPolicyShares; i++ {
+		rg.ShareChecked(ethereum.NewRig(fmt.Sprintf("rig%d", i), "192.168.1.9"), "invalid")
+	}
+	if !rg.Banned(ethereum.NewRig("another", "192.168.1.9")) {
+		t.Fatalf("expected every rig of the IP to be banned")
+	}
+}
+
+func TestRigGuardThrottlesRequests(t *testing.T) {
+	rg := NewRigGuard(newStatRecorder(), 1, 0, nil, nil)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if rg.Allow(rig) {
+			allowed++
+		}
+	}
+	if allowed != int(RequestBurst) {
+		t.Fatalf("expected %d requests to be allowed at once, got %d", int(RequestBurst), allowed)
+	}
+	if !rg.Allow(rig2) {
+		t.Fatalf("expected other rigs not to be throttled")
+	}
+}
+
+func TestRigGuardAllowAndDenyLists(t *testing.T) {
+	_, allow, _ := net.ParseCIDR("192.168.1.2/32")
+	_, deny, _ := net.ParseCIDR("192.168.1.0/24")
+	rg := NewRigGuard(newStatRecorder(), 1, 0, []*net.IPNet{allow}, []*net.IPNet{deny})
+	for i := uint64(0); i < DuplicateBanThreshold; i++ {
+		rg.ShareChecked(rig, "duplicated")
+	}
+	for i := 0; i < 10; i++ {
+		if !rg.Allow(rig) {
+			t.Fatalf("expected allowed rig never to be banned nor throttled")
+		}
+	}
+	if !rg.Banned(rig2) {
+		t.Fatalf("expected denied rig to be banned")
+	}
+}
+
+func TestRigGuardForgetsRigsAfterTheirWindowAndBan(t *testing.T) {
+	rg := NewRigGuard(newStatRecorder(), 1, 1, nil, nil)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		rg.allowAt(ethereum.NewRig(fmt.Sprintf("rig%d", i), "192.168.1.9"), start)
+	}
+	for i := uint64(0); i < DuplicateBanThreshold; i++ {
+		rg.shareCheckedAt(rig, "duplicated", start)
+	}
+	if len(rg.rigs) != 4 || len(rg.ips) != 2 {
+		t.Fatalf("expected 4 rigs and 2 IPs, got %d and %d", len(rg.rigs), len(rg.ips))
+	}
+	// the window is over but the ban is not
+	later := start.Add(PolicyWindow)
+	rg.allowAt(rig2, later)
+	if len(rg.rigs) != 2 || rg.rigs[rig.ID()] == nil || rg.ips[rig.IP()] == nil {
+		t.Fatalf("expected only the banned rig and IP and the new rig to be kept, got %d rigs", len(rg.rigs))
+	}
+	if !rg.banned(rig, later) {
+		t.Fatalf("expected the rig to stay banned")
+	}
+	end := start.Add(BanDuration + PolicyWindow)
+	rg.allowAt(rig2, end)
+	if len(rg.rigs) != 1 || len(rg.ips) != 1 || rg.rigs[rig2.ID()] == nil {
+		t.Fatalf("expected rigs whose ban is over to be forgotten, got %d rigs and %d IPs", len(rg.rigs), len(rg.ips))
+	}
+}
@@ -0,0 +1,82 @@
+package stat
+
+import (
+	"math/big"
+	"sort"
+	"time"
+)
+
+// RigSnapshot is a copy of a rig's counters at a point of time. It is safe
+// to read after the StatRecorder is updated.
+type RigSnapshot struct {
+	ID                string
+	IP                string
+	MinedShare        uint64
+	ValidShare        uint64
+	RejectedShare     uint64
+	StaleShare        uint64
+	OrphanedShare     uint64
+	BadMixShare       uint64
+	StaleRate         float64
+	BlockFound        uint64
+	BannedUntil       time.Time
+	ThrottledRequest  uint64
+	ReportedHashrate  *big.Int
+	EffectiveHashrate *big.Int
+}
+
+// FarmSnapshot returns a copy of the overall farm data.
+func (sr *StatRecorder) FarmSnapshot() OverallFarmData {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	result := *sr.FarmData.OverallFarmData
+	result.TotalValidDifficulty = new(big.Int).Set(result.TotalValidDifficulty)
+	result.AverageShareDifficulty = new(big.Int).Set(result.AverageShareDifficulty)
+	result.ReportedHashrate = new(big.Int).Set(result.ReportedHashrate)
+	result.EffectiveHashrate = new(big.Int).Set(result.EffectiveHashrate)
+	if result.BlockReward == nil {
+		result.BlockReward = big.NewInt(0)
+	} else {
+		result.BlockReward = new(big.Int).Set(result.BlockReward)
+	}
+	result.Rigs = nil
+	return result
+}
+
+// RigSnapshots returns counters of all rigs ordered by rig id. Effective
+// hashrate is measured over the short window.
+func (sr *StatRecorder) RigSnapshots() []*RigSnapshot {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	t := time.Now().In(Zone)
+	start := t.Add(-time.Duration(ShortWindow) * time.Second)
+	ids := []string{}
+	for id := range sr.RigDatas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	result := []*RigSnapshot{}
+	for _, id := range ids {
+		rigData := sr.RigDatas[id]
+		snapshot := &RigSnapshot{
+			ID:                id,
+			MinedShare:        rigData.MinedShare,
+			ValidShare:        rigData.ValidShare,
+			RejectedShare:     rigData.RejectedShare,
+			StaleShare:        rigData.StaleShare,
+			OrphanedShare:     rigData.OrphanedShare,
+			BadMixShare:       rigData.BadMixShare,
+			StaleRate:         rigData.StaleRate,
+			BlockFound:        rigData.BlockFound,
+			BannedUntil:       rigData.BannedUntil,
+			ThrottledRequest:  rigData.ThrottledRequest,
+			ReportedHashrate:  new(big.Int).Set(rigData.AverageReportedHashrate),
+			EffectiveHashrate: rigData.RecentEffectiveHashrate(start, t),
+		}
+		if rig := sr.FarmData.Rigs[id]; rig != nil {
+			snapshot.IP = rig.IP
+		}
+		result = append(result, snapshot)
+	}
+	return result
+}
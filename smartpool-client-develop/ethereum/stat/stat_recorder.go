@@ -4,6 +4,7 @@ import (
 	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
 	"sync"
 	"time"
 )
@@ -31,34 +32,34 @@ func loadStatRecorder(storage smartpool.PersistentStorage) (*StatRecorder, error
 func NewStatRecorder(storage smartpool.PersistentStorage) *StatRecorder {
 	statRecorder, err := loadStatRecorder(storage)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't load stat from last session. Initialize with fresh stat recorder.\n")
+		logger.Warnf("Couldn't load stat from last session. Initialize with fresh stat recorder.\n")
 	}
-	smartpool.Output.Printf("Stat persister is running...\n")
+	logger.Infof("Stat persister is running...\n")
 	go func(sr *StatRecorder, storage smartpool.PersistentStorage) {
 		for {
-			smartpool.Output.Printf("Truncating stat datas...\n")
+			logger.Infof("Truncating stat datas...\n")
 			err := sr.truncateData(storage)
 			if err == nil {
-				smartpool.Output.Printf("Done truncating stat.\n")
+				logger.Debugf("Done truncating stat.\n")
 			} else {
-				smartpool.Output.Printf("Failed truncating stat. (%s)\n", err.Error())
+				logger.Warnf("Failed truncating stat. (%s)\n", err.Error())
 			}
 			time.Sleep(time.Minute)
 		}
 	}(statRecorder, storage)
-	smartpool.Output.Printf("Stat truncator is running...\n")
+	logger.Infof("Stat truncator is running...\n")
 	return statRecorder
 }
 
 func (sr *StatRecorder) Persist(storage smartpool.PersistentStorage) error {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
-	smartpool.Output.Printf("Saving stats to disk...\n")
+	logger.Debugf("Saving stats to disk...\n")
 	err := storage.Persist(sr, STATRECORDER_FILE)
 	if err == nil {
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
 	} else {
-		smartpool.Output.Printf("Failed. (%s)\n", err.Error())
+		logger.Warnf("Failed. (%s)\n", err.Error())
 	}
 	return err
 }
@@ -87,10 +88,10 @@ func (sr *StatRecorder) getRigData(rig smartpool.Rig) *RigData {
 	return data
 }
 
-func (sr *StatRecorder) ShareRestored(noShares uint64) {
+func (sr *StatRecorder) ShareRestored(noShares uint64, noUnverified uint64) {
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
-	sr.FarmData.ShareRestored(noShares)
+	sr.FarmData.ShareRestored(noShares, noUnverified)
 }
 
 func (sr *StatRecorder) RecordShare(status string, share smartpool.Share, rig smartpool.Rig) {
@@ -109,6 +110,31 @@ func (sr *StatRecorder) RecordClaim(status string, claim smartpool.Claim) {
 	sr.FarmData.AddClaim(status, claim, t)
 }
 
+// RecordBlock records the outcome of a found block and the reward credited
+// for it.
+func (sr *StatRecorder) RecordBlock(status string, reward *big.Int) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.FarmData.AddBlock(status, reward)
+}
+
+// RecordBan records that rig is banned until the time for the reason.
+func (sr *StatRecorder) RecordBan(rig smartpool.Rig, reason string, until time.Time) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.getRigData(rig).AddBan(reason, until)
+	sr.FarmData.Ban++
+}
+
+// RecordThrottledRequest records a request of rig refused because of its
+// request rate.
+func (sr *StatRecorder) RecordThrottledRequest(rig smartpool.Rig) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.getRigData(rig).ThrottledRequest++
+	sr.FarmData.ThrottledRequest++
+}
+
 func (sr *StatRecorder) RecordHashrate(hashrate hexutil.Uint64, id common.Hash, rig smartpool.Rig) {
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
@@ -128,6 +154,30 @@ func (sr *StatRecorder) RecordHashrate(hashrate hexutil.Uint64, id common.Hash,
 	)
 }
 
+// RigHashrate returns effective hashrate of the rig over the short window.
+// It falls back to the rig's reported hashrate when the rig hasn't had any
+// accepted share in the window.
+func (sr *StatRecorder) RigHashrate(rig smartpool.Rig) *big.Int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	t := time.Now().In(Zone)
+	rigData := sr.getRigData(rig)
+	hashrate := rigData.RecentEffectiveHashrate(
+		t.Add(-time.Duration(ShortWindow)*time.Second), t)
+	if hashrate.Cmp(common.Big0) == 0 {
+		return new(big.Int).Set(rigData.AverageReportedHashrate)
+	}
+	return hashrate
+}
+
+// RigBadMixShares returns number of shares with a bad mix digest the rig
+// submitted since the time period containing start.
+func (sr *StatRecorder) RigBadMixShares(rig smartpool.Rig, start time.Time) uint64 {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.getRigData(rig).RecentBadMixShares(start)
+}
+
 func (sr *StatRecorder) OverallFarmStat() interface{} {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
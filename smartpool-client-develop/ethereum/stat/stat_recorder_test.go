@@ -5,6 +5,8 @@ import (
 	"github.com/SmartPool/smartpool-client/ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
 	"sync"
 	"testing"
 	"time"
@@ -76,3 +78,24 @@ func TestRecordHashrateFromMultipleRigs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRecordStaleShares(t *testing.T) {
+	recorder := newStatRecorder()
+	share := ethereum.NewShare(&types.Header{}, big.NewInt(100), "")
+	for i := 0; i < 4; i++ {
+		recorder.RecordShare("submitted", share, rig)
+		recorder.RecordShare("accepted", share, rig)
+	}
+	recorder.RecordShare("stale", share, rig)
+	recorder.RecordShare("orphaned", share, rig)
+	rigStat := recorder.OverallRigStat(rig).(*OverallRigData)
+	if rigStat.StaleShare != 1 || rigStat.OrphanedShare != 1 || rigStat.ValidShare != 4 {
+		t.Fatalf("expected 1 stale and 1 orphaned of 4 valid shares, got %d, %d, %d", rigStat.StaleShare, rigStat.OrphanedShare, rigStat.ValidShare)
+	}
+	if rigStat.StaleRate != 0.5 {
+		t.Fatalf("expected stale rate 0.5, got %f", rigStat.StaleRate)
+	}
+	if recorder.RigSnapshots()[0].StaleRate != 0.5 {
+		t.Fatalf("expected stale rate in the rig snapshot")
+	}
+}
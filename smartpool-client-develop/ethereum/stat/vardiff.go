@@ -0,0 +1,72 @@
+package stat
+
+import (
+	"github.com/SmartPool/smartpool-client"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	// TargetShareInterval is how often each rig should find a share
+	TargetShareInterval = 10 * time.Second
+	// RetargetInterval is the minimum time between two difficulty changes
+	// of a rig
+	RetargetInterval = 5 * time.Minute
+)
+
+type rigDifficulty struct {
+	diff       *big.Int
+	retargeted time.Time
+}
+
+// VarDiff assigns each rig the highest difficulty tier that keeps it
+// finding a share every TargetShareInterval at its observed hashrate.
+// It implements smartpool.DifficultyAdjuster.
+type VarDiff struct {
+	mu       sync.Mutex
+	recorder *StatRecorder
+	tiers    []*big.Int
+	rigs     map[string]*rigDifficulty
+}
+
+func (vd *VarDiff) target(hashrate *big.Int) *big.Int {
+	wanted := new(big.Int).Mul(
+		hashrate, big.NewInt(int64(TargetShareInterval/time.Second)))
+	result := vd.tiers[0]
+	for _, tier := range vd.tiers {
+		if tier.Cmp(wanted) <= 0 {
+			result = tier
+		}
+	}
+	return result
+}
+
+func (vd *VarDiff) Difficulty(rig smartpool.Rig) *big.Int {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+	t := time.Now()
+	current := vd.rigs[rig.ID()]
+	if current != nil && t.Sub(current.retargeted) < RetargetInterval {
+		return current.diff
+	}
+	diff := vd.target(vd.recorder.RigHashrate(rig))
+	if current != nil && current.diff.Cmp(diff) != 0 {
+		logger.Infof(
+			"Retargeted share difficulty of rig %s from %s to %s.\n",
+			rig.ID(), current.diff.Text(10), diff.Text(10))
+	}
+	vd.rigs[rig.ID()] = &rigDifficulty{diff, t}
+	return diff
+}
+
+// NewVarDiff creates a VarDiff choosing from difficulty tiers which must be
+// in ascending order.
+func NewVarDiff(recorder *StatRecorder, tiers []*big.Int) *VarDiff {
+	return &VarDiff{
+		mu:       sync.Mutex{},
+		recorder: recorder,
+		tiers:    tiers,
+		rigs:     map[string]*rigDifficulty{},
+	}
+}
@@ -0,0 +1,43 @@
+package stat
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"testing"
+)
+
+func newTestVarDiff(recorder *StatRecorder) *VarDiff {
+	return NewVarDiff(recorder, []*big.Int{
+		big.NewInt(1000), big.NewInt(2000), big.NewInt(4000),
+	})
+}
+
+func TestVarDiffGivesLowestTierToNewRig(t *testing.T) {
+	vd := newTestVarDiff(newStatRecorder())
+	if vd.Difficulty(rig).Cmp(big.NewInt(1000)) != 0 {
+		t.Fail()
+	}
+}
+
+func TestVarDiffUsesReportedHashrateWithoutShares(t *testing.T) {
+	recorder := newStatRecorder()
+	recorder.RecordHashrate(hexutil.Uint64(250), common.Hash{}, rig)
+	vd := newTestVarDiff(recorder)
+	// 250 h/s * 10s = 2500
+	if vd.Difficulty(rig).Cmp(big.NewInt(2000)) != 0 {
+		t.Fail()
+	}
+	if vd.Difficulty(rig2).Cmp(big.NewInt(1000)) != 0 {
+		t.Fail()
+	}
+}
+
+func TestVarDiffDoesntExceedHighestTier(t *testing.T) {
+	recorder := newStatRecorder()
+	recorder.RecordHashrate(hexutil.Uint64(1000000), common.Hash{}, rig)
+	vd := newTestVarDiff(recorder)
+	if vd.Difficulty(rig).Cmp(big.NewInt(4000)) != 0 {
+		t.Fail()
+	}
+}
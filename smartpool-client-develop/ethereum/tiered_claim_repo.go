@@ -0,0 +1,156 @@
+package ethereum
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/storage"
+	"math/big"
+)
+
+// TieredClaimRepo keeps a separate share stream for each share difficulty
+// tier so every claim only holds shares of one difficulty and its sampled
+// share's extradata always encodes the claim difficulty.
+// Open claims are shared between tiers because the contract indexes all
+// claims of a miner in one batch.
+// The contract requires each claim's min counter to be greater than the
+// last claim's max counter so when a tier is claimed, shares of other tiers
+// that are not newer than the claim are discarded. The tier with the most
+// difficulty ready to claim is chosen to keep the loss small.
+type TieredClaimRepo struct {
+	base  *TimestampClaimRepo
+	tiers map[string]*TimestampClaimRepo
+	diffs []*big.Int
+}
+
+func tierNamespace(diff *big.Int) string {
+	return fmt.Sprintf("diff-%s", diff.Text(16))
+}
+
+// NewTieredClaimRepo creates a claim repo for difficulty tiers diffs. The
+// first tier keeps using the storage as is so shares from sessions without
+// variable difficulty are restored.
+func NewTieredClaimRepo(diffs []*big.Int, miner, coinbase string, ps smartpool.PersistentStorage, policy RestoreConflictPolicy) *TieredClaimRepo {
+	repo := &TieredClaimRepo{
+		tiers: map[string]*TimestampClaimRepo{},
+		diffs: diffs,
+	}
+	for i, diff := range diffs {
+		var tier *TimestampClaimRepo
+		if i == 0 {
+			tier = NewTimestampClaimRepo(diff, miner, coinbase, ps, policy)
+			repo.base = tier
+		} else {
+			logger.Debugf("Loading shares of difficulty %s...\n", diff.Text(10))
+			tier = NewTimestampClaimRepo(
+				diff, miner, coinbase,
+				storage.NewNamespaceStorage(ps, tierNamespace(diff)),
+				policy,
+			)
+		}
+		repo.tiers[diff.Text(16)] = tier
+	}
+	return repo
+}
+
+func (cr *TieredClaimRepo) AddShare(s smartpool.Share) error {
+	tier := cr.tiers[s.ShareDifficulty().Text(16)]
+	if tier == nil {
+		return errors.New(
+			fmt.Sprintf("unsupported difficulty 0x%s", s.ShareDifficulty().Text(16)))
+	}
+	return tier.AddShare(s)
+}
+
+func (cr *TieredClaimRepo) GetCurrentClaim(threshold int) smartpool.Claim {
+	var chosen *TimestampClaimRepo
+	credit := big.NewInt(0)
+	for _, diff := range cr.diffs {
+		tier := cr.tiers[diff.Text(16)]
+		noShares := tier.NoValidShares()
+		logger.Infof("Difficulty %s: %d valid shares\n", diff.Text(10), noShares)
+		if noShares < uint64(threshold) {
+			continue
+		}
+		tierCredit := new(big.Int).Mul(diff, new(big.Int).SetUint64(noShares))
+		if tierCredit.Cmp(credit) > 0 {
+			chosen = tier
+			credit = tierCredit
+		}
+	}
+	if chosen == nil {
+		return nil
+	}
+	claim := chosen.GetCurrentClaim(threshold)
+	if claim == nil {
+		return nil
+	}
+	for _, tier := range cr.tiers {
+		if tier == chosen {
+			continue
+		}
+		discarded := tier.DiscardSharesUpTo(claim.Max())
+		if discarded > 0 {
+			logger.Warnf(
+				"Discarded %d shares of difficulty %s older than the claim of difficulty %s.\n",
+				discarded, tier.diff.Text(10), chosen.diff.Text(10))
+		}
+	}
+	return claim
+}
+
+func (cr *TieredClaimRepo) PutOpenClaim(claim smartpool.Claim) {
+	cr.base.PutOpenClaim(claim)
+}
+
+func (cr *TieredClaimRepo) RemoveOpenClaim(claim smartpool.Claim) {
+	cr.base.RemoveOpenClaim(claim)
+}
+
+func (cr *TieredClaimRepo) GetOpenClaim(claimIndex int) smartpool.Claim {
+	return cr.base.GetOpenClaim(claimIndex)
+}
+
+func (cr *TieredClaimRepo) LatestOpenClaim() smartpool.Claim {
+	return cr.base.LatestOpenClaim()
+}
+
+func (cr *TieredClaimRepo) ResetOpenClaims() {
+	cr.base.ResetOpenClaims()
+}
+
+func (cr *TieredClaimRepo) OpenClaims() []smartpool.Claim {
+	return cr.base.OpenClaims()
+}
+
+func (cr *TieredClaimRepo) NumOpenClaims() uint64 {
+	return cr.base.NumOpenClaims()
+}
+
+func (cr *TieredClaimRepo) SealClaimBatch() {
+	cr.base.SealClaimBatch()
+}
+
+func (cr *TieredClaimRepo) Persist(ps smartpool.PersistentStorage) error {
+	for _, tier := range cr.tiers {
+		var err error
+		if tier == cr.base {
+			err = tier.Persist(ps)
+		} else {
+			err = tier.Persist(
+				storage.NewNamespaceStorage(ps, tierNamespace(tier.diff)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cr *TieredClaimRepo) NoActiveShares() uint64 {
+	var result uint64
+	for _, tier := range cr.tiers {
+		result += tier.NoActiveShares()
+	}
+	return result
+}
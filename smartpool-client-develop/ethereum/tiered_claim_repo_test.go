@@ -0,0 +1,86 @@
+package ethereum
+
+import (
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTieredRepo(t *testing.T, diffs ...*big.Int) (*TieredClaimRepo, func()) {
+	dir, err := ioutil.TempDir("", "tiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tiers other than the first are kept in namespaces of the storage
+	ps, err := storage.NewBoltStorage(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	repo := NewTieredClaimRepo(
+		diffs,
+		common.HexToAddress("0xe034afdcc2ba0441ff215ee9ba0da3e86450108d").Hex(),
+		common.HexToAddress("0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96").Hex(),
+		ps, RestoreAbort,
+	)
+	return repo, func() {
+		for _, tier := range repo.tiers {
+			if tier.journal != nil {
+				tier.journal.Close()
+			}
+		}
+		ps.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTieredClaimRepoKeepsOneClaimStreamPerTier(t *testing.T) {
+	low, high := big.NewInt(100000), big.NewInt(200000)
+	repo, cleanup := newTieredRepo(t, low, high)
+	defer cleanup()
+	var nonce uint64
+	add := func(diff *big.Int, times ...int64) {
+		for _, time := range times {
+			nonce++
+			s := newJournalTestShare(nonce, time)
+			s.shareDifficulty = diff
+			if err := repo.AddShare(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add(high, 1, 1)
+	add(low, 1, 1, 1, 1, 1, 2)
+	add(high, 3)
+	add(low, 5)
+	// the low tier has more difficulty to claim, the high tier's shares
+	// older than its claim can't be claimed after it
+	first := repo.GetCurrentClaim(1)
+	if first == nil {
+		t.Fatalf("expected a claim")
+	}
+	if first.NumShares().Int64() != 6 || first.Difficulty().Cmp(low) != 0 {
+		t.Fatalf("expected 6 shares of difficulty %s, got %s shares of difficulty %s",
+			low.Text(10), first.NumShares().Text(10), first.Difficulty().Text(10))
+	}
+	if repo.NoActiveShares() != 2 {
+		t.Fatalf("expected 2 shares left, got %d", repo.NoActiveShares())
+	}
+	add(high, 4)
+	second := repo.GetCurrentClaim(1)
+	if second == nil {
+		t.Fatalf("expected a claim")
+	}
+	if second.NumShares().Int64() != 1 || second.Difficulty().Cmp(high) != 0 {
+		t.Fatalf("expected 1 share of difficulty %s, got %s shares of difficulty %s",
+			high.Text(10), second.NumShares().Text(10), second.Difficulty().Text(10))
+	}
+	if second.Min().Cmp(first.Max()) <= 0 {
+		t.Fatalf("expected claim min 0x%s to be greater than last claim max 0x%s",
+			second.Min().Text(16), first.Max().Text(16))
+	}
+}
@@ -31,43 +31,68 @@ type TimestampClaimRepo struct {
 	recentTimestamp *big.Int
 	noShares        uint64
 	noRecentShares  uint64
-	storage         smartpool.PersistentStorage
 	diff            *big.Int
 	miner           string
 	coinbase        string
+	journal         *ShareJournal
 }
 
-func NewTimestampClaimRepo(diff *big.Int, miner, coinbase string, storage smartpool.PersistentStorage) *TimestampClaimRepo {
+// NewTimestampClaimRepo restores shares and claims of last session from
+// storage. Shares accepted after the last snapshot are replayed from the
+// share journal kept next to storage. If they were mined for another miner
+// or difficulty, policy decides what happens to them.
+func NewTimestampClaimRepo(diff *big.Int, miner, coinbase string, storage smartpool.PersistentStorage, policy RestoreConflictPolicy) *TimestampClaimRepo {
 	shares, err := loadActiveShares(storage)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't load active shares from last session (%s). Initialize with empty share pool.\n", err)
+		logger.Warnf("Couldn't load active shares from last session (%s). Initialize with empty share pool.\n", err)
 	}
+	journal, checkpoint, records := openShareJournal(storage)
 	activeClaims, err := loadActiveClaims(storage)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't load active claims from last session (%s). Initialize with empty active claims list.\n", err)
+		logger.Warnf("Couldn't load active claims from last session (%s). Initialize with empty active claims list.\n", err)
 	}
 	openClaims, err := loadOpenClaims(storage)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't load open claims from last session (%s). Initialize with empty open claims list.\n", err)
+		logger.Warnf("Couldn't load open claims from last session (%s). Initialize with empty open claims list.\n", err)
 	}
-	noShares := 0
-	noRecentShares := 0
+	var noShares, noRecentShares uint64
 	currentTimestamp := big.NewInt(0)
+	if checkpoint.RecentTimestamp != nil {
+		currentTimestamp.Set(checkpoint.RecentTimestamp)
+	}
 	changedDiff := false
 	changedMiner := false
 	changedCoinbase := false
-	if len(shares) > 0 {
-		for _, s := range shares {
-			if currentTimestamp.Cmp(s.Timestamp()) < 0 {
-				currentTimestamp.Add(s.Timestamp(), common.Big0)
-			}
+	for _, s := range shares {
+		if currentTimestamp.Cmp(s.Timestamp()) < 0 {
+			currentTimestamp.Add(s.Timestamp(), common.Big0)
+		}
+	}
+	for _, s := range shares {
+		if s.Timestamp().Cmp(currentTimestamp) == 0 {
+			noRecentShares++
+		} else {
+			noShares++
+		}
+	}
+	// replayed shares are counted in the order they were accepted so the
+	// counts are the same as before the crash
+	replayed := 0
+	for _, record := range records {
+		s := record.share()
+		if shares[shareID(s)] != nil {
+			continue
 		}
+		shares[shareID(s)] = s
+		currentTimestamp, noShares, noRecentShares = countShare(
+			s, currentTimestamp, noShares, noRecentShares)
+		replayed++
+	}
+	if replayed > 0 {
+		logger.Infof("Replayed %d shares from share journal.\n", replayed)
+	}
+	if len(shares) > 0 {
 		for _, s := range shares {
-			if s.Timestamp().Cmp(currentTimestamp) == 0 {
-				noRecentShares++
-			} else {
-				noShares++
-			}
 			if s.ShareDifficulty().Cmp(diff) != 0 {
 				changedDiff = true
 			}
@@ -80,66 +105,53 @@ func NewTimestampClaimRepo(diff *big.Int, miner, coinbase string, storage smartp
 		}
 	}
 	var oneShare *Share
+	for _, s := range shares {
+		oneShare = s
+		break
+	}
 	if changedCoinbase {
-		smartpool.Output.Printf("SmartPool contract address changed. Discarded %d shares from last session.\n", len(shares))
+		logger.Warnf("SmartPool contract address changed. Discarded %d shares from last session.\n", len(shares))
 		shares = map[string]*Share{}
 		noShares = 0
 		noRecentShares = 0
 		currentTimestamp = big.NewInt(0)
-	} else if changedMiner {
-		for _, s := range shares {
-			oneShare = s
-			break
+		discardJournal(journal)
+	} else if changedMiner || changedDiff {
+		if changedMiner {
+			logger.Infof("You have %d shares from last session with miner %s that were not submitted to the contract.\n", len(shares), oneShare.MinerAddress())
+			logger.Infof("However you are going to run SmartPool with different miner %s.\n", miner)
+		} else {
+			logger.Infof("You have %d shares from last session with difficulty %s that were not submitted to the contract.\n", len(shares), oneShare.ShareDifficulty().Text(10))
+			logger.Infof("However you are going to run SmartPool with different share difficulty %s.\n", diff.Text(10))
 		}
-		fmt.Printf("You have %d shares from last session with miner %s that were not submitted to the contract.\n", len(shares), oneShare.BlockHeader().Coinbase.Hex())
-		fmt.Printf("However you are going to run SmartPool with different miner %s.\n", miner)
-		fmt.Printf("Please choose one of following options:\n")
-		fmt.Printf("1. Discard those shares and continue running SmartPool with new miner.\n")
-		fmt.Printf("2. Abort SmartPool and rerun it with --miner %s\n", oneShare.MinerAddress())
-		var choice string
-		for {
-			fmt.Printf("Enter 1 or 2: ")
-			fmt.Scanf("%s", &choice)
-			if choice == "1" {
-				shares = map[string]*Share{}
-				activeClaims = []smartpool.Claim{}
-				openClaims = []smartpool.Claim{}
-				noShares = 0
-				noRecentShares = 0
-				currentTimestamp = big.NewInt(0)
-				smartpool.Output.Printf("You chose to discard the shares from last session.\n")
-				break
-			} else if choice == "2" {
+		switch policy {
+		case RestoreDiscard:
+			logger.Warnf("Discarding the shares from last session (--on-restore-conflict=discard).\n")
+		case RestoreArchive:
+			archive, err := archiveSession(
+				storage, oneShare.MinerAddress(), oneShare.ShareDifficulty(),
+				shares, activeClaims, openClaims)
+			if err != nil {
+				logger.Errorf("Couldn't archive the shares from last session (%s). Abort!\n", err)
 				os.Exit(1)
 			}
-		}
-	} else if changedDiff {
-		for _, s := range shares {
-			oneShare = s
-			break
-		}
-		fmt.Printf("You have %d shares from last session with difficulty %s that were not submitted to the contract.\n", len(shares), oneShare.ShareDifficulty().Text(10))
-		fmt.Printf("However you are going to run SmartPool with different share difficulty %s.\n", diff.Text(10))
-		fmt.Printf("Please choose one of following options:\n")
-		fmt.Printf("1. Discard those shares and continue running SmartPool with new difficulty.\n")
-		fmt.Printf("2. Abort SmartPool and rerun it with --diff %s\n", oneShare.ShareDifficulty().Text(10))
-		var choice string
-		for {
-			fmt.Printf("Enter 1 or 2: ")
-			fmt.Scanf("%s", &choice)
-			if choice == "1" {
-				shares = map[string]*Share{}
-				activeClaims = []smartpool.Claim{}
-				openClaims = []smartpool.Claim{}
-				noShares = 0
-				noRecentShares = 0
-				currentTimestamp = big.NewInt(0)
-				smartpool.Output.Printf("You chose to discard the shares from last session.\n")
-				break
-			} else if choice == "2" {
-				os.Exit(1)
+			logger.Infof("Archived the shares from last session to %s.\n", archive.Namespace)
+		default:
+			if changedMiner {
+				logger.Infof("Rerun SmartPool with --miner %s to submit them.\n", oneShare.MinerAddress())
+			} else {
+				logger.Infof("Rerun SmartPool with --diff %s to submit them.\n", oneShare.ShareDifficulty().Text(10))
 			}
+			logger.Errorf("Or run with --on-restore-conflict=discard or --on-restore-conflict=archive to continue without them. Abort!\n")
+			os.Exit(1)
 		}
+		shares = map[string]*Share{}
+		activeClaims = []smartpool.Claim{}
+		openClaims = []smartpool.Claim{}
+		noShares = 0
+		noRecentShares = 0
+		currentTimestamp = big.NewInt(0)
+		discardJournal(journal)
 	}
 	cr := TimestampClaimRepo{
 		shares,
@@ -148,16 +160,16 @@ func NewTimestampClaimRepo(diff *big.Int, miner, coinbase string, storage smartp
 		openClaims,
 		sync.RWMutex{},
 		currentTimestamp,
-		uint64(noShares),
-		uint64(noRecentShares),
-		storage,
+		noShares,
+		noRecentShares,
 		diff,
 		miner,
 		coinbase,
+		journal,
 	}
-	smartpool.Output.Printf("Loaded %d valid shares\n", noShares)
-	smartpool.Output.Printf("Loaded timestamp: 0x%s\n", currentTimestamp.Text(16))
-	smartpool.Output.Printf("Loaded %d shares with current timestamp\n", noRecentShares)
+	logger.Debugf("Loaded %d valid shares\n", noShares)
+	logger.Debugf("Loaded timestamp: 0x%s\n", currentTimestamp.Text(16))
+	logger.Debugf("Loaded %d shares with current timestamp\n", noRecentShares)
 	return &cr
 }
 
@@ -196,6 +208,7 @@ func loadClaims(storage smartpool.PersistentStorage, file string) ([]smartpool.C
 				s.MinerAddress,
 				s.SolutionState,
 				nil,
+				"",
 			})
 		}
 		cl.SetEvidence(claim.ShareIndex)
@@ -229,6 +242,7 @@ func loadActiveShares(storage smartpool.PersistentStorage) (map[string]*Share, e
 			gobShare.MinerAddress,
 			gobShare.SolutionState,
 			nil,
+			"",
 		}
 	}
 	return shares, nil
@@ -238,8 +252,48 @@ func (cr *TimestampClaimRepo) NoActiveShares() uint64 {
 	return cr.noShares + cr.noRecentShares
 }
 
+// NoValidShares returns number of shares that can be put into a claim now.
+func (cr *TimestampClaimRepo) NoValidShares() uint64 {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.noShares
+}
+
+// ReleaseRecentShares lets shares with the most recent timestamp be put
+// into the next claim. It must only be called when no more shares are
+// added, e.g. to an archived session, because later shares could have
+// smaller counters than the claim.
+func (cr *TimestampClaimRepo) ReleaseRecentShares() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.noShares += cr.noRecentShares
+	cr.noRecentShares = 0
+	cr.recentTimestamp = new(big.Int).Add(cr.recentTimestamp, common.Big1)
+}
+
+// DiscardSharesUpTo removes shares whose counter is not greater than counter.
+// Those shares can't be claimed anymore because the contract only accepts
+// claims having min counter greater than the last submitted claim's max.
+func (cr *TimestampClaimRepo) DiscardSharesUpTo(counter *big.Int) uint64 {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var discarded uint64
+	for shareID, s := range cr.activeShares {
+		if s.Counter().Cmp(counter) <= 0 {
+			delete(cr.activeShares, shareID)
+			if s.Timestamp().Cmp(cr.recentTimestamp) == 0 {
+				cr.noRecentShares--
+			} else {
+				cr.noShares--
+			}
+			discarded++
+		}
+	}
+	return discarded
+}
+
 func (cr *TimestampClaimRepo) Persist(storage smartpool.PersistentStorage) error {
-	smartpool.Output.Printf("Saving active shares to disk...\n")
+	logger.Debugf("Saving active shares to disk...\n")
 	cr.mu.RLock()
 	defer cr.mu.RUnlock()
 	gobShares := map[string]gobShare{}
@@ -261,26 +315,32 @@ func (cr *TimestampClaimRepo) Persist(storage smartpool.PersistentStorage) error
 		// fmt.Printf("share json: %s\n", shareJson)
 	}
 	if err := storage.Persist(&gobShares, ACTIVE_SHARE_FILE); err != nil {
-		smartpool.Output.Printf("Failed. (%s)\n", err.Error())
+		logger.Warnf("Failed. (%s)\n", err.Error())
 		return err
 	} else {
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
+	}
+	if cr.journal != nil {
+		if err := cr.journal.persistCheckpoint(storage, cr.recentTimestamp); err != nil {
+			logger.Warnf("Couldn't persist share checkpoint. (%s)\n", err.Error())
+			return err
+		}
 	}
 	cr.claimMu.RLock()
 	defer cr.claimMu.RUnlock()
-	smartpool.Output.Printf("Saving active claims to disk...\n")
+	logger.Debugf("Saving active claims to disk...\n")
 	if err := cr.persistActiveClaims(storage); err != nil {
-		smartpool.Output.Printf("Failed. (%s)\n", err.Error())
+		logger.Warnf("Failed. (%s)\n", err.Error())
 		return err
 	} else {
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
 	}
-	smartpool.Output.Printf("Saving open claims to disk...\n")
+	logger.Debugf("Saving open claims to disk...\n")
 	if err := cr.persistOpenClaims(storage); err != nil {
-		smartpool.Output.Printf("Failed. (%s)\n", err.Error())
+		logger.Warnf("Failed. (%s)\n", err.Error())
 		return err
 	} else {
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
 	}
 	return nil
 }
@@ -320,7 +380,11 @@ func (cr *TimestampClaimRepo) persistClaims(claims []smartpool.Claim, storage sm
 func (cr *TimestampClaimRepo) AddShare(s smartpool.Share) error {
 	cr.mu.Lock()
 	defer cr.mu.Unlock()
-	share := s.(*Share)
+	return cr.addShare(s.(*Share))
+}
+
+// addShare adds share to the repo. The caller must hold cr.mu.
+func (cr *TimestampClaimRepo) addShare(share *Share) error {
 	shareID := fmt.Sprintf(
 		"%s-%v",
 		share.BlockHeader().Hash().Hex(),
@@ -337,29 +401,60 @@ func (cr *TimestampClaimRepo) AddShare(s smartpool.Share) error {
 			fmt.Sprintf("inconsistent difficulty (expected 0x%s, got 0x%s)", cr.diff.Text(16), share.ShareDifficulty().Text(16)))
 	}
 	if cr.activeShares[shareID] != nil {
-		return errors.New("duplicated share")
+		return smartpool.ErrDuplicatedShare
 	} else {
 		cr.activeShares[shareID] = share
 	}
-	if share.Timestamp().Cmp(cr.recentTimestamp) == 0 {
-		cr.noRecentShares++
-	} else if share.Timestamp().Cmp(cr.recentTimestamp) < 0 {
-		cr.noShares++
-	} else if share.Timestamp().Cmp(cr.recentTimestamp) > 0 {
-		cr.noShares += cr.noRecentShares
-		cr.noRecentShares = 1
-		cr.recentTimestamp = big.NewInt(0)
-		cr.recentTimestamp.Add(share.Timestamp(), common.Big0)
+	if cr.journal != nil {
+		if err := cr.journal.Append(share); err != nil {
+			logger.Warnf("Couldn't write share to share journal (%s). It is only persisted with the next snapshot.\n", err)
+		}
 	}
+	cr.recentTimestamp, cr.noShares, cr.noRecentShares = countShare(
+		share, cr.recentTimestamp, cr.noShares, cr.noRecentShares)
 	return nil
 }
 
+// countShare counts a new share in the number of shares with the most
+// recent timestamp or older.
+func countShare(share *Share, recentTimestamp *big.Int, noShares, noRecentShares uint64) (*big.Int, uint64, uint64) {
+	if share.Timestamp().Cmp(recentTimestamp) == 0 {
+		noRecentShares++
+	} else if share.Timestamp().Cmp(recentTimestamp) < 0 {
+		noShares++
+	} else if share.Timestamp().Cmp(recentTimestamp) > 0 {
+		noShares += noRecentShares
+		noRecentShares = 1
+		recentTimestamp = big.NewInt(0)
+		recentTimestamp.Add(share.Timestamp(), common.Big0)
+	}
+	return recentTimestamp, noShares, noRecentShares
+}
+
+func shareID(s *Share) string {
+	return fmt.Sprintf(
+		"%s-%v",
+		s.BlockHeader().Hash().Hex(),
+		s.Nonce())
+}
+
+// discardJournal drops all shares in journal once the shares of last
+// session are discarded or archived so they aren't replayed again.
+func discardJournal(journal *ShareJournal) {
+	if journal == nil {
+		return
+	}
+	if err := journal.Compact(journal.Seq()); err != nil {
+		logger.Warnf("Couldn't discard shares in share journal (%s).\n", err)
+	}
+}
+
 func (cr *TimestampClaimRepo) getCurrentClaim(threshold int) smartpool.Claim {
 	cr.mu.Lock()
 	defer cr.mu.Unlock()
-	smartpool.Output.Printf("Have %d valid shares\n", cr.noShares)
-	smartpool.Output.Printf("Current timestamp: 0x%s\n", cr.recentTimestamp.Text(16))
-	smartpool.Output.Printf("Shares with current timestamp: %d\n", cr.noRecentShares)
+	logger.Debugf("Have %d valid shares\n", cr.noShares)
+	logger.Debugf("Current timestamp: 0x%s\n", cr.recentTimestamp.Text(16))
+	logger.Debugf("Shares with current timestamp: %d\n", cr.noRecentShares)
 	if cr.noShares < uint64(threshold) {
 		return nil
 	}
@@ -404,6 +499,23 @@ func (cr *TimestampClaimRepo) GetOpenClaim(index int) smartpool.Claim {
 	}
 }
 
+func (cr *TimestampClaimRepo) LatestOpenClaim() smartpool.Claim {
+	cr.claimMu.Lock()
+	defer cr.claimMu.Unlock()
+	if len(cr.activeClaims) == 0 {
+		return nil
+	}
+	return cr.activeClaims[len(cr.activeClaims)-1]
+}
+
+func (cr *TimestampClaimRepo) OpenClaims() []smartpool.Claim {
+	cr.claimMu.Lock()
+	defer cr.claimMu.Unlock()
+	claims := make([]smartpool.Claim, len(cr.activeClaims))
+	copy(claims, cr.activeClaims)
+	return claims
+}
+
 func (cr *TimestampClaimRepo) SealClaimBatch() {
 	cr.claimMu.Lock()
 	defer cr.claimMu.Unlock()
@@ -423,8 +535,8 @@ func (cr *TimestampClaimRepo) ResetOpenClaims() {
 	cr.activeClaims = []smartpool.Claim{}
 }
 
+// GetCurrentClaim doesn't persist the repo. SmartPool persists it together
+// with the latest counter so both are updated atomically.
 func (cr *TimestampClaimRepo) GetCurrentClaim(threshold int) smartpool.Claim {
-	c := cr.getCurrentClaim(threshold)
-	cr.Persist(cr.storage)
-	return c
+	return cr.getCurrentClaim(threshold)
 }
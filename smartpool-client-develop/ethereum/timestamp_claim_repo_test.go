@@ -3,9 +3,14 @@ package ethereum
 import (
 	"encoding/json"
 	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/storage"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -13,9 +18,10 @@ import (
 func newRepo() *TimestampClaimRepo {
 	return NewTimestampClaimRepo(
 		big.NewInt(100000),
-		"0xe034afdcc2ba0441ff215ee9ba0da3e86450108d",
-		"0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96",
+		common.HexToAddress("0xe034afdcc2ba0441ff215ee9ba0da3e86450108d").Hex(),
+		common.HexToAddress("0x9af93376af1ddd22fa2e94fd0a030b3dea96bb96").Hex(),
 		&testPersistentStorage{},
+		RestoreAbort,
 	)
 }
 
@@ -31,6 +37,7 @@ func newTestShare() *Share {
 		s.MinerAddress,
 		s.SolutionState,
 		nil,
+		"",
 	}
 }
 
@@ -61,18 +68,15 @@ func endlessPersist(repo *TimestampClaimRepo, storage smartpool.PersistentStorag
 	}
 }
 
-func endlessGetCurrentClaim(t *testing.T, repo *TimestampClaimRepo, stop chan bool) {
-	var claim smartpool.Claim
+// getCurrentClaimUntilFound sends the first claim it gets to found.
+func getCurrentClaimUntilFound(repo *TimestampClaimRepo, found chan smartpool.Claim, stop chan bool) {
 	for {
 		select {
 		case <-stop:
-			if claim == nil {
-				t.Fail()
-			}
 			return
 		default:
-			claim = repo.GetCurrentClaim(2)
-			if claim != nil {
+			if claim := repo.GetCurrentClaim(2); claim != nil {
+				found <- claim
 				return
 			}
 		}
@@ -85,12 +89,106 @@ func TestPersistAndAddShareAndGetCurrentClaimConcurrently(t *testing.T) {
 	stopAddingShare := make(chan bool, 1)
 	stopPersisting := make(chan bool, 1)
 	stopGettingClaim := make(chan bool, 1)
+	found := make(chan smartpool.Claim, 1)
 	go endlessAddShare(repo, stopAddingShare)
 	go endlessPersist(repo, storage, stopPersisting)
-	go endlessGetCurrentClaim(t, repo, stopGettingClaim)
-	time.Sleep(50 * time.Millisecond)
-	stopAddingShare <- true
-	stopPersisting <- true
-	stopGettingClaim <- true
-	time.Sleep(50 * time.Millisecond)
+	go getCurrentClaimUntilFound(repo, found, stopGettingClaim)
+	defer func() {
+		stopAddingShare <- true
+		stopPersisting <- true
+		stopGettingClaim <- true
+	}()
+	select {
+	case <-found:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a claim while shares are added and persisted")
+	}
+}
+
+func TestArchiveSharesWhenDifficultyChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smartpool")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	bs, err := storage.NewBoltStorage(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("couldn't open storage: %s", err)
+	}
+	defer bs.Close()
+	repo := newRepo()
+	if err = repo.AddShare(newTestShare()); err != nil {
+		t.Fatalf("couldn't add share: %s", err)
+	}
+	if err = repo.Persist(bs); err != nil {
+		t.Fatalf("persist failed: %s", err)
+	}
+	repo = NewTimestampClaimRepo(
+		big.NewInt(200000), repo.miner, repo.coinbase, bs, RestoreArchive)
+	if repo.NoActiveShares() != 0 {
+		t.Fatalf("expected shares of the old difficulty to be archived")
+	}
+	archives, err := LoadArchives(bs)
+	if err != nil || len(archives) != 1 {
+		t.Fatalf("expected 1 archive, got %d (%v)", len(archives), err)
+	}
+	if archives[0].NumShares != 1 || archives[0].Difficulty.Cmp(big.NewInt(100000)) != 0 {
+		t.Fatalf("expected archive of 1 share of difficulty 100000, got %d shares of %s",
+			archives[0].NumShares, archives[0].Difficulty)
+	}
+	restored := NewTimestampClaimRepo(
+		archives[0].Difficulty, archives[0].Miner, repo.coinbase,
+		archives[0].Storage(bs), RestoreAbort)
+	if restored.NoActiveShares() != 1 {
+		t.Fatalf("expected the archived share to be restored, got %d", restored.NoActiveShares())
+	}
+	// the session isn't archived again on the next start
+	repo = NewTimestampClaimRepo(
+		big.NewInt(200000), repo.miner, repo.coinbase, bs, RestoreAbort)
+	if repo.NoActiveShares() != 0 {
+		t.Fatalf("expected the session not to be archived again")
+	}
+}
+
+func TestArchivesOfTheSameSecondDontOverwriteEachOther(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smartpool")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	bs, err := storage.NewBoltStorage(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("couldn't open storage: %s", err)
+	}
+	defer bs.Close()
+	for i := 0; i < 2; i++ {
+		shares := map[string]*Share{"share": newTestShare()}
+		if _, err = archiveSession(
+			bs, "miner", big.NewInt(100000), shares,
+			[]smartpool.Claim{}, []smartpool.Claim{}); err != nil {
+			t.Fatalf("couldn't archive session: %s", err)
+		}
+	}
+	archives, err := LoadArchives(bs)
+	if err != nil || len(archives) != 2 {
+		t.Fatalf("expected 2 archives, got %d (%v)", len(archives), err)
+	}
+	if archives[0].Namespace == archives[1].Namespace {
+		t.Fatalf("expected archives to have different namespaces, both are %s", archives[0].Namespace)
+	}
+}
+
+func TestReleaseRecentSharesOfArchivedSession(t *testing.T) {
+	repo := newRepo()
+	if err := repo.AddShare(newTestShare()); err != nil {
+		t.Fatalf("couldn't add share: %s", err)
+	}
+	if claim := repo.GetCurrentClaim(1); claim != nil {
+		t.Fatalf("expected the share with the most recent timestamp to be held back")
+	}
+	repo.ReleaseRecentShares()
+	claim := repo.GetCurrentClaim(1)
+	if claim == nil || claim.NumShares().Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("expected a claim of the released share, got %v", claim)
+	}
 }
@@ -7,11 +7,14 @@ import (
 	"math/big"
 )
 
-func BuildExtraData(address common.Address, diff *big.Int) string {
+func minerID(address common.Address) *big.Int {
 	// id = address % (26+26+10)**11
 	base := big.NewInt(0)
 	base.Exp(big.NewInt(62), big.NewInt(11), nil)
 	id := big.NewInt(0)
-	id.Mod(address.Big(), base)
-	return fmt.Sprintf("SmartPool-%s%s", smartpool.BigToBase62(id), smartpool.BigToBase62(diff))
+	return id.Mod(address.Big(), base)
+}
+
+func BuildExtraData(address common.Address, diff *big.Int) string {
+	return fmt.Sprintf("SmartPool-%s%s", smartpool.BigToBase62(minerID(address)), smartpool.BigToBase62(diff))
 }
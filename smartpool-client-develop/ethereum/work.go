@@ -65,6 +65,22 @@ func (w *Work) AcceptSolution(sol smartpool.Solution) smartpool.Share {
 	return s
 }
 
+// ForDifficulty returns the work with share difficulty diff. Its header
+// carries extradata encoding diff as the contract expects, so its pow hash
+// differs from the node's work and a full block solution of it can't be
+// submitted to the node.
+func (w *Work) ForDifficulty(diff *big.Int) *Work {
+	if diff.Cmp(w.ShareDifficulty) == 0 {
+		return w
+	}
+	header := types.CopyHeader(w.BlockHeader)
+	header.Extra = []byte(BuildExtraData(common.HexToAddress(w.MinerAddress), diff))
+	return &Work{
+		header, header.HashNoNonce().Hex(), w.SeedHash, diff,
+		w.MinerAddress, w.CreatedAt,
+	}
+}
+
 func (w *Work) PoWHash() common.Hash {
 	return common.HexToHash(w.Hash)
 }
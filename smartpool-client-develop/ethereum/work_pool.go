@@ -1,7 +1,11 @@
 package ethereum
 
 import (
+	"bytes"
+	"errors"
 	"github.com/SmartPool/smartpool-client"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
 	"sync"
 	"time"
 )
@@ -9,11 +13,24 @@ import (
 // workpool keeps track of pending works to ensure that each submitted solution
 // can actually be accepted by a real pow work.
 // workpool also implements ShareReceiver interface.
+// It also follows the chain head through the works it is given to know if
+// a work is still current, stale or orphaned.
 type WorkPool struct {
-	mu    sync.RWMutex
-	works map[string]*Work
+	mu       sync.RWMutex
+	works    map[string]*Work
+	chain    *canonicalChain
+	digester MixDigester
 }
 
+// ACTIVE_WORK_WINDOW is how long rigs are expected to keep mining a work
+// after it was last fetched from the node.
+var ACTIVE_WORK_WINDOW = 30 * time.Second
+
+// MAX_UNCLE_DEPTH is how many blocks the chain head can be ahead of a
+// stale work's parent for a block of the work to still be included as an
+// uncle.
+var MAX_UNCLE_DEPTH uint64 = 6
+
 const (
 	WORKPOOL_FILE     string = "workpool"
 	FullBlockSolution int    = 2
@@ -21,36 +38,94 @@ const (
 	InvalidShare      int    = 0
 )
 
+var errWorkNotFound = errors.New("work not found")
+
+// MixDigester computes the ethash mix digest and pow value of a header hash
+// and nonce. ethash.Ethash implements it with the light cache of the
+// block's epoch.
+type MixDigester interface {
+	Hashimoto(blockNumber uint64, hash common.Hash, nonce uint64) ([]byte, []byte)
+}
+
 // AcceptSolution takes solution and find corresponding work and return
 // associated share.
 // It returns nil if the work is not found.
 func (wp *WorkPool) AcceptSolution(s smartpool.Solution) smartpool.Share {
+	share, err := wp.CheckSolution(s)
+	if err != nil {
+		return nil
+	}
+	return share
+}
+
+// CheckSolution returns the share of s or why s is rejected. When mix
+// digests are verified, a share whose mix digest is not the one ethash
+// gives is rejected with smartpool.ErrBadMixDigest.
+func (wp *WorkPool) CheckSolution(s smartpool.Solution) (smartpool.Share, error) {
 	wp.mu.RLock()
-	defer wp.mu.RUnlock()
 	work := wp.works[s.WorkID()]
+	size := len(wp.works)
+	status := ""
+	if work != nil {
+		status = wp.chain.status(work)
+	}
+	head := wp.chain.head
+	digester := wp.digester
+	wp.mu.RUnlock()
 	if work == nil {
-		smartpool.Output.Printf("work (%v) doesn't exist in workpool (len: %d)\n", s, len(wp.works))
-		return nil
+		logger.Warnf("work (%v) doesn't exist in workpool (len: %d)\n", s, size)
+		return nil, errWorkNotFound
 	}
 	share := work.AcceptSolution(s).(*Share)
 	if share.SolutionState == InvalidShare {
-		smartpool.Output.Printf("Solution (%v) is invalid\n", s)
-		return nil
-	} else {
-		// smartpool.Output.Printf(
-		// 	"Create share for work: ID: %s - createdAt: %s - timestamp: 0x%s\n",
-		// 	work.ID(),
-		// 	work.CreatedAt(),
-		// 	work.BlockHeader().Time.Text(16),
-		// )
-		return share
+		logger.Warnf("Solution (%v) is invalid\n", s)
+		return nil, smartpool.ErrInvalidSolution
+	}
+	// hashimoto takes a while so it is done without holding the lock
+	if digester != nil {
+		digest, _ := digester.Hashimoto(share.NumberU64(), share.HashNoNonce(), share.Nonce())
+		if !bytes.Equal(digest, share.MixDigest().Bytes()) {
+			logger.Warnf("Solution (%v) has a bad mix digest\n", s)
+			return nil, smartpool.ErrBadMixDigest
+		}
+	}
+	share.workStatus = status
+	if status != smartpool.CurrentWork {
+		logger.Debugf(
+			"Solution (%v) is for %s work of block %s, chain head is at %d\n",
+			s, status, work.BlockHeader.Number, head)
 	}
+	return share, nil
+}
+
+// VerifyMixDigest makes solutions verified against the mix digest digester
+// computes. Without it, only the pow value of the miner's mix digest is
+// checked so a fake mix digest is only caught by the contract.
+func (wp *WorkPool) VerifyMixDigest(digester MixDigester) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.digester = digester
 }
 
 func (wp *WorkPool) AddWork(w *Work) {
 	wp.mu.Lock()
 	defer wp.mu.Unlock()
 	wp.works[w.ID()] = w
+	wp.chain.add(w)
+}
+
+// Work returns the work with id or nil if it is not in the pool.
+func (wp *WorkPool) Work(id string) *Work {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return wp.works[id]
+}
+
+// Size returns number of works in the pool.
+func (wp *WorkPool) Size() int {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return len(wp.works)
 }
 
 func (wp *WorkPool) RemoveWork(hash string) {
@@ -59,6 +134,27 @@ func (wp *WorkPool) RemoveWork(hash string) {
 	delete(wp.works, hash)
 }
 
+// SafeCounterBound returns the smallest counter a share of a work fetched
+// in the last ACTIVE_WORK_WINDOW can have. It returns nil if no work was
+// fetched in the window.
+func (wp *WorkPool) SafeCounterBound() *big.Int {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	var timestamp *big.Int
+	for _, work := range wp.works {
+		if time.Since(work.CreatedAt) > ACTIVE_WORK_WINDOW {
+			continue
+		}
+		if timestamp == nil || work.BlockHeader.Time.Cmp(timestamp) < 0 {
+			timestamp = work.BlockHeader.Time
+		}
+	}
+	if timestamp == nil {
+		return nil
+	}
+	return new(big.Int).Lsh(timestamp, 64)
+}
+
 func (wp *WorkPool) oldHashes() []string {
 	wp.mu.RLock()
 	defer wp.mu.RUnlock()
@@ -77,7 +173,7 @@ func (wp *WorkPool) Clean() {
 		wp.RemoveWork(hash)
 	}
 	if len(oldHashes) > 0 {
-		smartpool.Output.Printf("Cleaned %d old works.\n", len(oldHashes))
+		logger.Infof("Cleaned %d old works.\n", len(oldHashes))
 	}
 }
 
@@ -91,12 +187,12 @@ func (wp *WorkPool) RunCleaner() {
 func (wp *WorkPool) Persist(storage smartpool.PersistentStorage) error {
 	wp.mu.RLock()
 	defer wp.mu.RUnlock()
-	smartpool.Output.Printf("Saving workpool to disk...\n")
+	logger.Debugf("Saving workpool to disk...\n")
 	err := storage.Persist(&wp.works, WORKPOOL_FILE)
 	if err == nil {
-		smartpool.Output.Printf("Done.\n")
+		logger.Debugf("Done.\n")
 	} else {
-		smartpool.Output.Printf("Failed. (%s)\n", err.Error())
+		logger.Warnf("Failed. (%s)\n", err.Error())
 	}
 	return err
 }
@@ -104,14 +200,14 @@ func (wp *WorkPool) Persist(storage smartpool.PersistentStorage) error {
 func NewWorkPool(storage smartpool.PersistentStorage) *WorkPool {
 	wp, err := loadWorkPool(storage)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't load workpool from last session (%s). Initialize with empty workpool.\n", err)
+		logger.Warnf("Couldn't load workpool from last session (%s). Initialize with empty workpool.\n", err)
 	}
-	smartpool.Output.Printf("Loaded %d works from last session.\n", len(wp.works))
+	logger.Debugf("Loaded %d works from last session.\n", len(wp.works))
 	return wp
 }
 
 func loadWorkPool(storage smartpool.PersistentStorage) (*WorkPool, error) {
-	wp := &WorkPool{sync.RWMutex{}, map[string]*Work{}}
+	wp := &WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
 	works := map[string]*Work{}
 	loadedWorks, err := storage.Load(&works, WORKPOOL_FILE)
 	if err != nil {
@@ -120,3 +216,62 @@ func loadWorkPool(storage smartpool.PersistentStorage) (*WorkPool, error) {
 	wp.works = *loadedWorks.(*map[string]*Work)
 	return wp, err
 }
+
+// canonicalChain remembers the parent hash of the latest works by block
+// number. The node always gives work on top of its head so the parent of
+// the highest work is the chain head.
+type canonicalChain struct {
+	head    uint64
+	parents map[uint64]common.Hash
+}
+
+func newCanonicalChain() *canonicalChain {
+	return &canonicalChain{0, map[uint64]common.Hash{}}
+}
+
+// add moves the head to the parent of w if it is not below the head. A work
+// with the same parent number but a different parent hash means the node
+// reorganized its head. Works below the head are from a node that is
+// lagging behind and don't change the chain.
+func (c *canonicalChain) add(w *Work) {
+	number := w.BlockHeader.Number.Uint64()
+	if number == 0 {
+		return
+	}
+	parent := number - 1
+	if parent < c.head {
+		return
+	}
+	if parent > c.head {
+		for n := range c.parents {
+			if n+MAX_UNCLE_DEPTH < parent {
+				delete(c.parents, n)
+			}
+		}
+	}
+	c.head = parent
+	c.parents[parent] = w.BlockHeader.ParentHash
+}
+
+func (c *canonicalChain) status(w *Work) string {
+	number := w.BlockHeader.Number.Uint64()
+	if number == 0 {
+		return smartpool.CurrentWork
+	}
+	parent := number - 1
+	if parent > c.head {
+		// the work was restored from last session and no newer work has
+		// been seen yet
+		return smartpool.CurrentWork
+	}
+	if c.head-parent > MAX_UNCLE_DEPTH {
+		return smartpool.OrphanedWork
+	}
+	if hash, known := c.parents[parent]; known && hash != w.BlockHeader.ParentHash {
+		return smartpool.OrphanedWork
+	}
+	if parent == c.head {
+		return smartpool.CurrentWork
+	}
+	return smartpool.StaleWork
+}
@@ -1,6 +1,8 @@
 package ethereum
 
 import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -35,7 +37,7 @@ func newTestSolution() *Solution {
 func TestWorkPoolAcceptSolution(t *testing.T) {
 	w := newTestWork()
 	s := newTestSolution()
-	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}}
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
 	wp.works[w.ID()] = w
 	if wp.AcceptSolution(s) == nil {
 		t.Fail()
@@ -43,7 +45,7 @@ func TestWorkPoolAcceptSolution(t *testing.T) {
 }
 
 func TestWorkPoolDoesntAcceptSolution(t *testing.T) {
-	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}}
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
 	s := newTestSolution()
 	if wp.AcceptSolution(s) != nil {
 		t.Fail()
@@ -51,7 +53,7 @@ func TestWorkPoolDoesntAcceptSolution(t *testing.T) {
 }
 
 func TestWorkPoolAddWorkByItsID(t *testing.T) {
-	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}}
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
 	w := newTestWork()
 	wp.AddWork(w)
 	if wp.works[w.ID()] == nil {
@@ -82,7 +84,7 @@ func endlessClean(wp *WorkPool, stop chan bool) {
 }
 
 func TestAddWorkConcurrently(t *testing.T) {
-	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}}
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
 	w := newTestWork()
 	w.CreatedAt = w.CreatedAt.Add(-8 * 12 * time.Second)
 	wp.AddWork(w)
@@ -93,3 +95,83 @@ func TestAddWorkConcurrently(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 	stop <- true
 }
+
+func newChainTestWork(number int64, parent string) *Work {
+	w := newTestWork()
+	w.BlockHeader.Number = big.NewInt(number)
+	w.BlockHeader.ParentHash = common.HexToHash(parent)
+	w.Hash = fmt.Sprintf("0x%x%s", number, parent)
+	return w
+}
+
+func TestWorkPoolClassifiesWorksByChainHead(t *testing.T) {
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
+	w10 := newChainTestWork(10, "0xa")
+	wp.AddWork(w10)
+	if wp.chain.status(w10) != smartpool.CurrentWork {
+		t.Fatalf("expected work on the head to be current")
+	}
+	w11 := newChainTestWork(11, "0xb")
+	wp.AddWork(w11)
+	if wp.chain.status(w10) != smartpool.StaleWork {
+		t.Fatalf("expected work on a superseded parent to be stale")
+	}
+	// a work from a lagging node doesn't move the head back
+	wp.AddWork(newChainTestWork(10, "0xa"))
+	if wp.chain.status(w11) != smartpool.CurrentWork {
+		t.Fatalf("expected work on the head to stay current")
+	}
+	// the node reorganized block 10
+	wp.AddWork(newChainTestWork(11, "0xc"))
+	if wp.chain.status(w11) != smartpool.OrphanedWork {
+		t.Fatalf("expected work on a reorganized parent to be orphaned")
+	}
+	if wp.chain.status(w10) != smartpool.StaleWork {
+		t.Fatalf("expected work on a canonical parent to stay stale")
+	}
+	wp.AddWork(newChainTestWork(int64(10+MAX_UNCLE_DEPTH+1), "0xd"))
+	if wp.chain.status(w10) != smartpool.OrphanedWork {
+		t.Fatalf("expected work too deep to be an uncle to be orphaned")
+	}
+}
+
+func TestWorkPoolAcceptSolutionOfStaleWork(t *testing.T) {
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
+	w := newTestWork()
+	wp.AddWork(w)
+	wp.AddWork(newChainTestWork(w.BlockHeader.Number.Int64()+2, "0xe"))
+	share := wp.AcceptSolution(newTestSolution())
+	if share == nil {
+		t.Fatalf("expected solution of a stale work to be accepted")
+	}
+	if share.(*Share).WorkStatus() != smartpool.StaleWork {
+		t.Fatalf("expected share to be stale, got %s", share.(*Share).WorkStatus())
+	}
+}
+
+// testDigester gives digest as the mix digest of every nonce.
+type testDigester struct {
+	digest common.Hash
+}
+
+func (d *testDigester) Hashimoto(blockNumber uint64, hash common.Hash, nonce uint64) ([]byte, []byte) {
+	return d.digest.Bytes(), nil
+}
+
+func TestWorkPoolRejectsBadMixDigest(t *testing.T) {
+	wp := WorkPool{sync.RWMutex{}, map[string]*Work{}, newCanonicalChain(), nil}
+	w := newTestWork()
+	wp.AddWork(w)
+	s := newTestSolution()
+	wp.VerifyMixDigest(&testDigester{s.MixDigest})
+	if _, err := wp.CheckSolution(s); err != nil {
+		t.Fatalf("expected solution with the right mix digest to be accepted: %s", err)
+	}
+	wp.VerifyMixDigest(&testDigester{common.HexToHash("0x1")})
+	if _, err := wp.CheckSolution(s); err != smartpool.ErrBadMixDigest {
+		t.Fatalf("expected solution to be rejected for its mix digest, got %v", err)
+	}
+	if wp.AcceptSolution(s) != nil {
+		t.Fatalf("expected no share for a bad mix digest")
+	}
+}
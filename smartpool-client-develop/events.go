@@ -0,0 +1,67 @@
+package smartpool
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	ShareAcceptedEvent  EventType = "share_accepted"
+	ShareRejectedEvent  EventType = "share_rejected"
+	BlockFoundEvent     EventType = "block_found"
+	UncleFoundEvent     EventType = "uncle_found"
+	BlockOutcomeEvent   EventType = "block_outcome"
+	RigBannedEvent      EventType = "rig_banned"
+	ClaimSubmittedEvent EventType = "claim_submitted"
+	ClaimVerifiedEvent  EventType = "claim_verified"
+	ClaimRejectedEvent  EventType = "claim_rejected"
+	TxRebroadcastEvent  EventType = "tx_rebroadcast"
+	NodeHealthEvent     EventType = "node_health"
+	NewWorkEvent        EventType = "new_work"
+)
+
+// Event is something that happened in a subsystem that stat and dashboard
+// layers may want to know about.
+type Event struct {
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	Subsystem string    `json:"subsystem"`
+	Fields    Fields    `json:"fields"`
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = map[chan Event]bool{}
+)
+
+// SubscribeEvents returns a channel receiving events emitted from now on
+// and a function to stop receiving them. Events are dropped for
+// subscribers whose buffer is full so slow subscribers never block the
+// emitter.
+func SubscribeEvents(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	subscribersMu.Lock()
+	subscribers[ch] = true
+	subscribersMu.Unlock()
+	return ch, func() {
+		subscribersMu.Lock()
+		defer subscribersMu.Unlock()
+		if subscribers[ch] {
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func emitEvent(e Event) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
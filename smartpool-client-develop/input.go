@@ -3,6 +3,7 @@ package smartpool
 import (
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"sync"
 	"time"
 )
 
@@ -12,23 +13,35 @@ type Input struct {
 	shareThreshold  int
 	claimThreshold  int
 	shareDifficulty *big.Int
+	maxShareDiff    *big.Int
 	submitInterval  time.Duration
 	contractAddr    string
 	minerAddr       string
 	extraData       string
 	hotStop         bool
+	// guards settings that can be changed while SmartPool is running
+	mu sync.RWMutex
 }
 
 func (i *Input) RPCEndpoint() string           { return i.rpcEndPoint }
 func (i *Input) KeystorePath() string          { return i.keystorePath }
-func (i *Input) ShareThreshold() int           { return i.shareThreshold }
-func (i *Input) ClaimThreshold() int           { return i.claimThreshold }
 func (i *Input) ShareDifficulty() *big.Int     { return i.shareDifficulty }
+func (i *Input) MaxShareDifficulty() *big.Int  { return i.maxShareDiff }
 func (i *Input) SubmitInterval() time.Duration { return i.submitInterval }
 func (i *Input) ContractAddress() string       { return i.contractAddr }
 func (i *Input) MinerAddress() string          { return i.minerAddr }
 func (i *Input) ExtraData() string             { return i.extraData }
 func (i *Input) HotStop() bool                 { return i.hotStop }
+func (i *Input) ShareThreshold() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.shareThreshold
+}
+func (i *Input) ClaimThreshold() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.claimThreshold
+}
 func (i *Input) SetMinerAddress(addr common.Address) {
 	i.minerAddr = addr.Hex()
 }
@@ -38,6 +51,27 @@ func (i *Input) SetExtraData(extra string) {
 func (i *Input) SetContractAddress(addr common.Address) {
 	i.contractAddr = addr.Hex()
 }
+func (i *Input) SetThresholds(shareThreshold, claimThreshold int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.shareThreshold = shareThreshold
+	i.claimThreshold = claimThreshold
+}
+
+// ShareDifficultyTiers returns share difficulties rigs can be assigned. They
+// start from ShareDifficulty and double up to MaxShareDifficulty.
+func (i *Input) ShareDifficultyTiers() []*big.Int {
+	tiers := []*big.Int{i.shareDifficulty}
+	if i.maxShareDiff == nil {
+		return tiers
+	}
+	diff := new(big.Int).Lsh(i.shareDifficulty, 1)
+	for diff.Cmp(i.maxShareDiff) <= 0 {
+		tiers = append(tiers, diff)
+		diff = new(big.Int).Lsh(diff, 1)
+	}
+	return tiers
+}
 
 func NewInput(
 	rpcEndPoint string,
@@ -45,6 +79,7 @@ func NewInput(
 	shareThreshold int,
 	claimThreshold int,
 	shareDifficulty *big.Int,
+	maxShareDiff *big.Int,
 	submitInterval time.Duration,
 	contractAddr string,
 	minerAddr string,
@@ -53,6 +88,7 @@ func NewInput(
 ) *Input {
 	return &Input{
 		rpcEndPoint, keystorePath, shareThreshold, claimThreshold, shareDifficulty,
-		submitInterval, contractAddr, minerAddr, extraData, hotStop,
+		maxShareDiff, submitInterval, contractAddr, minerAddr, extraData, hotStop,
+		sync.RWMutex{},
 	}
 }
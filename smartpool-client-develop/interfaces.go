@@ -22,6 +22,10 @@ type UserInput interface {
 	ShareThreshold() int
 	ClaimThreshold() int
 	ShareDifficulty() *big.Int
+	// MaxShareDifficulty returns the highest difficulty a rig can be
+	// assigned. Variable difficulty is disabled when it is not greater than
+	// ShareDifficulty.
+	MaxShareDifficulty() *big.Int
 	SubmitInterval() time.Duration
 	ContractAddress() string
 	MinerAddress() string
@@ -30,7 +34,7 @@ type UserInput interface {
 }
 
 // Global output mechanism
-var Output UserOutput = StdOut{}
+var Output UserOutput = NewLogger("main")
 
 // UserOutput accepts all the information that SmartPool wants to tell the user.
 // It's only responsibility is to accept information. How the information is
@@ -54,6 +58,21 @@ type PersistentStorage interface {
 	Load(data interface{}, id string) (interface{}, error)
 }
 
+// Batch collects data persisted to it and writes all of them to the
+// underlying storage at once when Commit is called. Load returns data
+// persisted to the batch before falling back to the underlying storage.
+type Batch interface {
+	PersistentStorage
+	Commit() error
+}
+
+// TransactionalStorage is a PersistentStorage that can commit a Batch
+// atomically so either all or none of its data is persisted.
+type TransactionalStorage interface {
+	PersistentStorage
+	NewBatch() Batch
+}
+
 // Contract is the interface for smartpool to interact with contract side of
 // SmartPool protocol.
 // Contract can be used for only one caller (Ethereum account) per
@@ -89,7 +108,14 @@ type Contract interface {
 	// of a share with index shareIndex in the cliam and submit to contract side
 	// in order to prove that the claim is valid so the miner can take credit
 	// of it.
-	VerifyClaim(submissionIndex *big.Int, shareIndex *big.Int, claim Claim) error
+	// txSent is called with hash of the verification tx as soon as it is
+	// broadcasted, before it is mined. It can be nil.
+	VerifyClaim(submissionIndex *big.Int, shareIndex *big.Int, claim Claim, txSent func(tx common.Hash)) error
+	// VerificationResult returns true once the verification tx sent by
+	// VerifyClaim is mined, with the error the contract rejected the claim
+	// with or nil if the claim was verified. It returns false with the error
+	// when the tx couldn't be looked up.
+	VerificationResult(tx common.Hash) (bool, error)
 }
 
 // NetworkClient represents client for blockchain network that miner is mining
@@ -102,6 +128,9 @@ type NetworkClient interface {
 	// GetWork returns a Work for SmartPool to give to the miner. How the work
 	// is formed is upto structs implementing this interface.
 	GetWork() Work
+	// GetWorkWithDifficulty returns the same work as GetWork but with share
+	// difficulty diff.
+	GetWorkWithDifficulty(diff *big.Int) Work
 	// SubmitSolution submits the solution that miner has submitted to SmartPool
 	// so the full block solution can take credits. It also maintain workflow
 	// between miner and the network client.
@@ -121,6 +150,39 @@ type ShareReceiver interface {
 	Persist(storage PersistentStorage) error
 }
 
+// VerifyingShareReceiver is a ShareReceiver that tells why it rejects a
+// solution.
+type VerifyingShareReceiver interface {
+	ShareReceiver
+	// CheckSolution returns the share of s or the reason s is rejected.
+	CheckSolution(s Solution) (Share, error)
+}
+
+// DifficultyAdjuster decides share difficulty of each rig so that rigs with
+// different hashrates submit shares at a similar rate.
+type DifficultyAdjuster interface {
+	Difficulty(rig Rig) *big.Int
+}
+
+// BanPolicy decides whether solutions of a rig are refused because of its
+// misbehavior.
+type BanPolicy interface {
+	Banned(rig Rig) bool
+}
+
+// RigPolicy is a BanPolicy that also learns from the outcome of every
+// solution and limits how often rigs can call the miner facing server.
+type RigPolicy interface {
+	BanPolicy
+	// ShareChecked tells the policy the outcome of a solution of rig. It is
+	// "accepted", "rejected", "invalid" (the solution's pow value or mix
+	// digest is wrong) or "duplicated".
+	ShareChecked(rig Rig, outcome string)
+	// Allow returns false when rig is banned or calls the server faster
+	// than it is allowed to.
+	Allow(rig Rig) bool
+}
+
 type PoolMonitor interface {
 	RequireClientUpdate() bool
 	RequireContractUpdate() bool
@@ -132,8 +194,9 @@ type StatRecorder interface {
 	RecordClaim(status string, claim Claim)
 	RecordHashrate(hashrate hexutil.Uint64, id common.Hash, rig Rig)
 	// Notify StatRecorder how many share were restored from last session
+	// and how many shares in submitted claims are still being verified
 	// so StatRecorder can track number of abandoned shares
-	ShareRestored(noshares uint64)
+	ShareRestored(noshares uint64, nounverified uint64)
 
 	OverallFarmStat() interface{}
 	FarmStat(start uint64, end uint64) interface{}
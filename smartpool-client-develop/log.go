@@ -1,33 +1,285 @@
 package smartpool
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-type Log struct {
-	*log.Logger
-	f *os.File
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
 }
 
-func NewLog() *Log {
-	f, err := os.OpenFile("smartpool.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if n == strings.ToLower(name) {
+			return Level(i), nil
+		}
 	}
-	return &Log{log.New(f, "", log.Lshortfile|log.LstdFlags), f}
+	return LevelInfo, fmt.Errorf("unknown log level %s", name)
+}
+
+// ParseLevels parses verbosity like "info,protocol=debug,geth=warn" into
+// the default level and levels of subsystems.
+func ParseLevels(spec string) (Level, map[string]Level, error) {
+	def := LevelInfo
+	levels := map[string]Level{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		level, err := ParseLevel(kv[len(kv)-1])
+		if err != nil {
+			return def, levels, err
+		}
+		if len(kv) == 1 {
+			def = level
+		} else {
+			levels[kv[0]] = level
+		}
+	}
+	return def, levels, nil
+}
+
+type Fields map[string]interface{}
+
+// LogConfig configures where and how log records are written.
+type LogConfig struct {
+	// Dir is the directory of LOG_FILE. Records are only written to stdout
+	// if it is empty.
+	Dir string
+	// JSON writes records to the log file as JSON objects, one per line.
+	JSON bool
+	// MaxSize is the size in bytes the log file is rotated at.
+	MaxSize int64
+	// MaxFiles is the number of rotated log files to keep.
+	MaxFiles int
+	// Level is the verbosity of subsystems not in Levels.
+	Level  Level
+	Levels map[string]Level
+}
+
+var LOG_FILE string = "smartpool.log"
+
+// logSink is where all loggers write to. Records always go to stdout in
+// text format and to the log file if there is one.
+type logSink struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	file   io.WriteCloser
+	json   bool
+	level  Level
+	levels map[string]Level
 }
 
-func (l *Log) Close() {
-	l.f.Close()
+var sink = &logSink{sync.Mutex{}, os.Stdout, nil, false, LevelInfo, map[string]Level{}}
+
+// SetupLogging makes all loggers write records as cfg says.
+func SetupLogging(cfg *LogConfig) error {
+	var file io.WriteCloser
+	if cfg.Dir != "" {
+		rf, err := newRotatingFile(filepath.Join(cfg.Dir, LOG_FILE), cfg.MaxSize, cfg.MaxFiles)
+		if err != nil {
+			return err
+		}
+		file = rf
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.file != nil {
+		sink.file.Close()
+	}
+	sink.file = file
+	sink.json = cfg.JSON
+	sink.level = cfg.Level
+	sink.levels = cfg.Levels
+	if sink.levels == nil {
+		sink.levels = map[string]Level{}
+	}
+	return nil
 }
 
-func (l *Log) Printf(format string, a ...interface{}) (n int, err error) {
-	l.Logger.Printf(format, a...)
+// SetLogLevel changes verbosity of subsystem. An empty subsystem changes
+// the default verbosity.
+func SetLogLevel(subsystem string, level Level) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if subsystem == "" {
+		sink.level = level
+	} else {
+		sink.levels[subsystem] = level
+	}
+}
+
+// SetLogLevels replaces verbosity of all subsystems.
+func SetLogLevels(level Level, levels map[string]Level) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.level = level
+	sink.levels = levels
+}
+
+func (s *logSink) enabled(subsystem string, level Level) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	min, ok := s.levels[subsystem]
+	if !ok {
+		min = s.level
+	}
+	return level >= min
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatText(t time.Time, level Level, subsystem, msg string, fields Fields) []byte {
+	buff := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buff, "%s %-5s [%s] %s", t.Format(time.RFC3339), strings.ToUpper(level.String()), subsystem, msg)
+	for _, k := range sortedKeys(fields) {
+		fmt.Fprintf(buff, " %s=%v", k, fields[k])
+	}
+	buff.WriteByte('\n')
+	return buff.Bytes()
+}
+
+func formatJSON(t time.Time, level Level, subsystem, msg string, fields Fields) []byte {
+	record := map[string]interface{}{}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		record[k] = v
+	}
+	record["time"] = t.Format(time.RFC3339Nano)
+	record["level"] = level.String()
+	record["subsystem"] = subsystem
+	record["msg"] = msg
+	line, err := json.Marshal(record)
+	if err != nil {
+		return formatText(t, level, subsystem, msg, fields)
+	}
+	return append(line, '\n')
+}
+
+func (s *logSink) write(level Level, subsystem, msg string, fields Fields) {
 	t := time.Now()
-	ts := t.Format(time.RFC3339)
-	fmt.Printf("%v ", ts)
-	return fmt.Printf(format, a...)
+	text := formatText(t, level, subsystem, msg, fields)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stdout.Write(text)
+	if s.file == nil {
+		return
+	}
+	if s.json {
+		s.file.Write(formatJSON(t, level, subsystem, msg, fields))
+	} else {
+		s.file.Write(text)
+	}
+}
+
+// Logger writes leveled records of a subsystem such as protocol, geth,
+// stat or ethminer with its fields attached. It is a UserOutput logging
+// Printf at info level.
+type Logger struct {
+	subsystem string
+	fields    Fields
+}
+
+func NewLogger(subsystem string) *Logger {
+	return &Logger{subsystem, Fields{}}
+}
+
+func (l *Logger) Subsystem() string {
+	return l.subsystem
+}
+
+// With returns a logger attaching keyvals, pairs of field names and values,
+// to every record.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := Fields{}
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	return &Logger{l.subsystem, fields}
+}
+
+func (l *Logger) log(level Level, format string, a ...interface{}) {
+	if !sink.enabled(l.subsystem, level) {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, a...), "\n")
+	sink.write(level, l.subsystem, msg, l.fields)
+}
+
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	l.log(LevelDebug, format, a...)
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) {
+	l.log(LevelInfo, format, a...)
+}
+
+func (l *Logger) Warnf(format string, a ...interface{}) {
+	l.log(LevelWarn, format, a...)
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) {
+	l.log(LevelError, format, a...)
+}
+
+// Emit publishes an event of the subsystem with the logger's fields and
+// fields to event subscribers.
+func (l *Logger) Emit(typ EventType, fields Fields) {
+	all := Fields{}
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+	emitEvent(Event{typ, time.Now(), l.subsystem, all})
+}
+
+func (l *Logger) Printf(format string, a ...interface{}) (n int, err error) {
+	l.Infof(format, a...)
+	return 0, nil
+}
+
+// Close closes the log file. Records are still written to stdout.
+func (l *Logger) Close() {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.file != nil {
+		sink.file.Close()
+		sink.file = nil
+	}
 }
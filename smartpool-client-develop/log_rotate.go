@@ -0,0 +1,81 @@
+package smartpool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// rotatingFile is a log file that is renamed to path.1 once it grows past
+// maxSize. Older files are shifted to path.2, path.3... and only maxFiles
+// of them are kept.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxFiles))
+	for i := rf.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.maxFiles > 0 {
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) Close() error {
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+func newRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0766); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path, maxSize, maxFiles, nil, 0}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
@@ -0,0 +1,46 @@
+package smartpool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	level, levels, err := ParseLevels("warn,protocol=debug, geth=error")
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	if level != LevelWarn || levels["protocol"] != LevelDebug || levels["geth"] != LevelError {
+		t.Fail()
+	}
+	if _, _, err = ParseLevels("protocol=verbose"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestRotatingFileKeepsMaxFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smartpool")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, LOG_FILE)
+	rf, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("couldn't open log file: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		rf.Write([]byte("0123456789"))
+	}
+	rf.Close()
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if info, err := os.Stat(name); err != nil || info.Size() != 10 {
+			t.Fatalf("%s should hold one write", name)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fail()
+	}
+}
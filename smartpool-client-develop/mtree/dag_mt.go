@@ -46,6 +46,16 @@ func _hash(a, b NodeData) NodeData {
 
 func _modifier(data NodeData) {}
 
+// DagElementHash returns the leaf node of DAG element w.
+func DagElementHash(w smartpool.Word) DagData {
+	return _elementHash(w).(DagData)
+}
+
+// DagNodeHash returns the parent node of left and right.
+func DagNodeHash(left, right DagData) DagData {
+	return _hash(left, right).(DagData)
+}
+
 func NewDagTree() *DagTree {
 	mtbuf := list.New()
 	return &DagTree{
@@ -73,25 +83,31 @@ func (dt DagTree) RootHash() smartpool.SPHash {
 
 func (dt DagTree) MerkleNodes() []*big.Int {
 	if dt.finalized {
-		result := []*big.Int{}
-		for i := 0; i*2 < len(dt.exportNodes); i++ {
-			if i*2+1 >= len(dt.exportNodes) {
-				result = append(result,
-					smartpool.BranchElementFromHash(
-						smartpool.SPHash(DagData{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
-						smartpool.SPHash(dt.exportNodes[i*2].(DagData))).Big())
-			} else {
-				result = append(result,
-					smartpool.BranchElementFromHash(
-						smartpool.SPHash(dt.exportNodes[i*2+1].(DagData)),
-						smartpool.SPHash(dt.exportNodes[i*2].(DagData))).Big())
-			}
-		}
-		return result
+		return PackMerkleNodes(dt.exportNodes)
 	}
 	panic("SP Merkle tree needs to be finalized by calling mt.Finalize()")
 }
 
+// PackMerkleNodes packs nodes of the stored level, in order, into the
+// elements the contract expects for its epoch data.
+func PackMerkleNodes(nodes []NodeData) []*big.Int {
+	result := []*big.Int{}
+	for i := 0; i*2 < len(nodes); i++ {
+		if i*2+1 >= len(nodes) {
+			result = append(result,
+				smartpool.BranchElementFromHash(
+					smartpool.SPHash(DagData{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
+					smartpool.SPHash(nodes[i*2].(DagData))).Big())
+		} else {
+			result = append(result,
+				smartpool.BranchElementFromHash(
+					smartpool.SPHash(nodes[i*2+1].(DagData)),
+					smartpool.SPHash(nodes[i*2].(DagData))).Big())
+		}
+	}
+	return result
+}
+
 // return only one array with necessary hashes for each
 // index in order. Element's hash and root are not included
 // eg. registered indexes are 1, 2, each needs 2 hashes
@@ -101,34 +117,50 @@ func (dt DagTree) MerkleNodes() []*big.Int {
 func (dt DagTree) AllBranchesArray() []smartpool.BranchElement {
 	if dt.finalized {
 		result := []smartpool.BranchElement{}
-		branches := dt.Branches()
 		for _, k := range dt.Indices() {
-			// p := proofs[k]
-			// fmt.Printf("Index: %d\nRawData: %s\nHashedData: %s\n", k, hex.EncodeToString(p.RawData[:]), proofs[k].HashedData.Hex())
-			hh := branches[k].ToNodeArray()[1:]
-			hashes := hh[:len(hh)-int(dt.StoredLevel())]
-			// fmt.Printf("Len proofs: %s\n", len(pfs))
-			for i := 0; i*2 < len(hashes); i++ {
-				// for anyone who is courious why i*2 + 1 comes before i * 2
-				// it's agreement between client side and contract side
-				if i*2+1 >= len(hashes) {
-					result = append(result,
-						smartpool.BranchElementFromHash(
-							smartpool.SPHash(DagData{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
-							smartpool.SPHash(hashes[i*2].(DagData))))
-				} else {
-					result = append(result,
-						smartpool.BranchElementFromHash(
-							smartpool.SPHash(hashes[i*2+1].(DagData)),
-							smartpool.SPHash(hashes[i*2].(DagData))))
-				}
-			}
+			result = append(result, dt.BranchArray(k)...)
 		}
 		return result
 	}
 	panic("SP Merkle tree needs to be finalized by calling mt.Finalize()")
 }
 
+// BranchArray returns the proof branch of the element at registered index
+// up to the stored level, packed the way AllBranchesArray packs it.
+func (dt DagTree) BranchArray(index uint32) []smartpool.BranchElement {
+	if dt.finalized {
+		branches := dt.Branches()
+		// p := proofs[k]
+		// fmt.Printf("Index: %d\nRawData: %s\nHashedData: %s\n", k, hex.EncodeToString(p.RawData[:]), proofs[k].HashedData.Hex())
+		hh := branches[index].ToNodeArray()[1:]
+		// fmt.Printf("Len proofs: %s\n", len(pfs))
+		return PackBranch(hh[:len(hh)-int(dt.StoredLevel())])
+	}
+	panic("SP Merkle tree needs to be finalized by calling mt.Finalize()")
+}
+
+// PackBranch packs hashes of a proof branch, from the element's sibling up,
+// into the elements the contract expects.
+func PackBranch(hashes []NodeData) []smartpool.BranchElement {
+	result := []smartpool.BranchElement{}
+	for i := 0; i*2 < len(hashes); i++ {
+		// for anyone who is courious why i*2 + 1 comes before i * 2
+		// it's agreement between client side and contract side
+		if i*2+1 >= len(hashes) {
+			result = append(result,
+				smartpool.BranchElementFromHash(
+					smartpool.SPHash(DagData{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
+					smartpool.SPHash(hashes[i*2].(DagData))))
+		} else {
+			result = append(result,
+				smartpool.BranchElementFromHash(
+					smartpool.SPHash(hashes[i*2+1].(DagData)),
+					smartpool.SPHash(hashes[i*2].(DagData))))
+		}
+	}
+	return result
+}
+
 func (dt DagTree) AllDAGElements() []smartpool.Word {
 	if dt.finalized {
 		result := []smartpool.Word{}
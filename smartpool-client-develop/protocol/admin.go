@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"errors"
+	"github.com/SmartPool/smartpool-client"
+)
+
+// PauseSubmitter stops claims from being submitted on every submit interval.
+// Shares are still accepted and a claim that is being submitted is finished.
+func (sp *SmartPool) PauseSubmitter() {
+	sp.pauseMu.Lock()
+	defer sp.pauseMu.Unlock()
+	sp.paused = true
+	logger.Infof("Submitter is paused.\n")
+}
+
+// ResumeSubmitter submits claims on every submit interval again after
+// PauseSubmitter.
+func (sp *SmartPool) ResumeSubmitter() {
+	sp.pauseMu.Lock()
+	defer sp.pauseMu.Unlock()
+	sp.paused = false
+	logger.Infof("Submitter is resumed.\n")
+}
+
+func (sp *SmartPool) SubmitterPaused() bool {
+	sp.pauseMu.RLock()
+	defer sp.pauseMu.RUnlock()
+	return sp.paused
+}
+
+// Stop stops the submitter once the claim being submitted, if any, is
+// finished. Current state is persisted and SubmitterStopped receives when
+// it stopped. Stopping a submitter that is already stopping does nothing.
+func (sp *SmartPool) Stop() {
+	select {
+	case sp.stopSubmitterChan <- true:
+	default:
+	}
+}
+
+// ForceSubmit asks the submitter to submit all shares received so far as a
+// claim without waiting for the submit interval or the share threshold. The
+// claim ends its claim batch so the batch is verified right away. It works
+// even when the submitter is paused.
+// ForceSubmit doesn't wait for the submission, it returns an error if the
+// submitter is not running or another forced submission is still waiting.
+func (sp *SmartPool) ForceSubmit() error {
+	if !sp.SubmitterRunning() {
+		return errors.New("submitter is not running")
+	}
+	select {
+	case sp.forceSubmitChan <- true:
+		return nil
+	default:
+		return errors.New("a forced submission is already waiting")
+	}
+}
+
+// ResetOpenClaims drops the open claims of the client and the contract so a
+// claim batch that can't be verified doesn't block the following ones.
+// Shares in the dropped claims are lost. The submitter must be paused so no
+// claim is submitted in the meantime. If a claim is being submitted,
+// ResetOpenClaims waits for it to finish. The claims of the client are only
+// dropped once the contract's are so both sides stay consistent.
+func (sp *SmartPool) ResetOpenClaims() error {
+	if !sp.SubmitterPaused() {
+		return errors.New("submitter must be paused before resetting open claims")
+	}
+	sp.submitMu.Lock()
+	defer sp.submitMu.Unlock()
+	logger.Warnf("Resetting open claims of the client and the contract on operator's request...\n")
+	if err := sp.Contract.ResetOpenClaims(); err != nil {
+		logger.Warnf("Couldn't reset open claims of the contract: %s\n", err)
+		return err
+	}
+	sp.ClaimRepo.ResetOpenClaims()
+	sp.updateSubmission(func(s *Submission) {
+		s.UnverifiedShares = 0
+		s.State = SubmissionIdle
+	})
+	logger.Debugf("Done.\n")
+	return nil
+}
+
+// OpenClaims returns the claims submitted to the contract in the current
+// claim batch.
+func (sp *SmartPool) OpenClaims() []smartpool.Claim {
+	return sp.ClaimRepo.OpenClaims()
+}
+
+// SubmissionState returns how far the current claim submission got. It is
+// empty when no claim is being submitted.
+// It doesn't wait for submitMu, which is held for the whole submission.
+func (sp *SmartPool) SubmissionState() string {
+	return sp.currentSubmission().State
+}
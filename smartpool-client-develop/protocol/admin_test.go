@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"github.com/SmartPool/smartpool-client"
+	"sync"
+	"testing"
+)
+
+func newTestSmartPoolWithOpenClaim() *SmartPool {
+	sp := newTestSmartPool()
+	sp.ClaimRepo.(*testClaimRepo).oc = []smartpool.Claim{&testClaim{[]smartpool.Share{}}}
+	sp.submission.State = SubmissionSealed
+	sp.submission.UnverifiedShares = 10
+	return sp
+}
+
+func TestSmartPoolResetOpenClaimsRequiresPausedSubmitter(t *testing.T) {
+	sp := newTestSmartPoolWithOpenClaim()
+	if err := sp.ResetOpenClaims(); err == nil {
+		t.Fatalf("expected reset to be refused while the submitter runs")
+	}
+	if sp.ClaimRepo.LatestOpenClaim() == nil {
+		t.Fatalf("expected open claims to be kept")
+	}
+}
+
+func TestSmartPoolResetOpenClaimsKeepsClaimsWhenContractFails(t *testing.T) {
+	sp := newTestSmartPoolWithOpenClaim()
+	sp.Contract.(*testContract).ResetFailed = true
+	sp.PauseSubmitter()
+	if err := sp.ResetOpenClaims(); err == nil {
+		t.Fatalf("expected reset to fail")
+	}
+	if sp.ClaimRepo.LatestOpenClaim() == nil ||
+		sp.submission.UnverifiedShares != 10 ||
+		sp.SubmissionState() != SubmissionSealed {
+		t.Fatalf("expected open claims of the client to be kept when the contract's are")
+	}
+}
+
+func TestSmartPoolResetOpenClaims(t *testing.T) {
+	sp := newTestSmartPoolWithOpenClaim()
+	sp.PauseSubmitter()
+	if err := sp.ResetOpenClaims(); err != nil {
+		t.Fatalf("reset failed: %s", err)
+	}
+	if sp.ClaimRepo.LatestOpenClaim() != nil ||
+		sp.submission.UnverifiedShares != 0 ||
+		sp.SubmissionState() != SubmissionIdle {
+		t.Fatalf("expected open claims to be reset")
+	}
+}
+
+func TestSmartPoolForceSubmitRequiresRunningSubmitter(t *testing.T) {
+	sp := newTestSmartPool()
+	if err := sp.ForceSubmit(); err == nil {
+		t.Fatalf("expected forced submission to be refused")
+	}
+}
+
+// run with -race to check SubmissionState is synchronized with the
+// submitter
+func TestSmartPoolSubmissionStateWhileSubmitting(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.setSubmissionState(SubmissionIdle)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sp.submitMu.Lock()
+		defer sp.submitMu.Unlock()
+		for i := 0; i < 100; i++ {
+			sp.setSubmissionState(SubmissionSealed)
+			sp.setSubmissionState(SubmissionIdle)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if state := sp.SubmissionState(); state != SubmissionSealed && state != SubmissionIdle {
+			t.Fatalf("unexpected state %q", state)
+		}
+	}
+	wg.Wait()
+}
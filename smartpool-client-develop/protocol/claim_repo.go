@@ -18,7 +18,13 @@ type ClaimRepo interface {
 	PutOpenClaim(claim smartpool.Claim)
 	RemoveOpenClaim(claim smartpool.Claim)
 	GetOpenClaim(claimIndex int) smartpool.Claim
+	// LatestOpenClaim returns the claim put by the last PutOpenClaim call if
+	// it is not sealed into a claim batch yet. It returns nil otherwise.
+	LatestOpenClaim() smartpool.Claim
 	ResetOpenClaims()
+	// OpenClaims returns the claims put by PutOpenClaim that are not sealed
+	// into a claim batch yet.
+	OpenClaims() []smartpool.Claim
 	NumOpenClaims() uint64
 	SealClaimBatch()
 	Persist(storage smartpool.PersistentStorage) error
@@ -0,0 +1,7 @@
+package protocol
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+var logger = smartpool.NewLogger("protocol")
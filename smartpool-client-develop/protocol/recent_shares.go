@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"github.com/SmartPool/smartpool-client"
+	"sync"
+)
+
+// RecentShares is how many of the last accepted shares SmartPool remembers
+// so a share submitted again after it was claimed is still rejected as a
+// duplicate rather than for its low counter.
+var RecentShares = 100000
+
+// recentShares is a bounded set of hashes of accepted shares. The oldest
+// hash is forgotten when a new one doesn't fit. A nil recentShares
+// remembers nothing.
+type recentShares struct {
+	mu     sync.Mutex
+	hashes map[smartpool.SPHash]bool
+	order  []smartpool.SPHash
+	next   int
+}
+
+func (rs *recentShares) add(hash smartpool.SPHash) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.hashes[hash] {
+		return
+	}
+	if len(rs.order) < RecentShares {
+		rs.order = append(rs.order, hash)
+	} else if len(rs.order) > 0 {
+		delete(rs.hashes, rs.order[rs.next])
+		rs.order[rs.next] = hash
+		rs.next = (rs.next + 1) % len(rs.order)
+	} else {
+		return
+	}
+	rs.hashes[hash] = true
+}
+
+func (rs *recentShares) contains(hash smartpool.SPHash) bool {
+	if rs == nil {
+		return false
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.hashes[hash]
+}
+
+func newRecentShares() *recentShares {
+	return &recentShares{sync.Mutex{}, map[smartpool.SPHash]bool{}, []smartpool.SPHash{}, 0}
+}
@@ -5,8 +5,8 @@ package protocol
 
 import (
 	"errors"
-	"fmt"
 	"github.com/SmartPool/smartpool-client"
+	"github.com/SmartPool/smartpool-client/storage"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"math/big"
@@ -20,6 +20,10 @@ import (
 
 const COUNTER_FILE string = "counter"
 
+// VerificationRecoveryWait is how long SmartPool waits for the verification
+// tx sent in last session to be mined before sending it again.
+var VerificationRecoveryWait = 10 * time.Minute
+
 // SmartPool represent smartpool protocol which interacts smartpool high level
 // interfaces and types together to do following procedures:
 // 1. Register the miner if needed
@@ -38,6 +42,8 @@ type SmartPool struct {
 	NetworkClient     smartpool.NetworkClient
 	Contract          smartpool.Contract
 	StatRecorder      smartpool.StatRecorder
+	DiffAdjuster      smartpool.DifficultyAdjuster
+	BanPolicy         smartpool.BanPolicy
 	ClaimRepo         ClaimRepo
 	Storage           smartpool.PersistentStorage
 	LatestCounter     *big.Int
@@ -51,11 +57,19 @@ type SmartPool struct {
 	loopStarted       bool
 	ticker            <-chan time.Time
 	counterMu         sync.RWMutex
+	thresholdMu       sync.RWMutex
 	runMu             sync.Mutex
+	submitMu          sync.Mutex
+	pauseMu           sync.RWMutex
+	paused            bool
+	stateMu           sync.RWMutex
 	SubmitterStopped  chan bool
 	stopSubmitterChan chan bool
+	forceSubmitChan   chan bool
 	signal            chan os.Signal
 	Input             smartpool.UserInput
+	submission        *Submission
+	recentShares      *recentShares
 }
 
 // Register registers miner address to the contract.
@@ -65,30 +79,34 @@ type SmartPool struct {
 // address registered before or registers the address if it didn't.
 func (sp *SmartPool) Register(addr common.Address) bool {
 	if sp.Contract.IsRegistered() {
-		smartpool.Output.Printf("The address is already registered to the pool. Good to go.\n")
+		logger.Infof("The address is already registered to the pool. Good to go.\n")
 		return true
 	}
 	if !sp.Contract.CanRegister() {
-		smartpool.Output.Printf("Your etherbase address couldn't register to the pool. You need to try another address.\n")
+		logger.Warnf("Your etherbase address couldn't register to the pool. You need to try another address.\n")
 		return false
 	}
-	smartpool.Output.Printf("Registering to the pool. Please wait...")
+	logger.Infof("Registering to the pool. Please wait...")
 	err := sp.Contract.Register(addr)
 	if err != nil {
-		smartpool.Output.Printf("Unable to register to the pool: %s\n", err)
+		logger.Warnf("Unable to register to the pool: %s\n", err)
 		return false
 	}
 	if !sp.Contract.IsRegistered() {
-		smartpool.Output.Printf("You are not accepted by the pool yet. Please wait about 30s and try again.\n")
+		logger.Infof("You are not accepted by the pool yet. Please wait about 30s and try again.\n")
 		return false
 	}
-	smartpool.Output.Printf("Done.\n")
+	logger.Debugf("Done.\n")
 	return true
 }
 
-// GetWork returns miner work
+// GetWork returns miner work. When DiffAdjuster is set, the work's share
+// difficulty is the one DiffAdjuster decided for the rig.
 func (sp *SmartPool) GetWork(rig smartpool.Rig) smartpool.Work {
-	return sp.NetworkClient.GetWork()
+	if sp.DiffAdjuster == nil {
+		return sp.NetworkClient.GetWork()
+	}
+	return sp.NetworkClient.GetWorkWithDifficulty(sp.DiffAdjuster.Difficulty(rig))
 }
 
 func (sp *SmartPool) SubmitHashrate(rig smartpool.Rig, hashrate hexutil.Uint64, id common.Hash) bool {
@@ -102,47 +120,133 @@ func (sp *SmartPool) SubmitHashrate(rig smartpool.Rig, hashrate hexutil.Uint64,
 // A share can only be added when it's counter is greater than the maximum
 // counter of the last verified claim
 func (sp *SmartPool) AcceptSolution(rig smartpool.Rig, s smartpool.Solution) bool {
-	share := sp.ShareReceiver.AcceptSolution(s)
+	rigLogger := logger.With("rig", rig.ID())
+	if sp.BanPolicy != nil && sp.BanPolicy.Banned(rig) {
+		rigLogger.Warnf("Share is discarded because the rig is banned.\n")
+		rigLogger.Emit(smartpool.ShareRejectedEvent, smartpool.Fields{"reason": "banned"})
+		go func() {
+			sp.StatRecorder.RecordShare("submitted", nil, rig)
+			sp.StatRecorder.RecordShare("rejected", nil, rig)
+		}()
+		return false
+	}
+	share, rejection := sp.checkSolution(s)
 	sp.counterMu.RLock()
 	defer sp.counterMu.RUnlock()
+	status := workStatus(share)
 	if share != nil && share.FullSolution() {
-		smartpool.Output.Printf("-->Yay! We found potential block!<--\n")
+		if status == smartpool.CurrentWork {
+			rigLogger.Infof("-->Yay! We found potential block!<--\n")
+			rigLogger.Emit(smartpool.BlockFoundEvent, smartpool.Fields{
+				"counter": share.Counter().Text(16),
+			})
+		} else if status == smartpool.StaleWork {
+			rigLogger.Infof("Found full solution of a stale work. It is likely an uncle.\n")
+			rigLogger.Emit(smartpool.UncleFoundEvent, smartpool.Fields{
+				"counter": share.Counter().Text(16),
+			})
+		} else {
+			rigLogger.Warnf("Found full solution of an orphaned work. It can't be included in the chain.\n")
+		}
 		sp.NetworkClient.SubmitSolution(s)
 	}
 	var success bool
+	var reason string
 	if share == nil || share.Counter().Cmp(sp.LatestCounter) <= 0 {
-		smartpool.Output.Printf("Share is discarded.\n")
+		rigLogger.Warnf("Share is discarded.\n")
+		reason = "invalid"
+		if rejection != nil {
+			reason = rejection.Error()
+		}
 		if share != nil && share.Counter().Cmp(sp.LatestCounter) <= 0 {
-			smartpool.Output.Printf("Share's counter (0x%s) is lower than last claim max counter (0x%s)\n", share.Counter().Text(16), sp.LatestCounter.Text(16))
+			rigLogger.Warnf("Share's counter (0x%s) is lower than last claim max counter (0x%s)\n", share.Counter().Text(16), sp.LatestCounter.Text(16))
+			reason = "low counter"
+			// the share may have been accepted and claimed already
+			if sp.recentShares.contains(share.Hash()) {
+				rejection = smartpool.ErrDuplicatedShare
+				reason = rejection.Error()
+			}
 		}
 		success = false
 	} else {
 		err := sp.ClaimRepo.AddShare(share)
 		if err != nil {
-			smartpool.Output.Printf("Discarded because of %s.\n", err.Error())
+			rigLogger.Warnf("Discarded because of %s.\n", err.Error())
+			reason = err.Error()
+			rejection = err
 			success = false
 		} else {
-			fmt.Print(".")
+			rigLogger.Debugf("Accepted share with counter 0x%s.\n", share.Counter().Text(16))
+			sp.recentShares.add(share.Hash())
 			success = true
 		}
 	}
+	if policy, ok := sp.BanPolicy.(smartpool.RigPolicy); ok {
+		policy.ShareChecked(rig, shareOutcome(success, rejection))
+	}
+	if success {
+		rigLogger.Emit(smartpool.ShareAcceptedEvent, smartpool.Fields{
+			"counter":    share.Counter().Text(16),
+			"difficulty": share.ShareDifficulty().Text(10),
+		})
+	} else {
+		rigLogger.Emit(smartpool.ShareRejectedEvent, smartpool.Fields{"reason": reason})
+	}
 
 	go func() {
 		sp.StatRecorder.RecordShare("submitted", share, rig)
 		if success {
-			if share.FullSolution() {
+			if share.FullSolution() && status == smartpool.CurrentWork {
 				sp.StatRecorder.RecordShare("fullsolution", share, rig)
 			} else {
 				sp.StatRecorder.RecordShare("accepted", share, rig)
 			}
+			if status != smartpool.CurrentWork {
+				sp.StatRecorder.RecordShare(status, share, rig)
+			}
 		} else {
 			sp.StatRecorder.RecordShare("rejected", share, rig)
+			if rejection == smartpool.ErrBadMixDigest {
+				sp.StatRecorder.RecordShare("badmix", share, rig)
+			}
 		}
 	}()
 
 	return success
 }
 
+// checkSolution returns the share of s and, if the ShareReceiver tells it,
+// why s is rejected.
+func (sp *SmartPool) checkSolution(s smartpool.Solution) (smartpool.Share, error) {
+	if receiver, ok := sp.ShareReceiver.(smartpool.VerifyingShareReceiver); ok {
+		return receiver.CheckSolution(s)
+	}
+	return sp.ShareReceiver.AcceptSolution(s), nil
+}
+
+// shareOutcome returns the outcome of a solution a RigPolicy learns from.
+func shareOutcome(success bool, rejection error) string {
+	if success {
+		return "accepted"
+	}
+	switch rejection {
+	case smartpool.ErrInvalidSolution, smartpool.ErrBadMixDigest:
+		return "invalid"
+	case smartpool.ErrDuplicatedShare:
+		return "duplicated"
+	}
+	return "rejected"
+}
+
+// workStatus returns the status of share's work on the chain. Shares that
+// don't know it are considered current.
+func workStatus(share smartpool.Share) string {
+	if s, ok := share.(smartpool.ChainAwareShare); ok {
+		return s.WorkStatus()
+	}
+	return smartpool.CurrentWork
+}
+
 // GetCurrentClaim returns new claim containing unsubmitted shares. If there
 // is no new shares, it returns nil.
 func (sp *SmartPool) GetCurrentClaim(threshold int) smartpool.Claim {
@@ -155,7 +259,7 @@ func (sp *SmartPool) GetVerificationIndex(claim smartpool.Claim) (*big.Int, *big
 	for {
 		claimIndex, shareIndex, err = sp.Contract.GetShareIndex(claim)
 		if err != nil {
-			smartpool.Output.Printf("Got error(%s) while trying to get verification index. Retry in 10s...\n", err.Error())
+			logger.Warnf("Got error(%s) while trying to get verification index. Retry in 10s...\n", err.Error())
 			time.Sleep(10 * time.Second)
 		} else {
 			return claimIndex, shareIndex
@@ -163,20 +267,59 @@ func (sp *SmartPool) GetVerificationIndex(claim smartpool.Claim) (*big.Int, *big
 	}
 }
 
+// SealClaim seals the current claim, puts it into the open claims queue and
+// makes it the claim being submitted.
 func (sp *SmartPool) SealClaim() smartpool.Claim {
+	return sp.sealClaim(sp.shareThreshold(), false)
+}
+
+// sealClaim seals a claim of at least threshold shares. When endBatch is
+// true, the claim is the last one of its batch regardless of the claim
+// threshold.
+func (sp *SmartPool) sealClaim(threshold int, endBatch bool) smartpool.Claim {
 	sp.counterMu.Lock()
 	defer sp.counterMu.Unlock()
-	claim := sp.GetCurrentClaim(sp.ShareThreshold)
-	if claim != nil {
-		sp.LatestCounter = claim.Max()
-		smartpool.Output.Printf("Set Latest Counter to 0x%s.\n", sp.LatestCounter.Text(16))
-		smartpool.Output.Printf("Persisting Latest Counter to storage...")
-		persistLatestCounter(sp.Storage, sp.LatestCounter)
-		smartpool.Output.Printf("Done.\n")
+	claim := sp.GetCurrentClaim(threshold)
+	if claim == nil {
+		return nil
+	}
+	lastClaim := endBatch || int(sp.ClaimRepo.NumOpenClaims()+1) >= sp.claimThreshold()
+	sp.ClaimRepo.PutOpenClaim(claim)
+	logger.Infof("The claim is successfully put into open claims queue.\n")
+	sp.LatestCounter = claim.Max()
+	logger.Infof("Set Latest Counter to 0x%s.\n", sp.LatestCounter.Text(16))
+	sp.stateMu.Lock()
+	defer sp.stateMu.Unlock()
+	sp.submission.LastClaim = lastClaim
+	sp.submission.ClaimShares = claim.NumShares().Uint64()
+	sp.submission.ClaimIndex = nil
+	sp.submission.ShareIndex = nil
+	sp.submission.VerifyTx = common.Hash{}
+	sp.submission.State = SubmissionSealed
+	logger.Debugf("Persisting Latest Counter to storage...")
+	// the shares left in claim repo, the open claim, the counter and the
+	// submission must be persisted atomically, otherwise shares of the claim
+	// could be restored with an older counter and claimed again or the
+	// claim could be lost
+	batch := storage.NewBatch(sp.Storage)
+	sp.ClaimRepo.Persist(batch)
+	persistLatestCounter(batch, sp.LatestCounter)
+	persistSubmission(batch, sp.submission)
+	if err := batch.Commit(); err != nil {
+		logger.Warnf("Failed. (%s)\n", err)
+	} else {
+		logger.Debugf("Done.\n")
 	}
 	return claim
 }
 
+// GetLatestCounter returns max counter of the last sealed claim.
+func (sp *SmartPool) GetLatestCounter() *big.Int {
+	sp.counterMu.RLock()
+	defer sp.counterMu.RUnlock()
+	return new(big.Int).Set(sp.LatestCounter)
+}
+
 func persistLatestCounter(ps smartpool.PersistentStorage, counter *big.Int) error {
 	return ps.Persist(counter, COUNTER_FILE)
 }
@@ -190,17 +333,20 @@ func (sp *SmartPool) consistencyCheck() error {
 		}
 		if numOpenClaimsContract.Uint64() != sp.ClaimRepo.NumOpenClaims() {
 			if waited >= 140 {
-				smartpool.Output.Printf("Unrecoverable inconsistent state between client and contract. Resetting both sides...")
+				logger.Errorf("Unrecoverable inconsistent state between client and contract. Resetting both sides...")
 				sp.ClaimRepo.ResetOpenClaims()
+				sp.updateSubmission(func(s *Submission) {
+					s.UnverifiedShares = 0
+				})
 				err := sp.Contract.ResetOpenClaims()
 				if err != nil {
 					return err
 				} else {
-					smartpool.Output.Printf("Done.\n")
+					logger.Debugf("Done.\n")
 				}
 				break
 			} else {
-				smartpool.Output.Printf(
+				logger.Warnf(
 					"Inconsistent open claim list between client(%d claims) and contract(%d claims). Recheck in 14s...\n",
 					sp.ClaimRepo.NumOpenClaims(),
 					numOpenClaimsContract.Uint64(),
@@ -215,59 +361,289 @@ func (sp *SmartPool) consistencyCheck() error {
 	return nil
 }
 
+func (sp *SmartPool) shareThreshold() int {
+	sp.thresholdMu.RLock()
+	defer sp.thresholdMu.RUnlock()
+	return sp.ShareThreshold
+}
+
+func (sp *SmartPool) claimThreshold() int {
+	sp.thresholdMu.RLock()
+	defer sp.thresholdMu.RUnlock()
+	return sp.ClaimThreshold
+}
+
+// SetThresholds changes the number of shares a claim needs and the number
+// of claims a batch needs while SmartPool is running. They are used from
+// the next claim on.
+func (sp *SmartPool) SetThresholds(shareThreshold, claimThreshold int) {
+	sp.thresholdMu.Lock()
+	defer sp.thresholdMu.Unlock()
+	sp.ShareThreshold = shareThreshold
+	sp.ClaimThreshold = claimThreshold
+}
+
 // Submit does all the protocol that communicates with the contract to submit
 // the claim then verify it.
 // It returns true when the claim is fully verified and accepted by the
 // contract. It returns false otherwise.
 func (sp *SmartPool) Submit() (bool, error) {
-	claim := sp.SealClaim()
-	if claim == nil {
-		return false, nil
-	}
+	return sp.submit(sp.shareThreshold(), false)
+}
+
+// submit seals a claim of at least threshold shares and submits it. When
+// endBatch is true, the claim is the last one of its batch regardless of the
+// claim threshold so the batch is verified right away.
+func (sp *SmartPool) submit(threshold int, endBatch bool) (bool, error) {
+	sp.submitMu.Lock()
+	defer sp.submitMu.Unlock()
+	// open claims are checked before sealing because the claim is put into
+	// the open claims queue as it is sealed
 	if err := sp.consistencyCheck(); err != nil {
 		return false, err
 	}
-	smartpool.Output.Printf("Submitting the claim with %d shares.\n", claim.NumShares().Int64())
-	var lastClaim bool
-	if int(sp.ClaimRepo.NumOpenClaims()+1) >= sp.ClaimThreshold {
-		lastClaim = true
-	} else {
-		lastClaim = false
+	claim := sp.sealClaim(threshold, endBatch)
+	if claim == nil {
+		return false, nil
 	}
-	sp.ClaimRepo.PutOpenClaim(claim)
-	smartpool.Output.Printf("The claim is successfully put into open claims queue.\n")
-	subErr := sp.Contract.SubmitClaim(claim, lastClaim)
+	logger.Infof("Submitting the claim with %d shares.\n", claim.NumShares().Int64())
+	return sp.submitClaim(claim)
+}
+
+func (sp *SmartPool) submitClaim(claim smartpool.Claim) (bool, error) {
+	submission := sp.currentSubmission()
+	subErr := sp.Contract.SubmitClaim(claim, submission.LastClaim)
 	if subErr != nil {
-		smartpool.Output.Printf("Got error submitting claim to contract: %s\n", subErr)
+		logger.Warnf("Got error submitting claim to contract: %s\n", subErr)
 		sp.ClaimRepo.RemoveOpenClaim(claim)
 		sp.StatRecorder.RecordClaim("error", claim)
+		sp.setSubmissionState(SubmissionIdle)
 		return false, subErr
 	}
 	sp.StatRecorder.RecordClaim("submitted", claim)
-	smartpool.Output.Printf("The claim is successfully submitted.\n")
-	if lastClaim {
+	logger.Infof("The claim is successfully submitted.\n")
+	logger.Emit(smartpool.ClaimSubmittedEvent, smartpool.Fields{
+		"shares": submission.ClaimShares,
+		"last":   submission.LastClaim,
+	})
+	sp.updateSubmission(func(s *Submission) {
+		s.UnverifiedShares += s.ClaimShares
+		s.State = SubmissionSubmitted
+	})
+	if !submission.LastClaim {
+		return true, nil
+	}
+	return sp.requestVerification(claim)
+}
+
+// requestVerification seals the claim batch and waits for the contract to
+// pick the share to verify. claim is nil when resuming from last session.
+func (sp *SmartPool) requestVerification(claim smartpool.Claim) (bool, error) {
+	// the batch is already sealed if the client stopped after sealing it
+	if sp.ClaimRepo.NumOpenClaims() > 0 {
 		sp.ClaimRepo.SealClaimBatch()
-		smartpool.Output.Printf("Waiting for verification index...")
-		claimIndex, shareIndex := sp.GetVerificationIndex(claim)
-		smartpool.Output.Printf("Verification for share index(%d) in claim index(%d) has been requested.\n", shareIndex.Int64(), claimIndex.Int64())
-		claim = sp.ClaimRepo.GetOpenClaim(int(claimIndex.Int64()))
-		if claim == nil {
-			smartpool.Output.Printf("Got nil claim for share index(%d) in claim index(%d). This is a bug. Please report it to SmartPool Team.\n", shareIndex.Int64(), claimIndex.Int64())
-			return false, errors.New("Nil claim. Incorrect verification indexes")
-		}
-		smartpool.Output.Printf("Submitting claim verification...\n")
-		verErr := sp.Contract.VerifyClaim(claimIndex, shareIndex, claim)
-		if verErr != nil {
-			smartpool.Output.Printf("%s\n", verErr)
+	}
+	sp.setSubmissionState(SubmissionSeedRequested)
+	logger.Debugf("Waiting for verification index...")
+	claimIndex, shareIndex := sp.GetVerificationIndex(claim)
+	logger.With("claim_index", claimIndex, "share_index", shareIndex).Infof(
+		"Verification for share index(%d) in claim index(%d) has been requested.\n", shareIndex.Int64(), claimIndex.Int64())
+	sp.updateSubmission(func(s *Submission) {
+		s.ClaimIndex = claimIndex
+		s.ShareIndex = shareIndex
+		s.State = SubmissionIndexKnown
+	})
+	return sp.verifyClaim()
+}
+
+func (sp *SmartPool) verifyClaim() (bool, error) {
+	submission := sp.currentSubmission()
+	claimIndex := submission.ClaimIndex
+	shareIndex := submission.ShareIndex
+	claim := sp.ClaimRepo.GetOpenClaim(int(claimIndex.Int64()))
+	if claim == nil {
+		logger.Errorf("Got nil claim for share index(%d) in claim index(%d). This is a bug. Please report it to SmartPool Team.\n", shareIndex.Int64(), claimIndex.Int64())
+		sp.updateSubmission(func(s *Submission) {
+			s.UnverifiedShares = 0
+			s.State = SubmissionIdle
+		})
+		return false, errors.New("Nil claim. Incorrect verification indexes")
+	}
+	logger.With("claim_index", claimIndex, "share_index", shareIndex).Infof("Submitting claim verification...\n")
+	verErr := sp.Contract.VerifyClaim(claimIndex, shareIndex, claim, sp.verificationSent)
+	return sp.verificationDone(claim, verErr)
+}
+
+// verificationDone records the contract's verdict on the claim batch whose
+// claim was chosen for verification.
+func (sp *SmartPool) verificationDone(claim smartpool.Claim, verErr error) (bool, error) {
+	submission := sp.currentSubmission()
+	claimLogger := logger.With("claim_index", submission.ClaimIndex, "share_index", submission.ShareIndex)
+	if verErr != nil {
+		claimLogger.Warnf("%s\n", verErr)
+		claimLogger.Emit(smartpool.ClaimRejectedEvent, smartpool.Fields{"error": verErr.Error()})
+		if claim != nil {
 			sp.StatRecorder.RecordClaim("rejected", claim)
-			return false, verErr
 		}
-		smartpool.Output.Printf("Claim is successfully verified.\n")
+		sp.updateSubmission(func(s *Submission) {
+			s.UnverifiedShares = 0
+			s.State = SubmissionRejected
+		})
+		return false, verErr
+	}
+	claimLogger.Infof("Claim is successfully verified.\n")
+	if claim != nil {
+		claimLogger.Emit(smartpool.ClaimVerifiedEvent, smartpool.Fields{
+			"shares": claim.NumShares().Uint64(),
+		})
 		sp.StatRecorder.RecordClaim("accepted", claim)
 	}
+	sp.updateSubmission(func(s *Submission) {
+		s.UnverifiedShares = 0
+		s.State = SubmissionVerified
+	})
 	return true, nil
 }
 
+func (sp *SmartPool) verificationSent(tx common.Hash) {
+	sp.updateSubmission(func(s *Submission) {
+		s.VerifyTx = tx
+		s.State = SubmissionVerifySent
+	})
+}
+
+// waitForVerification waits for the verification tx sent in last session to
+// be mined and takes the contract's verdict from its receipt. If it isn't
+// mined in time, the verification is sent again.
+func (sp *SmartPool) waitForVerification() (bool, error) {
+	submission := sp.currentSubmission()
+	logger.Debugf("Waiting for verification tx %s from last session...\n", submission.VerifyTx.Hex())
+	waited := time.Duration(0)
+	for {
+		mined, verErr := sp.Contract.VerificationResult(submission.VerifyTx)
+		if mined {
+			logger.Infof("Verification tx %s from last session is mined.\n", submission.VerifyTx.Hex())
+			claim := sp.ClaimRepo.GetOpenClaim(int(submission.ClaimIndex.Int64()))
+			return sp.verificationDone(claim, verErr)
+		}
+		if verErr != nil {
+			return false, verErr
+		}
+		if waited >= VerificationRecoveryWait {
+			logger.Infof("Verification tx %s is not mined in time. Resending claim verification.\n", submission.VerifyTx.Hex())
+			return sp.verifyClaim()
+		}
+		time.Sleep(14 * time.Second)
+		waited += 14 * time.Second
+	}
+}
+
+// Resume continues the claim submission that was interrupted when the
+// client stopped in last session. It returns false with nil error when there
+// is nothing to resume. Otherwise it returns the same as Submit would return
+// for the claim.
+func (sp *SmartPool) Resume() (bool, error) {
+	submission := sp.currentSubmission()
+	switch submission.State {
+	case SubmissionSealed:
+		claim := sp.ClaimRepo.LatestOpenClaim()
+		if claim == nil {
+			logger.Warnf("Couldn't find the claim sealed in last session. It is dropped.\n")
+			sp.setSubmissionState(SubmissionIdle)
+			return false, nil
+		}
+		numOpenClaimsContract, err := sp.Contract.NumOpenClaims()
+		if err != nil {
+			return false, err
+		}
+		if numOpenClaimsContract.Uint64() != sp.ClaimRepo.NumOpenClaims() {
+			logger.Infof("Resubmitting the claim sealed in last session with %d shares.\n", claim.NumShares().Int64())
+			return sp.submitClaim(claim)
+		}
+		logger.Infof("The claim sealed in last session was already submitted.\n")
+		sp.StatRecorder.RecordClaim("submitted", claim)
+		sp.updateSubmission(func(s *Submission) {
+			s.UnverifiedShares += s.ClaimShares
+			s.State = SubmissionSubmitted
+		})
+		if !submission.LastClaim {
+			return true, nil
+		}
+		return sp.requestVerification(claim)
+	case SubmissionSubmitted:
+		if !submission.LastClaim {
+			return false, nil
+		}
+		logger.Infof("Resuming verification of the claim batch submitted in last session.\n")
+		return sp.requestVerification(nil)
+	case SubmissionSeedRequested:
+		logger.Infof("Resuming verification of the claim batch submitted in last session.\n")
+		return sp.requestVerification(nil)
+	case SubmissionIndexKnown:
+		logger.Infof("Resuming verification of the claim batch submitted in last session.\n")
+		return sp.verifyClaim()
+	case SubmissionVerifySent:
+		return sp.waitForVerification()
+	}
+	return false, nil
+}
+
+// SubmitRemaining finishes the claim submission interrupted in last session
+// then submits every share left as the last claim of its batch regardless
+// of the thresholds. It is used to submit an archived session that won't
+// get new shares. It returns the same as Submit would return for the claim.
+func (sp *SmartPool) SubmitRemaining() (bool, error) {
+	sp.submitMu.Lock()
+	_, err := sp.Resume()
+	sp.submitMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return sp.submit(1, true)
+}
+
+// RestoredShares returns number of shares from last session that are not
+// submitted yet and number of shares in submitted claims that are still
+// being verified.
+func (sp *SmartPool) RestoredShares() (uint64, uint64) {
+	submission := sp.currentSubmission()
+	pending := sp.ClaimRepo.NoActiveShares()
+	if submission.State == SubmissionSealed {
+		pending += submission.ClaimShares
+	}
+	return pending, submission.UnverifiedShares
+}
+
+// currentSubmission returns a copy of the submission so it can be read
+// while the submitter changes it.
+func (sp *SmartPool) currentSubmission() Submission {
+	sp.stateMu.RLock()
+	defer sp.stateMu.RUnlock()
+	return *sp.submission
+}
+
+// updateSubmission changes the submission with update and persists it with
+// the claim repo. The submission is guarded by stateMu rather than submitMu,
+// which is held for the whole submission, so its state can be read while a
+// claim is being submitted.
+func (sp *SmartPool) updateSubmission(update func(s *Submission)) {
+	sp.stateMu.Lock()
+	defer sp.stateMu.Unlock()
+	update(sp.submission)
+	batch := storage.NewBatch(sp.Storage)
+	sp.ClaimRepo.Persist(batch)
+	persistSubmission(batch, sp.submission)
+	if err := batch.Commit(); err != nil {
+		logger.Warnf("Couldn't persist submission state (%s): %s\n", sp.submission.State, err)
+	}
+}
+
+func (sp *SmartPool) setSubmissionState(state string) {
+	sp.updateSubmission(func(s *Submission) {
+		s.State = state
+	})
+}
+
 func (sp *SmartPool) stopSubmitter() {
 	sp.stopSubmitterChan <- true
 }
@@ -275,7 +651,7 @@ func (sp *SmartPool) stopSubmitter() {
 func (sp *SmartPool) monitor() {
 	for {
 		if sp.PoolMonitor.RequireContractUpdate() {
-			smartpool.Output.Printf(
+			logger.Infof(
 				"We deployed new contract at %s. Please restart SmartPool client with --spcontract %s.\n",
 				sp.PoolMonitor.ContractAddress().Hex(),
 				sp.PoolMonitor.ContractAddress().Hex())
@@ -285,7 +661,7 @@ func (sp *SmartPool) monitor() {
 			}
 		}
 		if sp.PoolMonitor.RequireClientUpdate() {
-			smartpool.Output.Printf("Your SmartPool client is too old. Please update to new version by going to https://github.com/SmartPool/smartpool-client.\n")
+			logger.Errorf("Your SmartPool client is too old. Please update to new version by going to https://github.com/SmartPool/smartpool-client.\n")
 			if sp.HotStop {
 				sp.stopSubmitter()
 				return
@@ -304,7 +680,7 @@ func (sp *SmartPool) shouldStop(err error) bool {
 		return false
 	}
 	if err.Error() == "timeout error" {
-		smartpool.Output.Printf("The tx might not be verified. Current claim is dropped. Continue with next claim.\n")
+		logger.Warnf("The tx might not be verified. Current claim is dropped. Continue with next claim.\n")
 		return false
 	} else if sp.HotStop {
 		return true
@@ -315,47 +691,70 @@ func (sp *SmartPool) shouldStop(err error) bool {
 
 func (sp *SmartPool) runPersister() {
 	for {
-		sp.persist()
+		sp.Persist()
 		time.Sleep(time.Minute)
 	}
 }
 
-func (sp *SmartPool) persist() {
-	sp.ClaimRepo.Persist(sp.Storage)
-	sp.StatRecorder.Persist(sp.Storage)
-	sp.ShareReceiver.Persist(sp.Storage)
+// Persist commits claims, the latest counter, stats and shares received so
+// far to storage. It is called every minute and when SmartPool stops.
+func (sp *SmartPool) Persist() {
+	batch := storage.NewBatch(sp.Storage)
+	sp.counterMu.RLock()
+	sp.ClaimRepo.Persist(batch)
+	persistLatestCounter(batch, sp.LatestCounter)
+	sp.counterMu.RUnlock()
+	sp.StatRecorder.Persist(batch)
+	sp.ShareReceiver.Persist(batch)
+	if err := batch.Commit(); err != nil {
+		logger.Warnf("Couldn't commit current state to storage: %s\n", err)
+	}
 }
 
 func (sp *SmartPool) actOnTick() {
 	defer func() {
 		if r := recover(); r != nil {
-			smartpool.Output.Printf("Recovered in actOnTick: %v\n", r)
+			logger.Errorf("Recovered in actOnTick: %v\n", r)
 			debug.PrintStack()
 		}
 	}()
-	var err error
+	sp.submitMu.Lock()
+	_, err := sp.Resume()
+	sp.submitMu.Unlock()
+	if sp.shouldStop(err) {
+		logger.Errorf("SmartPool stopped. If you want SmartPool to keep running, please use \"--no-hot-stop\" to disable Hot Stop mode.\n")
+		sp.Exit()
+		return
+	}
 Loop:
 	for {
 		select {
 		case <-sp.ticker:
-			_, err = sp.Submit()
-			if sp.shouldStop(err) {
-				smartpool.Output.Printf("SmartPool stopped. If you want SmartPool to keep running, please use \"--no-hot-stop\" to disable Hot Stop mode.\n")
-				break Loop
+			if sp.SubmitterPaused() {
+				logger.Debugf("Submitter is paused. Skipping claim submission.\n")
+				continue
 			}
+			_, err = sp.Submit()
+		case <-sp.forceSubmitChan:
+			logger.Infof("Submitting a claim on operator's request.\n")
+			_, err = sp.submit(1, true)
 		case <-sp.stopSubmitterChan:
 			break Loop
 		}
+		if sp.shouldStop(err) {
+			logger.Errorf("SmartPool stopped. If you want SmartPool to keep running, please use \"--no-hot-stop\" to disable Hot Stop mode.\n")
+			break Loop
+		}
 	}
 	sp.Exit()
 }
 
 func (sp *SmartPool) Exit() {
-	smartpool.Output.Printf("Persisting current state to disk...\n")
-	sp.persist()
-	smartpool.Output.Printf("Gracefully stopped SmartPool.\n")
+	logger.Debugf("Persisting current state to disk...\n")
+	sp.Persist()
+	logger.Infof("Gracefully stopped SmartPool.\n")
 	sp.SubmitterStopped <- true
-	smartpool.Output.Printf("Close log file.\n")
+	logger.Debugf("Close log file.\n")
 	smartpool.Output.Close()
 }
 
@@ -368,7 +767,7 @@ func (sp *SmartPool) Run() bool {
 	defer sp.runMu.Unlock()
 	if sp.Register(sp.MinerAddress) {
 		if sp.loopStarted {
-			smartpool.Output.Printf("Warning: calling Run() multiple times\n")
+			logger.Warnf("Calling Run() multiple times\n")
 			return false
 		}
 		err := sp.NetworkClient.Configure(
@@ -380,18 +779,18 @@ func (sp *SmartPool) Run() bool {
 		}
 		for {
 			if sp.NetworkClient.ReadyToMine() {
-				smartpool.Output.Printf("The network is ready for mining.\n")
+				logger.Infof("The network is ready for mining.\n")
 				sp.ticker = time.Tick(sp.SubmitInterval)
 				go sp.monitor()
 				go sp.actOnTick()
-				smartpool.Output.Printf("Share collector is running...\n")
+				logger.Infof("Share collector is running...\n")
 				go sp.runPersister()
-				smartpool.Output.Printf("Share persister and stat persister are running...\n")
+				logger.Infof("Share persister and stat persister are running...\n")
 				go sp.handleSignal()
 				sp.loopStarted = true
 				break
 			}
-			smartpool.Output.Printf("The network is not ready for mining yet. Retry in 10s...\n")
+			logger.Warnf("The network is not ready for mining yet. Retry in 10s...\n")
 			time.Sleep(10 * time.Second)
 		}
 		return true
@@ -402,7 +801,7 @@ func (sp *SmartPool) Run() bool {
 
 func (sp *SmartPool) handleSignal() {
 	<-sp.signal
-	smartpool.Output.Printf("Got shutdown signal:\n")
+	logger.Infof("Got shutdown signal.\n")
 	sp.Exit()
 }
 
@@ -421,9 +820,13 @@ func NewSmartPool(
 	claimThreshold int, hotStop bool, input smartpool.UserInput) *SmartPool {
 	counter, err := loadLatestCounter(ps)
 	if err != nil {
-		smartpool.Output.Printf("Couldn't load counter from storage. Initialize it to 0.\n")
+		logger.Warnf("Couldn't load counter from storage. Initialize it to 0.\n")
 		counter = big.NewInt(0)
 	}
+	submission, err := loadSubmission(ps)
+	if err != nil {
+		logger.Warnf("Couldn't load submission state from storage. Initialize it to idle.\n")
+	}
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	return &SmartPool{
@@ -447,7 +850,10 @@ func NewSmartPool(
 		runMu:             sync.Mutex{},
 		SubmitterStopped:  make(chan bool, 1),
 		stopSubmitterChan: make(chan bool, 1),
+		forceSubmitChan:   make(chan bool, 1),
 		Input:             input,
 		signal:            sig,
+		submission:        submission,
+		recentShares:      newRecentShares(),
 	}
 }
@@ -1,8 +1,10 @@
 package protocol
 
 import (
+	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 )
@@ -162,6 +164,178 @@ func TestSmartPoolSubmitReturnFalseWhenUnableToVerify(t *testing.T) {
 	}
 }
 
+func TestSmartPoolRecordVerificationTx(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.ShareThreshold = 1
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	sp.Submit()
+	if sp.submission.State != SubmissionVerified {
+		t.Fail()
+	}
+	if sp.submission.UnverifiedShares != 0 {
+		t.Fail()
+	}
+}
+
+func TestSmartPoolResumeNothingAfterVerified(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.submission.State = SubmissionVerified
+	if ok, err := sp.Resume(); ok || err != nil {
+		t.Fail()
+	}
+}
+
+func TestSmartPoolResumeVerificationWhenIndexKnown(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.ShareThreshold = 1
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	sp.GetCurrentClaim(1)
+	sp.submission.State = SubmissionIndexKnown
+	sp.submission.LastClaim = true
+	sp.submission.ClaimIndex = big.NewInt(0)
+	sp.submission.ShareIndex = big.NewInt(0)
+	if ok, _ := sp.Resume(); !ok {
+		t.Fail()
+	}
+	c := sp.Contract.(*testContract)
+	if c.SubmitTime != nil || c.IndexRequestedTime != nil {
+		t.Fail()
+	}
+}
+
+func TestSmartPoolDoesntResubmitSubmittedClaim(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.ShareThreshold = 1
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	sp.GetCurrentClaim(1)
+	sp.submission.State = SubmissionSealed
+	sp.submission.LastClaim = true
+	sp.submission.ClaimShares = 1
+	if ok, _ := sp.Resume(); !ok {
+		t.Fail()
+	}
+	c := sp.Contract.(*testContract)
+	if c.SubmitTime != nil || c.IndexRequestedTime == nil {
+		t.Fail()
+	}
+	if sp.submission.State != SubmissionVerified {
+		t.Fail()
+	}
+}
+
+func TestSmartPoolSubmitRemainingIgnoresThresholds(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.ClaimThreshold = 10
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	ok, err := sp.SubmitRemaining()
+	if !ok || err != nil {
+		t.Fatalf("expected the remaining share to be submitted and verified, got %v (%v)", ok, err)
+	}
+	c := sp.Contract.(*testContract)
+	if c.GetLastSubmittedClaim().NumShares().Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("expected a claim of 1 share")
+	}
+	if ok, _ := sp.SubmitRemaining(); ok {
+		t.Fatalf("expected nothing left to submit")
+	}
+}
+
+func TestSmartPoolRestoreSharesOfSealedClaim(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.submission.State = SubmissionSealed
+	sp.submission.ClaimShares = 5
+	sp.submission.UnverifiedShares = 10
+	pending, unverified := sp.RestoredShares()
+	if pending != 5 || unverified != 10 {
+		t.Fail()
+	}
+}
+
+func TestSmartPoolTakesVerdictFromVerificationTxOfLastSession(t *testing.T) {
+	sp := newTestSmartPool()
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	sp.GetCurrentClaim(1)
+	c := sp.Contract.(*testContract)
+	c.VerifyFailed = true
+	sp.submission.State = SubmissionVerifySent
+	sp.submission.ClaimIndex = big.NewInt(0)
+	sp.submission.UnverifiedShares = 1
+	if ok, err := sp.Resume(); ok || err == nil {
+		t.Fatalf("expected the rejection of the mined tx, got %v (%v)", ok, err)
+	}
+	if c.VerifyCalls != 0 {
+		t.Fatalf("expected the mined verification not to be resent")
+	}
+	if sp.submission.State != SubmissionRejected || sp.submission.UnverifiedShares != 0 {
+		t.Fatalf("expected the batch to be rejected, got state %s", sp.submission.State)
+	}
+}
+
+func TestSmartPoolResendsVerificationNotMinedInTime(t *testing.T) {
+	defer func(wait time.Duration) { VerificationRecoveryWait = wait }(VerificationRecoveryWait)
+	VerificationRecoveryWait = 0
+	sp := newTestSmartPool()
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	sp.GetCurrentClaim(1)
+	c := sp.Contract.(*testContract)
+	c.VerifyPending = true
+	sp.submission.State = SubmissionVerifySent
+	sp.submission.ClaimIndex = big.NewInt(0)
+	sp.submission.ShareIndex = big.NewInt(0)
+	if ok, err := sp.Resume(); !ok || err != nil {
+		t.Fatalf("expected the resent verification to pass, got %v (%v)", ok, err)
+	}
+	if c.VerifyCalls != 1 {
+		t.Fatalf("expected the verification to be resent once, sent %d times", c.VerifyCalls)
+	}
+}
+
+// batchRecordingStorage records ids persisted by each committed batch.
+type batchRecordingStorage struct {
+	testPersistentStorage
+	commits [][]string
+}
+
+type recordingBatch struct {
+	storage *batchRecordingStorage
+	ids     []string
+}
+
+func (b *recordingBatch) Persist(data interface{}, id string) error {
+	b.ids = append(b.ids, id)
+	return nil
+}
+func (b *recordingBatch) Load(data interface{}, id string) (interface{}, error) {
+	return b.storage.Load(data, id)
+}
+func (b *recordingBatch) Commit() error {
+	b.storage.commits = append(b.storage.commits, b.ids)
+	return nil
+}
+func (s *batchRecordingStorage) NewBatch() smartpool.Batch {
+	return &recordingBatch{s, nil}
+}
+
+func TestSmartPoolSealsClaimInOneBatch(t *testing.T) {
+	sp := newTestSmartPool()
+	ps := &batchRecordingStorage{}
+	sp.Storage = ps
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	if sp.sealClaim(1, false) == nil {
+		t.Fatalf("expected a claim")
+	}
+	if len(ps.commits) != 1 {
+		t.Fatalf("expected one batch, got %v", ps.commits)
+	}
+	persisted := strings.Join(ps.commits[0], ",")
+	if persisted != COUNTER_FILE+","+SUBMISSION_FILE {
+		t.Fatalf("expected the counter and the submission in the batch, got %s", persisted)
+	}
+	if sp.submission.State != SubmissionSealed || sp.submission.ClaimShares != 1 {
+		t.Fatalf("expected the sealed claim to be the submission, got state %s", sp.submission.State)
+	}
+}
+
 func TestSmartPoolDoesntRunWhenMinerRegistered(t *testing.T) {
 	sp := newTestSmartPool()
 	if sp.Run() {
@@ -280,3 +454,68 @@ func TestSmartPoolStopIfContractAddressChangedInHotStopMode(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSmartPoolStopsSubmitterOnRequest(t *testing.T) {
+	sp := newTestSmartPool()
+	testContract := sp.Contract.(*testContract)
+	testContract.Registered = true
+	sp.Run()
+	sp.Stop()
+	sp.Stop()
+	select {
+	case <-sp.SubmitterStopped:
+		break
+	case <-time.After(100 * time.Millisecond):
+		t.Fail()
+	}
+}
+
+// testRigPolicy records outcomes of solutions.
+type testRigPolicy struct {
+	outcomes []string
+}
+
+func (p *testRigPolicy) Banned(rig smartpool.Rig) bool { return false }
+func (p *testRigPolicy) Allow(rig smartpool.Rig) bool  { return true }
+func (p *testRigPolicy) ShareChecked(rig smartpool.Rig, outcome string) {
+	p.outcomes = append(p.outcomes, outcome)
+}
+
+func TestSmartPoolRejectsClaimedShareAsDuplicated(t *testing.T) {
+	sp := newTestSmartPool()
+	policy := &testRigPolicy{}
+	sp.BanPolicy = policy
+	sp.LatestCounter = big.NewInt(5)
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)})
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(10)})
+	if sp.sealClaim(1, false) == nil {
+		t.Fatalf("expected a claim")
+	}
+	if sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(9)}) {
+		t.Fatalf("expected claimed share to be rejected")
+	}
+	sp.AcceptSolution(rig, &testSolution{Counter: big.NewInt(8)})
+	expected := []string{"accepted", "accepted", "duplicated", "rejected"}
+	if len(policy.outcomes) != len(expected) {
+		t.Fatalf("expected outcomes %v, got %v", expected, policy.outcomes)
+	}
+	for i, outcome := range expected {
+		if policy.outcomes[i] != outcome {
+			t.Fatalf("expected outcomes %v, got %v", expected, policy.outcomes)
+		}
+	}
+}
+
+func TestRecentSharesForgetsOldestShare(t *testing.T) {
+	old := RecentShares
+	RecentShares = 2
+	defer func() { RecentShares = old }()
+	rs := newRecentShares()
+	for _, h := range []byte{1, 2, 2, 3} {
+		rs.add((&testShare{h: h}).Hash())
+	}
+	if rs.contains((&testShare{h: 1}).Hash()) ||
+		!rs.contains((&testShare{h: 2}).Hash()) || !rs.contains((&testShare{h: 3}).Hash()) {
+		t.Fatalf("expected only the last 2 shares to be remembered")
+	}
+}
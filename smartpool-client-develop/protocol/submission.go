@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"github.com/SmartPool/smartpool-client"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+const SUBMISSION_FILE string = "submission"
+
+// States of a claim submission. A claim goes through them in order. A claim
+// that is not the last one of its batch is done once it is submitted. The
+// last claim of a batch is done when it is verified or rejected.
+const (
+	SubmissionIdle          = ""
+	SubmissionSealed        = "sealed"
+	SubmissionSubmitted     = "submitted"
+	SubmissionSeedRequested = "seed-requested"
+	SubmissionIndexKnown    = "index-known"
+	SubmissionVerifySent    = "verify-sent"
+	SubmissionVerified      = "verified"
+	SubmissionRejected      = "rejected"
+)
+
+// Submission records how far SmartPool got in submitting and verifying the
+// current claim. It is persisted on every state change so the protocol can
+// resume from where it stopped when the client is restarted.
+type Submission struct {
+	State     string
+	LastClaim bool
+	// ClaimShares is number of shares in the current claim
+	ClaimShares uint64
+	// UnverifiedShares is number of shares in submitted claims of the
+	// current batch
+	UnverifiedShares uint64
+	ClaimIndex       *big.Int
+	ShareIndex       *big.Int
+	VerifyTx         common.Hash
+}
+
+func persistSubmission(ps smartpool.PersistentStorage, s *Submission) error {
+	return ps.Persist(s, SUBMISSION_FILE)
+}
+
+func loadSubmission(ps smartpool.PersistentStorage) (*Submission, error) {
+	submission := &Submission{}
+	loadedSubmission, err := ps.Load(submission, SUBMISSION_FILE)
+	if err != nil || loadedSubmission == nil {
+		return &Submission{}, err
+	}
+	return loadedSubmission.(*Submission), nil
+}
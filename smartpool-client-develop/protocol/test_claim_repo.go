@@ -43,12 +43,24 @@ func (cr *testClaimRepo) GetOpenClaim(claimIndex int) smartpool.Claim {
 	return cr.oc[0]
 }
 
+func (cr *testClaimRepo) LatestOpenClaim() smartpool.Claim {
+	if len(cr.oc) == 0 {
+		return nil
+	}
+	return cr.oc[len(cr.oc)-1]
+}
+
 func (cr *testClaimRepo) ResetOpenClaims() {
+	cr.oc = []smartpool.Claim{}
 }
 
 func (cr *testClaimRepo) RemoveOpenClaim(claim smartpool.Claim) {
 }
 
+func (cr *testClaimRepo) OpenClaims() []smartpool.Claim {
+	return []smartpool.Claim{}
+}
+
 func (cr *testClaimRepo) NumOpenClaims() uint64 {
 	return 0
 }
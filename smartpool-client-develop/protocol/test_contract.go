@@ -17,10 +17,13 @@ type testContract struct {
 	IndexRequestedTime  *time.Time
 	claim               *testClaim
 	DelayedVerification bool
+	ResetFailed         bool
+	VerifyPending       bool
+	VerifyCalls         int
 }
 
 func newTestContract() *testContract {
-	return &testContract{false, false, false, false, nil, nil, nil, false}
+	return &testContract{false, false, false, false, nil, nil, nil, false, false, false, 0}
 }
 
 func (c *testContract) Version() string {
@@ -50,7 +53,11 @@ func (c *testContract) GetShareIndex(claim smartpool.Claim) (*big.Int, *big.Int,
 	c.IndexRequestedTime = &t
 	return big.NewInt(0), big.NewInt(100), nil
 }
-func (c *testContract) VerifyClaim(claimIndex *big.Int, shareIndex *big.Int, claim smartpool.Claim) error {
+func (c *testContract) VerifyClaim(claimIndex *big.Int, shareIndex *big.Int, claim smartpool.Claim, txSent func(tx common.Hash)) error {
+	c.VerifyCalls++
+	if txSent != nil {
+		txSent(common.Hash{})
+	}
 	if c.VerifyFailed {
 		return errors.New("fail")
 	}
@@ -59,6 +66,15 @@ func (c *testContract) VerifyClaim(claimIndex *big.Int, shareIndex *big.Int, cla
 	}
 	return nil
 }
+func (c *testContract) VerificationResult(tx common.Hash) (bool, error) {
+	if c.VerifyPending {
+		return false, nil
+	}
+	if c.VerifyFailed {
+		return true, errors.New("fail")
+	}
+	return true, nil
+}
 func (c *testContract) GetLastSubmittedClaim() *testClaim {
 	return c.claim
 }
@@ -66,5 +82,8 @@ func (c *testContract) NumOpenClaims() (*big.Int, error) {
 	return big.NewInt(0), nil
 }
 func (c *testContract) ResetOpenClaims() error {
+	if c.ResetFailed {
+		return errors.New("fail")
+	}
 	return nil
 }
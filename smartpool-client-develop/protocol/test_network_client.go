@@ -4,6 +4,7 @@ import (
 	"github.com/SmartPool/smartpool-client"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
 )
 
 type testNetworkClient struct {
@@ -14,6 +15,10 @@ func (n *testNetworkClient) GetWork() smartpool.Work {
 	return &testWork{}
 }
 
+func (n *testNetworkClient) GetWorkWithDifficulty(diff *big.Int) smartpool.Work {
+	return &testWork{}
+}
+
 func (n *testNetworkClient) SubmitSolution(s smartpool.Solution) bool {
 	return true
 }
@@ -9,7 +9,7 @@ type testShareReceiver struct {
 
 func (spc *testShareReceiver) AcceptSolution(s smartpool.Solution) smartpool.Share {
 	sol := s.(*testSolution)
-	return &testShare{c: sol.Counter}
+	return &testShare{c: sol.Counter, h: byte(sol.Counter.Int64())}
 }
 
 func (spc *testShareReceiver) Persist(storage smartpool.PersistentStorage) error {
@@ -16,7 +16,7 @@ func (self *testStatRecorder) RecordClaim(status string, claim smartpool.Claim)
 }
 func (self *testStatRecorder) RecordHashrate(hashrate hexutil.Uint64, id common.Hash, rig smartpool.Rig) {
 }
-func (self *testStatRecorder) ShareRestored(noshares uint64) {
+func (self *testStatRecorder) ShareRestored(noshares uint64, nounverified uint64) {
 }
 
 func (self *testStatRecorder) OverallFarmStat() interface{} {
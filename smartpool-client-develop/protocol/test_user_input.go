@@ -20,6 +20,9 @@ func (self *testUserInput) ShareThreshold() int {
 func (self *testUserInput) ShareDifficulty() *big.Int {
 	return big.NewInt(1000000)
 }
+func (self *testUserInput) MaxShareDifficulty() *big.Int {
+	return big.NewInt(1000000)
+}
 func (self *testUserInput) SubmitInterval() time.Duration {
 	return time.Minute
 }
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+// directBatch is used for storages that don't support transactions. It
+// persists data right away so Commit doesn't do anything.
+type directBatch struct {
+	storage smartpool.PersistentStorage
+}
+
+func (db *directBatch) Persist(data interface{}, id string) error {
+	return db.storage.Persist(data, id)
+}
+
+func (db *directBatch) Load(data interface{}, id string) (interface{}, error) {
+	return db.storage.Load(data, id)
+}
+
+func (db *directBatch) Commit() error {
+	return nil
+}
+
+// NewBatch returns a batch that commits atomically if ps is a
+// smartpool.TransactionalStorage. Otherwise, data persisted to the batch is
+// written to ps immediately.
+func NewBatch(ps smartpool.PersistentStorage) smartpool.Batch {
+	if ts, ok := ps.(smartpool.TransactionalStorage); ok {
+		return ts.NewBatch()
+	}
+	return &directBatch{ps}
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	BOLT_FILE      string = "smartpool.db"
+	dataBucket            = []byte("data")
+	metaBucket            = []byte("meta")
+	gobMigratedKey        = []byte("gob_migrated")
+)
+
+// GobIDs are ids of data the client persists with GobFileStorage. Only gob
+// files of these ids are migrated into a bolt database, other files in the
+// same directory such as share journals, session archives and logs are
+// left alone.
+var GobIDs = []string{
+	"counter", "submission", "workpool",
+	"active_shares", "active_claims", "open_claims",
+	"stat_recorder", "epochseed", "block_tracker",
+}
+
+var (
+	// farm and rig stats are persisted per period
+	gobStatID = regexp.MustCompile(`^(farm|rig-.+)-data-[0-9]+$`)
+	// claim repos of difficulty tiers are kept in diff-<hex> namespaces
+	gobTierPrefix = regexp.MustCompile(`^diff-[0-9a-f]+_`)
+)
+
+func isGobID(id string) bool {
+	if gobStatID.MatchString(id) {
+		return true
+	}
+	id = gobTierPrefix.ReplaceAllString(id, "")
+	for _, gobID := range GobIDs {
+		if id == gobID {
+			return true
+		}
+	}
+	return false
+}
+
+// BoltStorage keeps all data in one embedded BoltDB file. Data is gob
+// encoded the same way as GobFileStorage encodes it so gob files can be
+// migrated as they are.
+type BoltStorage struct {
+	db             *bolt.DB
+	mu             sync.Mutex
+	registeredType map[string]bool
+}
+
+func (bs *BoltStorage) encode(data interface{}) ([]byte, error) {
+	bs.mu.Lock()
+	registerType(bs.registeredType, data)
+	bs.mu.Unlock()
+	buff := bytes.NewBuffer([]byte{})
+	if err := gob.NewEncoder(buff).Encode(data); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (bs *BoltStorage) decode(raw []byte, data interface{}) (interface{}, error) {
+	bs.mu.Lock()
+	registerType(bs.registeredType, data)
+	bs.mu.Unlock()
+	err := gob.NewDecoder(bytes.NewReader(raw)).Decode(data)
+	return data, err
+}
+
+func (bs *BoltStorage) get(id string) []byte {
+	var raw []byte
+	bs.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(dataBucket).Get([]byte(id)); value != nil {
+			// value is only valid during the transaction
+			raw = append([]byte{}, value...)
+		}
+		return nil
+	})
+	return raw
+}
+
+func (bs *BoltStorage) put(values map[string][]byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dataBucket)
+		for id, raw := range values {
+			if err := bucket.Put([]byte(id), raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BoltStorage) Persist(data interface{}, id string) error {
+	raw, err := bs.encode(data)
+	if err != nil {
+		return err
+	}
+	return bs.put(map[string][]byte{id: raw})
+}
+
+func (bs *BoltStorage) Load(data interface{}, id string) (interface{}, error) {
+	raw := bs.get(id)
+	if raw == nil {
+		return data, fmt.Errorf("%s not found", id)
+	}
+	return bs.decode(raw, data)
+}
+
+func (bs *BoltStorage) NewBatch() smartpool.Batch {
+	return &boltBatch{bs, map[string][]byte{}}
+}
+
+// MigrateGobFiles copies the files of GobIDs GobFileStorage persisted in
+// dir into the database. It only does that once so later sessions don't overwrite newer
+// data with the old gob files. The gob files are kept untouched.
+func (bs *BoltStorage) MigrateGobFiles(dir string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(gobMigratedKey) != nil {
+			return nil
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		bucket := tx.Bucket(dataBucket)
+		migrated := 0
+		for _, f := range files {
+			if f.IsDir() || !isGobID(f.Name()) {
+				continue
+			}
+			raw, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(f.Name()), raw); err != nil {
+				return err
+			}
+			migrated++
+		}
+		if migrated > 0 {
+			logger.Infof("Migrated %d gob files from %s to %s.\n", migrated, dir, bs.db.Path())
+		}
+		return meta.Put(gobMigratedKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// FilePath returns the path of a file with id kept next to the database.
+func (bs *BoltStorage) FilePath(id string) string {
+	return filepath.Join(filepath.Dir(bs.db.Path()), id)
+}
+
+func (bs *BoltStorage) Close() error {
+	return bs.db.Close()
+}
+
+// boltBatch keeps encoded data in memory until Commit writes all of it in
+// one BoltDB transaction.
+type boltBatch struct {
+	storage *BoltStorage
+	values  map[string][]byte
+}
+
+func (bb *boltBatch) Persist(data interface{}, id string) error {
+	raw, err := bb.storage.encode(data)
+	if err != nil {
+		return err
+	}
+	bb.values[id] = raw
+	return nil
+}
+
+func (bb *boltBatch) Load(data interface{}, id string) (interface{}, error) {
+	if raw, exist := bb.values[id]; exist {
+		return bb.storage.decode(raw, data)
+	}
+	return bb.storage.Load(data, id)
+}
+
+func (bb *boltBatch) Commit() error {
+	if err := bb.storage.put(bb.values); err != nil {
+		return err
+	}
+	bb.values = map[string][]byte{}
+	return nil
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0766); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(dataBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db, sync.Mutex{}, map[string]bool{}}, nil
+}
+
+// NewPersistentStorage returns the storage backend named backend which is
+// either "gob" or "bolt". Gob files from earlier sessions are migrated into
+// a new bolt database.
+func NewPersistentStorage(backend string) (smartpool.PersistentStorage, error) {
+	switch backend {
+	case "gob":
+		return NewGobFileStorage(), nil
+	case "bolt":
+		bs, err := NewBoltStorage(filepath.Join(SmartPoolDir, BOLT_FILE))
+		if err != nil {
+			return nil, err
+		}
+		if err = bs.MigrateGobFiles(SmartPoolDir); err != nil {
+			bs.Close()
+			return nil, err
+		}
+		return bs, nil
+	}
+	return nil, fmt.Errorf("unsupported storage backend: %s", backend)
+}
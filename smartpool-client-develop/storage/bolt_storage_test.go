@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testData struct {
+	Name   string
+	Shares map[string]uint64
+}
+
+// withTempDir runs f with SmartPoolDir set to a new temp dir.
+func withTempDir(t *testing.T, f func(dir string)) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := SmartPoolDir
+	SmartPoolDir = dir
+	defer func() { SmartPoolDir = old }()
+	f(dir)
+}
+
+func loadTestData(t *testing.T, ps interface {
+	Load(data interface{}, id string) (interface{}, error)
+}, id string) *testData {
+	data, err := ps.Load(&testData{}, id)
+	if err != nil {
+		t.Fatalf("couldn't load %s: %s", id, err)
+	}
+	return data.(*testData)
+}
+
+func TestBoltStorageRoundTrip(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		path := filepath.Join(dir, BOLT_FILE)
+		bs, err := NewBoltStorage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = bs.Persist(&testData{"rig", map[string]uint64{"0x01": 3}}, "rig"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = bs.Load(&testData{}, "missing"); err == nil {
+			t.Fatalf("expected missing data not to be found")
+		}
+		bs.Close()
+		if bs, err = NewBoltStorage(path); err != nil {
+			t.Fatal(err)
+		}
+		defer bs.Close()
+		data := loadTestData(t, bs, "rig")
+		if data.Name != "rig" || data.Shares["0x01"] != 3 {
+			t.Fatalf("expected persisted data after reopening, got %+v", data)
+		}
+	})
+}
+
+func TestBoltBatchCommitsAtOnce(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		bs, err := NewBoltStorage(filepath.Join(dir, BOLT_FILE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bs.Close()
+		bs.Persist(&testData{"old", nil}, "a")
+		batch := NewBatch(bs)
+		batch.Persist(&testData{"new", nil}, "a")
+		batch.Persist(&testData{"new", nil}, "b")
+		if data := loadTestData(t, batch, "a"); data.Name != "new" {
+			t.Fatalf("expected the batch to load its own data, got %+v", data)
+		}
+		if data := loadTestData(t, bs, "a"); data.Name != "old" {
+			t.Fatalf("expected data not to be written before commit, got %+v", data)
+		}
+		if _, err = bs.Load(&testData{}, "b"); err == nil {
+			t.Fatalf("expected data not to be written before commit")
+		}
+		if err = batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if loadTestData(t, bs, "a").Name != "new" || loadTestData(t, bs, "b").Name != "new" {
+			t.Fatalf("expected all data of the batch to be written on commit")
+		}
+	})
+}
+
+func TestBatchOfGobStoragePersistsDirectly(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		gfs := NewGobFileStorage()
+		batch := NewBatch(gfs)
+		batch.Persist(&testData{"rig", nil}, "rig")
+		if data := loadTestData(t, gfs, "rig"); data.Name != "rig" {
+			t.Fatalf("expected data to be written before commit, got %+v", data)
+		}
+	})
+}
+
+func TestBoltStorageMigratesGobFilesOnce(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		gfs := NewGobFileStorage()
+		gfs.Persist(&testData{"gob", map[string]uint64{"0x01": 1}}, "active_shares")
+		gfs.Persist(&testData{"tier", nil}, "diff-61a80_open_claims")
+		// files that are not gob data of the client
+		for _, name := range []string{"work.temp", "share_journal", "smartpool.log", "archive-20171201-101010.000000000-1_active_shares"} {
+			ioutil.WriteFile(filepath.Join(dir, name), []byte("not gob"), 0600)
+		}
+		bs, err := NewBoltStorage(filepath.Join(dir, BOLT_FILE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bs.Close()
+		if err = bs.MigrateGobFiles(dir); err != nil {
+			t.Fatal(err)
+		}
+		if data := loadTestData(t, bs, "active_shares"); data.Name != "gob" || data.Shares["0x01"] != 1 {
+			t.Fatalf("expected the gob file to be migrated, got %+v", data)
+		}
+		if data := loadTestData(t, bs, "diff-61a80_open_claims"); data.Name != "tier" {
+			t.Fatalf("expected the gob file of the tier to be migrated, got %+v", data)
+		}
+		for _, name := range []string{"work.temp", "share_journal", "smartpool.log", "archive-20171201-101010.000000000-1_active_shares"} {
+			if bs.get(name) != nil {
+				t.Fatalf("expected %s not to be migrated", name)
+			}
+		}
+		if _, err = os.Stat(filepath.Join(dir, "active_shares")); err != nil {
+			t.Fatalf("expected the gob file to be kept: %s", err)
+		}
+		// later sessions keep their data over the old gob files
+		bs.Persist(&testData{"bolt", nil}, "active_shares")
+		if err = bs.MigrateGobFiles(dir); err != nil {
+			t.Fatal(err)
+		}
+		if data := loadTestData(t, bs, "active_shares"); data.Name != "bolt" {
+			t.Fatalf("expected gob files to be migrated only once, got %+v", data)
+		}
+	})
+}
+
+func TestBoltStorageMigratesMissingDir(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		bs, err := NewBoltStorage(filepath.Join(dir, "bolt", BOLT_FILE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bs.Close()
+		if err = bs.MigrateGobFiles(filepath.Join(dir, "missing")); err != nil {
+			t.Fatalf("expected a missing gob dir to be skipped: %s", err)
+		}
+	})
+}
@@ -76,14 +76,18 @@ func getName(value interface{}) string {
 	return name
 }
 
-func (gfs *GobFileStorage) register(value interface{}) {
+func registerType(registeredType map[string]bool, value interface{}) {
 	name := getName(value)
-	if _, found := gfs.registeredType[name]; !found {
+	if _, found := registeredType[name]; !found {
 		gob.Register(value)
-		gfs.registeredType[name] = true
+		registeredType[name] = true
 	}
 }
 
+func (gfs *GobFileStorage) register(value interface{}) {
+	registerType(gfs.registeredType, value)
+}
+
 func (gfs *GobFileStorage) persistToFile(data interface{}, id string) error {
 	err := os.MkdirAll(SmartPoolDir, 0766)
 	if err != nil {
@@ -122,6 +126,11 @@ func (gfs *GobFileStorage) Load(data interface{}, id string) (interface{}, error
 	return data, err
 }
 
+// FilePath returns the path of a file with id kept next to the gob files.
+func (gfs *GobFileStorage) FilePath(id string) string {
+	return getFile(id)
+}
+
 func NewGobFileStorage() *GobFileStorage {
 	return &GobFileStorage{
 		sync.Mutex{},
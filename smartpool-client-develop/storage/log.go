@@ -0,0 +1,7 @@
+package storage
+
+import (
+	"github.com/SmartPool/smartpool-client"
+)
+
+var logger = smartpool.NewLogger("storage")
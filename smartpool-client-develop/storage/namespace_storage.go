@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/SmartPool/smartpool-client"
+)
+
+// NamespaceStorage keeps data of different namespaces apart in the same
+// underlying storage by prefixing every id with the namespace.
+type NamespaceStorage struct {
+	storage   smartpool.PersistentStorage
+	namespace string
+}
+
+func (ns *NamespaceStorage) id(id string) string {
+	return fmt.Sprintf("%s_%s", ns.namespace, id)
+}
+
+func (ns *NamespaceStorage) Namespace() string {
+	return ns.namespace
+}
+
+func (ns *NamespaceStorage) Persist(data interface{}, id string) error {
+	return ns.storage.Persist(data, ns.id(id))
+}
+
+func (ns *NamespaceStorage) Load(data interface{}, id string) (interface{}, error) {
+	return ns.storage.Load(data, ns.id(id))
+}
+
+// FilePath returns the path of a file with id of the namespace kept next
+// to the data of the underlying storage. ok is false if the underlying
+// storage doesn't keep its data on the local file system.
+func (ns *NamespaceStorage) FilePath(id string) (string, bool) {
+	return FilePath(ns.storage, ns.id(id))
+}
+
+func NewNamespaceStorage(storage smartpool.PersistentStorage, namespace string) *NamespaceStorage {
+	return &NamespaceStorage{storage, namespace}
+}
+
+// namespaceBatch is a batch of the underlying storage that keeps data of
+// the namespace apart.
+type namespaceBatch struct {
+	*NamespaceStorage
+	batch smartpool.Batch
+}
+
+func (nb *namespaceBatch) Commit() error {
+	return nb.batch.Commit()
+}
+
+// NewBatch returns a batch that commits atomically if the underlying
+// storage does, so data of the namespace is committed together.
+func (ns *NamespaceStorage) NewBatch() smartpool.Batch {
+	batch := NewBatch(ns.storage)
+	return &namespaceBatch{NewNamespaceStorage(batch, ns.namespace), batch}
+}
+
+// FilePath returns the path of a file with id kept next to the data of ps
+// so data that doesn't fit ps, such as an append-only log, can be kept
+// with it. ok is false if ps doesn't keep its data on the local file
+// system.
+func FilePath(ps smartpool.PersistentStorage, id string) (string, bool) {
+	switch s := ps.(type) {
+	case *NamespaceStorage:
+		return s.FilePath(id)
+	case interface {
+		FilePath(id string) string
+	}:
+		return s.FilePath(id), true
+	}
+	return "", false
+}
@@ -1,6 +1,7 @@
 package smartpool
 
 import (
+	"errors"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"math/big"
 )
@@ -88,6 +89,29 @@ func BigToBase62(num *big.Int) string {
 	return base62DigitsToString(digits)
 }
 
+// Base62ToBig decodes a string BigToBase62 returned. It returns false if str
+// has a character that is not a base 62 digit.
+func Base62ToBig(str string) (*big.Int, bool) {
+	result := big.NewInt(0)
+	base := big.NewInt(62)
+	for i := len(str) - 1; i >= 0; i-- {
+		var digit int64
+		c := str[i]
+		if '0' <= c && c <= '9' {
+			digit = int64(c - '0')
+		} else if 'a' <= c && c <= 'z' {
+			digit = int64(c-'a') + 10
+		} else if 'A' <= c && c <= 'Z' {
+			digit = int64(c-'A') + 36
+		} else {
+			return nil, false
+		}
+		result.Mul(result, base)
+		result.Add(result, big.NewInt(digit))
+	}
+	return result, true
+}
+
 func (h SPHash) Str() string   { return string(h[:]) }
 func (h SPHash) Bytes() []byte { return h[:] }
 func (h SPHash) Big() *big.Int { return BytesToBig(h[:]) }
@@ -143,6 +167,37 @@ type Share interface {
 	FullSolution() bool
 }
 
+// Statuses of the work a share was mined on when the share was accepted.
+// A work is current when its parent is the chain head, stale when its
+// parent was superseded but a block of it can still be included as an
+// uncle and orphaned otherwise.
+const (
+	CurrentWork  = "current"
+	StaleWork    = "stale"
+	OrphanedWork = "orphaned"
+)
+
+// ErrBadMixDigest is the reason a solution is rejected when its mix digest
+// is not the one ethash gives for its nonce. The contract would reject a
+// claim containing its share.
+var ErrBadMixDigest = errors.New("bad mix digest")
+
+// ErrInvalidSolution is the reason a solution is rejected when its pow value
+// doesn't meet the share difficulty of its work.
+var ErrInvalidSolution = errors.New("solution doesn't meet share difficulty")
+
+// ErrDuplicatedShare is the reason a share is rejected when the same share
+// was already accepted.
+var ErrDuplicatedShare = errors.New("duplicated share")
+
+// ChainAwareShare is a Share that knows the status of its work on the
+// canonical chain.
+type ChainAwareShare interface {
+	Share
+	// WorkStatus returns CurrentWork, StaleWork or OrphanedWork.
+	WorkStatus() string
+}
+
 // Claim represent a batch of shares which needs to reorganize its shares in
 // ascending order of share counter.
 type Claim interface {